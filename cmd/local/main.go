@@ -1,88 +1,290 @@
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
-	"go-proxy-eins/internal/cipher"
 	"go-proxy-eins/internal/config"
-	"go-proxy-eins/internal/httpproxy"
 	"go-proxy-eins/internal/logger"
-	"go-proxy-eins/internal/protocol"
 	"go-proxy-eins/internal/sysproxy"
+	"go-proxy-eins/internal/version"
+	"go-proxy-eins/pkg/proxy"
 )
 
 var (
 	originalProxyConfig *sysproxy.ProxyConfig
+
+	// proxyBackupPath 保存 originalProxyConfig 的文件，未保存时为空
+	proxyBackupPath string
 )
 
 func main() {
 	// 加载配置
 	cfg, err := config.LoadLocalConfig()
+	if errors.Is(err, config.ErrShowVersion) {
+		fmt.Println(version.String("local"))
+		os.Exit(0)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 		os.Exit(1)
 	}
 
 	// 初始化日志
-	logger.Init(logger.ParseLevel(cfg.LogLevel), os.Stdout)
-	logger.Log.Info("Starting local proxy", 
-		"socks5", cfg.LocalAddr, 
+	var logOutput io.Writer = os.Stdout
+	if cfg.LogFile != "" {
+		logWriter, err := logger.NewRotatingWriter(cfg.LogFile, cfg.LogMaxSize, cfg.LogMaxBackups, cfg.LogMaxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+			os.Exit(1)
+		}
+		defer logWriter.Close()
+		logWriter.ReopenOnSIGHUP()
+		logOutput = logWriter
+	}
+	logger.InitWithFormat(logger.ParseLevel(cfg.LogLevel), logger.ParseFormat(cfg.LogFormat), logOutput)
+	logger.ToggleDebugOnSIGUSR1()
+
+	// 初始化访问日志
+	if cfg.AccessLog != "" {
+		accessWriter, err := logger.NewRotatingWriter(cfg.AccessLog, cfg.LogMaxSize, cfg.LogMaxBackups, cfg.LogMaxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open access log: %v\n", err)
+			os.Exit(1)
+		}
+		defer accessWriter.Close()
+		accessWriter.ReopenOnSIGHUP()
+		if err := logger.InitAccess(accessWriter, cfg.AccessLogFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to initialize access log: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 连通性自检，不启动监听器也不修改系统代理
+	if cfg.SelfTest {
+		os.Exit(runSelfTest(cfg))
+	}
+
+	logger.Log.Info("Starting local proxy",
+		"version", version.Version,
+		"socks5", cfg.LocalAddr,
 		"http", cfg.HTTPProxyAddr,
-		"server", cfg.Server, 
+		"server", cfg.Server,
 		"obfuscate", cfg.Obfuscate,
 		"auto_proxy", cfg.AutoProxy)
 
+	// 系统代理只能指向 TCP 地址，有多个地址时使用第一个
+	proxies := systemProxies(cfg)
+	if cfg.AutoProxy && proxies == (sysproxy.Proxies{}) {
+		logger.Log.Warn("Proxy listeners selected by system_proxy have no TCP address, system proxy will not be configured")
+		cfg.AutoProxy = false
+	}
+
 	// 设置系统代理（如果启用）
+	sysproxy.DryRun = cfg.DryRun
+	if len(cfg.ProxyBypass) > 0 {
+		sysproxy.Bypass = cfg.ProxyBypass
+	}
 	if cfg.AutoProxy {
-		if err := setupSystemProxy(cfg); err != nil {
+		if err := setupSystemProxy(proxies); err != nil {
 			logger.Log.Warn("Failed to setup system proxy", "error", err)
 		}
 	}
 
+	// 启动 SOCKS5 与 HTTP 代理监听器
+	local := proxy.NewLocalProxy(cfg)
+
 	// 设置信号处理（优雅退出）
-	setupSignalHandler(cfg)
+	setupSignalHandler(cfg, local)
+
+	if err := local.Start(context.Background()); err != nil {
+		logger.Log.Error("Failed to start local proxy", "error", err)
+		os.Exit(1)
+	}
+
+	// 由信号处理器负责退出
+	select {}
+}
+
+// runSelfTest 执行连通性自检并输出结果，返回进程退出码
+func runSelfTest(cfg *config.LocalConfig) int {
+	if cfg.SelfTestJSON {
+		result, err := proxy.SelfTest(cfg, cfg.TestTarget)
+		report := proxy.NewSelfTestReport(cfg.Server, cfg.TestTarget, result, err)
+		json.NewEncoder(os.Stdout).Encode(report)
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("Testing server %s (obfuscate=%v, target %s)\n", cfg.Server, cfg.Obfuscate, cfg.TestTarget)
+
+	result, err := proxy.SelfTest(cfg, cfg.TestTarget)
+
+	var stage string
+	var testErr *proxy.SelfTestError
+	if errors.As(err, &testErr) {
+		stage = testErr.Stage
+	}
 
-	// 启动 SOCKS5 监听器
-	go startSOCKS5Listener(cfg)
+	if stage == proxy.StageConnect {
+		fmt.Printf("FAIL  can't reach server: %v\n", testErr.Err)
+		fmt.Println("      check the server address, port and firewall")
+		return 1
+	}
+	fmt.Printf("OK    connected to server in %v\n", result.Connect)
+
+	switch stage {
+	case proxy.StageHandshake:
+		fmt.Printf("FAIL  server did not complete the handshake: %v\n", testErr.Err)
+		fmt.Println("      the address may not be a go-proxy-eins server")
+		return 1
+	case proxy.StageAuth:
+		fmt.Println("FAIL  password mismatch or server rejected the handshake")
+		fmt.Println("      check the password, and that client/server clocks differ by less than 30s")
+		return 1
+	case proxy.StageVersion:
+		fmt.Printf("FAIL  %v\n", testErr.Err)
+		fmt.Println("      upgrade the client and server to compatible versions")
+		return 1
+	case proxy.StageObfuscate:
+		fmt.Println("FAIL  obfuscation setting mismatch")
+		fmt.Println("      set \"obfuscate\" (-o) to the same value on the client and server")
+		return 1
+	case proxy.StageCompress:
+		fmt.Println("FAIL  compression setting mismatch")
+		fmt.Println("      set \"compress\" (-compress) to the same value on the client and server")
+		return 1
+	case proxy.StagePlaintext:
+		fmt.Println("FAIL  plaintext setting mismatch")
+		fmt.Println("      set \"plaintext\" (-plaintext) to the same value on the client and server")
+		return 1
+	case proxy.StageSuite:
+		fmt.Println("FAIL  cipher suite mismatch")
+		fmt.Println("      set \"cipher\" (-cipher) to the same value on the client and server")
+		return 1
+	case proxy.StageRekey:
+		fmt.Println("FAIL  rekey setting mismatch")
+		fmt.Println("      enable \"rekey_after\" (-rekey-after) on both the client and server, or on neither")
+		return 1
+	case proxy.StageIdentity:
+		fmt.Printf("FAIL  %v\n", testErr.Err)
+		fmt.Println("      check \"server_fingerprint\" against the fingerprint in the server's startup log; if it changed unexpectedly, the connection may be intercepted")
+		return 1
+	}
+	fmt.Printf("OK    handshake succeeded in %v\n", result.Handshake)
+
+	switch stage {
+	case proxy.StageCipher:
+		fmt.Printf("FAIL  cipher/obfuscation mismatch: %v\n", testErr.Err)
+		fmt.Println("      the server's replies can't be decrypted; check that the client and server use the same release and settings")
+		return 1
+	case proxy.StageTunnel:
+		fmt.Printf("FAIL  encrypted tunnel failed: %v\n", testErr.Err)
+		return 1
+	case proxy.StageTarget:
+		fmt.Printf("FAIL  target unreachable: %v\n", testErr.Err)
+		fmt.Println("      the server is working but could not reach the target")
+		return 1
+	}
+	fmt.Printf("OK    target %s reachable, round trip %v\n", cfg.TestTarget, result.Target)
 
-	// 启动 HTTP 代理监听器（主 goroutine）
-	startHTTPProxyListener(cfg)
+	return 0
 }
 
-// setupSystemProxy 设置系统代理（支持 Windows 和 Linux）
-func setupSystemProxy(cfg *config.LocalConfig) error {
-	// 尝试获取当前代理配置进行备份
-	current, err := sysproxy.GetCurrentProxy()
+// systemProxies 按 system_proxy 选择的协议生成系统代理地址
+// HTTP/HTTPS 使用第一个非 Unix 套接字的 HTTP 代理地址，SOCKS 使用第一个非 Unix 套接字的 SOCKS5 地址
+func systemProxies(cfg *config.LocalConfig) sysproxy.Proxies {
+	var proxies sysproxy.Proxies
+	for _, protocol := range cfg.SystemProxy {
+		switch protocol {
+		case sysproxy.ProtocolHTTP:
+			proxies.HTTP = firstTCPAddr(cfg.HTTPProxyAddr)
+		case sysproxy.ProtocolHTTPS:
+			proxies.HTTPS = firstTCPAddr(cfg.HTTPProxyAddr)
+		case sysproxy.ProtocolSOCKS:
+			proxies.SOCKS = firstTCPAddr(cfg.LocalAddr)
+		}
+	}
+	return proxies
+}
+
+// firstTCPAddr 返回第一个非 Unix 套接字的地址，没有时返回空字符串
+func firstTCPAddr(addrs []string) string {
+	for _, addr := range addrs {
+		if !proxy.IsUnixAddr(addr) {
+			return addr
+		}
+	}
+	return ""
+}
+
+// setupSystemProxy 将系统代理设置为 proxies（支持 Windows 和 Linux）
+func setupSystemProxy(proxies sysproxy.Proxies) error {
+	backupPath, err := sysproxy.BackupPath()
 	if err != nil {
-		logger.Log.Warn("Failed to get current proxy settings, will disable proxy on exit", "error", err)
-		// 不返回错误，继续设置代理
-		// 退出时会尝试禁用代理作为兜底方案
-	} else {
-		originalProxyConfig = current
-		logger.Log.Info("Current proxy settings backed up", 
-			"enabled", current.Enabled, 
-			"server", current.Server)
+		logger.Log.Warn("Proxy settings backup file unavailable, settings can't be recovered after a crash", "error", err)
+	}
+
+	// 备份文件还在说明上次没有正常退出，系统代理可能仍指向已不存在的本地端口，先恢复为当时备份的设置
+	if backupPath != "" {
+		leftover, err := sysproxy.LoadBackup(backupPath)
+		if err != nil {
+			logger.Log.Warn("Ignoring unreadable proxy settings backup", "error", err)
+		} else if leftover != nil {
+			logger.Log.Warn("Previous run did not exit cleanly, restoring proxy settings from backup",
+				"path", backupPath,
+				"enabled", leftover.Enabled,
+				"server", leftover.Server)
+			if err := sysproxy.RestoreProxy(leftover); err != nil {
+				logger.Log.Warn("Failed to restore proxy settings from backup", "error", err)
+			}
+			originalProxyConfig = leftover
+		}
+	}
+
+	// 尝试获取当前代理配置进行备份
+	if originalProxyConfig == nil {
+		current, err := sysproxy.GetCurrentProxy()
+		if err != nil {
+			logger.Log.Warn("Failed to get current proxy settings, will disable proxy on exit", "error", err)
+			// 不返回错误，继续设置代理
+			// 退出时会尝试禁用代理作为兜底方案
+		} else {
+			originalProxyConfig = current
+			logger.Log.Info("Current proxy settings backed up",
+				"enabled", current.Enabled,
+				"server", current.Server)
+		}
+	}
+
+	// 备份写入磁盘，进程被强制结束后下次启动仍能恢复；dry-run 不修改系统代理，无需备份
+	if originalProxyConfig != nil && backupPath != "" && !sysproxy.DryRun {
+		if err := sysproxy.SaveBackup(backupPath, originalProxyConfig); err != nil {
+			logger.Log.Warn("Failed to save proxy settings backup", "error", err)
+		} else {
+			proxyBackupPath = backupPath
+		}
 	}
 
-	// 设置新的 HTTP 代理
-	if err := sysproxy.SetHTTPProxy(cfg.HTTPProxyAddr); err != nil {
-		return fmt.Errorf("failed to set HTTP proxy: %w", err)
+	// 设置新的代理
+	if err := sysproxy.SetProxy(proxies); err != nil {
+		return fmt.Errorf("failed to set system proxy: %w", err)
 	}
 
-	logger.Log.Info("System proxy configured", "proxy", cfg.HTTPProxyAddr)
+	logger.Log.Info("System proxy configured", "proxy", sysproxy.FormatProxyServer(proxies))
 	return nil
 }
 
 // setupSignalHandler 设置信号处理器以优雅退出
-func setupSignalHandler(cfg *config.LocalConfig) {
+func setupSignalHandler(cfg *config.LocalConfig, local *proxy.LocalProxy) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
@@ -90,10 +292,13 @@ func setupSignalHandler(cfg *config.LocalConfig) {
 		sig := <-sigChan
 		logger.Log.Info("Received signal, shutting down...", "signal", sig)
 
+		// 关闭监听器（同时删除 Unix 套接字文件）
+		local.Close()
+
 		// 恢复系统代理
 		if cfg.AutoProxy {
 			restored := false
-			
+
 			// 尝试恢复原始代理配置
 			if originalProxyConfig != nil {
 				if err := sysproxy.RestoreProxy(originalProxyConfig); err != nil {
@@ -103,7 +308,7 @@ func setupSignalHandler(cfg *config.LocalConfig) {
 					restored = true
 				}
 			}
-			
+
 			// 如果恢复失败或没有备份，尝试直接禁用代理
 			if !restored {
 				logger.Log.Warn("Original proxy config not available, attempting to disable proxy...")
@@ -115,269 +320,18 @@ func setupSignalHandler(cfg *config.LocalConfig) {
 					logger.Log.Error("  Or run: ./scripts/restore-proxy-linux.sh")
 				} else {
 					logger.Log.Info("System proxy disabled successfully")
+					restored = true
 				}
 			}
-		}
-
-		os.Exit(0)
-	}()
-}
-
-// startSOCKS5Listener 启动 SOCKS5 监听器
-func startSOCKS5Listener(cfg *config.LocalConfig) {
-	listener, err := net.Listen("tcp", cfg.LocalAddr)
-	if err != nil {
-		logger.Log.Error("Failed to listen on SOCKS5 address", "error", err, "addr", cfg.LocalAddr)
-		return
-	}
-	defer listener.Close()
-
-	logger.Log.Info("SOCKS5 proxy is running", "address", listener.Addr())
-
-	for {
-		client, err := listener.Accept()
-		if err != nil {
-			logger.Log.Warn("Failed to accept SOCKS5 connection", "error", err)
-			continue
-		}
-
-		go handleSOCKS5(client, cfg)
-	}
-}
-
-// startHTTPProxyListener 启动 HTTP 代理监听器
-func startHTTPProxyListener(cfg *config.LocalConfig) {
-	listener, err := net.Listen("tcp", cfg.HTTPProxyAddr)
-	if err != nil {
-		logger.Log.Error("Failed to listen on HTTP proxy address", "error", err, "addr", cfg.HTTPProxyAddr)
-		os.Exit(1)
-	}
-	defer listener.Close()
-
-	logger.Log.Info("HTTP proxy is running", "address", listener.Addr())
 
-	for {
-		client, err := listener.Accept()
-		if err != nil {
-			logger.Log.Warn("Failed to accept HTTP connection", "error", err)
-			continue
-		}
-
-		go handleHTTPProxy(client, cfg)
-	}
-}
-
-// handleHTTPProxy 处理 HTTP 代理连接
-func handleHTTPProxy(client net.Conn, cfg *config.LocalConfig) {
-	defer client.Close()
-
-	// 设置超时
-	if cfg.Timeout > 0 {
-		client.SetDeadline(time.Now().Add(cfg.GetTimeout()))
-	}
-
-	reader := bufio.NewReader(client)
-
-	// 读取第一行以确定请求类型
-	requestLine, err := reader.ReadString('\n')
-	if err != nil {
-		return
-	}
-
-	// 检查是否是 CONNECT 请求
-	if len(requestLine) >= 7 && requestLine[:7] == "CONNECT" {
-		httpproxy.HandleHTTPConnect(client, reader, requestLine, cfg)
-	} else {
-		// 其他 HTTP 方法暂不支持（可以扩展）
-		httpproxy.HandleHTTPConnect(client, reader, requestLine, cfg)
-	}
-}
-
-// handleSOCKS5 处理 SOCKS5 连接
-func handleSOCKS5(client net.Conn, cfg *config.LocalConfig) {
-	defer client.Close()
-
-	// 设置超时
-	if cfg.Timeout > 0 {
-		client.SetDeadline(time.Now().Add(cfg.GetTimeout()))
-	}
-
-	logger.Log.Debug("New SOCKS5 connection", "remote", client.RemoteAddr())
-
-	reader := bufio.NewReader(client)
-
-	// 1. SOCKS5 认证
-	ver, err := reader.ReadByte()
-	if err != nil {
-		return
-	}
-	nmethods, err := reader.ReadByte()
-	if err != nil {
-		return
-	}
-	reader.Discard(int(nmethods)) // 跳过 methods
-
-	if ver != 0x05 {
-		client.Write([]byte{0x05, 0xFF}) // 不支持的版本
-		return
-	}
-	client.Write([]byte{0x05, 0x00}) // 无需认证
-
-	// 2. 解析 SOCKS5 请求
-	buf := make([]byte, 4)
-	if _, err := io.ReadFull(reader, buf); err != nil {
-		return
-	}
-
-	if buf[1] != 0x01 { // 只支持 CONNECT
-		client.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // 不支持的命令
-		return
-	}
-
-	atyp := buf[3]
-
-	// 解析目标地址
-	var addr string
-	switch atyp {
-	case 0x01: // IPv4
-		ip := make([]byte, 4)
-		if _, err := io.ReadFull(reader, ip); err != nil {
-			return
-		}
-		addr = net.IP(ip).String()
-	case 0x03: // 域名
-		length, err := reader.ReadByte()
-		if err != nil {
-			return
-		}
-		host := make([]byte, length)
-		if _, err := io.ReadFull(reader, host); err != nil {
-			return
-		}
-		addr = string(host)
-	case 0x04: // IPv6
-		ip := make([]byte, 16)
-		if _, err := io.ReadFull(reader, ip); err != nil {
-			return
+			// 系统代理已不再指向本程序，删除备份；否则保留，下次启动时再恢复
+			if restored && proxyBackupPath != "" {
+				if err := sysproxy.RemoveBackup(proxyBackupPath); err != nil {
+					logger.Log.Warn("Failed to remove proxy settings backup", "error", err)
+				}
+			}
 		}
-		addr = net.IP(ip).String()
-	default:
-		client.Write([]byte{0x05, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // 不支持的地址类型
-		return
-	}
 
-	// 解析端口
-	portBuf := make([]byte, 2)
-	if _, err := io.ReadFull(reader, portBuf); err != nil {
-		return
-	}
-	port := binary.BigEndian.Uint16(portBuf)
-	dest := fmt.Sprintf("%s:%d", addr, port)
-
-	logger.Log.Info("SOCKS5 request", "target", dest, "client", client.RemoteAddr())
-
-	// 3. 连接远程服务器
-	server, err := net.DialTimeout("tcp", cfg.Server, cfg.GetTimeout())
-	if err != nil {
-		logger.Log.Error("Failed to connect to server", "error", err)
-		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // 一般错误
-		return
-	}
-	defer server.Close()
-
-	// 设置服务器连接超时
-	if cfg.Timeout > 0 {
-		server.SetDeadline(time.Now().Add(cfg.GetTimeout()))
-	}
-
-	logger.Log.Debug("Connected to server", "server", cfg.Server)
-
-	// 4. 执行握手认证
-	salt, err := protocol.ClientHandshake(server, cfg.Password)
-	if err != nil {
-		logger.Log.Error("Handshake failed", "error", err)
-		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-		return
-	}
-
-	logger.Log.Debug("Handshake successful")
-
-	// 5. 创建加密器
-	cipherInstance, err := cipher.NewCipher(cfg.Password, salt)
-	if err != nil {
-		logger.Log.Error("Failed to create cipher", "error", err)
-		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-		return
-	}
-
-	// 6. 包装连接（加密 + 可选混淆）
-	var serverReader io.Reader = server
-	var serverWriter io.Writer = server
-
-	if cfg.Obfuscate {
-		serverReader = protocol.NewObfuscatedReader(serverReader)
-		serverWriter = protocol.NewObfuscatedWriter(serverWriter)
-	}
-
-	secureReader := cipher.NewSecureReader(serverReader, cipherInstance)
-	secureWriter := cipher.NewSecureWriter(serverWriter, cipherInstance)
-
-	// 7. 发送目标地址到服务器
-	// 协议: [地址长度(1字节)][地址字符串]
-	if _, err := secureWriter.Write([]byte{byte(len(dest))}); err != nil {
-		logger.Log.Error("Failed to send target address length", "error", err)
-		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-		return
-	}
-	if _, err := secureWriter.Write([]byte(dest)); err != nil {
-		logger.Log.Error("Failed to send target address", "error", err)
-		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-		return
-	}
-
-	// 8. 等待服务器连接目标的响应
-	status := make([]byte, 1)
-	if _, err := secureReader.Read(status); err != nil {
-		logger.Log.Error("Failed to read server response", "error", err)
-		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-		return
-	}
-
-	if status[0] != 0 {
-		logger.Log.Warn("Server failed to connect to target", "target", dest)
-		client.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-		return
-	}
-
-	// 清除超时，允许长时间数据传输
-	client.SetDeadline(time.Time{})
-	server.SetDeadline(time.Time{})
-
-	// 9. 回复 SOCKS5 成功
-	client.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-
-	logger.Log.Debug("Tunnel established", "target", dest)
-
-	// 10. 双向转发数据
-	errCh := make(chan error, 2)
-
-	// 浏览器 -> 服务器
-	go func() {
-		_, err := io.Copy(secureWriter, reader)
-		errCh <- err
-	}()
-
-	// 服务器 -> 浏览器
-	go func() {
-		_, err := io.Copy(client, secureReader)
-		errCh <- err
+		os.Exit(0)
 	}()
-
-	// 等待任一方向结束
-	err = <-errCh
-	if err != nil && err != io.EOF {
-		logger.Log.Debug("Transfer ended", "error", err)
-	}
-
-	logger.Log.Debug("Connection closed", "target", dest)
 }
@@ -1,169 +1,117 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/activation"
 	"go-proxy-eins/internal/config"
 	"go-proxy-eins/internal/logger"
-	"go-proxy-eins/internal/protocol"
-	"go-proxy-eins/internal/socks5"
+	"go-proxy-eins/internal/version"
+	"go-proxy-eins/pkg/proxy"
 )
 
 func main() {
 	// 加载配置
 	cfg, err := config.LoadServerConfig()
+	if errors.Is(err, config.ErrShowVersion) {
+		fmt.Println(version.String("server"))
+		os.Exit(0)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 		os.Exit(1)
 	}
 
-	// 初始化日志
-	logger.Init(logger.ParseLevel(cfg.LogLevel), os.Stdout)
-	logger.Log.Info("Starting proxy server", "port", cfg.Port, "obfuscate", cfg.Obfuscate)
-
-	// 监听端口
-	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", cfg.Port))
-	if err != nil {
-		logger.Log.Error("Failed to listen", "error", err)
-		os.Exit(1)
+	if cfg.SelfTest {
+		os.Exit(runSelfTest(cfg))
 	}
-	defer listener.Close()
 
-	logger.Log.Info("Server is running", "address", listener.Addr())
-
-	// 接受连接
-	for {
-		conn, err := listener.Accept()
+	// 初始化日志
+	var logOutput io.Writer = os.Stdout
+	if cfg.LogFile != "" {
+		logWriter, err := logger.NewRotatingWriter(cfg.LogFile, cfg.LogMaxSize, cfg.LogMaxBackups, cfg.LogMaxAge)
 		if err != nil {
-			logger.Log.Warn("Failed to accept connection", "error", err)
-			continue
+			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+			os.Exit(1)
 		}
-
-		go handleConnection(conn, cfg)
+		defer logWriter.Close()
+		logWriter.ReopenOnSIGHUP()
+		logOutput = logWriter
 	}
-}
-
-func handleConnection(conn net.Conn, cfg *config.ServerConfig) {
-	defer conn.Close()
+	logger.InitWithFormat(logger.ParseLevel(cfg.LogLevel), logger.ParseFormat(cfg.LogFormat), logOutput)
+	logger.ToggleDebugOnSIGUSR1()
+	logger.Log.Info("Starting proxy server", "version", version.Version, "listen", cfg.GetListenAddr(), "obfuscate", cfg.Obfuscate)
 
-	// 设置超时
-	if cfg.Timeout > 0 {
-		conn.SetDeadline(time.Now().Add(cfg.GetTimeout()))
-	}
-
-	logger.Log.Debug("New connection", "remote", conn.RemoteAddr())
-
-	// 1. 握手认证
-	salt, err := protocol.ServerHandshake(conn, conn, cfg.Password)
+	srv, err := proxy.NewServer(cfg)
 	if err != nil {
-		logger.Log.Warn("Handshake failed", "remote", conn.RemoteAddr(), "error", err)
-		return
+		logger.Log.Error("Failed to create server", "error", err)
+		os.Exit(1)
 	}
 
-	logger.Log.Debug("Handshake successful", "remote", conn.RemoteAddr())
-
-	// 2. 创建加密器
-	cipherInstance, err := cipher.NewCipher(cfg.Password, salt)
+	// systemd socket activation：使用继承的监听套接字，否则自行监听端口
+	listeners, err := activation.Listeners()
 	if err != nil {
-		logger.Log.Error("Failed to create cipher", "error", err)
-		return
+		logger.Log.Error("Failed to use inherited sockets", "error", err)
+		os.Exit(1)
 	}
-
-	// 3. 包装连接（加密 + 可选混淆）
-	var reader io.Reader = conn
-	var writer io.Writer = conn
-
-	if cfg.Obfuscate {
-		reader = protocol.NewObfuscatedReader(reader)
-		writer = protocol.NewObfuscatedWriter(writer)
+	if len(listeners) > 0 {
+		if len(listeners) > 1 {
+			logger.Log.Warn("Multiple inherited sockets, using the first one", "count", len(listeners))
+			for _, l := range listeners[1:] {
+				l.Close()
+			}
+		}
+		logger.Log.Info("Using socket from systemd", "address", listeners[0].Addr())
+		err = srv.StartListener(context.Background(), listeners[0])
+	} else {
+		err = srv.Start(context.Background())
 	}
-
-	secureReader := cipher.NewSecureReader(reader, cipherInstance)
-	secureWriter := cipher.NewSecureWriter(writer, cipherInstance)
-
-	// 4. 读取目标地址
-	// 协议: [地址长度(1字节)][地址字符串]
-	lenBuf := make([]byte, 1)
-	if _, err := secureReader.Read(lenBuf); err != nil {
-		logger.Log.Error("Failed to read target address length", "error", err)
-		return
+	if err != nil {
+		logger.Log.Error("Failed to start server", "error", err)
+		os.Exit(1)
 	}
-	addrLen := int(lenBuf[0])
 
-	addrBuf := make([]byte, addrLen)
-	if _, err := io.ReadFull(secureReader, addrBuf); err != nil {
-		logger.Log.Error("Failed to read target address", "error", err)
-		return
-	}
-	targetAddr := string(addrBuf)
+	// 等待退出信号（优雅退出）
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigChan
+	logger.Log.Info("Received signal, shutting down...", "signal", sig)
 
-	logger.Log.Info("Connecting to target", "target", targetAddr, "client", conn.RemoteAddr())
+	srv.Shutdown(cfg.GetShutdownGrace())
+}
 
-	// 5. 连接目标服务器（通过上游 SOCKS5 代理或直连）
-	var target net.Conn
-	if cfg.HasUpstreamProxy() {
-		// 通过上游 SOCKS5 代理连接
-		logger.Log.Debug("Using upstream SOCKS5 proxy", "proxy", cfg.UpstreamProxy, "target", targetAddr)
-		target, err = socks5.DialWithAuth(
-			cfg.UpstreamProxy,
-			targetAddr,
-			cfg.UpstreamUsername,
-			cfg.UpstreamPassword,
-			cfg.GetTimeout(),
-		)
-		if err != nil {
-			logger.Log.Warn("Failed to connect via upstream proxy", "proxy", cfg.UpstreamProxy, "target", targetAddr, "error", err)
-			secureWriter.Write([]byte{1}) // 连接失败
-			return
-		}
-	} else {
-		// 直接连接目标
-		target, err = net.DialTimeout("tcp", targetAddr, cfg.GetTimeout())
+// selfTestSize 吞吐量测量时每个方向传输的数据量
+const selfTestSize = 256 << 20
+
+// runSelfTest 在本机回环地址上测量隧道吞吐量并输出结果，返回进程退出码
+func runSelfTest(cfg *config.ServerConfig) int {
+	logger.Init(logger.LevelWarn, os.Stderr)
+	if cfg.SelfTestJSON {
+		result, err := proxy.Benchmark(cfg, selfTestSize)
+		json.NewEncoder(os.Stdout).Encode(proxy.NewBenchmarkReport(cfg, selfTestSize, result, err))
 		if err != nil {
-			logger.Log.Warn("Failed to connect to target", "target", targetAddr, "error", err)
-			secureWriter.Write([]byte{1}) // 连接失败
-			return
+			return 1
 		}
+		return 0
 	}
-	defer target.Close()
-
-	// 清除超时，允许长时间数据传输
-	conn.SetDeadline(time.Time{})
-	target.SetDeadline(time.Time{})
-
-	// 6. 通知客户端连接成功
-	if _, err := secureWriter.Write([]byte{0}); err != nil {
-		logger.Log.Error("Failed to send success response", "error", err)
-		return
-	}
-
-	logger.Log.Debug("Connection established", "target", targetAddr)
 
-	// 7. 双向转发数据
-	errCh := make(chan error, 2)
+	fmt.Printf("Measuring loopback tunnel throughput (cipher=%s, obfuscate=%v, compress=%v, plaintext=%v, %d MB each way)\n",
+		cfg.GetCipher(), cfg.Obfuscate, cfg.Compress, cfg.Plaintext, selfTestSize>>20)
 
-	// 客户端 -> 目标
-	go func() {
-		_, err := io.Copy(target, secureReader)
-		errCh <- err
-	}()
-
-	// 目标 -> 客户端
-	go func() {
-		_, err := io.Copy(secureWriter, target)
-		errCh <- err
-	}()
-
-	// 等待任一方向结束
-	err = <-errCh
-	if err != nil && err != io.EOF {
-		logger.Log.Debug("Transfer ended", "error", err)
+	result, err := proxy.Benchmark(cfg, selfTestSize)
+	if err != nil {
+		fmt.Printf("FAIL  %v\n", err)
+		return 1
 	}
-
-	logger.Log.Debug("Connection closed", "target", targetAddr)
+	fmt.Printf("OK    upload   %8.1f MB/s (%v)\n", result.UploadRate(), result.Upload.Round(time.Millisecond))
+	fmt.Printf("OK    download %8.1f MB/s (%v)\n", result.DownloadRate(), result.Download.Round(time.Millisecond))
+	return 0
 }
@@ -3,6 +3,8 @@ module go-proxy-eins
 go 1.25.6
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
 	golang.org/x/crypto v0.31.0
 	golang.org/x/sys v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,6 @@
+// Package activation 实现 systemd socket activation（LISTEN_FDS 协议），
+// 从 systemd 继承已打开的监听套接字。
+package activation
+
+// listenFDsStart systemd 传递的第一个文件描述符
+const listenFDsStart = 3
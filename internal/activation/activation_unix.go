@@ -0,0 +1,61 @@
+//go:build !windows
+
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Listeners 返回 systemd 传入的监听器，未通过 socket activation 启动时返回 nil
+// 读取后会清除 LISTEN_* 环境变量，避免被子进程继承
+func Listeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		listener, err := listenerFromFD(fd)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// listenerFromFD 将继承的文件描述符转换为 net.Listener
+func listenerFromFD(fd int) (net.Listener, error) {
+	syscall.CloseOnExec(fd)
+
+	file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid inherited file descriptor %d", fd)
+	}
+	// net.FileListener 会复制描述符，原文件可以关闭
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("inherited file descriptor %d is not a listening socket: %w", fd, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,98 @@
+//go:build !windows
+
+package activation
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestListenerFromFD(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// 模拟 systemd 传入的描述符：复制一份监听套接字的描述符
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(file.Fd()))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inherited, err := listenerFromFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	if inherited.Addr().String() != ln.Addr().String() {
+		t.Fatalf("listener address = %v, want %v", inherited.Addr(), ln.Addr())
+	}
+
+	// 原监听器关闭后，继承的监听器仍在同一套接字上接受连接
+	ln.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := inherited.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	conn, err := net.Dial("tcp", inherited.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenerFromFDNotSocket(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	fd, err := syscall.Dup(int(r.Fd()))
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listenerFromFD(fd); err == nil {
+		t.Fatal("expected an error for a file descriptor that is not a socket")
+	}
+}
+
+func TestListenersRequiresMatchingPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := Listeners()
+	if err != nil || listeners != nil {
+		t.Fatalf("Listeners() = %v, %v; want nil for another process's LISTEN_PID", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Fatal("LISTEN_FDS was cleared although the descriptors belong to another process")
+	}
+}
+
+func TestListenersWithoutActivation(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	listeners, err := Listeners()
+	if err != nil || listeners != nil {
+		t.Fatalf("Listeners() = %v, %v; want nil without socket activation", listeners, err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package activation
+
+import "net"
+
+// Listeners Windows 不支持 socket activation，始终返回 nil
+func Listeners() ([]net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,140 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-proxy-eins/internal/logger"
+)
+
+// ErrOpen 熔断器处于打开状态，请求被直接拒绝
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State 熔断器状态
+type State int
+
+const (
+	Closed   State = iota // 正常放行
+	Open                  // 连续失败过多，冷却期内直接拒绝
+	HalfOpen              // 冷却期结束，放行一个探测请求
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker 连续失败 threshold 次后打开，冷却 cooldown 后放行一个探测请求
+// 探测成功则恢复，失败则重新进入冷却
+type Breaker struct {
+	threshold int // 0 表示不启用
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+}
+
+// New 创建熔断器，threshold <= 0 时始终放行
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow 判断是否放行本次请求，放行后调用方必须调用 Success 或 Failure 之一
+func (b *Breaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		remaining := b.cooldown - time.Since(b.openedAt)
+		if remaining > 0 {
+			return fmt.Errorf("%w, retry in %s", ErrOpen, remaining.Round(time.Second))
+		}
+		b.setState(HalfOpen)
+		return nil
+	case HalfOpen:
+		// 探测请求尚未结束
+		return fmt.Errorf("%w, probing server", ErrOpen)
+	default:
+		return nil
+	}
+}
+
+// Success 记录一次成功，熔断器恢复为关闭状态
+func (b *Breaker) Success() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != Closed {
+		b.setState(Closed)
+	}
+}
+
+// Failure 记录一次失败，达到阈值或探测失败时打开熔断器
+func (b *Breaker) Failure() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch b.state {
+	case HalfOpen:
+		b.openedAt = time.Now()
+		b.setState(Open)
+	case Closed:
+		if b.failures >= b.threshold {
+			b.openedAt = time.Now()
+			b.setState(Open)
+		}
+	}
+}
+
+// State 返回当前状态
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState 切换状态并记录日志，调用方需持有锁
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+
+	switch state {
+	case Open:
+		logger.Log.Warn("Server circuit breaker opened, rejecting new connections",
+			"from", from, "failures", b.failures, "cooldown", b.cooldown)
+	case HalfOpen:
+		logger.Log.Info("Server circuit breaker half-open, probing server", "from", from)
+	case Closed:
+		logger.Log.Info("Server circuit breaker closed, server reachable again", "from", from)
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -21,17 +26,73 @@ const (
 	// Salt 长度
 	SaltLen = 32
 
-	// 数据包最大长度 (16MB)
+	// 数据包中密文的最大长度 (64KB)，包括 AEAD 认证标签
 	MaxPacketSize = 0xFFFF
+
+	// 加密套件，两端必须一致
+	// SuiteXChaCha20Poly1305 每个数据包携带 24 字节 nonce；SuiteChaCha20Poly1305 不发送 nonce，
+	// 两端按方向各自维护计数器生成 12 字节 nonce，每个数据包节省 24 字节，数据包丢失、重复或乱序都会导致解密失败
+	SuiteXChaCha20Poly1305 = "xchacha20-poly1305"
+	SuiteChaCha20Poly1305  = "chacha20-poly1305"
+
+	// MaxPacketsPerKey 同一密钥下 SecureWriter 最多发送的数据包数
+	// nonce 计数器为 64 位，达到上限后 Write 返回 ErrNonceExhausted 而不是回绕复用 nonce，需要重新握手换用新的 salt；启用换钥时自动换用下一个密钥
+	MaxPacketsPerKey = math.MaxUint64
+)
+
+var (
+	// ErrDecrypt 数据包认证失败，数据被篡改或密钥不一致
+	ErrDecrypt = errors.New("decryption failed")
+
+	// ErrCipherMismatch 对端的第一个数据包就无法解密，通常是两端的加密或混淆设置不一致
+	ErrCipherMismatch = errors.New("cipher/obfuscation mismatch")
+
+	// ErrFraming 数据包头部不合法（长度或 nonce 不符合预期），通常是数据流错位、被截断或篡改
+	// 在读取密文之前检测，错位的数据流立即失败，不会再按垃圾长度读取数据
+	ErrFraming = errors.New("invalid packet framing")
+
+	// ErrNonceExhausted 已用完当前密钥的 nonce，继续发送会复用 nonce
+	ErrNonceExhausted = errors.New("nonce counter exhausted, rekey required")
+)
+
+// Role 本端在连接中的角色，SuiteChaCha20Poly1305 的隐式 nonce 以此区分两个方向，避免两端使用相同的 nonce
+type Role byte
+
+const (
+	RoleClient Role = 0
+	RoleServer Role = 1
 )
 
 // Cipher 封装 ChaCha20-Poly1305 AEAD 加密
 type Cipher struct {
 	aead cipher.AEAD
+
+	// key 与 newAEAD 用于换钥时派生下一个密钥，见 rekey.go
+	key     []byte
+	newAEAD func(key []byte) (cipher.AEAD, error)
+
+	// implicit nonce 不随数据包发送，由计数器与方向生成
+	implicit bool
+	role     Role
 }
 
-// NewCipher 从密码和 salt 创建加密器
+// NewCipher 从密码和 salt 创建 SuiteXChaCha20Poly1305 加密器
 func NewCipher(password string, salt []byte) (*Cipher, error) {
+	return NewCipherSuite(password, salt, SuiteXChaCha20Poly1305, RoleClient)
+}
+
+// NewCipherSuite 从密码和 salt 创建 suite 指定的加密器，suite 为空时使用 SuiteXChaCha20Poly1305
+// role 只影响 SuiteChaCha20Poly1305，两端必须使用不同的角色
+func NewCipherSuite(password string, salt []byte, suite string, role Role) (*Cipher, error) {
+	var newAEAD func(key []byte) (cipher.AEAD, error)
+	switch suite {
+	case "", SuiteXChaCha20Poly1305:
+		newAEAD = chacha20poly1305.NewX
+	case SuiteChaCha20Poly1305:
+		newAEAD = chacha20poly1305.New
+	default:
+		return nil, fmt.Errorf("unsupported cipher suite %q", suite)
+	}
 	if len(salt) != SaltLen {
 		return nil, fmt.Errorf("invalid salt length: %d, expected %d", len(salt), SaltLen)
 	}
@@ -47,20 +108,65 @@ func NewCipher(password string, salt []byte) (*Cipher, error) {
 	)
 
 	// 创建 ChaCha20-Poly1305 AEAD
-	aead, err := chacha20poly1305.NewX(key)
+	aead, err := newAEAD(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	return &Cipher{aead: aead}, nil
+	return &Cipher{aead: aead, key: key, newAEAD: newAEAD, implicit: suite == SuiteChaCha20Poly1305, role: role}, nil
 }
 
+// Overhead 返回每个数据包在明文之外增加的字节数：2 字节长度 + 发送的 nonce + AEAD 认证标签
+func (c *Cipher) Overhead() int {
+	return c.headerLen() + c.aead.Overhead()
+}
+
+// headerLen 数据包头部长度：[2字节长度][nonce]，隐式 nonce 时只有长度
+func (c *Cipher) headerLen() int {
+	if c.implicit {
+		return 2
+	}
+	return 2 + c.aead.NonceSize()
+}
+
+// implicitNonce 生成隐式 nonce：[发送方角色(1)][0(3)][计数器(8)]
+func (c *Cipher) implicitNonce(nonce []byte, sender Role, counter uint64) {
+	clear(nonce)
+	nonce[0] = byte(sender)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+}
+
+// peer 返回对端的角色
+func (c *Cipher) peer() Role {
+	if c.role == RoleServer {
+		return RoleClient
+	}
+	return RoleServer
+}
+
+// readBufferMin SecureReader 缓冲区首次分配的最小容量，之后按需倍增，最多 MaxPacketSize
+const readBufferMin = 4 << 10
+
 // SecureReader 包装 io.Reader，自动解密数据
+// 头部、密文与明文缓冲区在每个读取器内复用，不随数据包分配；缓冲区只增不减，
+// 每个连接的读取方向最多占用约 2 * MaxPacketSize (128KB)
 type SecureReader struct {
-	src    io.Reader
-	cipher *Cipher
-	nonce  uint64
-	buffer []byte
+	src      io.Reader
+	cipher   *Cipher
+	nonce    uint64
+	buffer   []byte // 上一个数据包中 p 放不下的明文，指向 plain
+	eof      bool   // 已收到对端的结束写入信号
+	received bool   // 已成功解密过数据包
+
+	// 保活使用的状态，见 keepalive.go；可以在 Read 之外的 goroutine 读取
+	lastRecv    atomic.Int64  // 最近一次收到数据包的时间（UnixNano）
+	done        atomic.Bool   // Read 已返回错误或 EOF，之后不会再读取
+	reply       *SecureWriter // 回复 ping 使用的写入器，为 nil 时忽略 ping
+	pongPending atomic.Bool
+
+	header     [2 + chacha20poly1305.NonceSizeX]byte // 长度与 nonce
+	ciphertext []byte
+	plain      []byte // 解密失败时 Open 会清零输出，不能原地解密，否则无法再按控制数据包尝试
 }
 
 // NewSecureReader 创建安全读取器
@@ -69,51 +175,151 @@ func NewSecureReader(src io.Reader, cipher *Cipher) *SecureReader {
 		src:    src,
 		cipher: cipher,
 		nonce:  0,
-		buffer: make([]byte, 0, MaxPacketSize),
 	}
 }
 
 // Read 实现 io.Reader，自动解密读取的数据
+// 数据包大于 p 时剩余的明文留到下次读取，调用方可以使用任意大小的缓冲区
 func (sr *SecureReader) Read(p []byte) (n int, err error) {
+	if len(sr.buffer) > 0 {
+		n = copy(p, sr.buffer)
+		sr.buffer = sr.buffer[n:]
+		return n, nil
+	}
+	if sr.eof {
+		return 0, io.EOF
+	}
+
+	// 控制数据包（换钥、保活）不含数据，处理后继续读取下一个数据包
+	var plaintext []byte
+	for {
+		var control bool
+		plaintext, control, err = sr.readPacket()
+		if err != nil {
+			sr.done.Store(true)
+			return 0, err
+		}
+		if !control {
+			break
+		}
+		if err := sr.handleControl(plaintext); err != nil {
+			sr.done.Store(true)
+			return 0, err
+		}
+	}
+
+	// 空数据包表示对端已结束写入
+	if len(plaintext) == 0 {
+		sr.eof = true
+		sr.done.Store(true)
+		return 0, io.EOF
+	}
+
+	// 复制到输出缓冲区
+	n = copy(p, plaintext)
+	sr.buffer = plaintext[n:]
+	return n, nil
+}
+
+// readPacket 读取并解密一个数据包，control 表示是控制数据包
+// 返回的明文指向 sr.plain，下次调用 readPacket 前有效
+func (sr *SecureReader) readPacket() (plaintext []byte, control bool, err error) {
 	// 读取数据长度 (2 字节)
-	lenBuf := make([]byte, 2)
+	lenBuf := sr.header[:2]
 	if _, err := io.ReadFull(sr.src, lenBuf); err != nil {
-		return 0, err
+		return nil, false, err
 	}
 	dataLen := binary.BigEndian.Uint16(lenBuf)
 
-	if dataLen > MaxPacketSize {
-		return 0, fmt.Errorf("packet too large: %d", dataLen)
+	// 密文至少包含认证标签
+	if int(dataLen) < sr.cipher.aead.Overhead() {
+		return nil, false, sr.framingError(fmt.Errorf("packet length %d shorter than authentication tag", dataLen))
 	}
 
-	// 读取 nonce
-	nonceBytes := make([]byte, chacha20poly1305.NonceSizeX)
-	if _, err := io.ReadFull(sr.src, nonceBytes); err != nil {
-		return 0, err
+	// 读取 nonce，隐式 nonce 按对端的发送计数生成，数据包丢失、重复或乱序时解密失败
+	nonceBytes := sr.header[2 : 2+sr.cipher.aead.NonceSize()]
+	if sr.cipher.implicit {
+		sr.cipher.implicitNonce(nonceBytes, sr.cipher.peer(), sr.nonce)
+	} else {
+		if _, err := io.ReadFull(sr.src, nonceBytes); err != nil {
+			return nil, false, err
+		}
+		// 发送的 nonce 是从 0 开始的计数器，与预期不符说明数据流已错位或数据包被重放、丢弃
+		if !sr.expectedNonce(nonceBytes) {
+			return nil, false, sr.framingError(fmt.Errorf("unexpected nonce for packet %d", sr.nonce))
+		}
 	}
 
 	// 读取加密数据
-	encryptedData := make([]byte, dataLen)
+	sr.ciphertext = grow(sr.ciphertext, int(dataLen))
+	encryptedData := sr.ciphertext
 	if _, err := io.ReadFull(sr.src, encryptedData); err != nil {
-		return 0, err
+		return nil, false, err
 	}
 
-	// 解密数据
-	plaintext, err := sr.cipher.aead.Open(nil, nonceBytes, encryptedData, nil)
+	// 解密数据，普通数据包没有附加数据，失败时再按控制数据包尝试
+	sr.plain = grow(sr.plain, len(encryptedData)-sr.cipher.aead.Overhead())
+	plaintext, err = sr.cipher.aead.Open(sr.plain[:0], nonceBytes, encryptedData, nil)
 	if err != nil {
-		return 0, fmt.Errorf("decryption failed: %w", err)
+		var controlErr error
+		plaintext, controlErr = sr.cipher.aead.Open(sr.plain[:0], nonceBytes, encryptedData, controlAD)
+		if controlErr != nil {
+			if !sr.received {
+				return nil, false, fmt.Errorf("%w: %w: %v", ErrCipherMismatch, ErrDecrypt, err)
+			}
+			return nil, false, fmt.Errorf("%w: %v", ErrDecrypt, err)
+		}
+		control = true
 	}
+	sr.received = true
+	sr.nonce++
+	sr.lastRecv.Store(time.Now().UnixNano())
+	return plaintext, control, nil
+}
 
-	// 复制到输出缓冲区
-	n = copy(p, plaintext)
-	return n, nil
+// grow 返回长度为 n 的缓冲区，容量不足时重新分配：至少 readBufferMin，按倍数扩大，不超过 MaxPacketSize
+// 原有内容不保留
+func grow(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		buf = make([]byte, min(max(n, 2*cap(buf), readBufferMin), MaxPacketSize))
+	}
+	return buf[:n]
+}
+
+// expectedNonce 判断发送的 nonce 是否为下一个计数值：前 16 字节为 0，后 8 字节为计数器
+func (sr *SecureReader) expectedNonce(nonce []byte) bool {
+	counter := len(nonce) - 8
+	for _, b := range nonce[:counter] {
+		if b != 0 {
+			return false
+		}
+	}
+	return binary.BigEndian.Uint64(nonce[counter:]) == sr.nonce
+}
+
+// framingError 包装头部不合法的错误，第一个数据包就不合法时通常是两端的加密或混淆设置不一致
+func (sr *SecureReader) framingError(err error) error {
+	if !sr.received {
+		return fmt.Errorf("%w: %w: %v", ErrCipherMismatch, ErrFraming, err)
+	}
+	return fmt.Errorf("%w: %v", ErrFraming, err)
 }
 
 // SecureWriter 包装 io.Writer，自动加密数据
+// 方法可以并发调用：每个数据包的 nonce 分配、加密与写出在锁内完成，并发写入的数据包不会交错或复用 nonce；
+// 并发调用 Write 时各次写入的先后顺序不确定
 type SecureWriter struct {
+	mu sync.Mutex
+
 	dst    io.Writer
 	cipher *Cipher
 	nonce  uint64
+
+	rekeyAfter uint64 // 当前密钥发送多少字节明文后自动换钥，0 表示不自动换钥
+	sent       uint64 // 当前密钥已发送的明文字节数
+
+	closed   atomic.Bool // 已调用 CloseWrite
+	peerEcho bool        // 对端能响应 ping，见 keepalive.go
 }
 
 // NewSecureWriter 创建安全写入器
@@ -130,37 +336,104 @@ func (sw *SecureWriter) Write(p []byte) (n int, err error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
-
-	// 限制单个数据包大小
-	if len(p) > MaxPacketSize-sw.cipher.aead.Overhead() {
-		return 0, fmt.Errorf("data too large: %d", len(p))
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if err := sw.writePacket(p); err != nil {
+		return 0, err
 	}
+	return len(p), nil
+}
 
-	// 生成 nonce（使用计数器）
-	nonceBytes := make([]byte, chacha20poly1305.NonceSizeX)
-	binary.BigEndian.PutUint64(nonceBytes[16:], sw.nonce)
-	sw.nonce++
+// CloseWrite 发送空数据包通知对端本端已结束写入（半关闭），底层连接保持打开
+// 之后不应再调用 Write；不认识该信号的旧版本对端会忽略空数据包
+func (sw *SecureWriter) CloseWrite() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.closed.Store(true)
+	return sw.writePacket(nil)
+}
 
-	// 加密数据
-	ciphertext := sw.cipher.aead.Seal(nil, nonceBytes, p, nil)
+// FrameWriter 能把多个数据块作为各自独立的帧一次写出的 Writer（如混淆层）
+// SecureWriter 通过它让长度、nonce、密文保持原有的分帧，同时只产生一次底层写入
+type FrameWriter interface {
+	WriteFrames(frames ...[]byte) (n int, err error)
+}
 
-	// 写入：[2字节长度][nonce][加密数据]
-	lenBuf := make([]byte, 2)
-	binary.BigEndian.PutUint16(lenBuf, uint16(len(ciphertext)))
+// FramesPerPacket 每个数据包交给 FrameWriter 的最多帧数：长度、nonce、密文；隐式 nonce 时没有 nonce 帧
+const FramesPerPacket = 3
 
-	if _, err := sw.dst.Write(lenBuf); err != nil {
-		return 0, err
+// FrameOverhead 返回 suite 每个数据包在明文之外增加的字节数：2 字节长度 + 发送的 nonce + AEAD 认证标签
+// suite 为空时按 SuiteXChaCha20Poly1305 计算，不支持的套件返回 0；已有加密器时与 Cipher.Overhead 相同
+func FrameOverhead(suite string) int {
+	switch suite {
+	case "", SuiteXChaCha20Poly1305:
+		return 2 + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+	case SuiteChaCha20Poly1305:
+		return 2 + chacha20poly1305.Overhead
+	default:
+		return 0
 	}
+}
 
-	if _, err := sw.dst.Write(nonceBytes); err != nil {
-		return 0, err
+// MaxPayloadSize 返回单个数据包能携带的最大明文长度，Write 传入更长的数据会返回错误
+func MaxPayloadSize() int {
+	return MaxPacketSize - chacha20poly1305.Overhead
+}
+
+// writePacket 加密并写出一个数据包，到达 rekeyAfter 时先换钥，调用方需持有 sw.mu
+func (sw *SecureWriter) writePacket(p []byte) error {
+	// 限制单个数据包大小
+	if len(p) > MaxPayloadSize() {
+		return fmt.Errorf("data too large: %d", len(p))
 	}
 
-	if _, err := sw.dst.Write(ciphertext); err != nil {
-		return 0, err
+	// 预留一个 nonce 给换钥控制数据包，自动换钥时不会因 nonce 用完而中断
+	if sw.rekeyAfter > 0 && (sw.sent >= sw.rekeyAfter || sw.nonce >= MaxPacketsPerKey-1) {
+		if err := sw.rekey(); err != nil {
+			return err
+		}
 	}
 
-	return len(p), nil
+	if err := sw.seal(p, nil); err != nil {
+		return err
+	}
+	sw.sent += uint64(len(p))
+	return nil
+}
+
+// seal 用当前密钥加密并写出一个数据包，ad 为附加数据，整个数据包只调用一次 dst.Write，调用方需持有 sw.mu
+func (sw *SecureWriter) seal(p, ad []byte) error {
+	// 头部与密文放在同一个缓冲区：[2字节长度][nonce][加密数据]，隐式 nonce 时没有 nonce
+	headerLen := sw.cipher.headerLen()
+	packet := make([]byte, headerLen, headerLen+len(p)+sw.cipher.aead.Overhead())
+	nonceBytes := packet[2:headerLen]
+
+	// 生成 nonce（使用计数器），计数器用完时拒绝发送，避免回绕后复用 nonce
+	if sw.nonce >= MaxPacketsPerKey {
+		return ErrNonceExhausted
+	}
+	if sw.cipher.implicit {
+		nonceBytes = make([]byte, sw.cipher.aead.NonceSize())
+		sw.cipher.implicitNonce(nonceBytes, sw.cipher.role, sw.nonce)
+	} else {
+		binary.BigEndian.PutUint64(nonceBytes[len(nonceBytes)-8:], sw.nonce)
+	}
+	sw.nonce++
+
+	// 直接加密到头部之后
+	packet = sw.cipher.aead.Seal(packet, nonceBytes, p, ad)
+	binary.BigEndian.PutUint16(packet[:2], uint16(len(packet)-headerLen))
+
+	if fw, ok := sw.dst.(FrameWriter); ok {
+		if sw.cipher.implicit {
+			_, err := fw.WriteFrames(packet[:2], packet[headerLen:])
+			return err
+		}
+		_, err := fw.WriteFrames(packet[:2], nonceBytes, packet[headerLen:])
+		return err
+	}
+	_, err := sw.dst.Write(packet)
+	return err
 }
 
 // GenerateSalt 生成随机 salt
@@ -0,0 +1,400 @@
+package cipher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var suites = []string{SuiteXChaCha20Poly1305, SuiteChaCha20Poly1305}
+
+// testCipher 用固定密钥创建加密器，跳过 Argon2 密钥派生
+func testCipher(t testing.TB, suite string, role Role) *Cipher {
+	t.Helper()
+	newAEAD := chacha20poly1305.NewX
+	if suite == SuiteChaCha20Poly1305 {
+		newAEAD = chacha20poly1305.New
+	}
+	key := bytes.Repeat([]byte{0x42}, chacha20poly1305.KeySize)
+	aead, err := newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Cipher{aead: aead, key: key, newAEAD: newAEAD, implicit: suite == SuiteChaCha20Poly1305, role: role}
+}
+
+// testPair 返回写入 buf 的客户端 SecureWriter 与从 buf 读取的服务端 SecureReader
+func testPair(t testing.TB, suite string) (*SecureWriter, *SecureReader, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	sw := NewSecureWriter(&buf, testCipher(t, suite, RoleClient))
+	sr := NewSecureReader(&buf, testCipher(t, suite, RoleServer))
+	return sw, sr, &buf
+}
+
+func TestCloseWriteSignalsEOF(t *testing.T) {
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			sw, sr, _ := testPair(t, suite)
+
+			if _, err := sw.Write([]byte("last words")); err != nil {
+				t.Fatal(err)
+			}
+			if err := sw.CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(sr)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if string(got) != "last words" {
+				t.Fatalf("read %q before EOF", got)
+			}
+			// EOF 之后继续读取仍返回 EOF，不会再读取底层连接
+			if n, err := sr.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Fatalf("Read after EOF = %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestCloseWriteIsAuthenticated(t *testing.T) {
+	sw, sr, buf := testPair(t, SuiteXChaCha20Poly1305)
+	if err := sw.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	// 篡改结束信号的认证标签后不能被当作半关闭
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+
+	if _, err := sr.Read(make([]byte, 1)); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("err = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestEmptyWriteSendsNothing(t *testing.T) {
+	sw, _, buf := testPair(t, SuiteXChaCha20Poly1305)
+	if n, err := sw.Write(nil); n != 0 || err != nil {
+		t.Fatalf("Write(nil) = %d, %v", n, err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("empty write produced %d bytes; it would be read as a half-close", buf.Len())
+	}
+}
+
+func TestOverheadMatchesFramedSize(t *testing.T) {
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			sw, _, buf := testPair(t, suite)
+			overhead := sw.cipher.Overhead()
+			if overhead != FrameOverhead(suite) {
+				t.Fatalf("Overhead() = %d, FrameOverhead(%q) = %d", overhead, suite, FrameOverhead(suite))
+			}
+			for _, size := range []int{1, 100, MaxPayloadSize()} {
+				buf.Reset()
+				if _, err := sw.Write(make([]byte, size)); err != nil {
+					t.Fatal(err)
+				}
+				if buf.Len() != size+overhead {
+					t.Fatalf("%d byte payload framed as %d bytes, want %d + %d", size, buf.Len(), size, overhead)
+				}
+			}
+		})
+	}
+}
+
+func TestNonceExhausted(t *testing.T) {
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			sw, sr, _ := testPair(t, suite)
+			sw.nonce = MaxPacketsPerKey - 1
+			sr.nonce = MaxPacketsPerKey - 1
+
+			// 最后一个 nonce 仍然可用
+			if _, err := sw.Write([]byte("last")); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, 4)
+			if _, err := io.ReadFull(sr, got); err != nil || string(got) != "last" {
+				t.Fatalf("read %q, %v", got, err)
+			}
+
+			// 计数器不回绕，拒绝复用 nonce
+			if _, err := sw.Write([]byte("wrapped")); !errors.Is(err, ErrNonceExhausted) {
+				t.Fatalf("err = %v, want ErrNonceExhausted", err)
+			}
+			if err := sw.CloseWrite(); !errors.Is(err, ErrNonceExhausted) {
+				t.Fatalf("CloseWrite err = %v, want ErrNonceExhausted", err)
+			}
+		})
+	}
+}
+
+func TestNonceLimitForcesRekey(t *testing.T) {
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			sw, sr, _ := testPair(t, suite)
+			sw.SetRekeyAfter(1 << 30)
+			sw.nonce = MaxPacketsPerKey - 1
+			sr.nonce = MaxPacketsPerKey - 1
+
+			// 换钥控制数据包使用最后一个 nonce，数据使用新密钥
+			for _, msg := range []string{"after rekey", "and again"} {
+				if _, err := sw.Write([]byte(msg)); err != nil {
+					t.Fatal(err)
+				}
+				got := make([]byte, len(msg))
+				if _, err := io.ReadFull(sr, got); err != nil || string(got) != msg {
+					t.Fatalf("read %q, %v", got, err)
+				}
+			}
+			if sw.nonce != 2 {
+				t.Fatalf("nonce = %d after rekey, want the counter restarted", sw.nonce)
+			}
+		})
+	}
+}
+
+func TestSecureRoundTripSizes(t *testing.T) {
+	sizes := []int{0, 1, 15, 16, 17, readBufferMin - 1, readBufferMin, readBufferMin + 1, MaxPayloadSize() - 1, MaxPayloadSize()}
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			sw, sr, buf := testPair(t, suite)
+			var want []byte
+			for _, size := range sizes {
+				payload := make([]byte, size)
+				for i := range payload {
+					payload[i] = byte(i * 7)
+				}
+				if _, err := sw.Write(payload); err != nil {
+					t.Fatalf("Write(%d bytes): %v", size, err)
+				}
+				want = append(want, payload...)
+			}
+			if _, err := sw.Write(make([]byte, MaxPayloadSize()+1)); err == nil {
+				t.Fatal("Write accepted a payload larger than MaxPayloadSize")
+			}
+			if err := sw.CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+
+			// 用小缓冲区读取，跨数据包边界拼接
+			var got []byte
+			p := make([]byte, 7)
+			for {
+				n, err := sr.Read(p)
+				got = append(got, p[:n]...)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("read %d bytes, want %d identical bytes", len(got), len(want))
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("%d bytes left unread", buf.Len())
+			}
+		})
+	}
+}
+
+func TestCorruptedFraming(t *testing.T) {
+	// 三个 1 字节明文的数据包，nonce 依次为 0、1、2
+	sw, _, buf := testPair(t, SuiteXChaCha20Poly1305)
+	for _, p := range []string{"a", "b", "c"} {
+		if _, err := sw.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := buf.Bytes()
+	size := len(stream) / 3
+	packet := func(i int) []byte { return stream[i*size : (i+1)*size] }
+	headerLen := 2 + chacha20poly1305.NonceSizeX
+
+	tests := []struct {
+		name string
+		data []byte
+		// valid 位于损坏之前的合法数据长度；为 0 时错误出现在第一个数据包，应同时报告两端设置不一致
+		valid int
+	}{
+		{"length shorter than tag", []byte{0x00, 0x05, 0xaa, 0xbb}, 0},
+		{"stream shifted by one byte", stream[1:], 0},
+		{"nonzero nonce prefix", func() []byte {
+			data := bytes.Clone(stream)
+			data[2] ^= 0x01
+			return data
+		}(), 0},
+		{"replayed packet", slices.Concat(packet(0), packet(0)), size},
+		{"skipped packet", slices.Concat(packet(0), packet(2)), size},
+		{"short length after valid packet", slices.Concat(packet(0), []byte{0x00, 0x01}), size},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := bytes.NewReader(tt.data)
+			sr := NewSecureReader(src, testCipher(t, SuiteXChaCha20Poly1305, RoleServer))
+			_, err := io.ReadAll(sr)
+			if !errors.Is(err, ErrFraming) {
+				t.Fatalf("err = %v, want ErrFraming", err)
+			}
+			if mismatch := errors.Is(err, ErrCipherMismatch); mismatch != (tt.valid == 0) {
+				t.Fatalf("err = %v, cipher mismatch reported = %v", err, mismatch)
+			}
+			// 头部不合法时立即失败，不再读取其后的密文
+			if consumed := len(tt.data) - src.Len(); consumed > tt.valid+headerLen {
+				t.Fatalf("consumed %d bytes, want at most %d valid + %d header bytes", consumed, tt.valid, headerLen)
+			}
+		})
+	}
+}
+
+func FuzzSecureReader(f *testing.F) {
+	sw, _, buf := testPair(f, SuiteXChaCha20Poly1305)
+	sw.Write([]byte("hello"))
+	f.Add(append([]byte(nil), buf.Bytes()...))
+	sw.CloseWrite()
+	f.Add(append([]byte(nil), buf.Bytes()...))
+	f.Add([]byte{0x00, 0x00})
+	f.Add([]byte{0xff, 0xff, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, suite := range suites {
+			// 任意输入都只能返回错误或 EOF，不能崩溃；种子用 XChaCha20 加密，ChaCha20 套件不应解密出任何数据
+			sr := NewSecureReader(bytes.NewReader(data), testCipher(t, suite, RoleServer))
+			got, err := io.ReadAll(sr)
+			if err == nil && len(got) > 0 && suite == SuiteChaCha20Poly1305 {
+				t.Fatalf("%s accepted %d bytes encrypted for another suite", suite, len(got))
+			}
+		}
+	})
+}
+
+// BenchmarkSecureRoundTrip 对比两种套件加密并解密一个数据包的耗时与线上字节数
+// 小数据包上 nonce 占比最大，SuiteChaCha20Poly1305 每包节省 24 字节
+func BenchmarkSecureRoundTrip(b *testing.B) {
+	for _, suite := range suites {
+		for _, size := range []int{64, 1400, 16 * 1024} {
+			b.Run(fmt.Sprintf("%s/%d", suite, size), func(b *testing.B) {
+				sw, sr, buf := testPair(b, suite)
+				payload := make([]byte, size)
+				got := make([]byte, size)
+				b.SetBytes(int64(size))
+				var wire int
+				for b.Loop() {
+					if _, err := sw.Write(payload); err != nil {
+						b.Fatal(err)
+					}
+					wire = buf.Len()
+					if _, err := io.ReadFull(sr, got); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(wire), "wire-bytes/packet")
+			})
+		}
+	}
+}
+
+// BenchmarkSecureReaderAllocs 连续读取大量数据包，读取器复用缓冲区，allocs/op 应为 0
+// 数据包分批写入，写入的开销不计入
+func BenchmarkSecureReaderAllocs(b *testing.B) {
+	const batch = 256
+	for _, suite := range suites {
+		for _, size := range []int{1400, MaxPayloadSize()} {
+			b.Run(fmt.Sprintf("%s/%d", suite, size), func(b *testing.B) {
+				sw, sr, buf := testPair(b, suite)
+				payload := make([]byte, size)
+				got := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for b.Loop() {
+					if buf.Len() == 0 {
+						b.StopTimer()
+						for range batch {
+							if _, err := sw.Write(payload); err != nil {
+								b.Fatal(err)
+							}
+						}
+						b.StartTimer()
+					}
+					if _, err := io.ReadFull(sr, got); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// 用 -race 运行：多个 goroutine 同时写入同一个 SecureWriter，数据包不交错，nonce 不重复
+func TestSecureWriterConcurrentWrites(t *testing.T) {
+	const writers, writes, size = 8, 200, 64
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			sw, sr, _ := testPair(t, suite)
+
+			// 每个数据包：[写入者][序号 2 字节][写入者重复填充]
+			var wg sync.WaitGroup
+			for w := range writers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					packet := bytes.Repeat([]byte{byte(w)}, size)
+					for i := range writes {
+						binary.BigEndian.PutUint16(packet[1:], uint16(i))
+						if _, err := sw.Write(packet); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			// 同一写入者的数据包保持顺序，不同写入者之间的顺序不确定
+			next := make([]int, writers)
+			packet := make([]byte, size)
+			for range writers * writes {
+				if _, err := io.ReadFull(sr, packet); err != nil {
+					t.Fatal(err)
+				}
+				w := int(packet[0])
+				if w >= writers || !bytes.Equal(packet[3:], bytes.Repeat([]byte{byte(w)}, size-3)) {
+					t.Fatalf("corrupted packet %x", packet)
+				}
+				if seq := int(binary.BigEndian.Uint16(packet[1:])); seq != next[w] {
+					t.Fatalf("writer %d: packet %d, want %d", w, seq, next[w])
+				}
+				next[w]++
+			}
+		})
+	}
+}
+
+// BenchmarkSecureWriterUncontended 只有一个 goroutine 写入时的开销，包括加锁
+func BenchmarkSecureWriterUncontended(b *testing.B) {
+	for _, suite := range suites {
+		for _, size := range []int{64, 1400} {
+			b.Run(fmt.Sprintf("%s/%d", suite, size), func(b *testing.B) {
+				sw := NewSecureWriter(io.Discard, testCipher(b, suite, RoleClient))
+				payload := make([]byte, size)
+				b.SetBytes(int64(size))
+				for b.Loop() {
+					if _, err := sw.Write(payload); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
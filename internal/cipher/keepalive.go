@@ -0,0 +1,107 @@
+package cipher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 保活：隧道长时间没有收到对端的数据包时发送 ping 控制数据包，对端收到后回复 pong，
+// 在超时时间内仍然没有收到任何数据包时认为连接已被中间设备静默丢弃。
+// 不认识 ping 的旧版本会把它当作无效数据包断开连接，只有握手确认对端支持时才能发送（见 SetPeerEcho）。
+
+// SetReplyWriter 设置回复 ping 使用的写入器，通常是同一条隧道另一个方向的 SecureWriter
+func (sr *SecureReader) SetReplyWriter(sw *SecureWriter) {
+	sr.reply = sw
+}
+
+// pong 在后台回复 ping，不阻塞读取；本端写入阻塞时最多只有一个待发送的 pong
+func (sr *SecureReader) pong() {
+	if sr.reply == nil || !sr.pongPending.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer sr.pongPending.Store(false)
+		sr.reply.control(controlPong)
+	}()
+}
+
+// SetPeerEcho 设置对端是否能响应 ping，由握手结果决定
+func (sw *SecureWriter) SetPeerEcho(ok bool) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.peerEcho = ok
+}
+
+// control 发送一个控制数据包；已半关闭时不再发送
+func (sw *SecureWriter) control(typ byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.closed.Load() {
+		return nil
+	}
+	// 与 writePacket 一样保留最后一个 nonce 给换钥控制数据包
+	if sw.rekeyAfter > 0 && sw.nonce >= MaxPacketsPerKey-1 {
+		if err := sw.rekey(); err != nil {
+			return err
+		}
+	}
+	return sw.seal([]byte{typ}, controlAD)
+}
+
+// Keepalive 在后台检查隧道：超过 interval 没有收到对端的数据包时发送 ping，之后 timeout 内仍没有收到任何数据包时调用 dead
+// sr 与 sw 属于同一条隧道；对端不支持 ping、interval 不大于 0 时不启用
+// sr 结束读取（连接关闭、出错或对端半关闭）或 sw 半关闭后停止检查
+// ping 在后台发送，本端写入被阻塞时仍按时判定超时
+func Keepalive(sr *SecureReader, sw *SecureWriter, interval, timeout time.Duration, dead func()) {
+	sw.mu.Lock()
+	enabled := sw.peerEcho && interval > 0 && timeout > 0
+	sw.mu.Unlock()
+	if !enabled {
+		return
+	}
+
+	start := time.Now()
+	go func() {
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+
+		var pingSent time.Time
+		var sending atomic.Bool
+		for range timer.C {
+			if sr.done.Load() || sw.closed.Load() {
+				return
+			}
+
+			now := time.Now()
+			last := time.Unix(0, sr.lastRecv.Load())
+			if last.Before(start) {
+				last = start
+			}
+
+			// 已发送 ping 且之后没有收到任何数据包
+			if !pingSent.IsZero() && last.Before(pingSent) {
+				if waited := now.Sub(pingSent); waited < timeout {
+					timer.Reset(timeout - waited)
+					continue
+				}
+				dead()
+				return
+			}
+			pingSent = time.Time{}
+
+			if idle := now.Sub(last); idle < interval {
+				timer.Reset(interval - idle)
+				continue
+			}
+			// 写入失败时转发同样会失败并结束隧道，这里不再处理
+			if sending.CompareAndSwap(false, true) {
+				go func() {
+					defer sending.Store(false)
+					sw.control(controlPing)
+				}()
+			}
+			pingSent = now
+			timer.Reset(timeout)
+		}
+	}()
+}
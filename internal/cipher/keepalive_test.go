@@ -0,0 +1,108 @@
+package cipher
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tunnelEnd 隧道一端的读写器
+type tunnelEnd struct {
+	sr *SecureReader
+	sw *SecureWriter
+}
+
+// serve 与转发时一样在后台持续读取；echo 为 false 时不回复 ping
+func (e tunnelEnd) serve(echo bool) {
+	if echo {
+		e.sr.SetReplyWriter(e.sw)
+	}
+	go io.Copy(io.Discard, e.sr)
+}
+
+// tunnelPair 在内存连接上创建一条双向隧道，两端都声明能响应 ping
+func tunnelPair(t *testing.T) (client, server tunnelEnd) {
+	t.Helper()
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	client = tunnelEnd{NewSecureReader(c, testCipher(t, SuiteChaCha20Poly1305, RoleClient)), NewSecureWriter(c, testCipher(t, SuiteChaCha20Poly1305, RoleClient))}
+	server = tunnelEnd{NewSecureReader(s, testCipher(t, SuiteChaCha20Poly1305, RoleServer)), NewSecureWriter(s, testCipher(t, SuiteChaCha20Poly1305, RoleServer))}
+	client.sw.SetPeerEcho(true)
+	server.sw.SetPeerEcho(true)
+	return client, server
+}
+
+func TestKeepalivePingPong(t *testing.T) {
+	client, server := tunnelPair(t)
+	client.serve(true)
+	server.serve(true)
+
+	var dead atomic.Bool
+	Keepalive(client.sr, client.sw, 50*time.Millisecond, 200*time.Millisecond, func() { dead.Store(true) })
+
+	// 两端都没有发送数据，只有 ping 与 pong 在传递
+	time.Sleep(time.Second)
+	if dead.Load() {
+		t.Fatal("tunnel declared dead while the peer answered pings")
+	}
+	if server.sr.lastRecv.Load() == 0 {
+		t.Fatal("server never received a ping")
+	}
+	if client.sr.lastRecv.Load() == 0 {
+		t.Fatal("client never received a pong")
+	}
+}
+
+func TestKeepaliveDeadPeer(t *testing.T) {
+	const interval, timeout = 50 * time.Millisecond, 200 * time.Millisecond
+
+	tests := map[string]func(server tunnelEnd){
+		// 对端读取数据但不回复 ping
+		"no pong": func(server tunnelEnd) { server.serve(false) },
+		// 对端不再读取，本端发送 ping 时阻塞
+		"not reading": func(tunnelEnd) {},
+	}
+	for name, stall := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, server := tunnelPair(t)
+			client.serve(true)
+			stall(server)
+
+			dead := make(chan struct{})
+			start := time.Now()
+			Keepalive(client.sr, client.sw, interval, timeout, func() { close(dead) })
+			select {
+			case <-dead:
+			case <-time.After(5 * time.Second):
+				t.Fatal("non-responding peer was never detected")
+			}
+			if elapsed := time.Since(start); elapsed < interval+timeout {
+				t.Fatalf("declared dead after %v, want at least interval + timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestKeepaliveRequiresPeerEcho(t *testing.T) {
+	client, server := tunnelPair(t)
+	client.serve(true)
+	server.serve(false)
+
+	// 对端不能响应 ping 时不发送，也不会因此断开
+	client.sw.SetPeerEcho(false)
+	var dead atomic.Bool
+	Keepalive(client.sr, client.sw, 50*time.Millisecond, 100*time.Millisecond, func() { dead.Store(true) })
+
+	time.Sleep(500 * time.Millisecond)
+	if dead.Load() {
+		t.Fatal("keepalive ran against a peer that cannot answer pings")
+	}
+	if server.sr.lastRecv.Load() != 0 {
+		t.Fatal("ping sent to a peer that cannot answer it")
+	}
+}
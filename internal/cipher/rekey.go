@@ -0,0 +1,90 @@
+package cipher
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"fmt"
+)
+
+// 换钥：每个方向独立进行。发送方用当前密钥发出一个换钥控制数据包，之后的数据包都用下一个密钥加密，
+// nonce 计数器从 0 重新开始；接收方解密到换钥控制数据包后同样切换，此前的数据包仍用旧密钥解密。
+// 控制数据包与普通数据包格式相同，只是以 controlAD 作为 AEAD 附加数据，线上长度不变，也无法被伪造或与数据混淆。
+// 数据流按顺序到达，控制数据包之前的数据不会丢失，也不需要暂停转发。
+
+// controlAD 控制数据包的 AEAD 附加数据
+var controlAD = []byte("go-proxy-eins control")
+
+// 控制数据包的明文：[类型(1)]
+const (
+	controlRekey = 0x01
+	controlPing  = 0x02 // 对端收到后回复 controlPong，见 keepalive.go
+	controlPong  = 0x03
+)
+
+// next 派生下一个密钥：HKDF-SHA256(当前密钥, 发送方角色)，两个方向的密钥各自演进、互不相同
+func (c *Cipher) next(sender Role) (*Cipher, error) {
+	key, err := hkdf.Key(sha256.New, c.key, nil, fmt.Sprintf("go-proxy-eins rekey %d", sender), len(c.key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive next key: %w", err)
+	}
+	aead, err := c.newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &Cipher{aead: aead, key: key, newAEAD: c.newAEAD, implicit: c.implicit, role: c.role}, nil
+}
+
+// SetRekeyAfter 设置当前密钥发送 n 字节明文后自动换钥，0 表示不自动换钥
+// 对端必须支持换钥（握手时协商），否则换钥后的数据包无法解密
+func (sw *SecureWriter) SetRekeyAfter(n uint64) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.rekeyAfter = n
+}
+
+// Rekey 发送换钥控制数据包并切换到下一个密钥，可以与 Write 并发调用
+func (sw *SecureWriter) Rekey() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.rekey()
+}
+
+// rekey 同 Rekey，调用方需持有 sw.mu
+func (sw *SecureWriter) rekey() error {
+	next, err := sw.cipher.next(sw.cipher.role)
+	if err != nil {
+		return err
+	}
+	if err := sw.seal([]byte{controlRekey}, controlAD); err != nil {
+		return err
+	}
+	sw.cipher = next
+	sw.nonce = 0
+	sw.sent = 0
+	return nil
+}
+
+// handleControl 处理对端的控制数据包
+func (sr *SecureReader) handleControl(msg []byte) error {
+	if len(msg) != 1 {
+		return fmt.Errorf("%w: unknown control packet", ErrFraming)
+	}
+	switch msg[0] {
+	case controlRekey:
+	case controlPing:
+		sr.pong()
+		return nil
+	case controlPong:
+		// 收到任何数据包都会刷新 lastRecv，不需要额外处理
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown control packet", ErrFraming)
+	}
+	next, err := sr.cipher.next(sr.cipher.peer())
+	if err != nil {
+		return err
+	}
+	sr.cipher = next
+	sr.nonce = 0
+	return nil
+}
@@ -0,0 +1,127 @@
+package cipher
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+func TestRekeyAcrossBoundary(t *testing.T) {
+	for _, suite := range suites {
+		t.Run(suite, func(t *testing.T) {
+			sw, sr, buf := testPair(t, suite)
+			sw.SetRekeyAfter(1000)
+			firstKey := sw.cipher.key
+
+			// 不同长度的写入让换钥点落在数据包之间的任意位置
+			data := make([]byte, 100*1024)
+			rand.NewChaCha8([32]byte{}).Read(data)
+			for rest := data; len(rest) > 0; {
+				n := min(len(rest), 1+len(rest)%1500)
+				if _, err := sw.Write(rest[:n]); err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[n:]
+			}
+			if err := sw.CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(sw.cipher.key, firstKey) {
+				t.Fatal("writer never rotated its key")
+			}
+
+			got, err := io.ReadAll(sr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("read %d bytes, want the %d bytes written", len(got), len(data))
+			}
+			if !bytes.Equal(sr.cipher.key, sw.cipher.key) {
+				t.Fatal("reader and writer ended on different keys")
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("%d bytes left unread", buf.Len())
+			}
+		})
+	}
+}
+
+func TestRekeyConcurrentWithWrite(t *testing.T) {
+	pr, pw := io.Pipe()
+	sw := NewSecureWriter(pw, testCipher(t, SuiteChaCha20Poly1305, RoleClient))
+	sr := NewSecureReader(pr, testCipher(t, SuiteChaCha20Poly1305, RoleServer))
+
+	const writes = 200
+	chunk := bytes.Repeat([]byte("in flight "), 100)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range writes {
+			if _, err := sw.Write(chunk); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		sw.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			if err := sw.Rekey(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	got, err := io.ReadAll(sr)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Repeat(chunk, writes); !bytes.Equal(got, want) {
+		t.Fatalf("read %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestRekeyDirectionsUseDifferentKeys(t *testing.T) {
+	c := testCipher(t, SuiteXChaCha20Poly1305, RoleClient)
+	client, err := c.next(RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := c.next(RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(client.key, server.key) || bytes.Equal(client.key, c.key) {
+		t.Fatal("derived keys are not distinct per direction")
+	}
+}
+
+func TestRekeyOldKeyRejected(t *testing.T) {
+	sw, sr, buf := testPair(t, SuiteXChaCha20Poly1305)
+	if _, err := sw.Write([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	stale := bytes.Clone(buf.Bytes())
+	if err := sw.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, len("before"))
+	if _, err := io.ReadFull(sr, got); err != nil || string(got) != "before" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	// 换钥后 nonce 从 0 重新开始，重放用旧密钥加密的第一个数据包时 nonce 相符，但无法解密
+	buf.Write(stale)
+	if _, err := sr.Read(got); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("stale packet: err = %v, want ErrDecrypt", err)
+	}
+}
@@ -2,62 +2,397 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/sysproxy"
 )
 
+// defaultPACPath 未配置 pac_path 时 PAC 文件的路径
+const defaultPACPath = "/proxy.pac"
+
+// defaultAuthTimeout 未配置 auth_timeout 时读取握手数据的超时
+const defaultAuthTimeout = 5 * time.Second
+
+// defaultKeepaliveTimeout 未配置 keepalive_timeout 时等待 ping 应答的时间
+const defaultKeepaliveTimeout = 10 * time.Second
+
+// ErrShowVersion 命令行指定了 -version，调用方应输出版本信息并退出
+var ErrShowVersion = errors.New("version requested")
+
 // ServerConfig 服务端配置
 type ServerConfig struct {
-	Port       int    `json:"port"`
-	Password   string `json:"password"`
-	Timeout    int    `json:"timeout"`     // 秒
-	LogLevel   string `json:"log_level"`
-	Obfuscate  bool   `json:"obfuscate"`
-	
-	// 上游 SOCKS5 代理配置（可选）
-	UpstreamProxy    string `json:"upstream_proxy"`     // e.g., "proxy.example.com:1080"
-	UpstreamUsername string `json:"upstream_username"`  // SOCKS5 用户名（可选）
-	UpstreamPassword string `json:"upstream_password"`  // SOCKS5 密码（可选）
+	// 监听地址：IP 地址（与 port 组合）或完整的 host:port（忽略 port），默认 0.0.0.0
+	ListenAddr string `json:"listen_addr"`
+
+	// 连接开头必须带有 PROXY protocol v1/v2 头部，日志、速率限制等使用其中的真实客户端地址
+	// 只在服务端位于 HAProxy、nginx stream 等负载均衡器之后时启用，此时不能再直接连接
+	ProxyProtocol bool `json:"proxy_protocol"`
+
+	// 握手认证失败时的响应：none（默认，发送 1 字节失败应答后关闭）、http（nginx 风格的 404 页面）、ssh（OpenSSH 标识行）
+	// 主动探测无法从异常的应答或断开方式识别出代理服务
+	Decoy string `json:"decoy"`
+
+	// 仅在本机回环地址上测量隧道吞吐量后退出（-selftest），不需要配置密码
+	SelfTest bool `json:"-"`
+	// 以 JSON 格式输出 -selftest 的结果（-json）
+	SelfTestJSON bool `json:"-"`
+
+	Port             int    `json:"port"`
+	Password         string `json:"password"`
+	PasswordFile     string `json:"password_file"`     // 从文件读取密码，避免出现在进程参数中
+	Timeout          int    `json:"timeout"`           // 秒
+	HandshakeTimeout int    `json:"handshake_timeout"` // 建连与握手超时（秒），0 表示使用 timeout
+	IdleTimeout      int    `json:"idle_timeout"`      // 隧道空闲超时（秒），0 表示不限制
+	LogLevel         string `json:"log_level"`
+	LogFormat        string `json:"log_format"` // text/json
+	Obfuscate        bool   `json:"obfuscate"`
+	Compress         bool   `json:"compress"` // 压缩隧道数据，需与客户端一致
+
+	// 转发时单次写入的超时（秒），对端长时间不读取（TCP 零窗口）时结束隧道；0 表示不限制
+	// 与 idle_timeout 不同，另一方向仍有数据时也不会顺延
+	WriteTimeout int `json:"write_timeout"`
+
+	// 握手后不加密也不混淆，只用于回环或可信网络，需与客户端一致；不能与 obfuscate 同时使用
+	Plaintext bool `json:"plaintext"`
+
+	// 加密套件：xchacha20-poly1305（默认）或 chacha20-poly1305（不发送 nonce，每个数据包少 24 字节），需与客户端一致
+	Cipher string `json:"cipher"`
+
+	// 每个方向发送多少 MB 数据后换用新的密钥，0 表示不换钥；是否启用需与客户端一致
+	RekeyAfter int `json:"rekey_after"`
+
+	// 隧道超过 keepalive_interval 秒没有收到数据时发送 ping，keepalive_timeout 秒内没有应答则断开；0 表示不发送
+	KeepaliveInterval int `json:"keepalive_interval"`
+	KeepaliveTimeout  int `json:"keepalive_timeout"`
+
+	// 接受的目标地址最大长度（字节），超过时拒绝连接；0 表示协议上限 1024
+	MaxTargetLen int `json:"max_target_len"`
+
+	// 服务端身份密钥文件（PEM 格式的 Ed25519 私钥），文件不存在时自动生成；为空表示不启用
+	// 启动时在日志中输出公钥指纹，客户端配置 server_fingerprint 后据此验证服务端身份
+	IdentityKey string `json:"identity_key"`
+
+	// 多用户密码文件，格式为 {"用户 ID": "密码"}（.yaml/.yml 文件按 YAML 解析），修改后自动重新加载；可与 password 同时使用
+	UsersFile string `json:"users_file"`
+
+	// 额外接受的密码与加密套件，更换密码或加密套件期间同时接受新旧凭据，客户端可以逐步迁移
+	Credentials []Credential `json:"credentials"`
+
+	// 日志文件配置（可选，为空时输出到 stdout）
+	LogFile       string `json:"log_file"`
+	LogMaxSize    int    `json:"log_max_size"`    // MB
+	LogMaxBackups int    `json:"log_max_backups"` // 保留的备份数
+	LogMaxAge     int    `json:"log_max_age"`     // 天
+
+	// 上游代理配置（可选）
+	UpstreamProxy    StringList `json:"upstream_proxy"`     // e.g., "proxy.example.com:1080"，多个时按顺序链式连接
+	UpstreamType     string     `json:"upstream_type"`      // socks5（默认）或 http（HTTP CONNECT 代理）
+	UpstreamUsername string     `json:"upstream_username"`  // 用户名（可选）
+	UpstreamPassword string     `json:"upstream_password"`  // 密码（可选）
+	UpstreamPoolSize int        `json:"upstream_pool_size"` // 预建立的上游空闲连接数，0 表示不启用
+	UpstreamPoolIdle int        `json:"upstream_pool_idle"` // 空闲连接最长保留时间（秒）
+
+	// 无法连接上游代理或握手中断时的重试次数与首次重试的等待时间（毫秒），之后每次翻倍并加随机抖动
+	// 上游代理明确拒绝（如目标不可达、认证失败）时不重试
+	UpstreamRetries    int `json:"upstream_retries"`
+	UpstreamRetryDelay int `json:"upstream_retry_delay"`
+
+	HealthAddr string `json:"health_addr"` // 健康检查 HTTP 监听地址（/healthz、/readyz），为空表示不启用
+	AdminAddr  string `json:"admin_addr"`  // 管理接口监听地址（/connections），没有认证，应只监听本机地址；为空表示不启用
+
+	ShutdownGrace  int `json:"shutdown_grace"`  // 退出时等待连接结束的最长时间（秒）
+	MaxConnections int `json:"max_connections"` // 最大并发连接数，0 表示不限制
+
+	// 连接目标（直连或经上游代理）的超时（秒），从收到目标地址开始计算，不受握手已耗费时间的影响；0 表示使用 handshake_timeout
+	ConnectTimeout int `json:"connect_timeout"`
+
+	// 客户端必须在 auth_timeout 秒内发完握手数据，防止慢速发送长期占用连接
+	AuthTimeout          int `json:"auth_timeout"`
+	MaxPendingHandshakes int `json:"max_pending_handshakes"` // 同时等待握手数据的最大连接数，0 表示不限制
+
+	// 每次 Argon2 密钥派生占用约 64MB 内存，限制同时进行的派生数以限制内存峰值，0 表示不限制
+	MaxConcurrentHandshakes int `json:"max_concurrent_handshakes"`
+
+	// 单个客户端 IP 的连接速率限制
+	ConnRateLimit float64 `json:"conn_rate_limit"` // 每秒允许的新连接数，0 表示不限制
+	ConnRateBurst int     `json:"conn_rate_burst"` // 允许的突发连接数
+
+	RateLimitKbps int `json:"rate_limit_kbps"` // 单条隧道每个方向的带宽上限（kbit/s），0 表示不限制
+
+	TCPKeepAlive int   `json:"tcp_keepalive"` // TCP keepalive 间隔（秒），0 表示关闭
+	TCPNoDelay   *bool `json:"tcp_nodelay"`   // 是否禁用 Nagle 算法，未设置时为 true
+
+	// 是否逐帧立即发送；关闭后突发传输中 1ms 内的连续小写入会合并发送，空闲后的第一次写入仍立即发送
+	LowLatency bool `json:"low_latency"`
+
+	// 识别每条隧道开头的数据属于哪种协议（TLS 的 SNI/ALPN、HTTP、SSH）并记录日志，用于排查问题
+	Sniff bool `json:"sniff"`
+
+	// 直连目标同时有 IPv6 与 IPv4 地址时，首选地址族未连通多久后并行尝试另一地址族（毫秒）
+	// 0 表示使用 Go 默认值（300ms），负数表示禁用并行尝试
+	DialFallbackDelay int `json:"dial_fallback_delay"`
+
+	// 直连目标使用的网络：tcp（IPv4 与 IPv6）、tcp4（只用 IPv4）、tcp6（只用 IPv6）
+	// 主机有 IPv6 地址但 IPv6 出口不通时设为 tcp4，避免每次连接都等待 IPv6 超时
+	DialNetwork string `json:"dial_network"`
+
+	// 直连目标时使用的本机源地址（IPv4 或 IPv6），必须是本机网卡上的地址；为空时由系统选择
+	// 指定后只会连接与其地址族相同的目标地址
+	SourceAddr string `json:"source_addr"`
+
+	// 解析直连目标使用的 DNS 服务器，如 "1.1.1.1"、"tcp://1.1.1.1"、"tls://1.1.1.1:853"、"https://1.1.1.1/dns-query"；为空时使用系统解析器
+	Resolver string `json:"resolver"`
+
+	// 目标改写，如 {"front.example.com": "backend.example.net", "a.example.com:443": "10.0.0.5:8443"}
+	// 键与值都可以是 host 或 host:port，键先按 host:port 再按 host 匹配，值不带端口时沿用原端口；只改变服务端实际连接的地址
+	FrontMap map[string]string `json:"front_map"`
+
+	// 目标端口过滤，如 [80, 443, "8000-9000"]
+	AllowedPorts PortList `json:"allowed_ports"` // 为空表示允许所有端口
+	BlockedPorts PortList `json:"blocked_ports"` // 优先于 allowed_ports
+}
+
+// Credential credentials 中的一组密码与加密套件
+type Credential struct {
+	Password string `json:"password"` // "@/path" 表示从文件读取
+	Cipher   string `json:"cipher"`   // 为空表示与 cipher 相同
+}
+
+// GetCipher 获取加密套件，未设置时为 fallback
+func (c Credential) GetCipher(fallback string) string {
+	if c.Cipher == "" {
+		return fallback
+	}
+	return c.Cipher
+}
+
+// StringList 字符串列表，JSON 中既可以写单个字符串也可以写字符串数组
+type StringList []string
+
+// UnmarshalJSON 实现 json.Unmarshaler
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if single == "" {
+			*l = nil
+		} else {
+			*l = StringList{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("expected string or array of strings: %w", err)
+	}
+	*l = list
+	return nil
+}
+
+// String 实现 flag.Value
+func (l *StringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+// Set 实现 flag.Value，多个值用逗号分隔，覆盖默认值
+func (l *StringList) Set(value string) error {
+	var list StringList
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	*l = list
+	return nil
 }
 
 // LocalConfig 客户端配置
 type LocalConfig struct {
-	LocalAddr     string `json:"local_addr"`
-	Server        string `json:"server"`
-	Password      string `json:"password"`
-	Timeout       int    `json:"timeout"`     // 秒
-	LogLevel      string `json:"log_level"`
-	Obfuscate     bool   `json:"obfuscate"`
-	HTTPProxyAddr string `json:"http_proxy_addr"` // HTTP 代理监听地址，如 "127.0.0.1:8080"
-	AutoProxy     bool   `json:"auto_proxy"`      // 是否自动设置系统代理
+	LocalAddr        StringList `json:"local_addr"` // host:port 或 unix:/path/to.sock，可以是多个地址
+	Server           string     `json:"server"`
+	Password         string     `json:"password"`
+	PasswordFile     string     `json:"password_file"`     // 从文件读取密码，避免出现在进程参数中
+	Timeout          int        `json:"timeout"`           // 秒
+	HandshakeTimeout int        `json:"handshake_timeout"` // 建连与握手超时（秒），0 表示使用 timeout
+	IdleTimeout      int        `json:"idle_timeout"`      // 隧道空闲超时（秒），0 表示不限制
+	LogLevel         string     `json:"log_level"`
+	LogFormat        string     `json:"log_format"` // text/json
+	Obfuscate        bool       `json:"obfuscate"`
+	Compress         bool       `json:"compress"`         // 压缩隧道数据，需与服务端一致
+	Plaintext        bool       `json:"plaintext"`        // 握手后不加密也不混淆，只用于可信网络，需与服务端一致
+	Cipher           string     `json:"cipher"`           // 加密套件：xchacha20-poly1305 或 chacha20-poly1305，需与服务端一致
+	HTTPProxyAddr    StringList `json:"http_proxy_addr"`  // HTTP 代理监听地址，如 "127.0.0.1:8080"，可以是多个地址
+	CombinedAddr     string     `json:"combined_addr"`    // 同时接受 SOCKS5 与 HTTP CONNECT 的监听地址（可选）
+	AutoProxy        bool       `json:"auto_proxy"`       // 是否自动设置系统代理
+	SystemProxy      StringList `json:"system_proxy"`     // 自动设置系统代理的协议：http、https、socks
+	UnixSocketMode   string     `json:"unix_socket_mode"` // Unix 套接字文件权限（八进制），如 "0600"
+	TestTarget       string     `json:"test_target"`      // 自检时请求连接的目标
+	SelfTest         bool       `json:"-"`                // 仅执行连通性自检（-test）
+	SelfTestJSON     bool       `json:"-"`                // 以 JSON 格式输出自检结果（-json）
+	DryRun           bool       `json:"-"`                // 只记录将要进行的系统代理修改，不实际执行（-dry-run）
+
+	// 转发时单次写入的超时（秒），对端长时间不读取（TCP 零窗口）时结束隧道；0 表示不限制
+	// 与 idle_timeout 不同，另一方向仍有数据时也不会顺延
+	WriteTimeout int `json:"write_timeout"`
+
+	// 自动设置系统代理时不走代理的地址：域名、IP、CIDR 或 <local>，为空时使用 sysproxy.DefaultBypass（含 IPv6 链路本地与 ULA）
+	ProxyBypass StringList `json:"proxy_bypass"`
+
+	// 每个方向发送多少 MB 数据后换用新的密钥，0 表示不换钥；是否启用需与服务端一致
+	RekeyAfter int `json:"rekey_after"`
+
+	// 发送给服务端的目标地址最大长度（字节），超过时不连接服务端直接拒绝；0 表示协议上限 1024
+	MaxTargetLen int `json:"max_target_len"`
+
+	// 服务端身份密钥的指纹（服务端启动日志中的 fingerprint），设置后握手时验证服务端身份；为空表示不验证
+	ServerFingerprint string `json:"server_fingerprint"`
+
+	// 握手时混淆与加密套件以服务端为准，不一致时自动采用服务端的设置；压缩、明文与换钥仍需一致
+	Negotiate bool `json:"negotiate"`
+
+	// 隧道超过 keepalive_interval 秒没有收到数据时发送 ping，keepalive_timeout 秒内没有应答则断开；0 表示不发送
+	KeepaliveInterval int `json:"keepalive_interval"`
+	KeepaliveTimeout  int `json:"keepalive_timeout"`
+
+	// 日志文件配置（可选，为空时输出到 stdout）
+	LogFile       string `json:"log_file"`
+	LogMaxSize    int    `json:"log_max_size"`    // MB
+	LogMaxBackups int    `json:"log_max_backups"` // 保留的备份数
+	LogMaxAge     int    `json:"log_max_age"`     // 天
+
+	// 访问日志（可选），每条隧道关闭时记录一行，与日志级别无关
+	AccessLog       string `json:"access_log"`        // 访问日志文件路径，轮转参数与 log_file 相同
+	AccessLogFormat string `json:"access_log_format"` // text/template 格式，为空时使用 Combined Log Format
+
+	RateLimitKbps int `json:"rate_limit_kbps"` // 单条隧道每个方向的带宽上限（kbit/s），0 表示不限制
+
+	TCPKeepAlive int   `json:"tcp_keepalive"` // TCP keepalive 间隔（秒），0 表示关闭
+	TCPNoDelay   *bool `json:"tcp_nodelay"`   // 是否禁用 Nagle 算法，未设置时为 true
+
+	// 是否逐帧立即发送；关闭后突发传输中 1ms 内的连续小写入会合并发送，空闲后的第一次写入仍立即发送
+	LowLatency bool `json:"low_latency"`
+
+	// 识别每条隧道开头的数据属于哪种协议（TLS 的 SNI/ALPN、HTTP、SSH）并记录日志，用于排查问题
+	Sniff bool `json:"sniff"`
+
+	// PAC 文件服务（可选），浏览器配置 http://pac_addr/pac_path 即可自动使用本地代理
+	PACAddr   string     `json:"pac_addr"`   // 监听地址，如 "127.0.0.1:8090"，为空表示不启用
+	PACPath   string     `json:"pac_path"`   // 默认 /proxy.pac
+	PACDirect StringList `json:"pac_direct"` // 直连的域名、通配符或 IPv4 CIDR，不带点的主机名总是直连
+
+	AdminAddr string `json:"admin_addr"` // 管理接口监听地址（/connections），没有认证，应只监听本机地址；为空表示不启用
+
+	// 允许连接 SOCKS5/HTTP 入口的客户端 IP 或 CIDR，如 ["127.0.0.1", "192.168.1.0/24"]，不在其中的连接在协商前直接关闭
+	// 为空时监听在回环地址的入口不限制，监听在其它地址的入口只允许本机连接；允许所有来源可设为 ["0.0.0.0/0", "::/0"]
+	ClientAllow StringList `json:"client_allow"`
+
+	// 服务器拨号熔断：连续 breaker_threshold 次连接或握手失败后，breaker_cooldown 秒内新连接直接失败
+	BreakerThreshold int `json:"breaker_threshold"` // 0 表示不启用
+	BreakerCooldown  int `json:"breaker_cooldown"`  // 秒
+
+	// 是否接受 Tor 扩展的 SOCKS5 RESOLVE/RESOLVE_PTR 命令，通过服务端解析而不建立连接
+	SOCKSResolve bool `json:"socks_resolve"`
+
+	// 服务器不可达、熔断或握手失败时改为直连目标，流量不再经过代理与加密，每次直连都会记录 warn 日志
+	FallbackDirect bool `json:"fallback_direct"`
+
+	// 多路复用：SOCKS5 与 HTTP 隧道共用 mux_connections 条长连接，新隧道不再单独握手；需要服务端同样支持
+	Mux            bool `json:"mux"`
+	MuxConnections int  `json:"mux_connections"` // 0 表示 1 条
 }
 
+// 上游代理类型，用于 upstream_type
+const (
+	UpstreamSOCKS5 = "socks5"
+	UpstreamHTTP   = "http"
+)
+
+// 握手认证失败时的诱饵响应类型，用于 decoy
+const (
+	DecoyNone = "none"
+	DecoyHTTP = "http"
+	DecoySSH  = "ssh"
+)
+
 // LoadServerConfig 加载服务端配置
 func LoadServerConfig() (*ServerConfig, error) {
 	// 默认配置
 	cfg := &ServerConfig{
-		Port:      8081,
-		Password:  "",
-		Timeout:   30,
-		LogLevel:  "info",
-		Obfuscate: false,
+		ListenAddr:              "0.0.0.0",
+		Port:                    8081,
+		Password:                "",
+		Timeout:                 30,
+		LogLevel:                "info",
+		LogFormat:               "text",
+		Obfuscate:               false,
+		Cipher:                  cipher.SuiteXChaCha20Poly1305,
+		LogMaxSize:              100,
+		LogMaxBackups:           5,
+		LogMaxAge:               30,
+		UpstreamType:            UpstreamSOCKS5,
+		Decoy:                   DecoyNone,
+		UpstreamPoolIdle:        60,
+		UpstreamRetries:         2,
+		UpstreamRetryDelay:      100,
+		DialNetwork:             "tcp",
+		ShutdownGrace:           30,
+		AuthTimeout:             5,
+		MaxPendingHandshakes:    256,
+		MaxConcurrentHandshakes: 8,
+		ConnRateBurst:           10,
+		TCPKeepAlive:            30,
+		LowLatency:              true,
 	}
 
 	// 命令行参数
 	var configFile string
-	flag.StringVar(&configFile, "c", "", "配置文件路径")
+	var showVersion bool
+	flag.StringVar(&configFile, "c", "", "配置文件路径，多个文件用逗号分隔，- 表示从标准输入读取")
 	flag.IntVar(&cfg.Port, "p", cfg.Port, "监听端口")
-	flag.StringVar(&cfg.Password, "k", "", "加密密码")
+	flag.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "监听地址，IP 地址或 host:port")
+	flag.BoolVar(&cfg.ProxyProtocol, "proxy-protocol", cfg.ProxyProtocol, "连接带有 PROXY protocol 头部（位于负载均衡器之后）")
+	flag.BoolVar(&cfg.Sniff, "sniff", cfg.Sniff, "记录每条隧道开头数据的协议（调试用）")
+	flag.StringVar(&cfg.Decoy, "decoy", cfg.Decoy, "握手认证失败时的诱饵响应 (none/http/ssh)")
+	flag.BoolVar(&cfg.SelfTest, "selftest", false, "在本机回环地址上测量隧道吞吐量后退出")
+	flag.BoolVar(&cfg.SelfTestJSON, "json", false, "以 JSON 格式输出 -selftest 的结果")
+	flag.StringVar(&cfg.Password, "k", "", "加密密码，@/path 表示从文件读取")
+	flag.StringVar(&cfg.PasswordFile, "password-file", "", "密码文件路径")
 	flag.IntVar(&cfg.Timeout, "t", cfg.Timeout, "连接超时（秒）")
 	flag.StringVar(&cfg.LogLevel, "l", cfg.LogLevel, "日志级别 (debug/info/warn/error)")
+	flag.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "日志格式 (text/json)")
+	flag.StringVar(&cfg.LogFile, "log-file", "", "日志文件路径（默认输出到 stdout）")
 	flag.BoolVar(&cfg.Obfuscate, "o", cfg.Obfuscate, "启用流量混淆")
+	flag.BoolVar(&cfg.Compress, "compress", cfg.Compress, "压缩隧道数据")
+	flag.BoolVar(&cfg.Plaintext, "plaintext", cfg.Plaintext, "不加密隧道数据（仅限可信网络）")
+	flag.StringVar(&cfg.Cipher, "cipher", cfg.Cipher, "加密套件 (xchacha20-poly1305/chacha20-poly1305)")
+	flag.IntVar(&cfg.RekeyAfter, "rekey-after", cfg.RekeyAfter, "每个方向发送多少 MB 数据后换钥，0 表示不换钥")
+	flag.IntVar(&cfg.KeepaliveInterval, "keepalive-interval", cfg.KeepaliveInterval, "隧道空闲多少秒后发送 ping，0 表示不发送")
+	flag.StringVar(&cfg.IdentityKey, "identity-key", "", "服务端身份密钥文件路径，不存在时自动生成")
+	flag.BoolVar(&showVersion, "version", false, "显示版本信息并退出")
 	flag.Parse()
 
+	// 版本查询不需要其它配置
+	if showVersion {
+		return nil, ErrShowVersion
+	}
+
 	// 如果指定了配置文件，先加载文件配置
 	if configFile != "" {
-		if err := loadConfigFromFile(configFile, cfg); err != nil {
+		if err := loadConfigFiles(configFile, cfg); err != nil {
 			return nil, fmt.Errorf("failed to load config file: %w", err)
 		}
 	}
@@ -65,9 +400,50 @@ func LoadServerConfig() (*ServerConfig, error) {
 	// 命令行参数会覆盖配置文件（通过重新解析 flag 实现）
 	// 这里简化处理，命令行参数优先级更高
 
+	password, err := resolvePassword(cfg.Password, cfg.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Password = password
+
+	if err := resolveCredentials(cfg); err != nil {
+		return nil, err
+	}
+
 	// 验证必填参数
-	if cfg.Password == "" {
-		return nil, fmt.Errorf("password is required (use -k flag or config file)")
+	if cfg.Password == "" && cfg.UsersFile == "" && len(cfg.Credentials) == 0 && !cfg.SelfTest {
+		return nil, fmt.Errorf("password is required (use -k, -password-file, users_file or config file)")
+	}
+
+	if cfg.Plaintext && cfg.Obfuscate {
+		return nil, fmt.Errorf("plaintext and obfuscate cannot be enabled together")
+	}
+	if err := validateCipher(cfg.Cipher); err != nil {
+		return nil, err
+	}
+	if cfg.MaxTargetLen > protocol.MaxTargetLen {
+		return nil, fmt.Errorf("invalid max_target_len %d: must not exceed %d", cfg.MaxTargetLen, protocol.MaxTargetLen)
+	}
+	if _, err := parseListenAddr(cfg.ListenAddr, cfg.Port); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Decoy {
+	case "", DecoyNone, DecoyHTTP, DecoySSH:
+	default:
+		return nil, fmt.Errorf("invalid decoy %q: must be %s, %s or %s", cfg.Decoy, DecoyNone, DecoyHTTP, DecoySSH)
+	}
+
+	switch cfg.UpstreamType {
+	case "", UpstreamSOCKS5, UpstreamHTTP:
+	default:
+		return nil, fmt.Errorf("invalid upstream_type %q: must be %s or %s", cfg.UpstreamType, UpstreamSOCKS5, UpstreamHTTP)
+	}
+
+	switch cfg.DialNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("invalid dial_network %q: must be tcp, tcp4 or tcp6", cfg.DialNetwork)
 	}
 
 	return cfg, nil
@@ -77,50 +453,214 @@ func LoadServerConfig() (*ServerConfig, error) {
 func LoadLocalConfig() (*LocalConfig, error) {
 	// 默认配置
 	cfg := &LocalConfig{
-		LocalAddr:     "127.0.0.1:1080",
-		Server:        "",
-		Password:      "",
-		Timeout:       30,
-		LogLevel:      "info",
-		Obfuscate:     false,
-		HTTPProxyAddr: "127.0.0.1:8080", // 默认 HTTP 代理端口
-		AutoProxy:     true,              // 默认启用自动代理
+		LocalAddr:        StringList{"127.0.0.1:1080"},
+		Server:           "",
+		Password:         "",
+		Timeout:          30,
+		LogLevel:         "info",
+		LogFormat:        "text",
+		Obfuscate:        false,
+		Cipher:           cipher.SuiteXChaCha20Poly1305,
+		HTTPProxyAddr:    StringList{"127.0.0.1:8080"}, // 默认 HTTP 代理端口
+		AutoProxy:        true,                         // 默认启用自动代理
+		SystemProxy:      StringList{"http", "https"},
+		UnixSocketMode:   "0600",
+		TestTarget:       "www.google.com:80",
+		LogMaxSize:       100,
+		LogMaxBackups:    5,
+		LogMaxAge:        30,
+		TCPKeepAlive:     30,
+		LowLatency:       true,
+		SOCKSResolve:     true,
+		BreakerThreshold: 5,
+		BreakerCooldown:  10,
+		PACPath:          defaultPACPath,
+		PACDirect:        StringList{"localhost", "127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
 	}
 
 	// 命令行参数
 	var configFile string
-	flag.StringVar(&configFile, "c", "", "配置文件路径")
-	flag.StringVar(&cfg.LocalAddr, "b", cfg.LocalAddr, "本地监听地址")
+	var showVersion bool
+	flag.StringVar(&configFile, "c", "", "配置文件路径，多个文件用逗号分隔，- 表示从标准输入读取")
+	flag.Var(&cfg.LocalAddr, "b", "本地监听地址，多个地址用逗号分隔")
 	flag.StringVar(&cfg.Server, "s", "", "服务器地址")
-	flag.StringVar(&cfg.Password, "k", "", "加密密码")
+	flag.StringVar(&cfg.Password, "k", "", "加密密码，@/path 表示从文件读取")
+	flag.StringVar(&cfg.PasswordFile, "password-file", "", "密码文件路径")
 	flag.IntVar(&cfg.Timeout, "t", cfg.Timeout, "连接超时（秒）")
 	flag.StringVar(&cfg.LogLevel, "l", cfg.LogLevel, "日志级别 (debug/info/warn/error)")
+	flag.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "日志格式 (text/json)")
+	flag.StringVar(&cfg.LogFile, "log-file", "", "日志文件路径（默认输出到 stdout）")
+	flag.StringVar(&cfg.AccessLog, "access-log", "", "访问日志文件路径")
 	flag.BoolVar(&cfg.Obfuscate, "o", cfg.Obfuscate, "启用流量混淆")
-	flag.StringVar(&cfg.HTTPProxyAddr, "http", cfg.HTTPProxyAddr, "HTTP 代理监听地址")
+	flag.BoolVar(&cfg.Compress, "compress", cfg.Compress, "压缩隧道数据")
+	flag.BoolVar(&cfg.Plaintext, "plaintext", cfg.Plaintext, "不加密隧道数据（仅限可信网络）")
+	flag.StringVar(&cfg.Cipher, "cipher", cfg.Cipher, "加密套件 (xchacha20-poly1305/chacha20-poly1305)")
+	flag.IntVar(&cfg.RekeyAfter, "rekey-after", cfg.RekeyAfter, "每个方向发送多少 MB 数据后换钥，0 表示不换钥")
+	flag.StringVar(&cfg.ServerFingerprint, "fingerprint", "", "服务端身份密钥的指纹，设置后验证服务端身份")
+	flag.BoolVar(&cfg.Negotiate, "negotiate", cfg.Negotiate, "混淆与加密套件自动采用服务端的设置")
+	flag.IntVar(&cfg.KeepaliveInterval, "keepalive-interval", cfg.KeepaliveInterval, "隧道空闲多少秒后发送 ping，0 表示不发送")
+	flag.Var(&cfg.HTTPProxyAddr, "http", "HTTP 代理监听地址，多个地址用逗号分隔")
+	flag.StringVar(&cfg.CombinedAddr, "combined", "", "同时接受 SOCKS5 与 HTTP CONNECT 的监听地址")
+	flag.StringVar(&cfg.PACAddr, "pac", "", "PAC 文件服务监听地址")
+	flag.StringVar(&cfg.AdminAddr, "admin", "", "管理接口监听地址")
+	flag.Var(&cfg.ClientAllow, "client-allow", "允许连接的客户端 IP 或 CIDR，多个用逗号分隔")
 	flag.BoolVar(&cfg.AutoProxy, "auto-proxy", cfg.AutoProxy, "自动设置系统代理")
+	flag.Var(&cfg.SystemProxy, "system-proxy", "自动设置系统代理的协议 (http,https,socks)，多个用逗号分隔")
+	flag.Var(&cfg.ProxyBypass, "proxy-bypass", "自动设置系统代理时不走代理的地址，多个用逗号分隔")
+	flag.BoolVar(&cfg.FallbackDirect, "fallback-direct", cfg.FallbackDirect, "服务器不可用时直连目标（流量不经过代理）")
+	flag.BoolVar(&cfg.Mux, "mux", cfg.Mux, "多条隧道共用到服务器的长连接")
+	flag.BoolVar(&cfg.Sniff, "sniff", cfg.Sniff, "记录每条隧道开头数据的协议（调试用）")
+	flag.StringVar(&cfg.UnixSocketMode, "socket-mode", cfg.UnixSocketMode, "Unix 套接字文件权限（八进制）")
+	flag.BoolVar(&cfg.SelfTest, "test", false, "检查与服务器的连通性后退出")
+	flag.BoolVar(&cfg.SelfTestJSON, "json", false, "以 JSON 格式输出 -test 的结果")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "只在日志中输出将要进行的系统代理修改，不实际执行")
+	flag.StringVar(&cfg.TestTarget, "test-target", cfg.TestTarget, "自检时请求连接的目标")
+	flag.BoolVar(&showVersion, "version", false, "显示版本信息并退出")
 	flag.Parse()
 
+	// 版本查询不需要其它配置
+	if showVersion {
+		return nil, ErrShowVersion
+	}
+
 	// 如果指定了配置文件，先加载文件配置
 	if configFile != "" {
-		if err := loadConfigFromFile(configFile, cfg); err != nil {
+		if err := loadConfigFiles(configFile, cfg); err != nil {
 			return nil, fmt.Errorf("failed to load config file: %w", err)
 		}
 	}
 
+	password, err := resolvePassword(cfg.Password, cfg.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Password = password
+
 	// 验证必填参数
+	if len(cfg.LocalAddr) == 0 {
+		return nil, fmt.Errorf("local address is required (use -b flag or config file)")
+	}
 	if cfg.Server == "" {
 		return nil, fmt.Errorf("server address is required (use -s flag or config file)")
 	}
 	if cfg.Password == "" {
-		return nil, fmt.Errorf("password is required (use -k flag or config file)")
+		return nil, fmt.Errorf("password is required (use -k, -password-file or config file)")
+	}
+	if cfg.Plaintext && cfg.Obfuscate {
+		return nil, fmt.Errorf("plaintext and obfuscate cannot be enabled together")
+	}
+	if err := validateCipher(cfg.Cipher); err != nil {
+		return nil, err
+	}
+	if cfg.MaxTargetLen > protocol.MaxTargetLen {
+		return nil, fmt.Errorf("invalid max_target_len %d: must not exceed %d", cfg.MaxTargetLen, protocol.MaxTargetLen)
+	}
+	if cfg.MuxConnections < 0 {
+		return nil, fmt.Errorf("invalid mux_connections %d: must not be negative", cfg.MuxConnections)
+	}
+	if cfg.ServerFingerprint != "" {
+		fp, err := protocol.NormalizeFingerprint(cfg.ServerFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerFingerprint = fp
+	}
+	if _, err := cfg.GetUnixSocketMode(); err != nil {
+		return nil, err
+	}
+	for _, protocol := range cfg.SystemProxy {
+		if protocol != "http" && protocol != "https" && protocol != "socks" {
+			return nil, fmt.Errorf("invalid system_proxy protocol %q (expected http, https or socks)", protocol)
+		}
+	}
+	if err := sysproxy.ValidateBypass(cfg.ProxyBypass); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
-// loadConfigFromFile 从 JSON 文件加载配置
+// validateCipher 检查 cipher 是否为支持的加密套件，为空表示默认套件
+func validateCipher(suite string) error {
+	switch suite {
+	case "", cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305:
+		return nil
+	}
+	return fmt.Errorf("invalid cipher %q: must be %s or %s", suite, cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305)
+}
+
+// resolveCredentials 读取 credentials 中以 @ 开头的密码文件，并检查密码与加密套件
+// 同一密码可以对应多个加密套件，但密码与加密套件都相同的凭据（包括 password 与 cipher）不能重复
+func resolveCredentials(cfg *ServerConfig) error {
+	seen := make(map[Credential]bool)
+	if cfg.Password != "" {
+		seen[Credential{Password: cfg.Password, Cipher: cfg.GetCipher()}] = true
+	}
+	for i := range cfg.Credentials {
+		c := &cfg.Credentials[i]
+		password, err := resolvePassword(c.Password, "")
+		if err != nil {
+			return fmt.Errorf("credentials[%d]: %w", i, err)
+		}
+		if password == "" {
+			return fmt.Errorf("credentials[%d]: password is required", i)
+		}
+		c.Password = password
+		if err := validateCipher(c.Cipher); err != nil {
+			return fmt.Errorf("credentials[%d]: %w", i, err)
+		}
+		key := Credential{Password: c.Password, Cipher: c.GetCipher(cfg.GetCipher())}
+		if seen[key] {
+			return fmt.Errorf("credentials[%d]: duplicate password and cipher", i)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// stdinConfigPath -c 参数为此值时从标准输入读取配置，密码不必落盘
+const stdinConfigPath = "-"
+
+// loadConfigFiles 按顺序加载逗号分隔的多个配置文件，后面的文件只覆盖其中出现的字段
+// 对象类型的字段（如 front_map）按键合并，数组整体替换
+func loadConfigFiles(paths string, cfg interface{}) error {
+	stdin := false
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if path == stdinConfigPath {
+			if stdin {
+				return fmt.Errorf("standard input can only be read once")
+			}
+			stdin = true
+		}
+		if err := loadConfigFromFile(path, cfg); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// loadConfigFromFile 从 JSON 文件加载配置，path 为 "-" 时读取标准输入
 func loadConfigFromFile(path string, cfg interface{}) error {
-	data, err := os.ReadFile(path)
+	if path == stdinConfigPath {
+		return loadConfig(os.Stdin, cfg)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return loadConfig(f, cfg)
+}
+
+// loadConfig 从 r 读取 JSON 配置，只覆盖其中出现的字段
+func loadConfig(r io.Reader, cfg interface{}) error {
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
@@ -132,17 +672,276 @@ func loadConfigFromFile(path string, cfg interface{}) error {
 	return nil
 }
 
+// GetListenAddr 获取服务端监听的 host:port，listen_addr 无效时返回 ""（LoadServerConfig 已校验）
+func (c *ServerConfig) GetListenAddr() string {
+	addr, _ := parseListenAddr(c.ListenAddr, c.Port)
+	return addr
+}
+
+// parseListenAddr 将 listen_addr 与 port 组合为 host:port
+// listen_addr 为空时使用 0.0.0.0，本身带端口时忽略 port；主机部分必须是 IP 地址，IPv6 链路本地地址可带 %zone
+func parseListenAddr(listen string, port int) (string, error) {
+	listen = strings.TrimSpace(listen)
+	if listen == "" {
+		listen = "0.0.0.0"
+	}
+	host, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		host, portStr = strings.Trim(listen, "[]"), strconv.Itoa(port)
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		return "", fmt.Errorf("invalid listen_addr %q: host must be an IP address", listen)
+	}
+	if p, err := strconv.Atoi(portStr); err != nil || p < 0 || p > 65535 {
+		return "", fmt.Errorf("invalid listen_addr %q: invalid port %q", listen, portStr)
+	}
+	return net.JoinHostPort(host, portStr), nil
+}
+
 // GetTimeout 获取超时时间
 func (c *ServerConfig) GetTimeout() time.Duration {
 	return time.Duration(c.Timeout) * time.Second
 }
 
+// GetHandshakeTimeout 获取建连与握手阶段的超时时间，未配置时使用 timeout
+func (c *ServerConfig) GetHandshakeTimeout() time.Duration {
+	if c.HandshakeTimeout > 0 {
+		return time.Duration(c.HandshakeTimeout) * time.Second
+	}
+	return c.GetTimeout()
+}
+
+// GetConnectTimeout 获取连接目标的超时时间，未配置时使用 handshake_timeout
+func (c *ServerConfig) GetConnectTimeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return time.Duration(c.ConnectTimeout) * time.Second
+	}
+	return c.GetHandshakeTimeout()
+}
+
+// GetAuthTimeout 获取读取客户端握手数据的超时时间，未配置时为 defaultAuthTimeout
+func (c *ServerConfig) GetAuthTimeout() time.Duration {
+	if c.AuthTimeout > 0 {
+		return time.Duration(c.AuthTimeout) * time.Second
+	}
+	return defaultAuthTimeout
+}
+
+// GetIdleTimeout 获取隧道空闲超时时间，0 表示不限制
+func (c *ServerConfig) GetIdleTimeout() time.Duration {
+	return time.Duration(c.IdleTimeout) * time.Second
+}
+
+// GetKeepaliveInterval 获取隧道空闲多久后发送 ping，0 表示不发送
+func (c *ServerConfig) GetKeepaliveInterval() time.Duration {
+	return time.Duration(c.KeepaliveInterval) * time.Second
+}
+
+// GetKeepaliveTimeout 获取等待 ping 应答的时间，未配置时为 defaultKeepaliveTimeout
+func (c *ServerConfig) GetKeepaliveTimeout() time.Duration {
+	if c.KeepaliveTimeout > 0 {
+		return time.Duration(c.KeepaliveTimeout) * time.Second
+	}
+	return defaultKeepaliveTimeout
+}
+
+// GetWriteTimeout 获取转发时单次写入的超时时间，0 表示不限制
+func (c *ServerConfig) GetWriteTimeout() time.Duration {
+	return time.Duration(c.WriteTimeout) * time.Second
+}
+
+// IsHTTPUpstream 检查上游代理是否为 HTTP CONNECT 代理
+func (c *ServerConfig) IsHTTPUpstream() bool {
+	return c.UpstreamType == UpstreamHTTP
+}
+
 // HasUpstreamProxy 检查是否配置了上游代理
 func (c *ServerConfig) HasUpstreamProxy() bool {
-	return c.UpstreamProxy != ""
+	return len(c.UpstreamProxy) > 0
+}
+
+// GetTCPKeepAlive 获取 TCP keepalive 间隔
+func (c *ServerConfig) GetTCPKeepAlive() time.Duration {
+	return time.Duration(c.TCPKeepAlive) * time.Second
+}
+
+// GetTCPNoDelay 获取是否禁用 Nagle 算法，未设置时为 true
+func (c *ServerConfig) GetTCPNoDelay() bool {
+	return c.TCPNoDelay == nil || *c.TCPNoDelay
+}
+
+// GetRateLimit 获取单条隧道的带宽上限（字节/秒），0 表示不限制
+func (c *ServerConfig) GetRateLimit() float64 {
+	return float64(c.RateLimitKbps) * 1000 / 8
+}
+
+// IsPortAllowed 检查是否允许连接目标端口
+func (c *ServerConfig) IsPortAllowed(port uint16) bool {
+	if c.BlockedPorts.Contains(port) {
+		return false
+	}
+	return len(c.AllowedPorts) == 0 || c.AllowedPorts.Contains(port)
+}
+
+// GetDialFallbackDelay 获取双栈拨号的回退延迟，语义同 net.Dialer.FallbackDelay
+func (c *ServerConfig) GetDialFallbackDelay() time.Duration {
+	return time.Duration(c.DialFallbackDelay) * time.Millisecond
+}
+
+// GetDialNetwork 获取直连目标使用的网络，未设置时为 tcp
+func (c *ServerConfig) GetDialNetwork() string {
+	if c.DialNetwork == "" {
+		return "tcp"
+	}
+	return c.DialNetwork
+}
+
+// GetRekeyAfter 获取换钥间隔（字节），0 表示不换钥
+func (c *ServerConfig) GetRekeyAfter() uint64 {
+	if c.RekeyAfter <= 0 {
+		return 0
+	}
+	return uint64(c.RekeyAfter) << 20
+}
+
+// GetMaxTargetLen 获取目标地址最大长度，未配置时为 protocol.MaxTargetLen
+func (c *ServerConfig) GetMaxTargetLen() int {
+	if c.MaxTargetLen <= 0 {
+		return protocol.MaxTargetLen
+	}
+	return c.MaxTargetLen
+}
+
+// GetCipher 获取加密套件，未设置时为 xchacha20-poly1305
+func (c *ServerConfig) GetCipher() string {
+	if c.Cipher == "" {
+		return cipher.SuiteXChaCha20Poly1305
+	}
+	return c.Cipher
+}
+
+// GetShutdownGrace 获取退出时等待连接结束的最长时间
+func (c *ServerConfig) GetShutdownGrace() time.Duration {
+	return time.Duration(c.ShutdownGrace) * time.Second
+}
+
+// GetUpstreamPoolIdle 获取上游空闲连接保留时间
+func (c *ServerConfig) GetUpstreamPoolIdle() time.Duration {
+	return time.Duration(c.UpstreamPoolIdle) * time.Second
+}
+
+// GetUpstreamRetryDelay 获取上游拨号首次重试前的等待时间
+func (c *ServerConfig) GetUpstreamRetryDelay() time.Duration {
+	return time.Duration(c.UpstreamRetryDelay) * time.Millisecond
 }
 
 // GetTimeout 获取超时时间
 func (c *LocalConfig) GetTimeout() time.Duration {
 	return time.Duration(c.Timeout) * time.Second
 }
+
+// GetHandshakeTimeout 获取建连与握手阶段的超时时间，未配置时使用 timeout
+func (c *LocalConfig) GetHandshakeTimeout() time.Duration {
+	if c.HandshakeTimeout > 0 {
+		return time.Duration(c.HandshakeTimeout) * time.Second
+	}
+	return c.GetTimeout()
+}
+
+// GetPACPath 获取 PAC 文件路径，保证以 / 开头
+func (c *LocalConfig) GetPACPath() string {
+	if c.PACPath == "" {
+		return defaultPACPath
+	}
+	if !strings.HasPrefix(c.PACPath, "/") {
+		return "/" + c.PACPath
+	}
+	return c.PACPath
+}
+
+// GetIdleTimeout 获取隧道空闲超时时间，0 表示不限制
+func (c *LocalConfig) GetIdleTimeout() time.Duration {
+	return time.Duration(c.IdleTimeout) * time.Second
+}
+
+// GetKeepaliveInterval 获取隧道空闲多久后发送 ping，0 表示不发送
+func (c *LocalConfig) GetKeepaliveInterval() time.Duration {
+	return time.Duration(c.KeepaliveInterval) * time.Second
+}
+
+// GetKeepaliveTimeout 获取等待 ping 应答的时间，未配置时为 defaultKeepaliveTimeout
+func (c *LocalConfig) GetKeepaliveTimeout() time.Duration {
+	if c.KeepaliveTimeout > 0 {
+		return time.Duration(c.KeepaliveTimeout) * time.Second
+	}
+	return defaultKeepaliveTimeout
+}
+
+// GetWriteTimeout 获取转发时单次写入的超时时间，0 表示不限制
+func (c *LocalConfig) GetWriteTimeout() time.Duration {
+	return time.Duration(c.WriteTimeout) * time.Second
+}
+
+// GetTCPKeepAlive 获取 TCP keepalive 间隔
+func (c *LocalConfig) GetTCPKeepAlive() time.Duration {
+	return time.Duration(c.TCPKeepAlive) * time.Second
+}
+
+// GetTCPNoDelay 获取是否禁用 Nagle 算法，未设置时为 true
+func (c *LocalConfig) GetTCPNoDelay() bool {
+	return c.TCPNoDelay == nil || *c.TCPNoDelay
+}
+
+// GetRateLimit 获取单条隧道的带宽上限（字节/秒），0 表示不限制
+func (c *LocalConfig) GetRateLimit() float64 {
+	return float64(c.RateLimitKbps) * 1000 / 8
+}
+
+// GetRekeyAfter 获取换钥间隔（字节），0 表示不换钥
+func (c *LocalConfig) GetRekeyAfter() uint64 {
+	if c.RekeyAfter <= 0 {
+		return 0
+	}
+	return uint64(c.RekeyAfter) << 20
+}
+
+// GetMaxTargetLen 获取目标地址最大长度，未配置时为 protocol.MaxTargetLen
+func (c *LocalConfig) GetMaxTargetLen() int {
+	if c.MaxTargetLen <= 0 {
+		return protocol.MaxTargetLen
+	}
+	return c.MaxTargetLen
+}
+
+// GetCipher 获取加密套件，未设置时为 xchacha20-poly1305
+func (c *LocalConfig) GetCipher() string {
+	if c.Cipher == "" {
+		return cipher.SuiteXChaCha20Poly1305
+	}
+	return c.Cipher
+}
+
+// GetBreakerCooldown 获取熔断冷却时间
+func (c *LocalConfig) GetBreakerCooldown() time.Duration {
+	return time.Duration(c.BreakerCooldown) * time.Second
+}
+
+// GetMuxConnections 获取多路复用的长连接数量，未配置时为 1
+func (c *LocalConfig) GetMuxConnections() int {
+	if c.MuxConnections <= 0 {
+		return 1
+	}
+	return c.MuxConnections
+}
+
+// GetUnixSocketMode 获取 Unix 套接字文件权限，未配置时为 0600
+func (c *LocalConfig) GetUnixSocketMode() (os.FileMode, error) {
+	if c.UnixSocketMode == "" {
+		return 0600, nil
+	}
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid unix_socket_mode %q (expected octal like \"0600\")", c.UnixSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
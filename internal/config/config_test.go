@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFromReader(t *testing.T) {
+	cfg := &LocalConfig{Server: "default:8081", Timeout: 30}
+	err := loadConfig(strings.NewReader(`{"password": "from-reader", "timeout": 10}`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Password != "from-reader" || cfg.Timeout != 10 {
+		t.Fatalf("password = %q, timeout = %d", cfg.Password, cfg.Timeout)
+	}
+	// 未出现的字段保持原值
+	if cfg.Server != "default:8081" {
+		t.Fatalf("server = %q, want the default kept", cfg.Server)
+	}
+
+	if err := loadConfig(strings.NewReader(`{"timeout": "ten"}`), cfg); err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
+
+// withStdin 将 os.Stdin 替换为内容为 data 的文件
+func withStdin(t *testing.T, data string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		f.Close()
+	})
+}
+
+// writeConfig 在临时目录中写入名为 name 的配置文件，返回路径
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFilesOverride(t *testing.T) {
+	shared := writeConfig(t, "shared.json", `{
+		"port": 8443,
+		"cipher": "chacha20-poly1305",
+		"obfuscate": true,
+		"timeout": 60,
+		"upstream_proxy": ["first:1080", "second:1080"],
+		"front_map": {"front.example": "a.example"}
+	}`)
+	personal := writeConfig(t, "personal.json", `{
+		"password": "mine",
+		"obfuscate": false,
+		"upstream_proxy": ["only:1080"],
+		"front_map": {"cdn.example": "b.example"}
+	}`)
+
+	cfg := &ServerConfig{Port: 8081}
+	if err := loadConfigFiles(shared+","+personal, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二个文件只覆盖其中出现的字段，出现的零值同样覆盖
+	if cfg.Port != 8443 || cfg.Cipher != "chacha20-poly1305" || cfg.Timeout != 60 {
+		t.Fatalf("shared settings lost: port %d, cipher %q, timeout %d", cfg.Port, cfg.Cipher, cfg.Timeout)
+	}
+	if cfg.Password != "mine" || cfg.Obfuscate {
+		t.Fatalf("password = %q, obfuscate = %v, want the second file's values", cfg.Password, cfg.Obfuscate)
+	}
+	// 数组整体替换，对象按键合并
+	if strings.Join(cfg.UpstreamProxy, ",") != "only:1080" {
+		t.Fatalf("upstream_proxy = %v, want the second file's list", cfg.UpstreamProxy)
+	}
+	if len(cfg.FrontMap) != 2 || cfg.FrontMap["front.example"] != "a.example" || cfg.FrontMap["cdn.example"] != "b.example" {
+		t.Fatalf("front_map = %v, want both files' entries", cfg.FrontMap)
+	}
+}
+
+func TestLoadConfigFilesError(t *testing.T) {
+	valid := writeConfig(t, "valid.json", `{"password": "x"}`)
+	invalid := writeConfig(t, "invalid.json", `{"password": `)
+	missing := filepath.Join(t.TempDir(), "missing.json")
+
+	for _, bad := range []string{invalid, missing} {
+		err := loadConfigFiles(valid+","+bad, &ServerConfig{})
+		if err == nil || !strings.Contains(err.Error(), bad) {
+			t.Errorf("err = %v, want an error naming %s", err, bad)
+		}
+	}
+}
+
+func TestLoadConfigFilesStdin(t *testing.T) {
+	withStdin(t, `{"server": "stdin:8081", "password": "secret"}`)
+	override := filepath.Join(t.TempDir(), "override.json")
+	if err := os.WriteFile(override, []byte(`{"server": "file:8081"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 标准输入与文件按顺序叠加，后面的只覆盖其中出现的字段
+	cfg := &LocalConfig{}
+	if err := loadConfigFiles("-, "+override, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server != "file:8081" || cfg.Password != "secret" {
+		t.Fatalf("server = %q, password = %q", cfg.Server, cfg.Password)
+	}
+}
+
+func TestLoadConfigFilesStdinOnlyOnce(t *testing.T) {
+	withStdin(t, `{}`)
+	if err := loadConfigFiles("-,-", &LocalConfig{}); err == nil {
+		t.Fatal("expected an error when standard input is listed twice")
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// passwordFilePrefix -k 参数以此开头时表示从文件读取密码，如 -k @/etc/go-proxy-eins/password
+const passwordFilePrefix = "@"
+
+// resolvePassword 根据 password 与 password_file 得到最终密码
+// 两者不能同时指定；password 为 "@/path" 形式时等同于 password_file
+func resolvePassword(password, passwordFile string) (string, error) {
+	if strings.HasPrefix(password, passwordFilePrefix) {
+		if passwordFile != "" {
+			return "", fmt.Errorf("password and password_file are mutually exclusive")
+		}
+		passwordFile = strings.TrimPrefix(password, passwordFilePrefix)
+		password = ""
+	}
+
+	if passwordFile == "" {
+		return password, nil
+	}
+	if password != "" {
+		return "", fmt.Errorf("password and password_file are mutually exclusive")
+	}
+	return readPasswordFile(passwordFile)
+}
+
+// readPasswordFile 读取密码文件并去掉末尾换行，文件对其他用户可读时输出警告
+func readPasswordFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	// 日志尚未初始化，直接输出到 stderr；Windows 上权限位没有意义
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0004 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: password file %s is world-readable (mode %04o), consider chmod 600\n", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return password, nil
+}
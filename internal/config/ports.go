@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange 端口区间（闭区间）
+type PortRange struct {
+	Low, High uint16
+}
+
+// PortList 端口列表，JSON 中每项可以是数字、"443" 或 "1-1024"
+type PortList []PortRange
+
+// UnmarshalJSON 实现 json.Unmarshaler
+func (l *PortList) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("expected array of ports: %w", err)
+	}
+
+	list := make(PortList, 0, len(items))
+	for _, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err != nil {
+			var n int
+			if err := json.Unmarshal(item, &n); err != nil {
+				return fmt.Errorf("invalid port entry %s", item)
+			}
+			s = strconv.Itoa(n)
+		}
+
+		r, err := ParsePortRange(s)
+		if err != nil {
+			return err
+		}
+		list = append(list, r)
+	}
+
+	*l = list
+	return nil
+}
+
+// ParsePortRange 解析 "443" 或 "1-1024" 形式的端口区间
+func ParsePortRange(s string) (PortRange, error) {
+	low, high, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		high = low
+	}
+
+	lo, err := parsePort(low)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	hi, err := parsePort(high)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if lo > hi {
+		return PortRange{}, fmt.Errorf("invalid port range %q: start is greater than end", s)
+	}
+
+	return PortRange{Low: lo, High: hi}, nil
+}
+
+// Contains 检查端口是否在列表中
+func (l PortList) Contains(port uint16) bool {
+	for _, r := range l {
+		if port >= r.Low && port <= r.High {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePort 解析 1-65535 的端口号
+func parsePort(s string) (uint16, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("port must be 1-65535")
+	}
+	return uint16(n), nil
+}
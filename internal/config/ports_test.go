@@ -0,0 +1,57 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPortListUnmarshal(t *testing.T) {
+	var l PortList
+	if err := json.Unmarshal([]byte(`[80, "443", "1000-2000", " 8080 "]`), &l); err != nil {
+		t.Fatal(err)
+	}
+	want := PortList{{80, 80}, {443, 443}, {1000, 2000}, {8080, 8080}}
+	if len(l) != len(want) {
+		t.Fatalf("parsed %v, want %v", l, want)
+	}
+	for i := range want {
+		if l[i] != want[i] {
+			t.Fatalf("parsed %v, want %v", l, want)
+		}
+	}
+}
+
+func TestPortListUnmarshalInvalid(t *testing.T) {
+	for _, in := range []string{`"80"`, `[0]`, `[65536]`, `["2000-1000"]`, `["1-"]`, `["http"]`, `[true]`} {
+		var l PortList
+		if err := json.Unmarshal([]byte(in), &l); err == nil {
+			t.Errorf("Unmarshal(%s) = %v, want error", in, l)
+		}
+	}
+}
+
+func TestIsPortAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed PortList
+		blocked PortList
+		port    uint16
+		want    bool
+	}{
+		{"default allows all", nil, nil, 25, true},
+		{"allowed single", PortList{{443, 443}}, nil, 443, true},
+		{"not in allowed", PortList{{443, 443}}, nil, 80, false},
+		{"allowed range low", PortList{{1, 1024}}, nil, 1, true},
+		{"allowed range high", PortList{{1, 1024}}, nil, 1024, true},
+		{"outside allowed range", PortList{{1, 1024}}, nil, 1025, false},
+		{"blocked", nil, PortList{{25, 25}}, 25, false},
+		{"not blocked", nil, PortList{{25, 25}}, 26, true},
+		{"blocked wins over allowed", PortList{{1, 1024}}, PortList{{25, 25}}, 25, false},
+	}
+	for _, tt := range tests {
+		cfg := &ServerConfig{AllowedPorts: tt.allowed, BlockedPorts: tt.blocked}
+		if got := cfg.IsPortAllowed(tt.port); got != tt.want {
+			t.Errorf("%s: IsPortAllowed(%d) = %v, want %v", tt.name, tt.port, got, tt.want)
+		}
+	}
+}
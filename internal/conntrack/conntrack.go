@@ -0,0 +1,161 @@
+package conntrack
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Registry 正在转发的隧道列表，用于管理接口查看与强制关闭，同时按目标主机汇总流量
+// 方法可以并发调用；nil Registry 不记录任何隧道
+type Registry struct {
+	mu     sync.Mutex
+	conns  map[string]*Conn
+	hosts  map[string]*hostTotals // 按目标主机汇总的已结束隧道流量，见 TopHosts
+	nextID atomic.Uint64
+
+	// 全部隧道的累计统计，不受 maxHosts 淘汰影响，见 Stats
+	started time.Time
+	totals  hostTotals
+}
+
+// NewRegistry 创建空的隧道列表
+func NewRegistry() *Registry {
+	return &Registry{conns: make(map[string]*Conn), hosts: make(map[string]*hostTotals), started: time.Now()}
+}
+
+// Conn 一条被跟踪的隧道
+type Conn struct {
+	ID     string
+	Proto  string // 客户端：SOCKS5 / HTTP；服务端：tunnel
+	Client string
+	Target string
+	Start  time.Time
+
+	// BytesUp 客户端 -> 目标方向已转发的字节数，转发过程中实时累加
+	BytesUp atomic.Int64
+	// BytesDown 目标 -> 客户端方向已转发的字节数
+	BytesDown atomic.Int64
+
+	registry  *Registry
+	closers   []io.Closer
+	closeOnce sync.Once
+}
+
+// Info 隧道的快照，用于 JSON 输出
+type Info struct {
+	ID        string    `json:"id"`
+	Proto     string    `json:"proto"`
+	Client    string    `json:"client"`
+	Target    string    `json:"target"`
+	Start     time.Time `json:"start"`
+	Duration  string    `json:"duration"`
+	BytesUp   int64     `json:"bytes_up"`
+	BytesDown int64     `json:"bytes_down"`
+}
+
+// Add 登记一条隧道，closers 为关闭隧道时需要关闭的连接（通常是客户端与服务器/目标两端）
+// 关闭连接会使阻塞在读写上的转发立即返回；隧道结束时调用方需调用 Remove
+func (r *Registry) Add(proto string, client net.Addr, target string, closers ...io.Closer) *Conn {
+	c := &Conn{
+		Proto:   proto,
+		Client:  "-",
+		Target:  target,
+		Start:   time.Now(),
+		closers: closers,
+	}
+	if client != nil && client.String() != "" {
+		c.Client = client.String()
+	}
+	if r == nil {
+		return c
+	}
+
+	c.ID = strconv.FormatUint(r.nextID.Add(1), 10)
+	c.registry = r
+	r.mu.Lock()
+	r.conns[c.ID] = c
+	r.totals.connections++
+	r.countConnLocked(hostOf(target))
+	r.mu.Unlock()
+	return c
+}
+
+// Remove 从列表中移除隧道并把流量计入目标主机的汇总，可重复调用
+func (c *Conn) Remove() {
+	r := c.registry
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if _, ok := r.conns[c.ID]; ok {
+		delete(r.conns, c.ID)
+		r.totals.bytesUp += c.BytesUp.Load()
+		r.totals.bytesDown += c.BytesDown.Load()
+		r.addBytesLocked(hostOf(c.Target), c.BytesUp.Load(), c.BytesDown.Load())
+	}
+	r.mu.Unlock()
+}
+
+// Close 关闭隧道的所有连接，可重复调用
+func (c *Conn) Close() {
+	c.closeOnce.Do(func() {
+		for _, closer := range c.closers {
+			closer.Close()
+		}
+	})
+}
+
+// Info 返回隧道当前状态的快照
+func (c *Conn) Info() Info {
+	return Info{
+		ID:        c.ID,
+		Proto:     c.Proto,
+		Client:    c.Client,
+		Target:    c.Target,
+		Start:     c.Start,
+		Duration:  time.Since(c.Start).Round(time.Second).String(),
+		BytesUp:   c.BytesUp.Load(),
+		BytesDown: c.BytesDown.Load(),
+	}
+}
+
+// List 返回所有隧道的快照，按建立时间排序
+func (r *Registry) List() []Info {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	conns := make([]*Conn, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Start.Before(conns[j].Start) })
+	infos := make([]Info, len(conns))
+	for i, c := range conns {
+		infos[i] = c.Info()
+	}
+	return infos
+}
+
+// Kill 关闭 id 对应的隧道，隧道不存在时返回 false
+// 隧道在转发结束后由其所属的 goroutine 移除
+func (r *Registry) Kill(id string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	c, ok := r.conns[id]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	c.Close()
+	return true
+}
@@ -0,0 +1,53 @@
+package conntrack
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// defaultTopHosts GET /destinations 未指定 limit 时返回的主机数
+const defaultTopHosts = 20
+
+// Register 在 mux 上注册隧道管理接口
+// GET /connections 列出正在转发的隧道；DELETE /connections/{id} 关闭一条隧道；
+// GET /destinations?limit=N 按目标主机汇总的流量，从大到小返回前 N 个（默认 20，0 表示全部）；
+// GET /stats 全部隧道的累计统计
+func Register(mux *http.ServeMux, r *Registry) {
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(r.Stats())
+	})
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, req *http.Request) {
+		infos := r.List()
+		if infos == nil {
+			infos = []Info{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+	})
+	mux.HandleFunc("GET /destinations", func(w http.ResponseWriter, req *http.Request) {
+		limit := defaultTopHosts
+		if s := req.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		top := r.TopHosts(limit)
+		if top == nil {
+			top = []HostStats{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(top)
+	})
+	mux.HandleFunc("DELETE /connections/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if !r.Kill(req.PathValue("id")) {
+			http.Error(w, "connection not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
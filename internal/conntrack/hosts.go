@@ -0,0 +1,116 @@
+package conntrack
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// maxHosts 按目标主机汇总时最多保留的主机数，超出时淘汰流量最少的主机
+const maxHosts = 1024
+
+// HostStats 一个目标主机（不含端口）的累计统计，包括正在转发的隧道
+type HostStats struct {
+	Host        string `json:"host"`
+	Connections int64  `json:"connections"` // 累计建立的隧道数
+	Active      int    `json:"active"`      // 正在转发的隧道数
+	BytesUp     int64  `json:"bytes_up"`
+	BytesDown   int64  `json:"bytes_down"`
+}
+
+// hostTotals 已结束隧道的累计流量及累计隧道数
+type hostTotals struct {
+	connections int64
+	bytesUp     int64
+	bytesDown   int64
+}
+
+// hostOf 返回目标地址的主机部分，域名转为小写
+func hostOf(target string) string {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	return strings.ToLower(host)
+}
+
+// countConnLocked 为 host 累加一条隧道，调用方需持有 r.mu
+func (r *Registry) countConnLocked(host string) {
+	t, ok := r.hosts[host]
+	if !ok {
+		if len(r.hosts) >= maxHosts {
+			r.evictLocked()
+		}
+		t = &hostTotals{}
+		r.hosts[host] = t
+	}
+	t.connections++
+}
+
+// addBytesLocked 隧道结束时把流量累加到 host，host 已被淘汰时重新登记，调用方需持有 r.mu
+func (r *Registry) addBytesLocked(host string, up, down int64) {
+	t, ok := r.hosts[host]
+	if !ok {
+		if len(r.hosts) >= maxHosts {
+			r.evictLocked()
+		}
+		t = &hostTotals{connections: 1}
+		r.hosts[host] = t
+	}
+	t.bytesUp += up
+	t.bytesDown += down
+}
+
+// evictLocked 淘汰累计流量最少的主机，调用方需持有 r.mu
+func (r *Registry) evictLocked() {
+	var victim string
+	var least int64 = -1
+	for host, t := range r.hosts {
+		if total := t.bytesUp + t.bytesDown; least < 0 || total < least {
+			victim, least = host, total
+		}
+	}
+	delete(r.hosts, victim)
+}
+
+// TopHosts 返回按总流量（上行 + 下行）从大到小排列的前 n 个目标主机，n <= 0 时返回全部
+// 正在转发的隧道计入其当前流量
+func (r *Registry) TopHosts(n int) []HostStats {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	stats := make(map[string]*HostStats, len(r.hosts))
+	for host, t := range r.hosts {
+		stats[host] = &HostStats{Host: host, Connections: t.connections, BytesUp: t.bytesUp, BytesDown: t.bytesDown}
+	}
+	for _, c := range r.conns {
+		host := hostOf(c.Target)
+		s, ok := stats[host]
+		if !ok {
+			s = &HostStats{Host: host, Connections: 1}
+			stats[host] = s
+		}
+		s.Active++
+		s.BytesUp += c.BytesUp.Load()
+		s.BytesDown += c.BytesDown.Load()
+	}
+	r.mu.Unlock()
+
+	top := make([]HostStats, 0, len(stats))
+	for _, s := range stats {
+		top = append(top, *s)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		ti, tj := top[i].BytesUp+top[i].BytesDown, top[j].BytesUp+top[j].BytesDown
+		if ti != tj {
+			return ti > tj
+		}
+		return top[i].Host < top[j].Host
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
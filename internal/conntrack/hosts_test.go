@@ -0,0 +1,104 @@
+package conntrack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTopHostsAggregatesByHost(t *testing.T) {
+	r := NewRegistry()
+
+	// 同一主机的不同端口与大小写合并为一项
+	for _, target := range []string{"example.com:443", "Example.com:80"} {
+		c := r.Add("SOCKS5", nil, target)
+		c.BytesUp.Add(100)
+		c.BytesDown.Add(1000)
+		c.Remove()
+	}
+	c := r.Add("HTTP", nil, "[2001:db8::1]:443")
+	c.BytesUp.Add(10)
+	c.BytesDown.Add(20)
+	c.Remove()
+	c.Remove() // 重复调用不会重复计入流量
+
+	// 正在转发的隧道计入当前流量
+	active := r.Add("SOCKS5", nil, "[2001:db8::1]:8080")
+	active.BytesDown.Add(5)
+
+	want := []HostStats{
+		{Host: "example.com", Connections: 2, BytesUp: 200, BytesDown: 2000},
+		{Host: "2001:db8::1", Connections: 2, Active: 1, BytesUp: 10, BytesDown: 25},
+	}
+	got := r.TopHosts(0)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("TopHosts(0) = %+v, want %+v", got, want)
+	}
+	if got := r.TopHosts(1); len(got) != 1 || got[0].Host != "example.com" {
+		t.Fatalf("TopHosts(1) = %+v, want only example.com", got)
+	}
+
+	active.Remove()
+	if got := r.TopHosts(0)[1]; got.Active != 0 || got.BytesDown != 25 || got.Connections != 2 {
+		t.Fatalf("after Remove: %+v, want the finished tunnel's totals", got)
+	}
+}
+
+func TestTopHostsBounded(t *testing.T) {
+	r := NewRegistry()
+	for i := range maxHosts + 10 {
+		c := r.Add("SOCKS5", nil, fmt.Sprintf("host%d.example:443", i))
+		c.BytesUp.Add(int64(i + 1))
+		c.Remove()
+	}
+
+	top := r.TopHosts(0)
+	if len(top) != maxHosts {
+		t.Fatalf("%d hosts kept, want at most %d", len(top), maxHosts)
+	}
+	// 淘汰的是流量最少的主机，最大的保留下来
+	if want := fmt.Sprintf("host%d.example", maxHosts+9); top[0].Host != want {
+		t.Fatalf("top host = %s, want %s", top[0].Host, want)
+	}
+}
+
+func TestDestinationsHandler(t *testing.T) {
+	r := NewRegistry()
+	for _, target := range []string{"a.example:443", "b.example:443", "c.example:443"} {
+		c := r.Add("SOCKS5", nil, target)
+		c.BytesUp.Add(int64(len(target)))
+		c.Remove()
+	}
+	mux := http.NewServeMux()
+	Register(mux, r)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(query string) (int, []HostStats) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/destinations" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var stats []HostStats
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, stats
+	}
+
+	if code, stats := get(""); code != http.StatusOK || len(stats) != 3 {
+		t.Fatalf("GET /destinations: %d, %d hosts", code, len(stats))
+	}
+	if code, stats := get("?limit=2"); code != http.StatusOK || len(stats) != 2 {
+		t.Fatalf("GET /destinations?limit=2: %d, %d hosts", code, len(stats))
+	}
+	if code, _ := get("?limit=-1"); code != http.StatusBadRequest {
+		t.Fatalf("GET /destinations?limit=-1: %d, want 400", code)
+	}
+}
@@ -0,0 +1,35 @@
+package conntrack
+
+import "time"
+
+// Stats 全部隧道的累计统计，GET /stats 的应答
+// 字段名与含义保持稳定，供监控脚本使用；以后只增加字段
+type Stats struct {
+	Uptime      int64 `json:"uptime_seconds"` // 进程启动后经过的秒数
+	Active      int   `json:"active"`         // 正在转发的隧道数
+	Connections int64 `json:"connections"`    // 累计建立的隧道数，包括正在转发的
+	BytesUp     int64 `json:"bytes_up"`       // 累计客户端 -> 目标字节数，包括正在转发的隧道
+	BytesDown   int64 `json:"bytes_down"`     // 累计目标 -> 客户端字节数，包括正在转发的隧道
+}
+
+// Stats 返回全部隧道的累计统计
+func (r *Registry) Stats() Stats {
+	if r == nil {
+		return Stats{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := Stats{
+		Uptime:      int64(time.Since(r.started).Seconds()),
+		Active:      len(r.conns),
+		Connections: r.totals.connections,
+		BytesUp:     r.totals.bytesUp,
+		BytesDown:   r.totals.bytesDown,
+	}
+	for _, c := range r.conns {
+		stats.BytesUp += c.BytesUp.Load()
+		stats.BytesDown += c.BytesDown.Load()
+	}
+	return stats
+}
@@ -0,0 +1,68 @@
+package conntrack
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	r := NewRegistry()
+	for i := range maxHosts + 5 {
+		c := r.Add("SOCKS5", nil, fmt.Sprintf("host%d.example:443", i))
+		c.BytesUp.Add(1)
+		c.BytesDown.Add(10)
+		c.Remove()
+	}
+	active := r.Add("HTTP", nil, "example.org:443")
+	active.BytesUp.Add(100)
+	active.BytesDown.Add(1000)
+
+	// 累计统计包括正在转发的隧道，不受按主机汇总的淘汰影响
+	n := int64(maxHosts + 5)
+	want := Stats{Active: 1, Connections: n + 1, BytesUp: n + 100, BytesDown: 10*n + 1000}
+	if got := r.Stats(); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+	active.Remove()
+	want.Active = 0
+	if got := r.Stats(); got != want {
+		t.Fatalf("after Remove: %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	r := NewRegistry()
+	c := r.Add("SOCKS5", nil, "example.com:443")
+	c.BytesUp.Add(3)
+	mux := http.NewServeMux()
+	Register(mux, r)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /stats: %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// 字段名是监控脚本依赖的接口，不能改变
+	var fields map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&fields); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"active", "bytes_down", "bytes_up", "connections", "uptime_seconds"}
+	if got := slices.Sorted(maps.Keys(fields)); !slices.Equal(got, want) {
+		t.Fatalf("GET /stats fields = %v, want %v", got, want)
+	}
+	if fields["active"] != 1.0 || fields["connections"] != 1.0 || fields["bytes_up"] != 3.0 {
+		t.Fatalf("GET /stats = %v", fields)
+	}
+}
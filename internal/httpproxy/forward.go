@@ -0,0 +1,332 @@
+package httpproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-proxy-eins/internal/breaker"
+	"go-proxy-eins/internal/config"
+	"go-proxy-eins/internal/conntrack"
+	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/mux"
+	"go-proxy-eins/internal/ratelimit"
+	"go-proxy-eins/internal/relay"
+)
+
+// keepAliveTimeout 未配置 idle_timeout 时，客户端连接等待下一个请求及隧道空闲的最长时间
+const keepAliveTimeout = 60 * time.Second
+
+// hopHeaders 逐跳头部，只对当前连接有效，不转发给下一跳
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HandleHTTPForward 处理普通 HTTP 代理请求，如 "GET http://example.com/ HTTP/1.1"
+// 同一客户端连接上的多个请求（包括流水线请求）按顺序处理，到每个目标主机的隧道在请求之间复用；
+// 客户端发送 Connection: close、空闲超时或出错时关闭连接
+func HandleHTTPForward(client net.Conn, reader *bufio.Reader, cfg *config.LocalConfig, serverBreaker *breaker.Breaker, sessions *mux.Pool, conns *conntrack.Registry, log *slog.Logger) {
+	defer client.Close()
+
+	f := &forwarder{
+		client:        client,
+		reader:        reader,
+		cfg:           cfg,
+		serverBreaker: serverBreaker,
+		sessions:      sessions,
+		conns:         conns,
+		log:           log,
+		timeout:       cfg.GetIdleTimeout(),
+		tunnels:       make(map[string]*forwardTunnel),
+	}
+	if f.timeout <= 0 {
+		f.timeout = keepAliveTimeout
+	}
+	defer f.closeTunnels()
+
+	for {
+		// 等待下一个请求
+		client.SetDeadline(time.Now().Add(f.timeout))
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			var netErr net.Error
+			if err != io.EOF && !errors.As(err, &netErr) {
+				log.Warn("Malformed HTTP request", "error", err)
+				sendHTTPError(client, http.StatusBadRequest, "Malformed HTTP request.")
+			}
+			return
+		}
+
+		if !f.serve(req) {
+			return
+		}
+	}
+}
+
+// forwarder 一个客户端连接上的普通 HTTP 代理状态
+type forwarder struct {
+	client        net.Conn
+	reader        *bufio.Reader
+	cfg           *config.LocalConfig
+	serverBreaker *breaker.Breaker
+	sessions      *mux.Pool
+	conns         *conntrack.Registry
+	log           *slog.Logger
+	timeout       time.Duration
+
+	tunnels map[string]*forwardTunnel // 按目标地址复用的隧道
+}
+
+// forwardTunnel 到某个目标主机的隧道
+type forwardTunnel struct {
+	conn   net.Conn
+	secure io.Writer
+	reader *bufio.Reader
+	writer *bufio.Writer
+	idle   *relay.IdleTimeout
+	used   bool // 已处理过请求，可能已被目标关闭
+}
+
+// serve 转发一个请求，返回是否继续在该连接上读取下一个请求
+func (f *forwarder) serve(req *http.Request) bool {
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		f.log.Warn("Unsupported HTTP proxy request", "method", req.Method, "uri", req.RequestURI)
+		sendHTTPError(f.client, http.StatusBadRequest, "Only absolute http:// URLs can be forwarded. Use CONNECT for HTTPS.")
+		return false
+	}
+
+	authority := req.URL.Host
+	if req.URL.Port() == "" {
+		authority = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	target, err := NormalizeTarget(authority, f.cfg.GetMaxTargetLen())
+	if err != nil {
+		f.log.Warn("Invalid HTTP request host", "host", req.URL.Host, "error", err)
+		sendHTTPError(f.client, http.StatusBadRequest, fmt.Sprintf("Invalid request host %q: %v.", req.URL.Host, err))
+		return false
+	}
+	f.log.Info("HTTP request", "method", req.Method, "target", target, "client", f.client.RemoteAddr())
+
+	access := logger.StartAccess("HTTP", f.client.RemoteAddr(), target)
+	defer access.Done()
+
+	// 客户端要求关闭（Connection: close 或未声明 keep-alive 的 HTTP/1.0）时，响应后关闭连接
+	clientClose := req.Close
+	upgrade := upgradeType(req.Header)
+	removeHopHeaders(req.Header)
+	if upgrade != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgrade)
+	}
+	req.Close = false
+
+	resp, t, terr := f.roundTrip(target, req, access)
+	if terr != nil {
+		sendHTTPError(f.client, terr.status, terr.message)
+		return false
+	}
+	defer resp.Body.Close()
+	access.Established()
+
+	tracked := f.conns.Add("HTTP", f.client.RemoteAddr(), target, f.client, t.conn)
+	defer tracked.Remove()
+
+	// 101 Switching Protocols 之后不再是 HTTP，转为双向转发直到任一方关闭
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		f.switchProtocols(target, t, resp, access, tracked)
+		return false
+	}
+
+	keepAlive := !clientClose && !resp.Close
+	reusable := !resp.Close
+	removeHopHeaders(resp.Header)
+	resp.Close = !keepAlive
+
+	down := &countWriter{w: ratelimit.NewWriter(f.client, f.cfg.GetRateLimit())}
+	err = resp.Write(down)
+	access.BytesDown += down.n
+	if err != nil {
+		f.log.Debug("Failed to forward HTTP response", "target", target, "error", err)
+		f.dropTunnel(target)
+		return false
+	}
+	if !reusable {
+		f.dropTunnel(target)
+	}
+	return keepAlive
+}
+
+// roundTrip 通过隧道发送请求并读取最终响应，1xx 中间响应直接转给客户端
+// 复用的隧道可能已被目标关闭，没有请求体的请求会换新隧道重试一次
+func (f *forwarder) roundTrip(target string, req *http.Request, access *logger.AccessEntry) (*http.Response, *forwardTunnel, *tunnelError) {
+	for attempt := 0; ; attempt++ {
+		t, terr := f.tunnel(target)
+		if terr != nil {
+			return nil, nil, terr
+		}
+		reused := t.used
+		t.used = true
+
+		resp, err := f.send(t, req, access)
+		if err == nil {
+			return resp, t, nil
+		}
+		f.dropTunnel(target)
+
+		if reused && attempt == 0 && req.Body == http.NoBody {
+			f.log.Debug("Reused tunnel failed, retrying", "target", target, "error", err)
+			continue
+		}
+		f.log.Error("Failed to forward HTTP request", "target", target, "error", err)
+		return nil, nil, &tunnelError{http.StatusBadGateway, "Lost connection to the target host while forwarding the request.", err}
+	}
+}
+
+// send 在隧道上写出请求并读取响应
+func (f *forwarder) send(t *forwardTunnel, req *http.Request, access *logger.AccessEntry) (*http.Response, error) {
+	deadline := time.Now().Add(f.timeout)
+	f.client.SetDeadline(deadline)
+	t.conn.SetDeadline(deadline)
+
+	if req.Body != http.NoBody {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{t.idle.Reader(req.Body), req.Body}
+	}
+
+	up := &countWriter{w: ratelimit.NewWriter(t.secure, f.cfg.GetRateLimit())}
+	t.writer.Reset(up)
+	err := req.Write(t.writer)
+	if err == nil {
+		err = t.writer.Flush()
+	}
+	access.BytesUp += up.n
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		resp, err := http.ReadResponse(t.reader, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 100 || resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+		if err := resp.Write(f.client); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// switchProtocols 转发 101 响应后在客户端与隧道之间双向转发
+func (f *forwarder) switchProtocols(target string, t *forwardTunnel, resp *http.Response, access *logger.AccessEntry, tracked *conntrack.Conn) {
+	if err := resp.Write(f.client); err != nil {
+		f.log.Debug("Failed to forward HTTP response", "target", target, "error", err)
+		return
+	}
+	f.log.Debug("HTTP connection upgraded", "target", target, "protocol", resp.Header.Get("Upgrade"))
+
+	up := &relay.Stream{Name: "up", Dst: ratelimit.NewWriter(t.idle.Writer(t.secure, t.conn), f.cfg.GetRateLimit()), Src: t.idle.Reader(f.reader), Peer: t.secure, Count: &tracked.BytesUp}
+	down := &relay.Stream{Name: "down", Dst: ratelimit.NewWriter(t.idle.Writer(f.client, f.client), f.cfg.GetRateLimit()), Src: t.reader, Peer: f.client, Count: &tracked.BytesDown}
+	relay.Join(up, down, func() {
+		f.client.Close()
+		t.conn.Close()
+	})
+	access.BytesUp += up.N
+	access.BytesDown += down.N
+	f.log.Debug("Transfer ended", relay.LogAttrs(up, down)...)
+}
+
+// tunnel 返回到 target 的隧道，没有时新建
+func (f *forwarder) tunnel(target string) (*forwardTunnel, *tunnelError) {
+	if t, ok := f.tunnels[target]; ok {
+		return t, nil
+	}
+
+	server, secureReader, secureWriter, terr := dialTunnel(target, f.cfg, f.serverBreaker, f.sessions, f.log)
+	if terr != nil {
+		return nil, terr
+	}
+
+	// 隧道读到数据时顺延客户端与隧道的截止时间
+	idle := relay.NewIdleTimeout(f.timeout, f.client, server).WithWriteTimeout(f.cfg.GetWriteTimeout())
+	t := &forwardTunnel{
+		conn:   server,
+		secure: secureWriter,
+		reader: bufio.NewReaderSize(idle.Reader(secureReader), relay.BufferSize),
+		writer: bufio.NewWriterSize(secureWriter, relay.BufferSize),
+		idle:   idle,
+	}
+	f.tunnels[target] = t
+	f.log.Debug("HTTP forward tunnel established", "target", target)
+	return t, nil
+}
+
+// dropTunnel 关闭并移除到 target 的隧道
+func (f *forwarder) dropTunnel(target string) {
+	if t, ok := f.tunnels[target]; ok {
+		t.conn.Close()
+		delete(f.tunnels, target)
+	}
+}
+
+// closeTunnels 关闭所有隧道
+func (f *forwarder) closeTunnels() {
+	for target := range f.tunnels {
+		f.dropTunnel(target)
+	}
+}
+
+// upgradeType 返回请求要切换到的协议（如 websocket），未请求升级时为空
+func upgradeType(h http.Header) string {
+	for _, v := range h.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "Upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// removeHopHeaders 删除逐跳头部及 Connection 中列出的头部
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// countWriter 统计写出的字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,241 @@
+package httpproxy_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// httpTarget 启动目标 HTTP 服务，每个连接交给 handle 处理，返回地址与已接受的连接数
+func httpTarget(t *testing.T, handle func(conn net.Conn, br *bufio.Reader)) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	accepted := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				handle(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return ln.Addr().String(), accepted
+}
+
+// servePaths 按顺序回复连接上的每个请求，响应体为请求路径；keep 返回 false 时回复后关闭连接
+func servePaths(keep func() bool) func(net.Conn, *bufio.Reader) {
+	return func(conn net.Conn, br *bufio.Reader) {
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, req.Body)
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(req.URL.Path), req.URL.Path)
+			if !keep() {
+				return
+			}
+		}
+	}
+}
+
+// dialForward 连接本地 HTTP 代理入口
+func dialForward(t *testing.T, h *proxytest.Harness) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", h.Local.HTTPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func startHarness(t *testing.T) *proxytest.Harness {
+	t.Helper()
+	h, err := proxytest.NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+// readBody 读取一个响应并返回状态码与响应体
+func readBody(t *testing.T, br *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestForwardPipelined(t *testing.T) {
+	h := startHarness(t)
+	target, accepted := httpTarget(t, servePaths(func() bool { return true }))
+	conn, br := dialForward(t, h)
+
+	// 三个请求一次写出，不等待响应
+	paths := []string{"/a", "/b", "/c"}
+	var reqs strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&reqs, "GET http://%s%s HTTP/1.1\r\nHost: %s\r\n\r\n", target, path, target)
+	}
+	if _, err := io.WriteString(conn, reqs.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		resp, body := readBody(t, br)
+		if resp.StatusCode != http.StatusOK || body != path {
+			t.Fatalf("response = %d %q, want 200 %q", resp.StatusCode, body, path)
+		}
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Fatalf("target accepted %d connections, want the tunnel reused", n)
+	}
+}
+
+func TestForwardConnectionClose(t *testing.T) {
+	h := startHarness(t)
+	closed := make(chan struct{})
+	target, _ := httpTarget(t, func(conn net.Conn, br *bufio.Reader) {
+		servePaths(func() bool { return true })(conn, br)
+		close(closed)
+	})
+	conn, br := dialForward(t, h)
+
+	fmt.Fprintf(conn, "GET http://%s/only HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", target, target)
+	resp, body := readBody(t, br)
+	if body != "/only" {
+		t.Fatalf("body = %q, want %q", body, "/only")
+	}
+	if !resp.Close {
+		t.Fatal("response does not announce Connection: close")
+	}
+
+	// 响应之后客户端连接与到目标的隧道都被关闭
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("client read after the response = %v, want EOF", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel to the target stayed open after Connection: close")
+	}
+}
+
+func TestForwardRetryStaleTunnel(t *testing.T) {
+	h := startHarness(t)
+	// 目标每个连接只处理一个请求，随后不声明 Connection: close 就关闭，如同 keep-alive 超时
+	served := make(chan struct{}, 2)
+	target, accepted := httpTarget(t, servePaths(func() bool {
+		served <- struct{}{}
+		return false
+	}))
+	conn, br := dialForward(t, h)
+
+	fmt.Fprintf(conn, "GET http://%s/first HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	if _, body := readBody(t, br); body != "/first" {
+		t.Fatalf("body = %q, want %q", body, "/first")
+	}
+	<-served
+
+	// 复用的隧道已被目标关闭，没有请求体的请求换新隧道重试
+	fmt.Fprintf(conn, "GET http://%s/second HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	resp, body := readBody(t, br)
+	if resp.StatusCode != http.StatusOK || body != "/second" {
+		t.Fatalf("response = %d %q, want 200 %q", resp.StatusCode, body, "/second")
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Fatalf("target accepted %d connections, want 2", n)
+	}
+}
+
+func TestForwardContinue(t *testing.T) {
+	h := startHarness(t)
+	target, _ := httpTarget(t, func(conn net.Conn, br *bufio.Reader) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\n")
+		body, _ := io.ReadAll(req.Body)
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	})
+	conn, br := dialForward(t, h)
+
+	fmt.Fprintf(conn, "POST http://%s/upload HTTP/1.1\r\nHost: %s\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello", target, target)
+
+	// 1xx 中间响应原样转给客户端，随后是最终响应
+	interim, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interim.StatusCode != http.StatusContinue {
+		t.Fatalf("first response = %d, want 100", interim.StatusCode)
+	}
+	resp, body := readBody(t, br)
+	if resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Fatalf("final response = %d %q, want 200 %q", resp.StatusCode, body, "hello")
+	}
+}
+
+func TestForwardSwitchingProtocols(t *testing.T) {
+	h := startHarness(t)
+	target, _ := httpTarget(t, func(conn net.Conn, br *bufio.Reader) {
+		req, err := http.ReadRequest(br)
+		if err != nil || req.Header.Get("Upgrade") != "websocket" {
+			io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		io.Copy(conn, br)
+	})
+	conn, br := dialForward(t, h)
+
+	fmt.Fprintf(conn, "GET http://%s/ws HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", target, target)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("response = %d, want 101", resp.StatusCode)
+	}
+
+	// 101 之后转为双向转发
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(br, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Fatalf("echo = %q, want %q", got, "ping")
+	}
+}
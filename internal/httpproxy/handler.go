@@ -2,167 +2,145 @@ package httpproxy
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/breaker"
 	"go-proxy-eins/internal/config"
+	"go-proxy-eins/internal/conntrack"
 	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/mux"
 	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/ratelimit"
+	"go-proxy-eins/internal/relay"
+	"go-proxy-eins/internal/sniff"
 )
 
 // HandleHTTPConnect 处理 HTTP CONNECT 请求
 // requestLine: 第一行请求，如 "CONNECT github.com:443 HTTP/1.1\r\n"
-func HandleHTTPConnect(client net.Conn, reader *bufio.Reader, requestLine string, cfg *config.LocalConfig) {
+// serverBreaker: 服务器拨号熔断器
+// sessions: 多路复用会话池，未启用 mux 时为 nil
+// log: 带连接 ID 的日志记录器
+func HandleHTTPConnect(client net.Conn, reader *bufio.Reader, requestLine string, cfg *config.LocalConfig, serverBreaker *breaker.Breaker, sessions *mux.Pool, conns *conntrack.Registry, log *slog.Logger) {
 	defer client.Close()
 
 	// 设置超时
-	if cfg.Timeout > 0 {
-		client.SetDeadline(time.Now().Add(cfg.GetTimeout()))
+	if cfg.GetHandshakeTimeout() > 0 {
+		client.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
 	}
 
-	logger.Log.Debug("New HTTP CONNECT request", "remote", client.RemoteAddr())
+	log.Debug("New HTTP CONNECT request", "remote", client.RemoteAddr())
 
 	// 解析 CONNECT 请求
 	// 格式: "CONNECT host:port HTTP/1.1"
 	parts := strings.Fields(requestLine)
 	if len(parts) < 2 {
-		sendHTTPError(client, 400, "Bad Request")
+		sendHTTPError(client, http.StatusBadRequest, "Malformed CONNECT request line.")
 		return
 	}
 
-	targetAddr := parts[1]
-	logger.Log.Info("HTTP CONNECT request", "target", targetAddr, "client", client.RemoteAddr())
-
-	// 读取并丢弃剩余的 HTTP 头（使用传入的 reader）
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return
-		}
-		// 空行表示头部结束
-		if line == "\r\n" || line == "\n" {
-			break
-		}
-	}
-
-	// 连接到远程服务器
-	server, err := net.DialTimeout("tcp", cfg.Server, cfg.GetTimeout())
-	if err != nil {
-		logger.Log.Error("Failed to connect to server", "error", err)
-		sendHTTPError(client, 502, "Bad Gateway")
-		return
-	}
-	defer server.Close()
-
-	// 设置服务器连接超时
-	if cfg.Timeout > 0 {
-		server.SetDeadline(time.Now().Add(cfg.GetTimeout()))
-	}
-
-	logger.Log.Debug("Connected to server", "server", cfg.Server)
-
-	// 执行握手认证
-	salt, err := protocol.ClientHandshake(server, cfg.Password)
+	targetAddr, err := NormalizeTarget(parts[1], cfg.GetMaxTargetLen())
 	if err != nil {
-		logger.Log.Error("Handshake failed", "error", err)
-		sendHTTPError(client, 502, "Bad Gateway")
+		log.Warn("Invalid CONNECT target", "target", parts[1], "error", err)
+		sendHTTPError(client, http.StatusBadRequest, fmt.Sprintf("Invalid CONNECT target %q: %v.", parts[1], err))
 		return
 	}
+	log.Info("HTTP CONNECT request", "target", targetAddr, "client", client.RemoteAddr())
 
-	logger.Log.Debug("Handshake successful")
+	access := logger.StartAccess("HTTP", client.RemoteAddr(), targetAddr)
+	defer access.Done()
 
-	// 创建加密器
-	cipherInstance, err := cipher.NewCipher(cfg.Password, salt)
+	// 读取剩余的 HTTP 头（使用传入的 reader）
+	header, err := readHeaders(reader)
 	if err != nil {
-		logger.Log.Error("Failed to create cipher", "error", err)
-		sendHTTPError(client, 502, "Bad Gateway")
-		return
-	}
-
-	// 包装连接（加密 + 可选混淆）
-	var serverReader io.Reader = server
-	var serverWriter io.Writer = server
-
-	if cfg.Obfuscate {
-		serverReader = protocol.NewObfuscatedReader(serverReader)
-		serverWriter = protocol.NewObfuscatedWriter(serverWriter)
-	}
-
-	secureReader := cipher.NewSecureReader(serverReader, cipherInstance)
-	secureWriter := cipher.NewSecureWriter(serverWriter, cipherInstance)
-
-	// 发送目标地址到服务器
-	// 协议: [地址长度(1字节)][地址字符串]
-	if _, err := secureWriter.Write([]byte{byte(len(targetAddr))}); err != nil {
-		logger.Log.Error("Failed to send target address length", "error", err)
-		sendHTTPError(client, 502, "Bad Gateway")
-		return
-	}
-	if _, err := secureWriter.Write([]byte(targetAddr)); err != nil {
-		logger.Log.Error("Failed to send target address", "error", err)
-		sendHTTPError(client, 502, "Bad Gateway")
-		return
-	}
-
-	// 等待服务器连接目标的响应
-	status := make([]byte, 1)
-	if _, err := secureReader.Read(status); err != nil {
-		logger.Log.Error("Failed to read server response", "error", err)
-		sendHTTPError(client, 502, "Bad Gateway")
+		switch {
+		case errors.Is(err, errHeaderTooLarge):
+			log.Warn("CONNECT request headers too large", "target", targetAddr)
+			sendHTTPError(client, http.StatusRequestHeaderFieldsTooLarge, "Request headers too large.")
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		default:
+			log.Debug("Malformed CONNECT request headers", "target", targetAddr, "error", err)
+			sendHTTPError(client, http.StatusBadRequest, "Malformed request headers.")
+		}
 		return
 	}
+	// 隧道目标以请求行的 authority 为准，Host 只用于日志
+	// 本地入口不认证客户端（访问由 client_allow 控制），Proxy-Authorization 等逐跳头部不转发，直接忽略
+	log.Debug("CONNECT request headers", "host", header.Get("Host"), "user_agent", header.Get("User-Agent"))
 
-	if status[0] != 0 {
-		logger.Log.Warn("Server failed to connect to target", "target", targetAddr)
-		sendHTTPError(client, 502, "Bad Gateway")
+	// 通过服务器建立到目标的隧道
+	server, secureReader, secureWriter, terr := dialTunnel(targetAddr, cfg, serverBreaker, sessions, log)
+	if terr != nil {
+		sendHTTPError(client, terr.status, terr.message)
 		return
 	}
+	defer server.Close()
 
-	// 清除超时，允许长时间数据传输
-	client.SetDeadline(time.Time{})
-	server.SetDeadline(time.Time{})
+	// 握手阶段结束，转发阶段使用空闲超时
+	idle := relay.NewIdleTimeout(cfg.GetIdleTimeout(), client, server).WithWriteTimeout(cfg.GetWriteTimeout())
 
 	// 发送 HTTP 200 Connection Established 响应
 	response := "HTTP/1.1 200 Connection Established\r\n\r\n"
 	if _, err := client.Write([]byte(response)); err != nil {
-		logger.Log.Error("Failed to send HTTP response", "error", err)
+		log.Error("Failed to send HTTP response", "error", err)
 		return
 	}
+	access.Established()
 
-	logger.Log.Debug("HTTP tunnel established", "target", targetAddr)
+	log.Debug("HTTP tunnel established", "target", targetAddr)
 
-	// 双向转发数据
-	errCh := make(chan error, 2)
+	tracked := conns.Add("HTTP", client.RemoteAddr(), targetAddr, client, server)
+	defer tracked.Remove()
 
-	// 浏览器 -> 服务器
-	go func() {
-		_, err := io.Copy(secureWriter, client)
-		errCh <- err
-	}()
+	var upSrc io.Reader = client
+	if cfg.Sniff {
+		upSrc = sniff.NewReader(client, log.With("target", targetAddr))
+	}
 
-	// 服务器 -> 浏览器
-	go func() {
-		_, err := io.Copy(client, secureReader)
-		errCh <- err
-	}()
+	// 双向转发数据，一端半关闭时通知另一端
+	up := &relay.Stream{Name: "up", Dst: ratelimit.NewWriter(idle.Writer(secureWriter, server), cfg.GetRateLimit()), Src: idle.Reader(upSrc), Peer: secureWriter, Count: &tracked.BytesUp}
+	down := &relay.Stream{Name: "down", Dst: ratelimit.NewWriter(idle.Writer(client, client), cfg.GetRateLimit()), Src: idle.Reader(secureReader), Peer: client, Count: &tracked.BytesDown}
+	relay.Join(up, down, func() {
+		client.Close()
+		server.Close()
+	})
+	access.BytesUp, access.BytesDown = up.N, down.N
+	log.Debug("Transfer ended", relay.LogAttrs(up, down)...)
 
-	// 等待任一方向结束
-	err = <-errCh
-	if err != nil && err != io.EOF {
-		logger.Log.Debug("Transfer ended", "error", err)
+	log.Debug("HTTP connection closed", "target", targetAddr)
+}
+
+// NormalizeTarget 规范化 CONNECT 请求的 authority-form 目标地址
+// 要求包含端口；IPv6 地址统一为 "[addr]:port" 形式，与服务端 net.Dial 的格式一致；超过 maxLen 字节时返回错误
+func NormalizeTarget(authority string, maxLen int) (string, error) {
+	host, port, err := protocol.ParseTarget(authority)
+	if err != nil {
+		return "", err
 	}
 
-	logger.Log.Debug("HTTP connection closed", "target", targetAddr)
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	if err := protocol.CheckTargetLen(target, maxLen); err != nil {
+		return "", err
+	}
+	return target, nil
 }
 
-// sendHTTPError 发送 HTTP 错误响应
-func sendHTTPError(conn net.Conn, statusCode int, statusText string) {
-	response := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: 0\r\n\r\n", statusCode, statusText)
+// sendHTTPError 发送 HTTP 错误响应，message 作为正文说明失败原因
+func sendHTTPError(conn net.Conn, statusCode int, message string) {
+	body := fmt.Sprintf("%d %s\n\n%s\n", statusCode, http.StatusText(statusCode), message)
+	response := fmt.Sprintf("HTTP/1.1 %d %s\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Length: %d\r\n"+
+		"Connection: close\r\n"+
+		"\r\n%s",
+		statusCode, http.StatusText(statusCode), len(body), body)
 	conn.Write([]byte(response))
 }
 
@@ -183,7 +161,7 @@ func IsHTTPConnect(data []byte) bool {
 	return string(data[:7]) == "CONNECT"
 }
 
-// DetectProtocol 检测协议类型 (HTTP CONNECT 或 SOCKS5)
+// DetectProtocol 检测协议类型 (HTTP 或 SOCKS5)
 func DetectProtocol(reader *bufio.Reader) (isHTTP bool, firstData []byte, err error) {
 	// 尝试窥探第一个字节
 	firstByte, err := reader.Peek(1)
@@ -196,14 +174,13 @@ func DetectProtocol(reader *bufio.Reader) (isHTTP bool, firstData []byte, err er
 		return false, firstByte, nil
 	}
 
-	// 尝试读取第一行以检查是否是 HTTP
-	// 窥探更多字节以检查是否包含 "CONNECT"
+	// 窥探请求方法（最长的 "OPTIONS " 为 8 字节）以检查是否是 HTTP
 	peek, err := reader.Peek(8)
 	if err != nil && err != io.EOF {
 		return false, nil, err
 	}
 
-	if len(peek) >= 7 && string(peek[:7]) == "CONNECT" {
+	if i := strings.IndexByte(string(peek), ' '); i > 0 && ValidateHTTPMethod(string(peek[:i])) {
 		return true, peek, nil
 	}
 
@@ -0,0 +1,46 @@
+package httpproxy
+
+import (
+	"testing"
+
+	"go-proxy-eins/internal/protocol"
+)
+
+func TestNormalizeTarget(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"example.com:443", "example.com:443"},
+		{"192.0.2.1:80", "192.0.2.1:80"},
+		{"[2606:4700::1111]:443", "[2606:4700::1111]:443"},
+		{"[::1]:8080", "[::1]:8080"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeTarget(tt.in, protocol.MaxTargetLen)
+		if err != nil {
+			t.Errorf("NormalizeTarget(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeTarget(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTargetRejects(t *testing.T) {
+	for _, in := range []string{
+		"[2606:4700::1111]",   // 缺少端口
+		"2606:4700::1111",     // 未加方括号，无法区分端口
+		"2606:4700::1111:443", // 同上
+		"[2606:4700::1111]:",  // 空端口
+		"[2606:4700::1111]:0", // 端口 0
+		"[2606:4700::1111]:70000",
+		"example.com",
+		":443",
+		"exa mple.com:443",
+	} {
+		if got, err := NormalizeTarget(in, protocol.MaxTargetLen); err == nil {
+			t.Errorf("NormalizeTarget(%q) = %q, want error", in, got)
+		}
+	}
+}
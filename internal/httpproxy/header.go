@@ -0,0 +1,70 @@
+package httpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+)
+
+const (
+	// CONNECT 请求头部的数量和总长度上限，防止客户端发送无限长的头部占用内存
+	maxConnectHeaders     = 100
+	maxConnectHeaderBytes = 64 << 10
+)
+
+// errHeaderTooLarge 头部数量或总长度超过上限
+var errHeaderTooLarge = errors.New("request headers too large")
+
+// readHeaders 读取请求行之后的头部直到空行，返回按规范形式 (如 Proxy-Authorization) 索引的头部
+// 超过 maxConnectHeaders 或 maxConnectHeaderBytes 时返回 errHeaderTooLarge
+func readHeaders(reader *bufio.Reader) (http.Header, error) {
+	header := make(http.Header)
+	remaining := maxConnectHeaderBytes
+	for lines := 0; ; lines++ {
+		line, err := readHeaderLine(reader, &remaining)
+		if err != nil {
+			return nil, err
+		}
+		// 空行表示头部结束
+		if len(line) == 0 {
+			return header, nil
+		}
+		if lines >= maxConnectHeaders {
+			return nil, errHeaderTooLarge
+		}
+		// 不支持已废弃的折行写法，以空白开头的行视为格式错误
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("malformed header line %q", line)
+		}
+
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok || len(name) == 0 || bytes.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("malformed header line %q", line)
+		}
+		key := textproto.CanonicalMIMEHeaderKey(string(name))
+		header[key] = append(header[key], string(bytes.TrimSpace(value)))
+	}
+}
+
+// readHeaderLine 读取一行并去掉行尾的 CRLF 或 LF，从 remaining 中扣除读取的字节数
+func readHeaderLine(reader *bufio.Reader, remaining *int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if *remaining -= len(chunk); *remaining < 0 {
+			return nil, errHeaderTooLarge
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+		return line, nil
+	}
+}
@@ -0,0 +1,106 @@
+package httpproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readHeadersFrom(s string) (map[string][]string, error) {
+	return readHeaders(bufio.NewReaderSize(strings.NewReader(s), 16))
+}
+
+func TestReadHeaders(t *testing.T) {
+	header, err := readHeadersFrom("Host: example.com:443\r\n" +
+		"proxy-authorization: Basic dTpw\r\n" +
+		"X-Repeated: a\n" +
+		"X-Repeated:  b \r\n" +
+		"\r\n" +
+		"tunnel data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header["Proxy-Authorization"]; len(got) != 1 || got[0] != "Basic dTpw" {
+		t.Errorf("Proxy-Authorization = %q, want the canonical key", got)
+	}
+	if got := header["Host"]; len(got) != 1 || got[0] != "example.com:443" {
+		t.Errorf("Host = %q", got)
+	}
+	if got := header["X-Repeated"]; len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("X-Repeated = %q, want both values trimmed", got)
+	}
+}
+
+func TestReadHeadersStopsAtBlankLine(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("Host: example.com\r\n\r\ntunnel data"))
+	if _, err := readHeaders(reader); err != nil {
+		t.Fatal(err)
+	}
+	// 空行之后是隧道数据，不能被头部解析读走
+	rest, _ := io.ReadAll(reader)
+	if string(rest) != "tunnel data" {
+		t.Fatalf("left %q after the headers", rest)
+	}
+}
+
+func TestReadHeadersTooMany(t *testing.T) {
+	var b strings.Builder
+	for i := range maxConnectHeaders {
+		fmt.Fprintf(&b, "X-H%d: v\r\n", i)
+	}
+	if _, err := readHeadersFrom(b.String() + "\r\n"); err != nil {
+		t.Fatalf("%d headers: %v", maxConnectHeaders, err)
+	}
+
+	b.WriteString("X-One-Too-Many: v\r\n\r\n")
+	if _, err := readHeadersFrom(b.String()); !errors.Is(err, errHeaderTooLarge) {
+		t.Fatalf("%d headers: err = %v, want errHeaderTooLarge", maxConnectHeaders+1, err)
+	}
+}
+
+func TestReadHeadersOversized(t *testing.T) {
+	// 单行超过上限时在读完整行之前失败，不必等到换行
+	long := "X-Long: " + strings.Repeat("a", maxConnectHeaderBytes)
+	if _, err := readHeadersFrom(long + "\r\n\r\n"); !errors.Is(err, errHeaderTooLarge) {
+		t.Fatalf("oversized line: err = %v, want errHeaderTooLarge", err)
+	}
+	if _, err := readHeaders(bufio.NewReader(io.MultiReader(strings.NewReader(long), neverEnding('a')))); !errors.Is(err, errHeaderTooLarge) {
+		t.Fatalf("endless line: err = %v, want errHeaderTooLarge", err)
+	}
+
+	// 每行都不长，但总长度超过上限
+	line := "X-Filler: " + strings.Repeat("b", maxConnectHeaderBytes/maxConnectHeaders) + "\r\n"
+	total := strings.Repeat(line, maxConnectHeaders-1) + "\r\n"
+	if _, err := readHeadersFrom(total); !errors.Is(err, errHeaderTooLarge) {
+		t.Fatalf("%d bytes of headers: err = %v, want errHeaderTooLarge", len(total), err)
+	}
+}
+
+func TestReadHeadersMalformed(t *testing.T) {
+	for _, s := range []string{
+		"no colon\r\n\r\n",
+		": empty name\r\n\r\n",
+		"Bad Name: v\r\n\r\n",
+		"Host: example.com\r\n folded: v\r\n\r\n",
+	} {
+		if _, err := readHeadersFrom(s); err == nil || errors.Is(err, errHeaderTooLarge) {
+			t.Errorf("readHeaders(%q): err = %v, want a malformed header error", s, err)
+		}
+	}
+	if _, err := readHeadersFrom("Host: example.com\r\n"); !errors.Is(err, io.EOF) {
+		t.Errorf("truncated headers: err = %v, want EOF", err)
+	}
+}
+
+// neverEnding 无限重复同一字节的 Reader
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
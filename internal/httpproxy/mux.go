@@ -0,0 +1,103 @@
+package httpproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/config"
+	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/mux"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/relay"
+)
+
+// ErrMuxUnsupported 服务器收到多路复用请求后关闭了连接，通常是不支持 mux 的旧版本
+var ErrMuxUnsupported = errors.New("server closed the connection after the mux request, it may not support multiplexing")
+
+// NewMuxPool 创建 mux_connections 条会话的会话池，会话在第一次使用时建立
+func NewMuxPool(cfg *config.LocalConfig) *mux.Pool {
+	return mux.NewPool(cfg.GetMuxConnections(), func() (*mux.Session, error) {
+		return dialMux(cfg)
+	})
+}
+
+// dialMux 连接服务器、完成握手并把连接切换为多路复用会话
+func dialMux(cfg *config.LocalConfig) (*mux.Session, error) {
+	log := logger.Log
+
+	server, err := net.DialTimeout("tcp", cfg.Server, cfg.GetHandshakeTimeout())
+	if err != nil {
+		return nil, err
+	}
+	relay.TuneTCP(server, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+	if !cfg.LowLatency {
+		server = relay.NewBatchConn(server, relay.BatchDelay).WithWriteTimeout(cfg.GetWriteTimeout())
+	}
+	if cfg.GetHandshakeTimeout() > 0 {
+		server.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+
+	opts := handshakeOptions(cfg)
+	salt, opts, err := protocol.ClientHandshake(server, cfg.Password, opts)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	secureReader, secureWriter, err := protocol.WrapTunnel(server, server, cfg.Password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	if err := protocol.WriteMuxRequest(secureWriter); err != nil {
+		server.Close()
+		return nil, err
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(secureReader, status); err != nil {
+		server.Close()
+		if errors.Is(err, cipher.ErrCipherMismatch) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrMuxUnsupported, err)
+	}
+	if status[0] != protocol.ConnectOK {
+		server.Close()
+		return nil, fmt.Errorf("server rejected the mux request: %s", protocol.ConnectStatusText(status[0]))
+	}
+
+	// 会话长期保持，空闲超时由各个流自行设置
+	server.SetDeadline(time.Time{})
+	protocol.StartKeepalive(secureReader, secureWriter, cfg.GetKeepaliveInterval(), cfg.GetKeepaliveTimeout(), func() {
+		log.Warn("Keepalive timeout, closing tunnel", "server", cfg.Server)
+		server.Close()
+	})
+
+	// 压缩作用于整个会话，位于加密层之上
+	var r io.Reader = secureReader
+	var w io.Writer = secureWriter
+	if cfg.Compress {
+		r = protocol.NewCompressedReader(secureReader)
+		w = protocol.NewCompressedWriter(secureWriter)
+	}
+
+	log.Debug("Multiplexed session established", "server", cfg.Server)
+	return mux.Client(server, r, w), nil
+}
+
+// muxErrorMessage 根据打开多路复用流失败的原因给出提示
+func muxErrorMessage(err error) string {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, ErrMuxUnsupported):
+		return "The proxy server does not support multiplexing. Upgrade the server or turn off mux in the client configuration."
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "Cannot reach the proxy server. Check that the server is running and the server address is correct."
+	default:
+		return handshakeErrorMessage(err)
+	}
+}
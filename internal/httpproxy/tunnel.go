@@ -0,0 +1,243 @@
+package httpproxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go-proxy-eins/internal/breaker"
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/config"
+	"go-proxy-eins/internal/mux"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/relay"
+)
+
+// tunnelError 建立隧道失败，包含返回给浏览器的状态码与说明
+type tunnelError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *tunnelError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tunnelError) Unwrap() error {
+	return e.err
+}
+
+// dialTunnel 连接服务器、完成握手并请求连接 target，返回服务器连接及解密/加密后的读写端
+// 服务器连接的截止时间为 handshake_timeout，由调用方在转发阶段重新设置
+// sessions 不为 nil 时在多路复用会话上打开一个流代替新的连接
+// 启用 fallback_direct 时，服务器不可达或握手失败会改为直连 target，返回的读写端即直连连接本身
+func dialTunnel(target string, cfg *config.LocalConfig, serverBreaker *breaker.Breaker, sessions *mux.Pool, log *slog.Logger) (net.Conn, io.Reader, io.Writer, *tunnelError) {
+	// 连续失败过多时熔断以快速失败
+	if err := serverBreaker.Allow(); err != nil {
+		if cfg.FallbackDirect {
+			return dialDirectTunnel(target, cfg, err, log)
+		}
+		log.Warn("Server unavailable, connection rejected", "error", err)
+		return nil, nil, nil, &tunnelError{http.StatusServiceUnavailable, "The proxy server has failed repeatedly and new connections are paused for a short cool-down. Try again in a few seconds.", err}
+	}
+	if sessions != nil {
+		return dialMuxTunnel(target, cfg, serverBreaker, sessions, log)
+	}
+	server, err := net.DialTimeout("tcp", cfg.Server, cfg.GetHandshakeTimeout())
+	if err != nil {
+		serverBreaker.Failure()
+		log.Error("Failed to connect to server", "error", err)
+		if cfg.FallbackDirect {
+			return dialDirectTunnel(target, cfg, err, log)
+		}
+		return nil, nil, nil, &tunnelError{http.StatusBadGateway, "Cannot reach the proxy server. Check that the server is running and the server address is correct.", err}
+	}
+	relay.TuneTCP(server, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+	if !cfg.LowLatency {
+		server = relay.NewBatchConn(server, relay.BatchDelay).WithWriteTimeout(cfg.GetWriteTimeout())
+	}
+
+	// 设置服务器连接超时
+	if cfg.GetHandshakeTimeout() > 0 {
+		server.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+
+	log.Debug("Connected to server", "server", cfg.Server)
+
+	// 执行握手认证
+	salt, opts, err := protocol.ClientHandshake(server, cfg.Password, handshakeOptions(cfg))
+	if err != nil {
+		server.Close()
+		serverBreaker.Failure()
+		log.Error("Handshake failed", "error", err)
+		if cfg.FallbackDirect {
+			return dialDirectTunnel(target, cfg, err, log)
+		}
+		return nil, nil, nil, &tunnelError{http.StatusBadGateway, handshakeErrorMessage(err), err}
+	}
+	serverBreaker.Success()
+
+	log.Debug("Handshake successful", "obfuscate", opts.Obfuscate, "cipher", opts.Cipher)
+
+	secureReader, secureWriter, terr := openTunnel(server, salt, opts, target, cfg, log)
+	if terr != nil {
+		server.Close()
+		return nil, nil, nil, terr
+	}
+	return server, secureReader, secureWriter, nil
+}
+
+// DialDirect 不经过代理服务器直接连接 target，用于 fallback_direct
+// cause 为无法使用服务器的原因；每次直连都以 warn 级别记录，流量不再经过加密隧道
+func DialDirect(target string, cfg *config.LocalConfig, cause error, log *slog.Logger) (net.Conn, error) {
+	log.Warn("Proxy server unavailable, connecting to target DIRECTLY without the proxy (fallback_direct)",
+		"target", target,
+		"reason", cause)
+	conn, err := net.DialTimeout("tcp", target, cfg.GetHandshakeTimeout())
+	if err != nil {
+		log.Error("Direct fallback connection failed", "target", target, "error", err)
+		return nil, err
+	}
+	relay.TuneTCP(conn, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+	if cfg.GetHandshakeTimeout() > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+	return conn, nil
+}
+
+// dialDirectTunnel 以 dialTunnel 的形式返回直连连接
+func dialDirectTunnel(target string, cfg *config.LocalConfig, cause error, log *slog.Logger) (net.Conn, io.Reader, io.Writer, *tunnelError) {
+	conn, err := DialDirect(target, cfg, cause, log)
+	if err != nil {
+		return nil, nil, nil, &tunnelError{http.StatusBadGateway, "Cannot reach the proxy server, and the direct fallback connection to the target host also failed.", err}
+	}
+	return conn, conn, conn, nil
+}
+
+// dialMuxTunnel 在会话池中打开一个流并请求连接 target，流的截止时间为 handshake_timeout
+func dialMuxTunnel(target string, cfg *config.LocalConfig, serverBreaker *breaker.Breaker, sessions *mux.Pool, log *slog.Logger) (net.Conn, io.Reader, io.Writer, *tunnelError) {
+	stream, err := sessions.Open()
+	if err != nil {
+		serverBreaker.Failure()
+		log.Error("Failed to open multiplexed stream", "error", err)
+		if cfg.FallbackDirect {
+			return dialDirectTunnel(target, cfg, err, log)
+		}
+		return nil, nil, nil, &tunnelError{http.StatusBadGateway, muxErrorMessage(err), err}
+	}
+	serverBreaker.Success()
+
+	if cfg.GetHandshakeTimeout() > 0 {
+		stream.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+	if terr := requestTarget(stream, stream, target, log); terr != nil {
+		stream.Close()
+		return nil, nil, nil, terr
+	}
+	return stream, stream, stream, nil
+}
+
+// openTunnel 在已完成握手的服务器连接上请求连接 target，opts 为握手协商后的选项
+func openTunnel(server net.Conn, salt []byte, opts protocol.HandshakeOptions, target string, cfg *config.LocalConfig, log *slog.Logger) (io.Reader, io.Writer, *tunnelError) {
+	// 创建加密器并包装连接（加密 + 可选混淆，plaintext 时不包装）
+	secureReader, secureWriter, err := protocol.WrapTunnel(server, server, cfg.Password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		log.Error("Failed to create cipher", "error", err)
+		return nil, nil, &tunnelError{http.StatusBadGateway, "Failed to set up encryption with the proxy server.", err}
+	}
+	if terr := requestTarget(secureReader, secureWriter, target, log); terr != nil {
+		return nil, nil, terr
+	}
+	protocol.StartKeepalive(secureReader, secureWriter, cfg.GetKeepaliveInterval(), cfg.GetKeepaliveTimeout(), func() {
+		log.Warn("Keepalive timeout, closing tunnel", "target", target)
+		server.Close()
+	})
+
+	// 压缩只用于转发阶段，位于加密层之上
+	if cfg.Compress {
+		return protocol.NewCompressedReader(secureReader), protocol.NewCompressedWriter(secureWriter), nil
+	}
+	return secureReader, secureWriter, nil
+}
+
+// requestTarget 发送目标地址并等待服务器连接目标的应答
+func requestTarget(r io.Reader, w io.Writer, target string, log *slog.Logger) *tunnelError {
+	// 发送目标地址到服务器
+	if err := protocol.WriteTarget(w, target); err != nil {
+		log.Error("Failed to send target address", "error", err)
+		return &tunnelError{http.StatusBadGateway, "Lost connection to the proxy server while sending the request.", err}
+	}
+
+	// 等待服务器连接目标的响应；HTTP CONNECT 的应答没有绑定地址，服务端给出的本地地址不使用
+	status, _, err := protocol.ReadConnectStatus(r)
+	if err != nil {
+		if errors.Is(err, cipher.ErrCipherMismatch) {
+			log.Error("Failed to decrypt server response, cipher or obfuscation settings likely differ from the server", "error", err)
+			return &tunnelError{http.StatusBadGateway, "The proxy server's response could not be decrypted. Check that the client and server use the same release and settings.", err}
+		}
+		log.Error("Failed to read server response", "error", err)
+		return &tunnelError{http.StatusBadGateway, "Lost connection to the proxy server while waiting for its response.", err}
+	}
+
+	if status != protocol.ConnectOK {
+		log.Warn("Server failed to connect to target", "target", target, "reason", protocol.ConnectStatusText(status))
+		code, message := connectFailure(status)
+		return &tunnelError{code, message, errors.New(protocol.ConnectStatusText(status))}
+	}
+	return nil
+}
+
+// handshakeOptions 返回握手时声明的本端选项
+func handshakeOptions(cfg *config.LocalConfig) protocol.HandshakeOptions {
+	return protocol.HandshakeOptions{Obfuscate: cfg.Obfuscate, Compress: cfg.Compress, Plaintext: cfg.Plaintext, Cipher: cfg.GetCipher(), RekeyAfter: cfg.GetRekeyAfter(), Fingerprint: cfg.ServerFingerprint, Negotiate: cfg.Negotiate}
+}
+
+// connectFailure 将服务端的连接目标应答状态转换为 HTTP 状态码与提示
+func connectFailure(status byte) (int, string) {
+	switch status {
+	case protocol.ConnectRefused:
+		return http.StatusBadGateway, "The target host refused the connection."
+	case protocol.ConnectTimeout:
+		return http.StatusGatewayTimeout, "Timed out connecting to the target host."
+	case protocol.ConnectDNSFailed:
+		return http.StatusBadGateway, "The proxy server could not resolve the target host name."
+	case protocol.ConnectNetUnreachable, protocol.ConnectHostUnreachable:
+		return http.StatusBadGateway, "The target host is unreachable from the proxy server."
+	case protocol.ConnectBlocked:
+		return http.StatusForbidden, "The proxy server does not allow connections to this port."
+	case protocol.ConnectForbidden:
+		return http.StatusForbidden, "The proxy server does not allow connections to this address."
+	default:
+		return http.StatusBadGateway, "The proxy server could not connect to the target host. The host may be down, refusing connections, or blocked by the server."
+	}
+}
+
+// handshakeErrorMessage 根据握手失败原因给出提示
+func handshakeErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, protocol.ErrAuthFailed):
+		return "Handshake with the proxy server failed: password mismatch or the server rejected the handshake. Check that the password matches the server and that the system clock is correct."
+	case errors.Is(err, protocol.ErrUnsupportedVersion):
+		return "Handshake with the proxy server failed: protocol version mismatch. Upgrade the client and server to the same release."
+	case errors.Is(err, protocol.ErrObfuscationMismatch):
+		return "Handshake with the proxy server failed: obfuscation setting differs from the server."
+	case errors.Is(err, protocol.ErrCompressionMismatch):
+		return "Handshake with the proxy server failed: compression setting differs from the server."
+	case errors.Is(err, protocol.ErrPlaintextMismatch):
+		return "Handshake with the proxy server failed: plaintext setting differs from the server."
+	case errors.Is(err, protocol.ErrCipherSuiteMismatch):
+		return "Handshake with the proxy server failed: cipher setting differs from the server."
+	case errors.Is(err, protocol.ErrRekeyMismatch):
+		return "Handshake with the proxy server failed: rekey setting differs from the server."
+	case errors.Is(err, protocol.ErrIdentityMismatch):
+		return "Handshake with the proxy server failed: the server's identity does not match the configured fingerprint. The connection may be intercepted, or the server key has changed."
+	case errors.Is(err, protocol.ErrIdentityUnavailable):
+		return "Handshake with the proxy server failed: a server fingerprint is configured but the server has no identity key."
+	default:
+		return "Handshake with the proxy server failed: the connection was interrupted."
+	}
+}
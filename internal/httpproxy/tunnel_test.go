@@ -0,0 +1,34 @@
+package httpproxy
+
+import (
+	"net/http"
+	"testing"
+
+	"go-proxy-eins/internal/protocol"
+)
+
+func TestConnectFailureStatus(t *testing.T) {
+	tests := []struct {
+		status byte
+		want   int
+	}{
+		{protocol.ConnectFailed, http.StatusBadGateway},
+		{protocol.ConnectRefused, http.StatusBadGateway},
+		{protocol.ConnectTimeout, http.StatusGatewayTimeout},
+		{protocol.ConnectBlocked, http.StatusForbidden},
+		{protocol.ConnectDNSFailed, http.StatusBadGateway},
+		{protocol.ConnectForbidden, http.StatusForbidden},
+		{protocol.ConnectNetUnreachable, http.StatusBadGateway},
+		{protocol.ConnectHostUnreachable, http.StatusBadGateway},
+		{0xff, http.StatusBadGateway}, // 新版本服务端的未知状态
+	}
+	for _, tt := range tests {
+		code, msg := connectFailure(tt.status)
+		if code != tt.want {
+			t.Errorf("connectFailure(%d) = %d, want %d", tt.status, code, tt.want)
+		}
+		if msg == "" {
+			t.Errorf("connectFailure(%d) has no message", tt.status)
+		}
+	}
+}
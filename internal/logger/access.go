@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultAccessFormat 默认访问日志格式（Combined Log Format，末尾追加上行字节、耗时与状态）
+const DefaultAccessFormat = `{{.Host}} - - [{{.CLFTime}}] "CONNECT {{.Target}} {{.Proto}}" {{.Code}} {{.BytesDown}} "-" "-" up={{.BytesUp}} duration={{.Millis}}ms status={{.Status}}`
+
+// 访问状态
+const (
+	AccessEstablished = "established"
+	AccessFailed      = "failed"
+)
+
+// clfTimeFormat Common Log Format 的时间格式
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	accessMu       sync.Mutex
+	accessOutput   io.Writer
+	accessTemplate *template.Template
+)
+
+// InitAccess 初始化访问日志，format 为空时使用 DefaultAccessFormat
+// 访问日志与 Log 的级别无关，未初始化时不输出
+func InitAccess(output io.Writer, format string) error {
+	if format == "" {
+		format = DefaultAccessFormat
+	}
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+
+	tmpl, err := template.New("access").Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid access log format: %w", err)
+	}
+	// 试执行一次，启动时就能发现引用了不存在字段的格式
+	if err := tmpl.Execute(io.Discard, &AccessEntry{}); err != nil {
+		return fmt.Errorf("invalid access log format: %w", err)
+	}
+
+	accessMu.Lock()
+	defer accessMu.Unlock()
+	accessOutput = output
+	accessTemplate = tmpl
+	return nil
+}
+
+// AccessEntry 一条隧道的访问记录，字段与方法均可在格式模板中引用
+type AccessEntry struct {
+	Start     time.Time
+	Client    string
+	Target    string
+	Proto     string // SOCKS5 / HTTP
+	Status    string // established / failed
+	BytesUp   int64  // 客户端 -> 服务器
+	BytesDown int64  // 服务器 -> 客户端
+	Duration  time.Duration
+}
+
+// StartAccess 在得到目标地址后开始记录一条访问，状态初始为 failed
+func StartAccess(proto string, client net.Addr, target string) *AccessEntry {
+	e := &AccessEntry{
+		Start:  time.Now(),
+		Client: "-",
+		Target: target,
+		Proto:  proto,
+		Status: AccessFailed,
+	}
+	if client != nil && client.String() != "" {
+		e.Client = client.String()
+	}
+	return e
+}
+
+// Established 标记隧道已建立
+func (e *AccessEntry) Established() {
+	e.Status = AccessEstablished
+}
+
+// Done 隧道关闭时调用，计算耗时并写入访问日志
+func (e *AccessEntry) Done() {
+	e.Duration = time.Since(e.Start)
+
+	accessMu.Lock()
+	defer accessMu.Unlock()
+	if accessOutput == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := accessTemplate.Execute(&buf, e); err != nil {
+		Log.Warn("Failed to format access log entry", "error", err)
+		return
+	}
+	accessOutput.Write(buf.Bytes())
+}
+
+// Host 客户端地址去掉端口后的部分
+func (e *AccessEntry) Host() string {
+	if host, _, err := net.SplitHostPort(e.Client); err == nil {
+		return host
+	}
+	return e.Client
+}
+
+// CLFTime 以 Common Log Format 格式返回开始时间
+func (e *AccessEntry) CLFTime() string {
+	return e.Start.Format(clfTimeFormat)
+}
+
+// Code 对应的 HTTP 状态码，便于日志分析工具识别
+func (e *AccessEntry) Code() int {
+	if e.Status == AccessEstablished {
+		return 200
+	}
+	return 502
+}
+
+// Millis 耗时（毫秒）
+func (e *AccessEntry) Millis() int64 {
+	return e.Duration.Milliseconds()
+}
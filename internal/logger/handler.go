@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// swapHandler 可原子替换底层处理器的 slog.Handler
+// WithAttrs/WithGroup 派生的处理器记录派生操作，底层处理器替换后按需重新派生，长连接的子日志记录器也能切换到新的输出
+type swapHandler struct {
+	root    *atomic.Pointer[slog.Handler]
+	derive  func(slog.Handler) slog.Handler // 从根处理器派生出本处理器的操作，根处理器本身为 nil
+	current atomic.Pointer[derived]
+}
+
+// derived 根处理器与由其派生出的处理器，根处理器变化后失效
+type derived struct {
+	base    slog.Handler
+	handler slog.Handler
+}
+
+func newSwapHandler(h slog.Handler) *swapHandler {
+	s := &swapHandler{root: new(atomic.Pointer[slog.Handler])}
+	s.root.Store(&h)
+	return s
+}
+
+// swap 替换根处理器，所有派生的处理器在下次使用时切换
+func (s *swapHandler) swap(h slog.Handler) {
+	s.root.Store(&h)
+}
+
+// handler 返回由当前根处理器派生出的处理器
+func (s *swapHandler) handler() slog.Handler {
+	base := *s.root.Load()
+	if s.derive == nil {
+		return base
+	}
+	if d := s.current.Load(); d != nil && d.base == base {
+		return d.handler
+	}
+	h := s.derive(base)
+	s.current.Store(&derived{base: base, handler: h})
+	return h
+}
+
+func (s *swapHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return s.handler().Enabled(ctx, l)
+}
+
+func (s *swapHandler) Handle(ctx context.Context, r slog.Record) error {
+	return s.handler().Handle(ctx, r)
+}
+
+func (s *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return s.with(func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs) })
+}
+
+func (s *swapHandler) WithGroup(name string) slog.Handler {
+	return s.with(func(h slog.Handler) slog.Handler { return h.WithGroup(name) })
+}
+
+func (s *swapHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	derive := op
+	if parent := s.derive; parent != nil {
+		derive = func(h slog.Handler) slog.Handler { return op(parent(h)) }
+	}
+	return &swapHandler{root: s.root, derive: derive}
+}
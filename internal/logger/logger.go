@@ -1,13 +1,27 @@
 package logger
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync/atomic"
 )
 
-var Log *slog.Logger
+var (
+	// level 当前日志级别，所有处理器共用，SetLevel 可在运行时修改
+	level slog.LevelVar
+	// configuredLevel Init 时配置的级别，ToggleDebug 据此恢复
+	configuredLevel atomic.Int64
+
+	root = newSwapHandler(slog.Default().Handler())
+
+	// Log 全局日志实例，Init 之前输出到 slog 默认 logger
+	// Log 本身不会被替换，Init 原子替换其底层处理器，运行时重新初始化不会与正在记录日志的 goroutine 竞争
+	Log = slog.New(root)
+)
 
 // LogLevel 日志级别
 type LogLevel string
@@ -19,35 +33,86 @@ const (
 	LevelError LogLevel = "error"
 )
 
-// Init 初始化日志系统
+// LogFormat 日志输出格式
+type LogFormat string
+
+const (
+	FormatText LogFormat = "text"
+	FormatJSON LogFormat = "json"
+)
+
+// Init 初始化日志系统（文本格式）
 func Init(level LogLevel, output io.Writer) {
+	InitWithFormat(level, FormatText, output)
+}
+
+// InitWithFormat 按指定格式初始化日志系统
+// 可以在运行时再次调用以更换格式或输出，已有的子日志记录器（如 WithConnID 返回的）随之切换
+func InitWithFormat(lvl LogLevel, format LogFormat, output io.Writer) {
 	if output == nil {
 		output = os.Stdout
 	}
 
-	var slogLevel slog.Level
-	switch strings.ToLower(string(level)) {
-	case "debug":
-		slogLevel = slog.LevelDebug
-	case "info":
-		slogLevel = slog.LevelInfo
-	case "warn":
-		slogLevel = slog.LevelWarn
-	case "error":
-		slogLevel = slog.LevelError
-	default:
-		slogLevel = slog.LevelInfo
-	}
+	SetLevel(lvl)
+	configuredLevel.Store(int64(toSlogLevel(lvl)))
 
 	opts := &slog.HandlerOptions{
-		Level: slogLevel,
+		Level: &level,
 	}
 
-	handler := slog.NewTextHandler(output, opts)
-	Log = slog.New(handler)
+	var handler slog.Handler
+	if format == FormatJSON {
+		handler = slog.NewJSONHandler(output, opts)
+	} else {
+		handler = slog.NewTextHandler(output, opts)
+	}
+	root.swap(handler)
 	slog.SetDefault(Log)
 }
 
+// SetLevel 修改日志级别，可在运行时并发调用
+func SetLevel(lvl LogLevel) {
+	level.Set(toSlogLevel(lvl))
+}
+
+// Level 返回当前日志级别
+func Level() LogLevel {
+	switch l := level.Level(); {
+	case l <= slog.LevelDebug:
+		return LevelDebug
+	case l <= slog.LevelInfo:
+		return LevelInfo
+	case l <= slog.LevelWarn:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// ToggleDebug 在 debug 级别与 Init 配置的级别之间切换，返回切换后的级别
+// 配置的级别本身就是 debug 时保持不变
+func ToggleDebug() LogLevel {
+	if level.Level() == slog.LevelDebug {
+		level.Set(slog.Level(configuredLevel.Load()))
+	} else {
+		level.Set(slog.LevelDebug)
+	}
+	return Level()
+}
+
+func toSlogLevel(lvl LogLevel) slog.Level {
+	switch strings.ToLower(string(lvl)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
 // ParseLevel 解析日志级别字符串
 func ParseLevel(s string) LogLevel {
 	switch strings.ToLower(s) {
@@ -63,3 +128,25 @@ func ParseLevel(s string) LogLevel {
 		return LevelInfo
 	}
 }
+
+// ParseFormat 解析日志格式字符串
+func ParseFormat(s string) LogFormat {
+	switch strings.ToLower(s) {
+	case "json":
+		return FormatJSON
+	default:
+		return FormatText
+	}
+}
+
+// NewConnID 生成短随机连接 ID，用于关联同一隧道的日志
+func NewConnID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// WithConnID 返回带有连接 ID 的子日志记录器
+func WithConnID(id string) *slog.Logger {
+	return Log.With("conn_id", id)
+}
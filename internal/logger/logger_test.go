@@ -0,0 +1,152 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInitWithFormatJSON(t *testing.T) {
+	var buf bytes.Buffer
+	InitWithFormat(LevelInfo, FormatJSON, &buf)
+	t.Cleanup(func() { Init(LevelInfo, nil) })
+
+	WithConnID("abcd1234").Info("Tunnel established", "target", "example.com:443")
+
+	line := strings.TrimSpace(buf.String())
+	if strings.Contains(line, "\n") {
+		t.Fatalf("expected a single line, got %q", line)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v\n%s", err, line)
+	}
+	want := map[string]any{
+		"level":   "INFO",
+		"msg":     "Tunnel established",
+		"target":  "example.com:443",
+		"conn_id": "abcd1234",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Error("missing time field")
+	}
+}
+
+func TestInitDefaultsToText(t *testing.T) {
+	var buf bytes.Buffer
+	InitWithFormat(LevelInfo, ParseFormat("bogus"), &buf)
+	t.Cleanup(func() { Init(LevelInfo, nil) })
+
+	Log.Info("hello", "k", "v")
+
+	line := buf.String()
+	if json.Valid([]byte(line)) {
+		t.Fatalf("expected text output, got JSON: %s", line)
+	}
+	if !strings.Contains(line, "msg=hello") || !strings.Contains(line, "k=v") {
+		t.Fatalf("unexpected text output: %s", line)
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	InitWithFormat(LevelWarn, FormatJSON, &buf)
+	t.Cleanup(func() { Init(LevelInfo, nil) })
+
+	Log.Info("dropped")
+	Log.Warn("kept")
+
+	if out := buf.String(); strings.Contains(out, "dropped") || !strings.Contains(out, "kept") {
+		t.Fatalf("unexpected output at warn level: %s", out)
+	}
+}
+
+// syncBuffer 可并发写入的 bytes.Buffer
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 用 -race 运行：重新初始化与修改级别的同时其它 goroutine 持续记录日志
+func TestReconfigureWhileLogging(t *testing.T) {
+	var first, second syncBuffer
+	InitWithFormat(LevelInfo, FormatText, &first)
+	t.Cleanup(func() { Init(LevelInfo, nil) })
+
+	conn := WithConnID("race0001")
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				Log.Info("global", "worker", i)
+				conn.Debug("per connection", "worker", i)
+			}
+		}()
+	}
+
+	for i := range 100 {
+		out, format := &first, FormatText
+		if i%2 == 1 {
+			out, format = &second, FormatJSON
+		}
+		InitWithFormat(LevelInfo, format, out)
+		SetLevel(LevelWarn)
+		ToggleDebug()
+		_ = Level()
+	}
+	close(stop)
+	wg.Wait()
+
+	// 最后一次初始化后，之前创建的子日志记录器也写入新的输出
+	InitWithFormat(LevelDebug, FormatJSON, &second)
+	conn.Debug("after reconfigure")
+	if !strings.Contains(second.String(), `"msg":"after reconfigure"`) {
+		t.Fatal("derived logger did not follow the reconfigured handler")
+	}
+}
+
+func TestToggleDebug(t *testing.T) {
+	var buf bytes.Buffer
+	InitWithFormat(LevelWarn, FormatText, &buf)
+	t.Cleanup(func() { Init(LevelInfo, nil) })
+
+	if got := ToggleDebug(); got != LevelDebug {
+		t.Fatalf("first toggle = %s, want debug", got)
+	}
+	Log.Debug("visible")
+	if got := ToggleDebug(); got != LevelWarn {
+		t.Fatalf("second toggle = %s, want the configured warn level", got)
+	}
+	Log.Debug("hidden")
+
+	if out := buf.String(); !strings.Contains(out, "visible") || strings.Contains(out, "hidden") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
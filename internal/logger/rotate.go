@@ -0,0 +1,193 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 备份文件名中的时间格式
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingWriter 按大小轮转的日志文件写入器
+// 备份文件命名为 <path>.<时间戳>，超出数量或时间的备份会被清理
+type RotatingWriter struct {
+	path       string
+	maxSize    int64         // 单个文件最大字节数，0 表示不轮转
+	maxBackups int           // 保留的备份数量，0 表示不限制
+	maxAge     time.Duration // 备份保留时长，0 表示不限制
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingWriter 创建日志文件写入器
+// maxSizeMB: 单个文件最大 MB 数; maxBackups: 最多保留备份数; maxAgeDays: 备份最长保留天数
+func NewRotatingWriter(path string, maxSizeMB, maxBackups, maxAgeDays int) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write 实现 io.Writer，写入前检查是否需要轮转
+func (w *RotatingWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize && w.size > 0 {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen 关闭并重新打开日志文件，用于配合外部 logrotate
+func (w *RotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+
+	return w.open()
+}
+
+// ReopenOnSIGHUP 收到 SIGHUP 时重新打开日志文件
+func (w *RotatingWriter) ReopenOnSIGHUP() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	go func() {
+		for range sigChan {
+			if err := w.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reopen log file: %v\n", err)
+				continue
+			}
+			if Log != nil {
+				Log.Info("Log file reopened", "path", w.path)
+			}
+		}
+	}()
+}
+
+// Close 关闭日志文件
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open 打开（或创建）日志文件，调用方需持有锁
+func (w *RotatingWriter) open() error {
+	if dir := filepath.Dir(w.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// rotate 将当前文件重命名为备份并打开新文件，调用方需持有锁
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	w.file = nil
+
+	backup := w.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(w.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.cleanup()
+	return nil
+}
+
+// cleanup 删除超出数量或过期的备份文件
+func (w *RotatingWriter) cleanup() {
+	if w.maxBackups <= 0 && w.maxAge <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path string
+		t    time.Time
+	}
+	var backups []backup
+	for _, m := range matches {
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(m, w.path+"."), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: m, t: t})
+	}
+
+	// 新的在前
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].t.After(backups[j].t)
+	})
+
+	cutoff := time.Now().Add(-w.maxAge)
+	for i, b := range backups {
+		if (w.maxBackups > 0 && i >= w.maxBackups) || (w.maxAge > 0 && b.t.Before(cutoff)) {
+			os.Remove(b.path)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package logger
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ToggleDebugOnSIGUSR1 收到 SIGUSR1 时在 debug 级别与配置的级别之间切换，无需重启即可排查问题
+func ToggleDebugOnSIGUSR1() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1)
+
+	go func() {
+		for range sigChan {
+			Log.Warn("Log level changed", "level", ToggleDebug())
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package logger
+
+// ToggleDebugOnSIGUSR1 Windows 没有 SIGUSR1，不做任何事
+func ToggleDebugOnSIGUSR1() {}
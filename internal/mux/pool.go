@@ -0,0 +1,71 @@
+package mux
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Pool 客户端的会话池：新的流轮流使用 size 条会话，会话断开或收到 goaway 后在下次使用时重新建立
+type Pool struct {
+	dial  func() (*Session, error)
+	slots []poolSlot
+	next  atomic.Uint32
+}
+
+type poolSlot struct {
+	mu      sync.Mutex
+	session *Session
+}
+
+// NewPool 创建会话池，dial 建立一条新的客户端会话（连接服务器、握手并协商多路复用）
+func NewPool(size int, dial func() (*Session, error)) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{dial: dial, slots: make([]poolSlot, size)}
+}
+
+// Open 打开一个流；所选会话恰好在此时断开时换用新会话重试一次
+func (p *Pool) Open() (*Stream, error) {
+	slot := &p.slots[int(p.next.Add(1)-1)%len(p.slots)]
+	session, err := slot.get(p.dial, nil)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := session.Open()
+	if err == nil {
+		return stream, nil
+	}
+	if session, err = slot.get(p.dial, session); err != nil {
+		return nil, err
+	}
+	return session.Open()
+}
+
+// get 返回槽位中可用的会话，没有或为 stale 时重新建立；同一槽位的并发调用共用一次建立
+func (slot *poolSlot) get(dial func() (*Session, error), stale *Session) (*Session, error) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.session != nil && slot.session != stale && slot.session.Usable() {
+		return slot.session, nil
+	}
+	session, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	slot.session = session
+	return session, nil
+}
+
+// Close 不再使用池中的会话，各会话在已打开的流全部结束后关闭
+func (p *Pool) Close() {
+	for i := range p.slots {
+		slot := &p.slots[i]
+		slot.mu.Lock()
+		if slot.session != nil {
+			slot.session.GoAway()
+			slot.session = nil
+		}
+		slot.mu.Unlock()
+	}
+}
@@ -0,0 +1,345 @@
+// Package mux 在一条已认证的隧道上承载多个独立的流
+//
+// 帧格式：[类型(1)][流 ID(4)][长度(2)][负载]
+// 只有客户端打开流（ID 为奇数，从 1 开始），每个流各自做流量控制：
+// 发送方最多发送 windowSize 字节尚未被对端读取的数据，接收方读取过半窗口后发送窗口更新，
+// 因此一个读取缓慢的流不会阻塞同一会话上的其他流
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// 帧类型
+const (
+	frameOpen   = 0x01 // 打开流
+	frameData   = 0x02 // 数据
+	frameWindow = 0x03 // 窗口更新，负载为 4 字节增量
+	frameFin    = 0x04 // 半关闭：发送方不再发送数据
+	frameReset  = 0x05 // 中止流
+	frameGoAway = 0x06 // 发送方不再接受新的流，流 ID 为 0
+)
+
+const (
+	headerLen = 7
+
+	// MaxFrameData 单个数据帧的最大负载，较大的写入拆成多帧，与其他流的帧交替发送
+	MaxFrameData = 16 << 10
+
+	// windowSize 每个流的接收窗口
+	windowSize = 256 << 10
+
+	// MaxStreams 一个会话上同时打开的流数量上限，超出时服务器拒绝新的流
+	MaxStreams = 1024
+
+	// acceptBacklog 已打开但尚未被 Accept 取走的流数量上限
+	acceptBacklog = 256
+)
+
+var (
+	// ErrSessionClosed 会话已关闭
+	ErrSessionClosed = errors.New("mux session closed")
+	// ErrGoAway 会话不再接受新的流
+	ErrGoAway = errors.New("mux session is not accepting new streams")
+	// ErrStreamReset 流被对端中止
+	ErrStreamReset = errors.New("mux stream reset by peer")
+
+	errFlowControl = errors.New("mux: peer exceeded stream window")
+)
+
+// Session 多路复用会话，r 和 w 是隧道的读写端（已解密、解压），conn 是底层连接
+type Session struct {
+	conn   net.Conn
+	r      io.Reader
+	w      io.Writer
+	client bool
+
+	wmu  sync.Mutex // 保证一帧通过一次 Write 完整写出
+	wbuf []byte
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	goAway  bool // 本端或对端已发出 goaway，不再打开新的流
+	err     error
+
+	accept    chan *Stream
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Client 创建客户端会话，只能通过 Open 打开流
+func Client(conn net.Conn, r io.Reader, w io.Writer) *Session {
+	return newSession(conn, r, w, true)
+}
+
+// Server 创建服务器会话，通过 Accept 接受客户端打开的流
+func Server(conn net.Conn, r io.Reader, w io.Writer) *Session {
+	return newSession(conn, r, w, false)
+}
+
+func newSession(conn net.Conn, r io.Reader, w io.Writer, client bool) *Session {
+	s := &Session{
+		conn:    conn,
+		r:       r,
+		w:       w,
+		client:  client,
+		wbuf:    make([]byte, headerLen+MaxFrameData),
+		streams: make(map[uint32]*Stream),
+		nextID:  1,
+		accept:  make(chan *Stream, acceptBacklog),
+		done:    make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// Open 打开一个新的流
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	if s.goAway {
+		s.mu.Unlock()
+		return nil, ErrGoAway
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(frameOpen, id, nil); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept 等待对端打开的下一个流；发出 goaway 后返回 ErrGoAway
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, s.closeErr()
+	}
+}
+
+// GoAway 通知对端不再接受新的流，已打开的流不受影响，全部结束后会话自动关闭
+func (s *Session) GoAway() {
+	s.mu.Lock()
+	if s.goAway || s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.goAway = true
+	idle := len(s.streams) == 0
+	s.mu.Unlock()
+
+	s.writeFrame(frameGoAway, 0, nil)
+	if idle {
+		s.Close()
+	}
+}
+
+// Usable 会话未关闭且仍可以打开新的流
+func (s *Session) Usable() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err == nil && !s.goAway
+}
+
+// NumStreams 当前打开的流数量
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Done 返回会话关闭时关闭的通道
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close 关闭会话和底层连接，所有流随之失败
+func (s *Session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+func (s *Session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+
+		close(s.done)
+		s.conn.Close()
+		for _, st := range streams {
+			st.fail(err)
+		}
+	})
+}
+
+func (s *Session) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		return ErrGoAway
+	}
+	return s.err
+}
+
+// remove 移除已结束的流；发出或收到 goaway 后最后一个流结束时关闭会话
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	drained := s.goAway && len(s.streams) == 0 && s.err == nil
+	s.mu.Unlock()
+	if drained {
+		s.Close()
+	}
+}
+
+// writeFrame 写出一帧，负载不超过 MaxFrameData
+func (s *Session) writeFrame(typ byte, id uint32, payload []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	select {
+	case <-s.done:
+		return s.closeErr()
+	default:
+	}
+
+	buf := s.wbuf[:headerLen+len(payload)]
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:5], id)
+	binary.BigEndian.PutUint16(buf[5:7], uint16(len(payload)))
+	copy(buf[headerLen:], payload)
+	if _, err := s.w.Write(buf); err != nil {
+		s.closeWithError(fmt.Errorf("mux write: %w", err))
+		return s.closeErr()
+	}
+	return nil
+}
+
+// recvLoop 读取并分发对端的帧，出错时关闭会话
+func (s *Session) recvLoop() {
+	header := make([]byte, headerLen)
+	payload := make([]byte, MaxFrameData)
+	for {
+		if _, err := io.ReadFull(s.r, header); err != nil {
+			s.closeWithError(readError(err))
+			return
+		}
+		typ := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		n := int(binary.BigEndian.Uint16(header[5:7]))
+		if n > MaxFrameData {
+			s.closeWithError(fmt.Errorf("mux frame too large: %d", n))
+			return
+		}
+		if _, err := io.ReadFull(s.r, payload[:n]); err != nil {
+			s.closeWithError(readError(err))
+			return
+		}
+		if err := s.handleFrame(typ, id, payload[:n]); err != nil {
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrSessionClosed
+	}
+	return fmt.Errorf("mux read: %w", err)
+}
+
+func (s *Session) handleFrame(typ byte, id uint32, payload []byte) error {
+	if typ == frameGoAway {
+		s.mu.Lock()
+		s.goAway = true
+		idle := len(s.streams) == 0
+		s.mu.Unlock()
+		if idle {
+			s.Close()
+		}
+		return nil
+	}
+	if typ == frameOpen {
+		return s.handleOpen(id)
+	}
+
+	// 流已在本端关闭时，对端在途的帧直接丢弃
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	if st == nil {
+		return nil
+	}
+
+	switch typ {
+	case frameData:
+		return st.push(payload)
+	case frameWindow:
+		if len(payload) != 4 {
+			return errors.New("mux: malformed window update")
+		}
+		st.addWindow(int(binary.BigEndian.Uint32(payload)))
+	case frameFin:
+		st.finReceived()
+	case frameReset:
+		st.fail(ErrStreamReset)
+		s.remove(id)
+	default:
+		return fmt.Errorf("mux: unknown frame type %d", typ)
+	}
+	return nil
+}
+
+// handleOpen 接受对端打开的流；超出上限或已发出 goaway 时回复 reset
+func (s *Session) handleOpen(id uint32) error {
+	if s.client || id%2 == 0 {
+		return fmt.Errorf("mux: unexpected stream open %d", id)
+	}
+
+	s.mu.Lock()
+	if _, exists := s.streams[id]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("mux: duplicate stream %d", id)
+	}
+	if s.goAway || len(s.streams) >= MaxStreams {
+		s.mu.Unlock()
+		return s.rejectStream(id)
+	}
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	select {
+	case s.accept <- st:
+		return nil
+	default:
+		s.remove(id)
+		return s.rejectStream(id)
+	}
+}
+
+// rejectStream 在接收协程中异步回复 reset，避免对端不读取时阻塞帧的分发
+func (s *Session) rejectStream(id uint32) error {
+	go s.writeFrame(frameReset, id, nil)
+	return nil
+}
@@ -0,0 +1,139 @@
+package mux
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// sessionPair 在内存连接上创建一对客户端与服务器会话
+func sessionPair(t *testing.T) (client, server *Session) {
+	t.Helper()
+	c, s := net.Pipe()
+	client = Client(c, c, c)
+	server = Server(s, s, s)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// openPair 在客户端打开一个流并在服务器接受
+func openPair(t *testing.T, client, server *Session) (*Stream, *Stream) {
+	t.Helper()
+	cs, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.ID() != ss.ID() {
+		t.Fatalf("client stream %d accepted as %d", cs.ID(), ss.ID())
+	}
+	return cs, ss
+}
+
+func TestStreamHalfClose(t *testing.T) {
+	client, server := sessionPair(t)
+	cs, ss := openPair(t, client, server)
+
+	go func() {
+		cs.Write([]byte("request"))
+		cs.CloseWrite()
+	}()
+	got, err := io.ReadAll(ss)
+	if err != nil || string(got) != "request" {
+		t.Fatalf("server read %q, %v", got, err)
+	}
+
+	// 半关闭后反方向仍可写入
+	go func() {
+		ss.Write([]byte("response"))
+		ss.CloseWrite()
+	}()
+	got, err = io.ReadAll(cs)
+	if err != nil || string(got) != "response" {
+		t.Fatalf("client read %q, %v", got, err)
+	}
+	cs.Close()
+	ss.Close()
+	if n := client.NumStreams(); n != 0 {
+		t.Fatalf("client still tracks %d streams", n)
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	client, server := sessionPair(t)
+	cs, ss := openPair(t, client, server)
+
+	// 未半关闭就关闭流会中止对端
+	ss.Close()
+	cs.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := cs.Read(make([]byte, 1)); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("read after reset: %v, want ErrStreamReset", err)
+	}
+
+	// 同一会话上的其他流不受影响
+	cs, ss = openPair(t, client, server)
+	go cs.Write([]byte("ok"))
+	got := make([]byte, 2)
+	if _, err := io.ReadFull(ss, got); err != nil || string(got) != "ok" {
+		t.Fatalf("second stream read %q, %v", got, err)
+	}
+}
+
+func TestStreamDeadline(t *testing.T) {
+	client, server := sessionPair(t)
+	cs, _ := openPair(t, client, server)
+
+	cs.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := cs.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read err = %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// 对端不读取时窗口用尽，写入在截止时间返回
+	cs.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := cs.Write(make([]byte, 2*windowSize))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n != windowSize {
+		t.Fatalf("write = %d, %v; want %d bytes and os.ErrDeadlineExceeded", n, err, windowSize)
+	}
+}
+
+func TestGoAway(t *testing.T) {
+	client, server := sessionPair(t)
+	cs, ss := openPair(t, client, server)
+
+	server.GoAway()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Usable() {
+		if time.Now().After(deadline) {
+			t.Fatal("client still usable after goaway")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.Open(); !errors.Is(err, ErrGoAway) {
+		t.Fatalf("Open after goaway: %v, want ErrGoAway", err)
+	}
+
+	// 已打开的流继续转发，全部结束后会话关闭
+	go ss.Write([]byte("draining"))
+	got := make([]byte, len("draining"))
+	if _, err := io.ReadFull(cs, got); err != nil {
+		t.Fatal(err)
+	}
+	cs.Close()
+	ss.Close()
+	for name, s := range map[string]*Session{"client": client, "server": server} {
+		select {
+		case <-s.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s session still open after its last stream closed", name)
+		}
+	}
+}
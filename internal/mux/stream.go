@@ -0,0 +1,242 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream 会话中的一个流，实现 net.Conn 和 CloseWrite
+type Stream struct {
+	session *Session
+	id      uint32
+
+	mu       sync.Mutex
+	buf      bytes.Buffer // 已收到尚未读取的数据
+	consumed int          // 已读取但尚未通过窗口更新告知对端的字节数
+	window   int          // 还可以向对端发送的字节数
+	finRecv  bool
+	finSent  bool
+	closed   bool
+	err      error // 流被中止或会话关闭
+
+	readable chan struct{}
+	writable chan struct{}
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		session:       s,
+		id:            id,
+		window:        windowSize,
+		readable:      make(chan struct{}, 1),
+		writable:      make(chan struct{}, 1),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+}
+
+// ID 流 ID
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read 实现 io.Reader，对端半关闭后读完缓冲数据返回 io.EOF
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.closed {
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(p)
+			st.consumed += n
+			var update int
+			if st.consumed >= windowSize/2 && !st.finRecv {
+				update = st.consumed
+				st.consumed = 0
+			}
+			st.mu.Unlock()
+			if update > 0 {
+				var inc [4]byte
+				binary.BigEndian.PutUint32(inc[:], uint32(update))
+				st.session.writeFrame(frameWindow, st.id, inc[:])
+			}
+			return n, nil
+		}
+		switch {
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return 0, err
+		case st.finRecv:
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		st.mu.Unlock()
+
+		select {
+		case <-st.readable:
+		case <-st.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write 实现 io.Writer，对端窗口用尽时等待窗口更新
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.mu.Lock()
+		switch {
+		case st.closed:
+			st.mu.Unlock()
+			return written, net.ErrClosed
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		case st.finSent:
+			st.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		n := min(len(p)-written, st.window, MaxFrameData)
+		st.window -= n
+		st.mu.Unlock()
+
+		if n == 0 {
+			select {
+			case <-st.writable:
+				continue
+			case <-st.writeDeadline.wait():
+				return written, os.ErrDeadlineExceeded
+			}
+		}
+		if err := st.session.writeFrame(frameData, st.id, p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// CloseWrite 半关闭：通知对端不再发送数据，仍可继续读取
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.closed || st.finSent || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.finSent = true
+	st.mu.Unlock()
+	return st.session.writeFrame(frameFin, st.id, nil)
+}
+
+// Close 关闭流；双方都已半关闭时直接释放，否则向对端发送 reset 中止该流
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	abort := st.err == nil && !(st.finSent && st.finRecv)
+	st.mu.Unlock()
+	st.notify()
+
+	if abort {
+		st.session.writeFrame(frameReset, st.id, nil)
+	}
+	st.session.remove(st.id)
+	return nil
+}
+
+// LocalAddr 底层连接的本地地址
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+// RemoteAddr 底层连接的对端地址
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+// SetDeadline 同时设置读写截止时间
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.readDeadline.set(t)
+	st.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline 设置读截止时间
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline 设置写截止时间，只作用于等待窗口更新的时间
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.writeDeadline.set(t)
+	return nil
+}
+
+// push 收到数据帧，超出窗口说明对端违反流量控制
+func (st *Stream) push(p []byte) error {
+	st.mu.Lock()
+	if st.closed || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	if st.finRecv || st.buf.Len()+len(p) > windowSize {
+		st.mu.Unlock()
+		return errFlowControl
+	}
+	st.buf.Write(p)
+	st.mu.Unlock()
+	signal(st.readable)
+	return nil
+}
+
+func (st *Stream) addWindow(n int) {
+	st.mu.Lock()
+	st.window += n
+	st.mu.Unlock()
+	signal(st.writable)
+}
+
+func (st *Stream) finReceived() {
+	st.mu.Lock()
+	st.finRecv = true
+	st.mu.Unlock()
+	signal(st.readable)
+}
+
+// fail 流被中止或会话关闭，唤醒所有等待者
+func (st *Stream) fail(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.mu.Unlock()
+	st.notify()
+}
+
+func (st *Stream) notify() {
+	signal(st.readable)
+	signal(st.writable)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
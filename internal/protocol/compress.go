@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+// compressBufferSize 压缩输出的缓冲区大小，一次刷新的压缩数据尽量合并为一个加密数据包
+const compressBufferSize = 32 * 1024
+
+// CompressedWriter 压缩写入器，位于加密层之上：数据先压缩再加密
+// 每次 Write 都会刷新（sync flush），交互流量不会滞留在压缩器中
+type CompressedWriter struct {
+	dst io.Writer
+	buf *bufio.Writer
+	zw  *flate.Writer
+}
+
+// NewCompressedWriter 创建压缩写入器，dst 通常是 SecureWriter
+func NewCompressedWriter(dst io.Writer) *CompressedWriter {
+	buf := bufio.NewWriterSize(dst, compressBufferSize)
+	zw, _ := flate.NewWriter(buf, flate.BestSpeed) // 级别合法时不会出错
+	return &CompressedWriter{dst: dst, buf: buf, zw: zw}
+}
+
+// Write 实现 io.Writer，返回时数据已写入 dst
+func (cw *CompressedWriter) Write(p []byte) (int, error) {
+	n, err := cw.zw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := cw.zw.Flush(); err != nil {
+		return n, err
+	}
+	return n, cw.buf.Flush()
+}
+
+// CloseWrite 结束压缩流后半关闭 dst，对端读到压缩流结尾时返回 io.EOF
+func (cw *CompressedWriter) CloseWrite() error {
+	if err := cw.zw.Close(); err != nil {
+		return err
+	}
+	if err := cw.buf.Flush(); err != nil {
+		return err
+	}
+	c, ok := cw.dst.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("underlying writer does not support half-close")
+	}
+	return c.CloseWrite()
+}
+
+// CompressedReader 解压读取器，位于加密层之上：数据先解密再解压
+type CompressedReader struct {
+	src  io.Reader
+	zr   io.ReadCloser
+	done bool // 已读到压缩流结尾并读完其后的半关闭信号
+}
+
+// NewCompressedReader 创建解压读取器，src 通常是 SecureReader
+func NewCompressedReader(src io.Reader) *CompressedReader {
+	return &CompressedReader{src: src, zr: flate.NewReader(src)}
+}
+
+// Read 实现 io.Reader
+// 读到压缩流结尾时继续读完 src 中其后的半关闭信号再返回 io.EOF；
+// 否则该数据包留在接收缓冲区中，之后关闭连接时内核会发送 RST，对端尚未读取的数据随之丢失
+func (cr *CompressedReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+	n, err := cr.zr.Read(p)
+	if err == io.EOF {
+		if _, derr := io.Copy(io.Discard, cr.src); derr != nil {
+			return n, derr
+		}
+		cr.done = true
+	}
+	return n, err
+}
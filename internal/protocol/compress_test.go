@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"go-proxy-eins/internal/cipher"
+)
+
+// secureTunnel 返回写入 buf 的客户端加密写入端与从 buf 读取的服务端读取端
+func secureTunnel(t testing.TB, buf *bytes.Buffer) (io.Reader, io.Writer) {
+	t.Helper()
+	salt := make([]byte, cipher.SaltLen)
+	_, w, err := WrapTunnel(nil, buf, testPassword, salt, HandshakeOptions{}, cipher.RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := WrapTunnel(buf, nil, testPassword, salt, HandshakeOptions{}, cipher.RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, w
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	random := make([]byte, 100*1024)
+	rand.Read(random)
+	tests := []struct {
+		name   string
+		chunks [][]byte
+	}{
+		{"text", [][]byte{[]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")}},
+		{"compressible", [][]byte{bytes.Repeat([]byte("abcdefgh"), 64*1024)}},
+		{"incompressible", [][]byte{random}},
+		{"many writes", [][]byte{[]byte("a"), {}, []byte("bc"), random[:1000], []byte("d")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r, w := secureTunnel(t, &buf)
+			cw := NewCompressedWriter(w)
+			cr := NewCompressedReader(r)
+
+			var want []byte
+			for _, chunk := range tt.chunks {
+				if _, err := cw.Write(chunk); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, chunk...)
+			}
+			if err := cw.CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(cr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("read %d bytes, want %d identical bytes", len(got), len(want))
+			}
+			// 半关闭信号已被读完，连接中没有残留数据
+			if buf.Len() != 0 {
+				t.Fatalf("%d bytes left after EOF", buf.Len())
+			}
+		})
+	}
+}
+
+func TestCompressedWriteIsFlushed(t *testing.T) {
+	var buf bytes.Buffer
+	r, w := secureTunnel(t, &buf)
+	cw := NewCompressedWriter(w)
+	cr := NewCompressedReader(r)
+
+	// 每次 Write 返回后对端即可读到，交互流量不会滞留在压缩器中
+	for _, msg := range []string{"ls\n", "exit\n"} {
+		if _, err := cw.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(cr, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Fatalf("read %q, want %q", got, msg)
+		}
+	}
+}
+
+func TestCompressedCloseWriteRequiresHalfClose(t *testing.T) {
+	cw := NewCompressedWriter(io.Discard)
+	if err := cw.CloseWrite(); err == nil {
+		t.Fatal("CloseWrite succeeded on a writer without half-close")
+	}
+}
+
+// BenchmarkCompressedWriter 对比可压缩与不可压缩数据经压缩层和加密层写出的开销，ratio 为线上字节数与明文之比
+func BenchmarkCompressedWriter(b *testing.B) {
+	const chunk = 16 * 1024
+	// 不可压缩的数据每次取不同的位置，避免在压缩窗口内与之前写入的数据重复
+	random := make([]byte, 64*chunk)
+	rand.Read(random)
+	text := bytes.Repeat([]byte("GET /index.html HTTP/1.1\r\n"), 64*chunk/26)
+	payloads := []struct {
+		name string
+		data []byte
+	}{
+		{"compressible", text},
+		{"incompressible", random},
+	}
+	for _, p := range payloads {
+		for _, compress := range []bool{false, true} {
+			name := p.name + "/plain"
+			if compress {
+				name = p.name + "/compressed"
+			}
+			b.Run(name, func(b *testing.B) {
+				var buf bytes.Buffer
+				_, w := secureTunnel(b, &buf)
+				if compress {
+					w = NewCompressedWriter(w)
+				}
+				var wire int
+				b.SetBytes(chunk)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					off := i % 63 * chunk
+					if _, err := w.Write(p.data[off : off+chunk]); err != nil {
+						b.Fatal(err)
+					}
+					wire += buf.Len()
+					buf.Reset()
+				}
+				b.ReportMetric(float64(wire)/float64(b.N*chunk), "ratio")
+			})
+		}
+	}
+}
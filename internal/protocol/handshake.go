@@ -1,33 +1,159 @@
 package protocol
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"time"
+
+	"go-proxy-eins/internal/cipher"
 )
 
 const (
+	// 协议版本，任何线上格式变化都需要递增
+	// 1: 初始版本（握手无版本字段，1 字节目标地址长度）
+	// 2: 握手携带版本字段，2 字节目标地址长度，支持域名解析请求
+	// 3: 握手携带选项字段（混淆）
+	// 4: 选项增加压缩
+	// 5: 选项增加明文传输
+	// 6: 选项增加 ChaCha20 加密套件
+	// 7: 选项增加服务端身份，成功应答后可能紧跟身份证明
+	// 8: 选项增加换钥，数据阶段增加换钥控制数据包
+	// 9: 选项增加协商，应答 9 之后紧跟协商结果
+	// 10: 选项增加保活，数据阶段增加 ping/pong 控制数据包
+	// 11: 连接成功的状态之后紧跟服务端连接目标使用的本地地址
+	// 服务端接受 MinProtocolVersion 到 ProtocolVersion 的客户端，较旧的客户端不会设置之后新增的选项
+	ProtocolVersion    = 11
+	MinProtocolVersion = 3
+
 	// 握手参数
-	SaltLen       = 32
-	TimestampLen  = 8
-	HMACLen       = 32
-	HandshakeLen  = SaltLen + TimestampLen + HMACLen
-	
+	VersionLen   = 1
+	FlagsLen     = 1
+	SaltLen      = 32
+	TimestampLen = 8
+	HMACLen      = 32
+	HandshakeLen = VersionLen + FlagsLen + SaltLen + TimestampLen + HMACLen
+
+	// 握手选项
+	FlagObfuscate = 0x01
+	FlagCompress  = 0x02
+	FlagPlaintext = 0x04
+	FlagChaCha20  = 0x08 // 使用 cipher.SuiteChaCha20Poly1305，未设置时为 cipher.SuiteXChaCha20Poly1305
+	FlagIdentity  = 0x10 // 要求服务端在握手应答后发送身份证明，见 identity.go
+	FlagRekey     = 0x20 // 两个方向都可以发送换钥控制数据包，见 cipher.SecureWriter.Rekey
+	FlagNegotiate = 0x40 // 混淆与加密套件以服务端为准，服务端在应答中返回协商结果
+	FlagKeepalive = 0x80 // 本端能应答 ping 控制数据包，见 cipher.Keepalive
+
+	// 握手应答
+	handshakeOK                  = 0
+	handshakeAuthFailed          = 1
+	handshakeUnsupportedVersion  = 2
+	handshakeObfuscationMismatch = 3
+	handshakeCompressionMismatch = 4
+	handshakePlaintextMismatch   = 5
+	handshakeCipherMismatch      = 6
+	handshakeIdentityUnavailable = 7
+	handshakeRekeyMismatch       = 8
+	handshakeNegotiated          = 9 // 之后 1 字节为协商后的选项，客户端设置了 FlagNegotiate 或 FlagKeepalive 时使用
+
 	// 时间戳允许误差（秒）
 	TimeSkewAllowance = 30
 )
 
-// ClientHandshake 客户端执行握手
-// 发送: [salt(32)][timestamp(8)][HMAC(32)]
-func ClientHandshake(conn io.ReadWriter, password string) ([]byte, error) {
+var (
+	// ErrAuthFailed 服务端拒绝握手（密码错误或时钟偏差过大）
+	ErrAuthFailed = errors.New("authentication failed: password mismatch or server rejected the handshake")
+
+	// ErrUnsupportedVersion 对端不支持本端的协议版本
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+	// ErrObfuscationMismatch 客户端与服务端的混淆设置不一致
+	ErrObfuscationMismatch = errors.New("obfuscation setting mismatch")
+
+	// ErrCompressionMismatch 客户端与服务端的压缩设置不一致
+	ErrCompressionMismatch = errors.New("compression setting mismatch")
+
+	// ErrPlaintextMismatch 客户端与服务端的明文传输设置不一致
+	ErrPlaintextMismatch = errors.New("plaintext setting mismatch")
+
+	// ErrCipherSuiteMismatch 客户端与服务端的加密套件不一致
+	ErrCipherSuiteMismatch = errors.New("cipher suite mismatch")
+
+	// ErrRekeyMismatch 客户端与服务端的换钥设置不一致
+	ErrRekeyMismatch = errors.New("rekey setting mismatch")
+
+	// ErrUnauthenticated 服务端没有收到有效的握手数据（读取失败、时间戳或 HMAC 无效），对端可能不是本程序的客户端
+	ErrUnauthenticated = errors.New("unauthenticated handshake")
+)
+
+// HandshakeOptions 握手时声明的本端选项，两端必须一致
+type HandshakeOptions struct {
+	Obfuscate bool
+	Compress  bool
+	Plaintext bool   // 握手后不加密也不混淆，见 WrapTunnel
+	Cipher    string // 加密套件，为空表示 cipher.SuiteXChaCha20Poly1305
+
+	// 本端每个密钥发送多少字节明文后换钥，0 表示不换钥；两端是否启用必须一致，字节数可以不同
+	RekeyAfter uint64
+
+	// 客户端：期望的服务端公钥指纹（见 NormalizeFingerprint），为空表示不验证服务端身份
+	Fingerprint string
+	// 服务端：身份密钥，客户端要求时对握手数据签名；为 nil 时拒绝要求验证身份的客户端
+	Identity ed25519.PrivateKey
+	// 服务端：认证失败时不发送应答，由调用方发送诱饵响应（见 decoy）
+	QuietAuthFailure bool
+
+	// 客户端：混淆与加密套件不一致时采用服务端的设置，而不是握手失败
+	Negotiate bool
+
+	// 握手结果：对端能应答 ping 控制数据包，本端可以发送 keepalive；plaintext 时始终为 false
+	Keepalive bool
+
+	// 服务端握手结果：客户端的协议版本，决定之后可以向其发送哪些内容（如 BoundAddrVersion）
+	Version byte
+}
+
+// Credential 服务端接受的一组密码与加密套件
+type Credential struct {
+	Password string
+	Cipher   string // 为空表示 cipher.SuiteXChaCha20Poly1305
+}
+
+// chacha20 是否使用 cipher.SuiteChaCha20Poly1305
+func (o HandshakeOptions) chacha20() bool {
+	return o.Cipher == cipher.SuiteChaCha20Poly1305
+}
+
+// cipherSuite 返回握手标志对应的加密套件
+func cipherSuite(chacha20 bool) string {
+	if chacha20 {
+		return cipher.SuiteChaCha20Poly1305
+	}
+	return cipher.SuiteXChaCha20Poly1305
+}
+
+// ClientHandshake 客户端执行握手，opts 为本端的混淆、压缩、明文与加密套件设置
+// 发送: [version(1)][flags(1)][salt(32)][timestamp(8)][HMAC(32)]
+// opts.Fingerprint 不为空时还要验证服务端对握手数据的签名
+// 返回 salt 及双方协商后的选项，调用方应使用返回的选项包装连接
+func ClientHandshake(conn io.ReadWriter, password string, opts HandshakeOptions) ([]byte, HandshakeOptions, error) {
+	if opts.Fingerprint != "" {
+		fp, err := NormalizeFingerprint(opts.Fingerprint)
+		if err != nil {
+			return nil, opts, err
+		}
+		opts.Fingerprint = fp
+	}
+
 	// 生成随机 salt
 	salt := make([]byte, SaltLen)
 	if _, err := rand.Read(salt); err != nil {
-		return nil, fmt.Errorf("failed to generate salt: %w", err)
+		return nil, opts, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
 	// 当前时间戳（Unix 秒）
@@ -35,74 +161,246 @@ func ClientHandshake(conn io.ReadWriter, password string) ([]byte, error) {
 	timestampBytes := make([]byte, TimestampLen)
 	binary.BigEndian.PutUint64(timestampBytes, uint64(timestamp))
 
-	// 计算 HMAC: HMAC-SHA256(password, salt + timestamp)
+	// 计算 HMAC: HMAC-SHA256(password, version + flags + salt + timestamp)
+	header := []byte{ProtocolVersion, handshakeFlags(opts)}
 	h := hmac.New(sha256.New, []byte(password))
+	h.Write(header)
 	h.Write(salt)
 	h.Write(timestampBytes)
 	mac := h.Sum(nil)
 
 	// 发送握手数据
 	handshake := make([]byte, 0, HandshakeLen)
+	handshake = append(handshake, header...)
 	handshake = append(handshake, salt...)
 	handshake = append(handshake, timestampBytes...)
 	handshake = append(handshake, mac...)
 
 	if _, err := conn.Write(handshake); err != nil {
-		return nil, fmt.Errorf("failed to send handshake: %w", err)
+		return nil, opts, fmt.Errorf("failed to send handshake: %w", err)
 	}
 
 	// 读取服务端响应 (1 字节)
 	response := make([]byte, 1)
 	if _, err := io.ReadFull(conn, response); err != nil {
-		return nil, fmt.Errorf("failed to read handshake response: %w", err)
+		return nil, opts, fmt.Errorf("failed to read handshake response: %w", err)
+	}
+
+	opts.Keepalive = false
+	switch response[0] {
+	case handshakeOK:
+	case handshakeNegotiated:
+		if header[1]&(FlagNegotiate|FlagKeepalive) == 0 {
+			return nil, opts, ErrAuthFailed
+		}
+		agreed := make([]byte, 1)
+		if _, err := io.ReadFull(conn, agreed); err != nil {
+			return nil, opts, fmt.Errorf("failed to read negotiated options: %w", err)
+		}
+		if opts.Negotiate {
+			opts.Obfuscate = agreed[0]&FlagObfuscate != 0
+			opts.Cipher = cipherSuite(agreed[0]&FlagChaCha20 != 0)
+		}
+		opts.Keepalive = agreed[0]&FlagKeepalive != 0
+	case handshakeUnsupportedVersion:
+		return nil, opts, fmt.Errorf("%w: server does not support version %d", ErrUnsupportedVersion, ProtocolVersion)
+	case handshakeObfuscationMismatch:
+		return nil, opts, fmt.Errorf("%w: client obfuscate=%v, server differs", ErrObfuscationMismatch, opts.Obfuscate)
+	case handshakeCompressionMismatch:
+		return nil, opts, fmt.Errorf("%w: client compress=%v, server differs", ErrCompressionMismatch, opts.Compress)
+	case handshakePlaintextMismatch:
+		return nil, opts, fmt.Errorf("%w: client plaintext=%v, server differs", ErrPlaintextMismatch, opts.Plaintext)
+	case handshakeCipherMismatch:
+		return nil, opts, fmt.Errorf("%w: client cipher=%s, server differs", ErrCipherSuiteMismatch, cipherSuite(opts.chacha20()))
+	case handshakeIdentityUnavailable:
+		return nil, opts, ErrIdentityUnavailable
+	case handshakeRekeyMismatch:
+		return nil, opts, fmt.Errorf("%w: client rekey=%v, server differs", ErrRekeyMismatch, opts.RekeyAfter > 0)
+	default:
+		return nil, opts, ErrAuthFailed
 	}
 
-	if response[0] != 0 {
-		return nil, fmt.Errorf("authentication failed")
+	if opts.Fingerprint != "" {
+		if err := verifyIdentity(conn, opts.Fingerprint, handshake); err != nil {
+			return nil, opts, err
+		}
 	}
 
-	return salt, nil
+	return salt, opts, nil
 }
 
-// ServerHandshake 服务端执行握手验证
+// ServerHandshake 服务端执行握手验证，opts 为本端的混淆、压缩、明文与加密套件设置
 // 返回 salt 用于后续加密
-func ServerHandshake(conn io.Reader, writer io.Writer, password string) ([]byte, error) {
-	// 读取握手数据
+func ServerHandshake(conn io.Reader, writer io.Writer, password string, opts HandshakeOptions) ([]byte, error) {
+	salt, _, err := ServerHandshakeAny(conn, writer, []string{password}, opts)
+	return salt, err
+}
+
+// ServerHandshakeAny 同 ServerHandshake，客户端使用 passwords 中任意一个密码均可通过认证
+// 返回 salt 及匹配的密码在 passwords 中的下标
+func ServerHandshakeAny(conn io.Reader, writer io.Writer, passwords []string, opts HandshakeOptions) ([]byte, int, error) {
+	creds := make([]Credential, len(passwords))
+	for i, password := range passwords {
+		creds[i] = Credential{Password: password, Cipher: opts.Cipher}
+	}
+	salt, index, _, err := ServerHandshakeCredentials(conn, writer, creds, opts)
+	return salt, index, err
+}
+
+// ServerHandshakeCredentials 同 ServerHandshakeAny，每个密码可以使用不同的加密套件，忽略 opts.Cipher
+// 客户端的密码与加密套件需与 creds 中的某一项一致，用于逐步更换密码或加密套件；
+// 返回 salt、匹配的凭据在 creds 中的下标及协商后的选项（加密套件为该凭据的套件），调用方应使用返回的选项包装连接
+func ServerHandshakeCredentials(conn io.Reader, writer io.Writer, creds []Credential, opts HandshakeOptions) ([]byte, int, HandshakeOptions, error) {
+	// 先读取版本，握手其余部分的格式由版本决定；不支持的版本（如没有选项字段的版本 2）立即拒绝，不等待其余数据
 	handshake := make([]byte, HandshakeLen)
-	if _, err := io.ReadFull(conn, handshake); err != nil {
-		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	if _, err := io.ReadFull(conn, handshake[:VersionLen]); err != nil {
+		return nil, -1, opts, fmt.Errorf("%w: failed to read handshake: %w", ErrUnauthenticated, err)
+	}
+	if version := handshake[0]; version < MinProtocolVersion || version > ProtocolVersion {
+		// 发送诱饵响应时不透露版本信息，按未认证的连接处理
+		if opts.QuietAuthFailure {
+			return nil, -1, opts, fmt.Errorf("%w: %w: %d", ErrUnauthenticated, ErrUnsupportedVersion, version)
+		}
+		writer.Write([]byte{handshakeUnsupportedVersion})
+		return nil, -1, opts, fmt.Errorf("%w: %d (supported %d-%d)", ErrUnsupportedVersion, version, MinProtocolVersion, ProtocolVersion)
+	}
+	if _, err := io.ReadFull(conn, handshake[VersionLen:]); err != nil {
+		return nil, -1, opts, fmt.Errorf("%w: failed to read handshake: %w", ErrUnauthenticated, err)
 	}
 
 	// 解析握手数据
-	salt := handshake[:SaltLen]
-	timestampBytes := handshake[SaltLen : SaltLen+TimestampLen]
-	receivedMAC := handshake[SaltLen+TimestampLen:]
+	const headerLen = VersionLen + FlagsLen
+	header := handshake[:headerLen]
+	salt := handshake[headerLen : headerLen+SaltLen]
+	timestampBytes := handshake[headerLen+SaltLen : headerLen+SaltLen+TimestampLen]
+	receivedMAC := handshake[headerLen+SaltLen+TimestampLen:]
 
 	// 验证时间戳
 	timestamp := int64(binary.BigEndian.Uint64(timestampBytes))
 	now := time.Now().Unix()
 	if abs(now-timestamp) > TimeSkewAllowance {
-		writer.Write([]byte{1}) // 认证失败
-		return nil, fmt.Errorf("timestamp out of range: %d vs %d", timestamp, now)
+		if !opts.QuietAuthFailure {
+			writer.Write([]byte{handshakeAuthFailed})
+		}
+		return nil, -1, opts, fmt.Errorf("%w: timestamp out of range: %d vs %d", ErrUnauthenticated, timestamp, now)
 	}
 
-	// 验证 HMAC
-	h := hmac.New(sha256.New, []byte(password))
-	h.Write(salt)
-	h.Write(timestampBytes)
-	expectedMAC := h.Sum(nil)
+	// 验证 HMAC，依次尝试每个凭据；同一密码可以对应多个加密套件，优先选择与客户端一致的
+	clientChaCha20 := header[1]&FlagChaCha20 != 0
+	index := -1
+	for i, cred := range creds {
+		h := hmac.New(sha256.New, []byte(cred.Password))
+		h.Write(header)
+		h.Write(salt)
+		h.Write(timestampBytes)
+		if !hmac.Equal(receivedMAC, h.Sum(nil)) {
+			continue
+		}
+		if index < 0 {
+			index = i
+		}
+		if (cred.Cipher == cipher.SuiteChaCha20Poly1305) == clientChaCha20 {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		if !opts.QuietAuthFailure {
+			writer.Write([]byte{handshakeAuthFailed})
+		}
+		return nil, -1, opts, fmt.Errorf("%w: invalid authentication", ErrUnauthenticated)
+	}
+
+	// 客户端要求协商时混淆与加密套件以服务端为准，其余选项仍须一致
+	negotiate := header[1]&FlagNegotiate != 0
+	serverChaCha20 := creds[index].Cipher == cipher.SuiteChaCha20Poly1305
 
-	if !hmac.Equal(receivedMAC, expectedMAC) {
-		writer.Write([]byte{1}) // 认证失败
-		return nil, fmt.Errorf("invalid authentication")
+	// 混淆设置不一致时后续帧会错位，在握手阶段明确拒绝
+	if clientObfuscate := header[1]&FlagObfuscate != 0; clientObfuscate != opts.Obfuscate && !negotiate {
+		writer.Write([]byte{handshakeObfuscationMismatch})
+		return nil, -1, opts, fmt.Errorf("%w: client obfuscate=%v, server obfuscate=%v", ErrObfuscationMismatch, clientObfuscate, opts.Obfuscate)
+	}
+	if clientCompress := header[1]&FlagCompress != 0; clientCompress != opts.Compress {
+		writer.Write([]byte{handshakeCompressionMismatch})
+		return nil, -1, opts, fmt.Errorf("%w: client compress=%v, server compress=%v", ErrCompressionMismatch, clientCompress, opts.Compress)
+	}
+	if clientPlaintext := header[1]&FlagPlaintext != 0; clientPlaintext != opts.Plaintext {
+		writer.Write([]byte{handshakePlaintextMismatch})
+		return nil, -1, opts, fmt.Errorf("%w: client plaintext=%v, server plaintext=%v", ErrPlaintextMismatch, clientPlaintext, opts.Plaintext)
 	}
+	if clientChaCha20 != serverChaCha20 && !negotiate {
+		writer.Write([]byte{handshakeCipherMismatch})
+		return nil, -1, opts, fmt.Errorf("%w: client cipher=%s, server cipher=%s", ErrCipherSuiteMismatch, cipherSuite(clientChaCha20), cipherSuite(serverChaCha20))
+	}
+	if clientRekey := header[1]&FlagRekey != 0; clientRekey != (opts.RekeyAfter > 0) {
+		writer.Write([]byte{handshakeRekeyMismatch})
+		return nil, -1, opts, fmt.Errorf("%w: client rekey=%v, server rekey=%v", ErrRekeyMismatch, clientRekey, opts.RekeyAfter > 0)
+	}
+	wantIdentity := header[1]&FlagIdentity != 0
+	if wantIdentity && opts.Identity == nil {
+		writer.Write([]byte{handshakeIdentityUnavailable})
+		return nil, -1, opts, fmt.Errorf("%w: client requested server identity", ErrIdentityUnavailable)
+	}
+
+	// 双方都能应答 ping 时才可以发送 keepalive
+	opts.Cipher = creds[index].Cipher
+	opts.Keepalive = header[1]&FlagKeepalive != 0 && !opts.Plaintext
+	opts.Version = header[0]
 
-	// 认证成功
-	if _, err := writer.Write([]byte{0}); err != nil {
-		return nil, fmt.Errorf("failed to send success response: %w", err)
+	// 认证成功，客户端要求时附带身份证明；旧版本客户端不认识协商应答，只在对方设置了对应选项时发送
+	response := []byte{handshakeOK}
+	if negotiate || header[1]&FlagKeepalive != 0 {
+		agreed := header[1] &^ (FlagObfuscate | FlagChaCha20 | FlagKeepalive)
+		if opts.Obfuscate {
+			agreed |= FlagObfuscate
+		}
+		if serverChaCha20 {
+			agreed |= FlagChaCha20
+		}
+		if opts.Keepalive {
+			agreed |= FlagKeepalive
+		}
+		response = []byte{handshakeNegotiated, agreed}
 	}
+	if wantIdentity {
+		response = append(response, signIdentity(opts.Identity, handshake)...)
+	}
+	if _, err := writer.Write(response); err != nil {
+		return nil, -1, opts, fmt.Errorf("failed to send success response: %w", err)
+	}
+
+	return salt, index, opts, nil
+}
 
-	return salt, nil
+// handshakeFlags 编码握手选项
+func handshakeFlags(opts HandshakeOptions) byte {
+	var flags byte
+	if opts.Obfuscate {
+		flags |= FlagObfuscate
+	}
+	if opts.Compress {
+		flags |= FlagCompress
+	}
+	if opts.Plaintext {
+		flags |= FlagPlaintext
+	}
+	if opts.chacha20() {
+		flags |= FlagChaCha20
+	}
+	if opts.Fingerprint != "" {
+		flags |= FlagIdentity
+	}
+	if opts.RekeyAfter > 0 {
+		flags |= FlagRekey
+	}
+	if opts.Negotiate {
+		flags |= FlagNegotiate
+	}
+	if !opts.Plaintext {
+		flags |= FlagKeepalive
+	}
+	return flags
 }
 
 func abs(n int64) int64 {
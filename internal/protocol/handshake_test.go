@@ -0,0 +1,340 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+)
+
+const testPassword = "handshake-test"
+
+// rawHandshake 按线上格式构造任意版本与选项的握手数据
+func rawHandshake(password string, version, flags byte) []byte {
+	salt := bytes.Repeat([]byte{0x5a}, SaltLen)
+	ts := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))
+
+	h := hmac.New(sha256.New, []byte(password))
+	h.Write([]byte{version, flags})
+	h.Write(salt)
+	h.Write(ts)
+
+	data := append([]byte{version, flags}, salt...)
+	data = append(data, ts...)
+	return append(data, h.Sum(nil)...)
+}
+
+// serverReply 将 data 交给 ServerHandshake，返回服务端的错误与应答
+func serverReply(t *testing.T, data []byte, opts HandshakeOptions) ([]byte, error) {
+	t.Helper()
+	var reply bytes.Buffer
+	_, err := ServerHandshake(bytes.NewReader(data), &reply, testPassword, opts)
+	return reply.Bytes(), err
+}
+
+// handshakePair 在内存连接上同时运行两端握手
+func handshakePair(t *testing.T, client, server HandshakeOptions) (clientOpts HandshakeOptions, clientErr, serverErr error) {
+	t.Helper()
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	c.SetDeadline(deadline)
+	s.SetDeadline(deadline)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ServerHandshake(s, s, testPassword, server)
+		// 服务端失败后关闭连接，客户端读取应答时不会阻塞
+		if err != nil {
+			s.Close()
+		}
+		errCh <- err
+	}()
+	_, clientOpts, clientErr = ClientHandshake(c, testPassword, client)
+	return clientOpts, clientErr, <-errCh
+}
+
+func TestHandshakeSuccess(t *testing.T) {
+	opts, clientErr, serverErr := handshakePair(t, HandshakeOptions{}, HandshakeOptions{})
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("client: %v, server: %v", clientErr, serverErr)
+	}
+	if !opts.Keepalive {
+		t.Error("keepalive not agreed between two current peers")
+	}
+}
+
+func TestHandshakeRejectsUnsupportedVersion(t *testing.T) {
+	for _, version := range []byte{0, 1, 2, ProtocolVersion + 1, 0xff} {
+		reply, err := serverReply(t, rawHandshake(testPassword, version, 0), HandshakeOptions{})
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("version %d: err = %v, want ErrUnsupportedVersion", version, err)
+		}
+		if !bytes.Equal(reply, []byte{handshakeUnsupportedVersion}) {
+			t.Errorf("version %d: reply = %v, want [%d]", version, reply, handshakeUnsupportedVersion)
+		}
+	}
+}
+
+func TestHandshakeAcceptsSupportedVersions(t *testing.T) {
+	for version := byte(MinProtocolVersion); version <= ProtocolVersion; version++ {
+		reply, err := serverReply(t, rawHandshake(testPassword, version, 0), HandshakeOptions{})
+		if err != nil {
+			t.Errorf("version %d: %v", version, err)
+			continue
+		}
+		// 没有设置协商或保活选项的客户端（如版本 3）只收到 1 字节成功应答
+		if !bytes.Equal(reply, []byte{handshakeOK}) {
+			t.Errorf("version %d: reply = %v, want [%d]", version, reply, handshakeOK)
+		}
+	}
+}
+
+func TestClientHandshakeUnsupportedVersionReply(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	go func() {
+		defer s.Close()
+		io.ReadFull(s, make([]byte, HandshakeLen))
+		s.Write([]byte{handshakeUnsupportedVersion})
+	}()
+
+	_, _, err := ClientHandshake(c, testPassword, HandshakeOptions{})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("err = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestClientHandshakeSendsCurrentVersion(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	got := make(chan []byte, 1)
+	go func() {
+		defer s.Close()
+		data := make([]byte, HandshakeLen)
+		io.ReadFull(s, data)
+		got <- data
+	}()
+
+	ClientHandshake(c, testPassword, HandshakeOptions{})
+	if data := <-got; data[0] != ProtocolVersion {
+		t.Fatalf("client sent version %d, want %d", data[0], ProtocolVersion)
+	}
+}
+
+func TestHandshakeObfuscationMismatch(t *testing.T) {
+	for _, clientObfuscate := range []bool{true, false} {
+		_, clientErr, serverErr := handshakePair(t,
+			HandshakeOptions{Obfuscate: clientObfuscate},
+			HandshakeOptions{Obfuscate: !clientObfuscate})
+		if !errors.Is(clientErr, ErrObfuscationMismatch) {
+			t.Errorf("client obfuscate=%v: client err = %v, want ErrObfuscationMismatch", clientObfuscate, clientErr)
+		}
+		if !errors.Is(serverErr, ErrObfuscationMismatch) {
+			t.Errorf("client obfuscate=%v: server err = %v, want ErrObfuscationMismatch", clientObfuscate, serverErr)
+		}
+	}
+}
+
+func TestHandshakeObfuscationNegotiated(t *testing.T) {
+	opts, clientErr, serverErr := handshakePair(t,
+		HandshakeOptions{Obfuscate: false, Negotiate: true},
+		HandshakeOptions{Obfuscate: true})
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("client: %v, server: %v", clientErr, serverErr)
+	}
+	if !opts.Obfuscate {
+		t.Fatal("negotiating client did not adopt the server's obfuscate setting")
+	}
+}
+
+func TestHandshakeRejectsVersionBeforeRest(t *testing.T) {
+	// 版本 2 的握手没有选项字段，服务端只读到版本就应答，不能等待第 74 个字节
+	c, s := net.Pipe()
+	defer c.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ServerHandshake(s, s, testPassword, HandshakeOptions{})
+		s.Close()
+		errCh <- err
+	}()
+
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Write([]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		t.Fatalf("no reply after the version byte: %v", err)
+	}
+	if reply[0] != handshakeUnsupportedVersion {
+		t.Fatalf("reply = %d, want %d", reply[0], handshakeUnsupportedVersion)
+	}
+	if err := <-errCh; !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("server err = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestHandshakeQuietUnsupportedVersion(t *testing.T) {
+	reply, err := serverReply(t, rawHandshake(testPassword, 2, 0), HandshakeOptions{QuietAuthFailure: true})
+	if !errors.Is(err, ErrUnauthenticated) || !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("err = %v, want ErrUnauthenticated and ErrUnsupportedVersion", err)
+	}
+	if len(reply) != 0 {
+		t.Fatalf("quiet server replied %v", reply)
+	}
+}
+
+func TestHandshakeQuietAuthFailure(t *testing.T) {
+	stale := rawHandshake(testPassword, ProtocolVersion, 0)
+	binary.BigEndian.PutUint64(stale[VersionLen+FlagsLen+SaltLen:], uint64(time.Now().Add(-time.Hour).Unix()))
+
+	tests := map[string][]byte{
+		"wrong password":  rawHandshake("wrong", ProtocolVersion, 0),
+		"stale timestamp": stale,
+		"truncated":       []byte("GET / HTTP/1.1\r\n\r\n"),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			reply, err := serverReply(t, data, HandshakeOptions{QuietAuthFailure: true})
+			if !errors.Is(err, ErrUnauthenticated) {
+				t.Fatalf("err = %v, want ErrUnauthenticated", err)
+			}
+			// 不发送应答，调用方改发诱饵响应
+			if len(reply) != 0 {
+				t.Fatalf("quiet server replied %v", reply)
+			}
+		})
+	}
+
+	reply, err := serverReply(t, rawHandshake("wrong", ProtocolVersion, 0), HandshakeOptions{})
+	if !errors.Is(err, ErrUnauthenticated) || !bytes.Equal(reply, []byte{handshakeAuthFailed}) {
+		t.Fatalf("reply %v, err %v; want the auth failure byte without QuietAuthFailure", reply, err)
+	}
+}
+
+func TestHandshakeCredentials(t *testing.T) {
+	// 更换期间同时接受新旧两组密码与加密套件，旧密码也可以搭配新套件
+	creds := []Credential{
+		{Password: "new-password", Cipher: cipher.SuiteChaCha20Poly1305},
+		{Password: "old-password", Cipher: cipher.SuiteXChaCha20Poly1305},
+		{Password: "old-password", Cipher: cipher.SuiteChaCha20Poly1305},
+	}
+	tests := []struct {
+		name     string
+		password string
+		cipher   string
+		index    int
+		err      error
+	}{
+		{"new credentials", "new-password", cipher.SuiteChaCha20Poly1305, 0, nil},
+		{"old credentials", "old-password", cipher.SuiteXChaCha20Poly1305, 1, nil},
+		{"old password with new cipher", "old-password", cipher.SuiteChaCha20Poly1305, 2, nil},
+		{"new password with old cipher", "new-password", cipher.SuiteXChaCha20Poly1305, -1, ErrCipherSuiteMismatch},
+		{"unknown password", "other-password", cipher.SuiteChaCha20Poly1305, -1, ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, s := net.Pipe()
+			defer c.Close()
+			defer s.Close()
+			deadline := time.Now().Add(5 * time.Second)
+			c.SetDeadline(deadline)
+			s.SetDeadline(deadline)
+
+			type result struct {
+				index int
+				opts  HandshakeOptions
+				err   error
+			}
+			done := make(chan result, 1)
+			go func() {
+				_, index, opts, err := ServerHandshakeCredentials(s, s, creds, HandshakeOptions{})
+				if err != nil {
+					s.Close()
+				}
+				done <- result{index, opts, err}
+			}()
+			_, _, clientErr := ClientHandshake(c, tt.password, HandshakeOptions{Cipher: tt.cipher})
+			res := <-done
+
+			if tt.err != nil {
+				if !errors.Is(res.err, tt.err) {
+					t.Fatalf("server err = %v, want %v", res.err, tt.err)
+				}
+				if clientErr == nil {
+					t.Fatal("client handshake succeeded against a rejecting server")
+				}
+				return
+			}
+			if res.err != nil || clientErr != nil {
+				t.Fatalf("server: %v, client: %v", res.err, clientErr)
+			}
+			if res.index != tt.index {
+				t.Fatalf("matched credential %d, want %d", res.index, tt.index)
+			}
+		})
+	}
+}
+
+func TestHandshakeNegotiate(t *testing.T) {
+	xchacha, chacha := cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305
+	tests := []struct {
+		name           string
+		client, server HandshakeOptions
+		want           HandshakeOptions // 客户端得到的混淆与加密套件
+		err            error
+	}{
+		{"same settings",
+			HandshakeOptions{Negotiate: true, Cipher: chacha}, HandshakeOptions{Cipher: chacha},
+			HandshakeOptions{Cipher: chacha}, nil},
+		{"adopt server cipher",
+			HandshakeOptions{Negotiate: true, Cipher: xchacha}, HandshakeOptions{Cipher: chacha},
+			HandshakeOptions{Cipher: chacha}, nil},
+		{"adopt server obfuscate and cipher",
+			HandshakeOptions{Negotiate: true, Obfuscate: true, Cipher: chacha}, HandshakeOptions{Cipher: xchacha},
+			HandshakeOptions{Cipher: xchacha}, nil},
+		{"compress still must match",
+			HandshakeOptions{Negotiate: true, Compress: true}, HandshakeOptions{Obfuscate: true},
+			HandshakeOptions{}, ErrCompressionMismatch},
+		{"plaintext still must match",
+			HandshakeOptions{Negotiate: true}, HandshakeOptions{Plaintext: true},
+			HandshakeOptions{}, ErrPlaintextMismatch},
+		{"rekey still must match",
+			HandshakeOptions{Negotiate: true, RekeyAfter: 1 << 20}, HandshakeOptions{},
+			HandshakeOptions{}, ErrRekeyMismatch},
+		{"cipher mismatch without negotiate",
+			HandshakeOptions{Cipher: xchacha}, HandshakeOptions{Cipher: chacha},
+			HandshakeOptions{}, ErrCipherSuiteMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, clientErr, serverErr := handshakePair(t, tt.client, tt.server)
+			if tt.err != nil {
+				if !errors.Is(clientErr, tt.err) || !errors.Is(serverErr, tt.err) {
+					t.Fatalf("client: %v, server: %v; want %v on both sides", clientErr, serverErr, tt.err)
+				}
+				return
+			}
+			if clientErr != nil || serverErr != nil {
+				t.Fatalf("client: %v, server: %v", clientErr, serverErr)
+			}
+			if opts.Obfuscate != tt.want.Obfuscate || opts.Cipher != tt.want.Cipher {
+				t.Fatalf("agreed obfuscate=%v cipher=%s, want obfuscate=%v cipher=%s",
+					opts.Obfuscate, opts.Cipher, tt.want.Obfuscate, tt.want.Cipher)
+			}
+			// 其余选项保持客户端的设置
+			if opts.Compress != tt.client.Compress || !opts.Negotiate {
+				t.Fatalf("negotiation changed other options: %+v", opts)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 服务端身份证明: [公钥(32)][签名(64)]，只在客户端设置 FlagIdentity 时紧随握手应答发送
+const IdentityProofLen = ed25519.PublicKeySize + ed25519.SignatureSize
+
+// identityContext 签名内容的前缀，避免签名被挪作他用
+const identityContext = "go-proxy-eins server identity v1\x00"
+
+var (
+	// ErrIdentityMismatch 服务端公钥与固定的指纹不符，或签名无效
+	ErrIdentityMismatch = errors.New("server identity mismatch")
+
+	// ErrIdentityUnavailable 客户端要求验证服务端身份，但服务端没有配置身份密钥
+	ErrIdentityUnavailable = errors.New("server identity not configured")
+)
+
+// Fingerprint 返回公钥的指纹：SHA-256 的十六进制小写形式
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint 检查并规范化配置中的指纹，允许大写及 ":" 分隔
+func NormalizeFingerprint(fp string) (string, error) {
+	fp = strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+	raw, err := hex.DecodeString(fp)
+	if err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("invalid server fingerprint: must be %d hex characters", sha256.Size*2)
+	}
+	return fp, nil
+}
+
+// signIdentity 服务端用身份密钥对客户端握手数据签名，握手数据含随机 salt，签名不能重放到其他连接
+func signIdentity(key ed25519.PrivateKey, handshake []byte) []byte {
+	proof := make([]byte, 0, IdentityProofLen)
+	proof = append(proof, key.Public().(ed25519.PublicKey)...)
+	return append(proof, ed25519.Sign(key, identityMessage(handshake))...)
+}
+
+// verifyIdentity 客户端读取服务端的身份证明，检查公钥指纹并验证对本次握手数据的签名
+func verifyIdentity(r io.Reader, fingerprint string, handshake []byte) error {
+	proof := make([]byte, IdentityProofLen)
+	if _, err := io.ReadFull(r, proof); err != nil {
+		return fmt.Errorf("failed to read server identity: %w", err)
+	}
+
+	pub := ed25519.PublicKey(proof[:ed25519.PublicKeySize])
+	if got := Fingerprint(pub); got != fingerprint {
+		return fmt.Errorf("%w: server fingerprint %s, expected %s", ErrIdentityMismatch, got, fingerprint)
+	}
+	if !ed25519.Verify(pub, identityMessage(handshake), proof[ed25519.PublicKeySize:]) {
+		return fmt.Errorf("%w: invalid signature", ErrIdentityMismatch)
+	}
+	return nil
+}
+
+func identityMessage(handshake []byte) []byte {
+	msg := make([]byte, 0, len(identityContext)+len(handshake))
+	msg = append(msg, identityContext...)
+	return append(msg, handshake...)
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testIdentity(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHandshakeIdentityMatch(t *testing.T) {
+	key := testIdentity(t)
+	fp := Fingerprint(key.Public().(ed25519.PublicKey))
+
+	// 配置中的指纹允许大写与 ":" 分隔
+	var colon []string
+	for i := 0; i < len(fp); i += 2 {
+		colon = append(colon, strings.ToUpper(fp[i:i+2]))
+	}
+	for _, pinned := range []string{fp, strings.Join(colon, ":")} {
+		_, clientErr, serverErr := handshakePair(t, HandshakeOptions{Fingerprint: pinned}, HandshakeOptions{Identity: key})
+		if clientErr != nil || serverErr != nil {
+			t.Fatalf("fingerprint %s: client: %v, server: %v", pinned, clientErr, serverErr)
+		}
+	}
+
+	// 未固定指纹的客户端照常连接配置了身份密钥的服务端
+	if _, clientErr, serverErr := handshakePair(t, HandshakeOptions{}, HandshakeOptions{Identity: key}); clientErr != nil || serverErr != nil {
+		t.Fatalf("unpinned client: client: %v, server: %v", clientErr, serverErr)
+	}
+}
+
+func TestHandshakeIdentityMismatch(t *testing.T) {
+	pinned := Fingerprint(testIdentity(t).Public().(ed25519.PublicKey))
+
+	_, clientErr, _ := handshakePair(t, HandshakeOptions{Fingerprint: pinned}, HandshakeOptions{Identity: testIdentity(t)})
+	if !errors.Is(clientErr, ErrIdentityMismatch) {
+		t.Fatalf("client err = %v, want ErrIdentityMismatch", clientErr)
+	}
+}
+
+func TestHandshakeIdentityUnavailable(t *testing.T) {
+	pinned := Fingerprint(testIdentity(t).Public().(ed25519.PublicKey))
+
+	_, clientErr, serverErr := handshakePair(t, HandshakeOptions{Fingerprint: pinned}, HandshakeOptions{})
+	if !errors.Is(clientErr, ErrIdentityUnavailable) || !errors.Is(serverErr, ErrIdentityUnavailable) {
+		t.Fatalf("client: %v, server: %v, want ErrIdentityUnavailable on both ends", clientErr, serverErr)
+	}
+}
+
+func TestVerifyIdentityRejectsReplayedProof(t *testing.T) {
+	key := testIdentity(t)
+	fp := Fingerprint(key.Public().(ed25519.PublicKey))
+	recorded := rawHandshake(testPassword, ProtocolVersion, FlagIdentity)
+
+	if err := verifyIdentity(bytes.NewReader(signIdentity(key, recorded)), fp, recorded); err != nil {
+		t.Fatalf("genuine proof: %v", err)
+	}
+
+	// 录下的身份证明签的是另一次握手的 salt，不能用于本次连接
+	current := bytes.Clone(recorded)
+	current[VersionLen+FlagsLen] ^= 0xff
+	err := verifyIdentity(bytes.NewReader(signIdentity(key, recorded)), fp, current)
+	if !errors.Is(err, ErrIdentityMismatch) {
+		t.Fatalf("replayed proof: err = %v, want ErrIdentityMismatch", err)
+	}
+}
+
+func TestNormalizeFingerprintInvalid(t *testing.T) {
+	for _, fp := range []string{"", "abcd", strings.Repeat("zz", 32), strings.Repeat("ab", 33)} {
+		if _, err := NormalizeFingerprint(fp); err == nil {
+			t.Errorf("NormalizeFingerprint(%q) succeeded", fp)
+		}
+	}
+	if _, _, err := ClientHandshake(nil, testPassword, HandshakeOptions{Fingerprint: "abcd"}); err == nil {
+		t.Error("ClientHandshake accepted an invalid fingerprint")
+	}
+}
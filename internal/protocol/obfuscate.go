@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync"
 )
 
 const (
@@ -12,9 +13,16 @@ const (
 	MaxPaddingLen = 64
 )
 
+// MaxObfuscationOverhead 返回混淆后每帧最多增加的字节数：前后填充长度 + 数据长度 + 两段最长填充
+// 加密层的每个数据包最多分为 cipher.FramesPerPacket 帧
+func MaxObfuscationOverhead() int {
+	return 1 + MaxPaddingLen + 2 + 1 + MaxPaddingLen
+}
+
 // ObfuscatedReader 包装 io.Reader，自动去除混淆
 type ObfuscatedReader struct {
-	src io.Reader
+	src    io.Reader
+	buffer []byte // 上一帧中 p 放不下的数据
 }
 
 // NewObfuscatedReader 创建混淆读取器
@@ -23,72 +31,103 @@ func NewObfuscatedReader(src io.Reader) *ObfuscatedReader {
 }
 
 // Read 实现 io.Reader，自动去除填充
+// 帧大于 p 时剩余的数据留到下次读取，调用方可以使用任意大小的缓冲区
+// 不含数据的帧（旧版本对空写入也会写出一帧）被直接跳过，不会返回 0, nil
 func (or *ObfuscatedReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(or.buffer) > 0 {
+		n = copy(p, or.buffer)
+		or.buffer = or.buffer[n:]
+		return n, nil
+	}
+
+	for {
+		data, err := or.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		if len(data) > 0 {
+			n = copy(p, data)
+			or.buffer = data[n:]
+			return n, nil
+		}
+	}
+}
+
+// readFrame 读取一帧并返回其中的数据，只有在帧边界处遇到 EOF 时才返回 io.EOF
+func (or *ObfuscatedReader) readFrame() ([]byte, error) {
 	// 读取前填充长度 (1 字节)
 	lenBuf := make([]byte, 1)
 	if _, err := io.ReadFull(or.src, lenBuf); err != nil {
-		return 0, err
+		return nil, err
 	}
 	prePaddingLen := int(lenBuf[0])
 
 	if prePaddingLen > MaxPaddingLen {
-		return 0, fmt.Errorf("invalid padding length: %d", prePaddingLen)
+		return nil, fmt.Errorf("invalid padding length: %d", prePaddingLen)
 	}
 
 	// 跳过前填充
 	if prePaddingLen > 0 {
 		padding := make([]byte, prePaddingLen)
-		if _, err := io.ReadFull(or.src, padding); err != nil {
-			return 0, err
+		if err := or.readFull(padding); err != nil {
+			return nil, err
 		}
 	}
 
 	// 读取实际数据长度 (2 字节)
-	if _, err := io.ReadFull(or.src, lenBuf[:1]); err != nil {
-		return 0, err
+	if err := or.readFull(lenBuf[:1]); err != nil {
+		return nil, err
 	}
 	var dataLenBuf [2]byte
 	dataLenBuf[0] = lenBuf[0]
-	if _, err := io.ReadFull(or.src, dataLenBuf[1:]); err != nil {
-		return 0, err
+	if err := or.readFull(dataLenBuf[1:]); err != nil {
+		return nil, err
 	}
 	dataLen := binary.BigEndian.Uint16(dataLenBuf[:])
 
 	// 读取实际数据
-	if int(dataLen) > len(p) {
-		return 0, fmt.Errorf("buffer too small: need %d, have %d", dataLen, len(p))
-	}
-
 	data := make([]byte, dataLen)
-	if _, err := io.ReadFull(or.src, data); err != nil {
-		return 0, err
+	if err := or.readFull(data); err != nil {
+		return nil, err
 	}
 
 	// 读取后填充长度
-	if _, err := io.ReadFull(or.src, lenBuf); err != nil {
-		return 0, err
+	if err := or.readFull(lenBuf); err != nil {
+		return nil, err
 	}
 	postPaddingLen := int(lenBuf[0])
 
 	if postPaddingLen > MaxPaddingLen {
-		return 0, fmt.Errorf("invalid post padding length: %d", postPaddingLen)
+		return nil, fmt.Errorf("invalid post padding length: %d", postPaddingLen)
 	}
 
 	// 跳过后填充
 	if postPaddingLen > 0 {
 		padding := make([]byte, postPaddingLen)
-		if _, err := io.ReadFull(or.src, padding); err != nil {
-			return 0, err
+		if err := or.readFull(padding); err != nil {
+			return nil, err
 		}
 	}
 
-	// 复制到输出
-	n = copy(p, data)
-	return n, nil
+	return data, nil
+}
+
+// readFull 读取帧的剩余部分，此时遇到 EOF 说明帧被截断，返回 io.ErrUnexpectedEOF 而不是 io.EOF
+func (or *ObfuscatedReader) readFull(b []byte) error {
+	_, err := io.ReadFull(or.src, b)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
 
 // ObfuscatedWriter 包装 io.Writer，自动添加混淆
+// 方法可以并发调用，每次调用的所有帧连续写出，不会与其它调用交错
 type ObfuscatedWriter struct {
+	mu  sync.Mutex
 	dst io.Writer
 }
 
@@ -97,61 +136,61 @@ func NewObfuscatedWriter(dst io.Writer) *ObfuscatedWriter {
 	return &ObfuscatedWriter{dst: dst}
 }
 
-// Write 实现 io.Writer，自动添加填充
+// Write 实现 io.Writer，自动添加填充；与 cipher.SecureWriter 一致，空写入不产生任何输出
 func (ow *ObfuscatedWriter) Write(p []byte) (n int, err error) {
-	// 生成随机填充长度
-	prePaddingLen := randomPaddingLen()
-	postPaddingLen := randomPaddingLen()
-
-	// 生成填充数据
-	prePadding := make([]byte, prePaddingLen)
-	postPadding := make([]byte, postPaddingLen)
-	if prePaddingLen > 0 {
-		rand.Read(prePadding)
-	}
-	if postPaddingLen > 0 {
-		rand.Read(postPadding)
-	}
+	return ow.WriteFrames(p)
+}
 
-	// 写入：[前填充长度(1)][前填充][数据长度(2)][数据][后填充长度(1)][后填充]
-	
-	// 前填充长度
-	if _, err := ow.dst.Write([]byte{byte(prePaddingLen)}); err != nil {
-		return 0, err
+// WriteFrames 将每个数据块分别混淆为一帧，所有帧合并后只调用一次 dst.Write
+// 每帧格式：[前填充长度(1)][前填充][数据长度(2)][数据][后填充长度(1)][后填充]
+// 空数据块被跳过，全部为空时不写入 dst
+func (ow *ObfuscatedWriter) WriteFrames(frames ...[]byte) (n int, err error) {
+	size := 0
+	for _, p := range frames {
+		if len(p) > 0xFFFF {
+			return 0, fmt.Errorf("data too large: %d", len(p))
+		}
+		size += MaxObfuscationOverhead() + len(p)
 	}
 
-	// 前填充
-	if prePaddingLen > 0 {
-		if _, err := ow.dst.Write(prePadding); err != nil {
-			return 0, err
+	buf := make([]byte, 0, size)
+	for _, p := range frames {
+		if len(p) == 0 {
+			continue
 		}
-	}
 
-	// 数据长度
-	lenBuf := make([]byte, 2)
-	binary.BigEndian.PutUint16(lenBuf, uint16(len(p)))
-	if _, err := ow.dst.Write(lenBuf); err != nil {
-		return 0, err
-	}
+		// 前填充
+		buf = appendPadding(buf)
 
-	// 实际数据
-	if _, err := ow.dst.Write(p); err != nil {
-		return 0, err
+		// 数据长度与实际数据
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(p)))
+		buf = append(buf, p...)
+		n += len(p)
+
+		// 后填充
+		buf = appendPadding(buf)
 	}
 
-	// 后填充长度
-	if _, err := ow.dst.Write([]byte{byte(postPaddingLen)}); err != nil {
-		return 0, err
+	if len(buf) == 0 {
+		return 0, nil
 	}
 
-	// 后填充
-	if postPaddingLen > 0 {
-		if _, err := ow.dst.Write(postPadding); err != nil {
-			return 0, err
-		}
+	ow.mu.Lock()
+	_, err = ow.dst.Write(buf)
+	ow.mu.Unlock()
+	if err != nil {
+		return 0, err
 	}
+	return n, nil
+}
 
-	return len(p), nil
+// appendPadding 追加 [填充长度(1)][随机填充]
+func appendPadding(buf []byte) []byte {
+	paddingLen := randomPaddingLen()
+	buf = append(buf, byte(paddingLen))
+	padding := buf[len(buf) : len(buf)+paddingLen]
+	rand.Read(padding)
+	return buf[:len(buf)+paddingLen]
 }
 
 // randomPaddingLen 生成随机填充长度 (0-MaxPaddingLen)
@@ -0,0 +1,339 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"go-proxy-eins/internal/cipher"
+)
+
+// countingWriter 记录底层 Write 的调用次数
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+// shortWriter 只写出一半数据并返回 io.ErrShortWrite
+type shortWriter struct {
+	bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	n, _ := w.Buffer.Write(p[:len(p)/2])
+	return n, io.ErrShortWrite
+}
+
+// obfuscatedTunnel 返回写入 dst 的混淆客户端写入端，以及从 src 读取的服务端读取端
+func obfuscatedTunnel(t testing.TB, suite string, src io.Reader, dst io.Writer) (io.Reader, io.Writer) {
+	t.Helper()
+	salt := make([]byte, cipher.SaltLen)
+	opts := HandshakeOptions{Obfuscate: true, Cipher: suite}
+	_, w, err := WrapTunnel(nil, dst, testPassword, salt, opts, cipher.RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := WrapTunnel(src, nil, testPassword, salt, opts, cipher.RoleServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, w
+}
+
+func TestObfuscatedSecureWriterSingleWritePerPacket(t *testing.T) {
+	for _, suite := range []string{cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305} {
+		t.Run(suite, func(t *testing.T) {
+			var dst countingWriter
+			r, w := obfuscatedTunnel(t, suite, &dst, &dst)
+
+			var want []byte
+			for i, size := range []int{1, 100, cipher.MaxPayloadSize()} {
+				payload := bytes.Repeat([]byte{byte('a' + i)}, size)
+				if _, err := w.Write(payload); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, payload...)
+				if dst.writes != i+1 {
+					t.Fatalf("after packet %d: %d writes to dst, want %d", i+1, dst.writes, i+1)
+				}
+			}
+
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("payload mismatch after obfuscated round trip")
+			}
+		})
+	}
+}
+
+func TestObfuscatedWriterShortWrite(t *testing.T) {
+	var dst shortWriter
+	ow := NewObfuscatedWriter(&dst)
+
+	n, err := ow.WriteFrames([]byte("length"), []byte("ciphertext"))
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("err = %v, want io.ErrShortWrite", err)
+	}
+	if n != 0 {
+		t.Fatalf("n = %d after a short write, want 0", n)
+	}
+}
+
+func TestSecureWriterPropagatesShortWrite(t *testing.T) {
+	var dst shortWriter
+	_, w := obfuscatedTunnel(t, cipher.SuiteXChaCha20Poly1305, nil, &dst)
+
+	if _, err := w.Write([]byte("payload")); !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("err = %v, want io.ErrShortWrite", err)
+	}
+}
+
+// BenchmarkObfuscatedSecureWriter 报告每个数据包产生的底层写入次数（writes/op）
+func BenchmarkObfuscatedSecureWriter(b *testing.B) {
+	for _, suite := range []string{cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305} {
+		b.Run(suite, func(b *testing.B) {
+			var dst countingWriter
+			_, w := obfuscatedTunnel(b, suite, nil, &dst)
+			payload := make([]byte, 16*1024)
+
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := w.Write(payload); err != nil {
+					b.Fatal(err)
+				}
+				dst.Reset()
+			}
+			b.ReportMetric(float64(dst.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestObfuscationOverheadBound(t *testing.T) {
+	var dst bytes.Buffer
+	ow := NewObfuscatedWriter(&dst)
+	// 填充长度随机，多次写入覆盖不同的填充
+	for range 200 {
+		dst.Reset()
+		if _, err := ow.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+		if overhead := dst.Len() - 100; overhead < 4 || overhead > MaxObfuscationOverhead() {
+			t.Fatalf("frame overhead %d outside [4, %d]", overhead, MaxObfuscationOverhead())
+		}
+	}
+}
+
+func TestObfuscatedPacketOverheadBound(t *testing.T) {
+	for _, suite := range []string{cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305} {
+		t.Run(suite, func(t *testing.T) {
+			var dst bytes.Buffer
+			_, w := obfuscatedTunnel(t, suite, nil, &dst)
+			c, err := cipher.NewCipherSuite(testPassword, make([]byte, cipher.SaltLen), suite, cipher.RoleClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limit := c.Overhead() + cipher.FramesPerPacket*MaxObfuscationOverhead()
+			for range 50 {
+				dst.Reset()
+				if _, err := w.Write(make([]byte, 100)); err != nil {
+					t.Fatal(err)
+				}
+				if overhead := dst.Len() - 100; overhead <= c.Overhead() || overhead > limit {
+					t.Fatalf("packet overhead %d outside (%d, %d]", overhead, c.Overhead(), limit)
+				}
+			}
+		})
+	}
+}
+
+func TestObfuscatedRoundTripSizes(t *testing.T) {
+	var dst bytes.Buffer
+	ow := NewObfuscatedWriter(&dst)
+	var want []byte
+	for _, size := range []int{0, 1, MaxPaddingLen, 1000, 0xFFFF} {
+		payload := bytes.Repeat([]byte{byte(size)}, size)
+		if n, err := ow.Write(payload); err != nil || n != size {
+			t.Fatalf("Write(%d bytes) = %d, %v", size, n, err)
+		}
+		want = append(want, payload...)
+	}
+	if _, err := ow.Write(make([]byte, 0x10000)); err == nil {
+		t.Fatal("Write accepted a frame longer than 0xFFFF bytes")
+	}
+
+	got, err := io.ReadAll(NewObfuscatedReader(&dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("read %d bytes, want %d identical bytes", len(got), len(want))
+	}
+}
+
+func TestObfuscatedReaderTruncatedFrame(t *testing.T) {
+	var dst bytes.Buffer
+	NewObfuscatedWriter(&dst).Write([]byte("payload"))
+	data := dst.Bytes()
+
+	// 帧中间截断返回 io.ErrUnexpectedEOF，只有帧边界处的 EOF 才是正常结束
+	for i := 1; i < len(data); i++ {
+		_, err := io.ReadAll(NewObfuscatedReader(bytes.NewReader(data[:i])))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("truncated at %d of %d bytes: err = %v, want io.ErrUnexpectedEOF", i, len(data), err)
+		}
+	}
+}
+
+func FuzzObfuscatedReader(f *testing.F) {
+	var dst bytes.Buffer
+	NewObfuscatedWriter(&dst).WriteFrames([]byte{0, 24}, []byte("ciphertext"))
+	f.Add(dst.Bytes())
+	f.Add([]byte{MaxPaddingLen + 1})
+	f.Add([]byte{0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// 任意输入都只能返回数据或错误，不能崩溃
+		io.ReadAll(NewObfuscatedReader(bytes.NewReader(data)))
+
+		// 写出后读回的数据不变
+		if len(data) > 0xFFFF {
+			return
+		}
+		var buf bytes.Buffer
+		if _, err := NewObfuscatedWriter(&buf).Write(data); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(NewObfuscatedReader(&buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("round trip returned %d bytes, want %d identical bytes", len(got), len(data))
+		}
+	})
+}
+
+// 用 -race 运行：并发调用 WriteFrames 时每次调用的帧连续写出
+func TestObfuscatedWriterConcurrentWrites(t *testing.T) {
+	const writers, writes = 8, 200
+	var dst bytes.Buffer
+	ow := NewObfuscatedWriter(&dst)
+
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				head := []byte{byte(w), byte(i >> 8), byte(i), 'h'}
+				body := []byte{byte(w), byte(i >> 8), byte(i), 'b'}
+				if _, err := ow.WriteFrames(head, body); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	or := NewObfuscatedReader(&dst)
+	pair := make([]byte, 8)
+	for range writers * writes {
+		if _, err := io.ReadFull(or, pair); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pair[:3], pair[4:7]) || pair[3] != 'h' || pair[7] != 'b' {
+			t.Fatalf("frames of one call interleaved with another: %x", pair)
+		}
+	}
+	if n, _ := io.ReadFull(or, pair); n != 0 {
+		t.Fatalf("%d unexpected bytes after all frames", n)
+	}
+}
+
+func TestObfuscatedEmptyWrite(t *testing.T) {
+	var dst countingWriter
+	ow := NewObfuscatedWriter(&dst)
+	if n, err := ow.Write(nil); n != 0 || err != nil {
+		t.Fatalf("Write(nil) = %d, %v", n, err)
+	}
+	if n, err := ow.WriteFrames([]byte{}, nil); n != 0 || err != nil {
+		t.Fatalf("WriteFrames of empty frames = %d, %v", n, err)
+	}
+	if dst.writes != 0 || dst.Len() != 0 {
+		t.Fatalf("empty writes produced %d writes, %d bytes", dst.writes, dst.Len())
+	}
+
+	// 空数据块与非空数据块一起写入时只为非空的写出帧
+	if _, err := ow.WriteFrames(nil, []byte("data"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(NewObfuscatedReader(&dst.Buffer))
+	if err != nil || string(got) != "data" {
+		t.Fatalf("read %q, %v; want data", got, err)
+	}
+}
+
+func TestObfuscatedReaderSkipsEmptyFrames(t *testing.T) {
+	// 旧版本对空写入写出的帧：[前填充长度 0][数据长度 0][后填充长度 0]
+	empty := []byte{0, 0, 0, 0}
+	var stream bytes.Buffer
+	stream.Write(empty)
+	stream.Write(empty)
+	NewObfuscatedWriter(&stream).Write([]byte("after empty frames"))
+	stream.Write(empty)
+
+	or := NewObfuscatedReader(&stream)
+	if n, err := or.Read(nil); n != 0 || err != nil {
+		t.Fatalf("Read(nil) = %d, %v", n, err)
+	}
+	p := make([]byte, 64)
+	n, err := or.Read(p)
+	if err != nil || string(p[:n]) != "after empty frames" {
+		t.Fatalf("Read = %q, %v; want the data after the empty frames", p[:n], err)
+	}
+	// 结尾只剩空帧时返回 io.EOF，不返回 0, nil
+	if n, err := or.Read(p); n != 0 || err != io.EOF {
+		t.Fatalf("Read at the end = %d, %v; want 0, io.EOF", n, err)
+	}
+}
+
+func TestObfuscatedTunnelEmptyWrites(t *testing.T) {
+	for _, suite := range []string{cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305} {
+		t.Run(suite, func(t *testing.T) {
+			var dst countingWriter
+			r, w := obfuscatedTunnel(t, suite, &dst, &dst)
+
+			var want []byte
+			for _, chunk := range []string{"", "first", "", "", "second", ""} {
+				writes := dst.writes
+				if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+				}
+				if chunk == "" && dst.writes != writes {
+					t.Fatal("empty write reached the connection")
+				}
+				want = append(want, chunk...)
+			}
+
+			var got bytes.Buffer
+			if _, err := io.Copy(&got, r); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != string(want) {
+				t.Fatalf("read %q, want %q", got.String(), want)
+			}
+		})
+	}
+}
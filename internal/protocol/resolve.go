@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// 域名解析应答状态
+const (
+	ResolveOK       byte = 0
+	ResolveFailed   byte = 1
+	ResolveNXDomain byte = 2
+	ResolveTimeout  byte = 3
+)
+
+var (
+	// ErrNXDomain 域名不存在
+	ErrNXDomain = errors.New("no such host")
+
+	// ErrResolveTimeout 服务端解析超时
+	ErrResolveTimeout = errors.New("resolve timeout")
+)
+
+// WriteResolveReply 发送域名解析应答
+// 协议: [状态(1字节)]，成功时再发送 [列表长度(2字节)][逗号分隔的 IP 列表]
+// 列表超过 MaxTargetLen 时截断多余的地址
+func WriteResolveReply(w io.Writer, status byte, ips []net.IP) error {
+	items := make([]string, len(ips))
+	for i, ip := range ips {
+		items[i] = ip.String()
+	}
+	return writeResolveList(w, status, items)
+}
+
+// WriteResolvePTRReply 发送反向解析应答，格式同 WriteResolveReply，列表为逗号分隔的域名
+func WriteResolvePTRReply(w io.Writer, status byte, names []string) error {
+	return writeResolveList(w, status, names)
+}
+
+func writeResolveList(w io.Writer, status byte, items []string) error {
+	if _, err := w.Write([]byte{status}); err != nil {
+		return err
+	}
+	if status != ResolveOK {
+		return nil
+	}
+
+	var list string
+	for _, item := range items {
+		if list != "" {
+			item = "," + item
+		}
+		if len(list)+len(item) > MaxTargetLen {
+			break
+		}
+		list += item
+	}
+
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(list)))
+	if _, err := w.Write(lenBuf); err != nil {
+		return err
+	}
+	if list == "" {
+		return nil
+	}
+	_, err := w.Write([]byte(list))
+	return err
+}
+
+// ReadResolveReply 读取域名解析应答
+// 域名不存在时返回 ErrNXDomain，服务端解析超时返回 ErrResolveTimeout
+func ReadResolveReply(r io.Reader) ([]net.IP, error) {
+	items, err := readResolveList(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, s := range items {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address in resolve reply: %q", s)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// ReadResolvePTRReply 读取反向解析应答，返回的域名可能带有末尾的点
+// 地址没有 PTR 记录时返回 ErrNXDomain，服务端解析超时返回 ErrResolveTimeout
+func ReadResolvePTRReply(r io.Reader) ([]string, error) {
+	return readResolveList(r)
+}
+
+func readResolveList(r io.Reader) ([]string, error) {
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(r, status); err != nil {
+		return nil, fmt.Errorf("failed to read resolve status: %w", err)
+	}
+
+	switch status[0] {
+	case ResolveOK:
+	case ResolveNXDomain:
+		return nil, ErrNXDomain
+	case ResolveTimeout:
+		return nil, ErrResolveTimeout
+	default:
+		return nil, fmt.Errorf("server failed to resolve (status %d)", status[0])
+	}
+
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return nil, fmt.Errorf("failed to read resolve reply length: %w", err)
+	}
+	listLen := int(binary.BigEndian.Uint16(lenBuf))
+	if listLen > MaxTargetLen {
+		return nil, fmt.Errorf("invalid resolve reply length: %d", listLen)
+	}
+	if listLen == 0 {
+		return nil, nil
+	}
+
+	listBuf := make([]byte, listLen)
+	if _, err := io.ReadFull(r, listBuf); err != nil {
+		return nil, fmt.Errorf("failed to read resolve reply: %w", err)
+	}
+	return strings.Split(string(listBuf), ","), nil
+}
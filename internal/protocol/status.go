@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+)
+
+// 连接目标应答状态，服务端收到 WriteTarget 请求后发送 1 字节
+// 旧版本服务端只会发送 ConnectOK 或 ConnectFailed，客户端把未知的非零值当作 ConnectFailed
+const (
+	ConnectOK              byte = 0
+	ConnectFailed          byte = 1 // 其它错误
+	ConnectRefused         byte = 2 // 目标拒绝连接
+	ConnectTimeout         byte = 3 // 连接目标超时
+	ConnectBlocked         byte = 4 // 目标端口被 allowed_ports/blocked_ports 禁止
+	ConnectDNSFailed       byte = 5 // 目标域名解析失败
+	ConnectForbidden       byte = 6 // 目标地址被服务端的访问策略禁止（如内网地址）
+	ConnectNetUnreachable  byte = 7 // 到目标的网络不可达
+	ConnectHostUnreachable byte = 8 // 目标主机不可达
+)
+
+// BoundAddrVersion 客户端的协议版本不低于该值时，服务端在 ConnectOK 之后紧跟连接目标使用的本地地址：
+// [地址长度(1字节，0、4 或 16)][IP][端口(2字节)]，地址未知（如经上游代理连接）时长度为 0，没有之后的字段
+const BoundAddrVersion = 11
+
+// WriteConnectOK 发送 ConnectOK；clientVersion 不低于 BoundAddrVersion 时随后附带 bound，两者在同一次写入中发送
+func WriteConnectOK(w io.Writer, clientVersion byte, bound net.Addr) error {
+	buf := []byte{ConnectOK}
+	if clientVersion >= BoundAddrVersion {
+		var addr netip.AddrPort
+		if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+			addr = tcpAddr.AddrPort()
+		}
+		buf = appendBoundAddr(buf, addr)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// appendBoundAddr 编码 ConnectOK 之后的本地地址，addr 无效时只写入长度 0
+func appendBoundAddr(buf []byte, addr netip.AddrPort) []byte {
+	if !addr.IsValid() {
+		return append(buf, 0)
+	}
+	ip := addr.Addr().Unmap().AsSlice()
+	buf = append(append(buf, byte(len(ip))), ip...)
+	return binary.BigEndian.AppendUint16(buf, addr.Port())
+}
+
+// ReadConnectStatus 读取服务端的连接目标应答状态，ConnectOK 时同时读取服务端连接目标使用的本地地址
+// 服务端没有给出地址时 bound 无效；调用方的协议版本需不低于 BoundAddrVersion（握手已确认服务端支持）
+func ReadConnectStatus(r io.Reader) (status byte, bound netip.AddrPort, err error) {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return 0, bound, err
+	}
+	if buf[0] != ConnectOK {
+		return buf[0], bound, nil
+	}
+
+	if _, err := io.ReadFull(r, buf[1:2]); err != nil {
+		return 0, bound, err
+	}
+	n := int(buf[1])
+	if n == 0 {
+		return ConnectOK, bound, nil
+	}
+	if n != 4 && n != 16 {
+		return 0, bound, fmt.Errorf("invalid bound address length %d", n)
+	}
+	addr := make([]byte, n+2)
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return 0, bound, err
+	}
+	ip, _ := netip.AddrFromSlice(addr[:n])
+	return ConnectOK, netip.AddrPortFrom(ip, binary.BigEndian.Uint16(addr[n:])), nil
+}
+
+// ConnectStatusText 返回连接目标应答状态的说明，用于日志与错误信息
+func ConnectStatusText(status byte) string {
+	switch status {
+	case ConnectOK:
+		return "connected"
+	case ConnectRefused:
+		return "connection refused by target"
+	case ConnectTimeout:
+		return "connection to target timed out"
+	case ConnectBlocked:
+		return "target port not allowed by server"
+	case ConnectDNSFailed:
+		return "target host name could not be resolved"
+	case ConnectForbidden:
+		return "target address forbidden by server"
+	case ConnectNetUnreachable:
+		return "target network unreachable"
+	case ConnectHostUnreachable:
+		return "target host unreachable"
+	default:
+		return fmt.Sprintf("server could not connect to target (status %d)", status)
+	}
+}
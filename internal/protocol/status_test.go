@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestConnectOKBoundAddr(t *testing.T) {
+	tests := map[string]struct {
+		bound net.Addr
+		want  netip.AddrPort
+	}{
+		"ipv4": {&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}, netip.MustParseAddrPort("192.0.2.1:40000")},
+		"ipv6": {&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, netip.MustParseAddrPort("[2001:db8::1]:443")},
+		// 经上游代理连接目标时没有本地地址，只发送长度 0
+		"unknown": {nil, netip.AddrPort{}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteConnectOK(&buf, BoundAddrVersion, tt.bound); err != nil {
+				t.Fatal(err)
+			}
+			status, bound, err := ReadConnectStatus(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != ConnectOK || bound != tt.want {
+				t.Fatalf("ReadConnectStatus = %d, %v; want ConnectOK, %v", status, bound, tt.want)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("%d bytes left after the status", buf.Len())
+			}
+		})
+	}
+}
+
+func TestConnectOKOldClient(t *testing.T) {
+	// 旧版本客户端只读取 1 字节状态，之后的数据属于隧道
+	var buf bytes.Buffer
+	if err := WriteConnectOK(&buf, BoundAddrVersion-1, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{ConnectOK}) {
+		t.Fatalf("wrote %x to an old client, want only ConnectOK", buf.Bytes())
+	}
+}
+
+func TestConnectStatusFailureHasNoAddr(t *testing.T) {
+	// 失败状态之后没有地址，紧随其后的数据不能被当作地址读取
+	buf := bytes.NewBuffer([]byte{ConnectRefused, 4})
+	status, bound, err := ReadConnectStatus(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != ConnectRefused || bound.IsValid() {
+		t.Fatalf("ReadConnectStatus = %d, %v; want ConnectRefused without an address", status, bound)
+	}
+	if buf.Len() != 1 {
+		t.Fatalf("read %d bytes past the status", 1-buf.Len())
+	}
+}
+
+func TestConnectStatusInvalidAddrLen(t *testing.T) {
+	if _, _, err := ReadConnectStatus(bytes.NewReader([]byte{ConnectOK, 5, 1, 2, 3, 4, 5, 0, 80})); err == nil {
+		t.Fatal("accepted a 5-byte bound address")
+	}
+}
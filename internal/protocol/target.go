@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// MaxTargetLen 目标地址的最大长度（字节），max_target_len 只能设置得更小
+const MaxTargetLen = 1024
+
+// ErrTargetTooLong 目标地址超过配置的 max_target_len
+var ErrTargetTooLong = errors.New("target address too long")
+
+// CheckTargetLen 检查目标地址不超过 maxLen 字节
+func CheckTargetLen(addr string, maxLen int) error {
+	if len(addr) > maxLen {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrTargetTooLong, len(addr), maxLen)
+	}
+	return nil
+}
+
+// 请求类型，编码在地址长度字段的最高三位
+const (
+	CmdConnect    byte = 0x00 // 连接目标地址
+	CmdResolve    byte = 0x01 // 请求服务端解析域名
+	CmdResolvePTR byte = 0x02 // 请求服务端反向解析 IP 地址
+	CmdMux        byte = 0x03 // 把该连接切换为多路复用会话
+)
+
+// MuxVersion 多路复用请求中携带的帧格式版本
+const MuxVersion = "1"
+
+const (
+	// resolveFlag 地址长度字段中表示 CmdResolve 的标志位
+	resolveFlag = 0x8000
+
+	// resolvePTRFlag 地址长度字段中表示 CmdResolvePTR 的标志位
+	// 不认识该标志的旧版本服务端会把它当作超长地址并关闭连接
+	resolvePTRFlag = 0x4000
+
+	// muxFlag 地址长度字段中表示 CmdMux 的标志位，旧版本服务端同样会关闭连接
+	muxFlag = 0x2000
+)
+
+// WriteTarget 发送连接请求
+// 协议: [地址长度(2字节, 大端)][地址字符串]，长度与地址分别作为独立的加密帧发送
+func WriteTarget(w io.Writer, addr string) error {
+	return writeRequest(w, CmdConnect, addr)
+}
+
+// WriteResolveRequest 发送域名解析请求，格式同 WriteTarget，长度字段最高位置 1
+func WriteResolveRequest(w io.Writer, host string) error {
+	return writeRequest(w, CmdResolve, host)
+}
+
+// WriteResolvePTRRequest 发送反向解析请求，格式同 WriteTarget，长度字段次高位置 1
+func WriteResolvePTRRequest(w io.Writer, ip string) error {
+	return writeRequest(w, CmdResolvePTR, ip)
+}
+
+// WriteMuxRequest 请求把连接切换为多路复用会话，地址字段为 MuxVersion
+// 服务端应答 1 字节 ConnectOK 后，双方在该连接上收发 mux 帧
+func WriteMuxRequest(w io.Writer) error {
+	return writeRequest(w, CmdMux, MuxVersion)
+}
+
+func writeRequest(w io.Writer, cmd byte, addr string) error {
+	if len(addr) == 0 || len(addr) > MaxTargetLen {
+		return fmt.Errorf("invalid target address length: %d", len(addr))
+	}
+
+	header := uint16(len(addr))
+	switch cmd {
+	case CmdResolve:
+		header |= resolveFlag
+	case CmdResolvePTR:
+		header |= resolvePTRFlag
+	case CmdMux:
+		header |= muxFlag
+	}
+
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, header)
+	if _, err := w.Write(lenBuf); err != nil {
+		return fmt.Errorf("failed to send target address length: %w", err)
+	}
+	if _, err := w.Write([]byte(addr)); err != nil {
+		return fmt.Errorf("failed to send target address: %w", err)
+	}
+	return nil
+}
+
+// ReadRequest 读取 WriteTarget、WriteResolveRequest、WriteResolvePTRRequest 或 WriteMuxRequest 发送的请求
+func ReadRequest(r io.Reader) (cmd byte, addr string, err error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return 0, "", fmt.Errorf("failed to read target address length: %w", err)
+	}
+
+	header := binary.BigEndian.Uint16(lenBuf)
+	cmd = CmdConnect
+	switch {
+	case header&resolveFlag != 0:
+		cmd = CmdResolve
+	case header&resolvePTRFlag != 0:
+		cmd = CmdResolvePTR
+	case header&muxFlag != 0:
+		cmd = CmdMux
+	}
+
+	addrLen := int(header &^ (resolveFlag | resolvePTRFlag | muxFlag))
+	if addrLen == 0 || addrLen > MaxTargetLen {
+		return 0, "", fmt.Errorf("invalid target address length: %d", addrLen)
+	}
+
+	addrBuf := make([]byte, addrLen)
+	if _, err := io.ReadFull(r, addrBuf); err != nil {
+		return 0, "", fmt.Errorf("failed to read target address: %w", err)
+	}
+	return cmd, string(addrBuf), nil
+}
+
+// ParseTarget 校验 host:port 形式的目标地址，返回主机与端口
+// IPv6 地址需带方括号；主机不能为空或包含空白、控制字符，端口需在 1-65535 之间
+func ParseTarget(addr string) (host string, port uint16, err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("target must be host:port: %w", err)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host in %q", addr)
+	}
+	for i := 0; i < len(host); i++ {
+		if host[i] <= ' ' || host[i] == 0x7f {
+			return "", 0, fmt.Errorf("invalid character in host %q", host)
+		}
+	}
+
+	n, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || n == 0 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, uint16(n), nil
+}
@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTargetRoundTripLongAddress(t *testing.T) {
+	// 超过单字节长度前缀的 255 字节上限，不能被截断
+	addr := strings.Repeat("a", 290) + ".example:443"
+	if len(addr) != 302 {
+		t.Fatalf("test address is %d bytes", len(addr))
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTarget(&buf, addr); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint16(buf.Bytes()); int(got) != len(addr) {
+		t.Fatalf("length prefix = %d, want %d", got, len(addr))
+	}
+
+	cmd, got, err := ReadRequest(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != CmdConnect || got != addr {
+		t.Fatalf("ReadRequest = %d, %d-byte address; want CmdConnect and the %d-byte address", cmd, len(got), len(addr))
+	}
+}
+
+func TestRequestCommandsRoundTrip(t *testing.T) {
+	tests := []struct {
+		write func(*bytes.Buffer) error
+		cmd   byte
+		addr  string
+	}{
+		{func(b *bytes.Buffer) error { return WriteTarget(b, "example.com:443") }, CmdConnect, "example.com:443"},
+		{func(b *bytes.Buffer) error { return WriteResolveRequest(b, "example.com") }, CmdResolve, "example.com"},
+		{func(b *bytes.Buffer) error { return WriteResolvePTRRequest(b, "192.0.2.1") }, CmdResolvePTR, "192.0.2.1"},
+		{func(b *bytes.Buffer) error { return WriteMuxRequest(b) }, CmdMux, MuxVersion},
+		{func(b *bytes.Buffer) error { return WriteTarget(b, strings.Repeat("x", MaxTargetLen)) }, CmdConnect, strings.Repeat("x", MaxTargetLen)},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		cmd, addr, err := ReadRequest(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd != tt.cmd || addr != tt.addr {
+			t.Errorf("ReadRequest = %d, %q; want %d, %q", cmd, addr, tt.cmd, tt.addr)
+		}
+	}
+}
+
+func TestWriteTargetRejectsInvalidLength(t *testing.T) {
+	for _, addr := range []string{"", strings.Repeat("x", MaxTargetLen+1)} {
+		var buf bytes.Buffer
+		if err := WriteTarget(&buf, addr); err == nil {
+			t.Errorf("WriteTarget accepted a %d-byte address", len(addr))
+		}
+		if buf.Len() != 0 {
+			t.Errorf("WriteTarget wrote %d bytes for a rejected address", buf.Len())
+		}
+	}
+}
+
+func TestReadRequestRejectsInvalidLength(t *testing.T) {
+	for _, header := range []uint16{0, MaxTargetLen + 1, resolveFlag} {
+		data := binary.BigEndian.AppendUint16(nil, header)
+		data = append(data, bytes.Repeat([]byte("x"), MaxTargetLen+1)...)
+		if _, _, err := ReadRequest(bytes.NewReader(data)); err == nil {
+			t.Errorf("ReadRequest accepted length header 0x%04x", header)
+		}
+	}
+}
+
+func FuzzParseTarget(f *testing.F) {
+	for _, seed := range []string{"example.com:443", "[2001:db8::1]:80", "192.0.2.1:65535", "host:0", ":80", "a b:1", "[::1]:http", "example.com"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, addr string) {
+		host, port, err := ParseTarget(addr)
+		if err != nil {
+			return
+		}
+		if host == "" || port == 0 {
+			t.Fatalf("ParseTarget(%q) = %q, %d without error", addr, host, port)
+		}
+		for i := 0; i < len(host); i++ {
+			if host[i] <= ' ' || host[i] == 0x7f {
+				t.Fatalf("ParseTarget(%q) accepted host %q with a control character", addr, host)
+			}
+		}
+
+		// 接受的目标重新拼接后结果不变，并能经 WriteTarget/ReadRequest 原样传输
+		joined := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		if h, p, err := ParseTarget(joined); err != nil || h != host || p != port {
+			t.Fatalf("ParseTarget(%q) = %q, %d, %v; want %q, %d", joined, h, p, err, host, port)
+		}
+		if len(addr) > MaxTargetLen {
+			return
+		}
+		var buf bytes.Buffer
+		if err := WriteTarget(&buf, addr); err != nil {
+			t.Fatal(err)
+		}
+		cmd, got, err := ReadRequest(&buf)
+		if err != nil || cmd != CmdConnect || got != addr {
+			t.Fatalf("ReadRequest = %d, %q, %v; want CmdConnect, %q", cmd, got, err, addr)
+		}
+	})
+}
+
+func TestCheckTargetLen(t *testing.T) {
+	for _, limit := range []int{16, MaxTargetLen} {
+		if err := CheckTargetLen(strings.Repeat("x", limit), limit); err != nil {
+			t.Errorf("%d bytes with limit %d: %v", limit, limit, err)
+		}
+		if err := CheckTargetLen(strings.Repeat("x", limit+1), limit); !errors.Is(err, ErrTargetTooLong) {
+			t.Errorf("%d bytes with limit %d: err = %v, want ErrTargetTooLong", limit+1, limit, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"io"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+)
+
+// WrapTunnel 在已完成握手的连接上建立加密层（及可选的混淆层），返回隧道的读写端
+// role 为本端的角色，客户端与服务端必须不同；opts.Plaintext 时不加密也不混淆，直接返回 r、w，目标地址等仍使用原有的帧格式
+// opts.RekeyAfter 大于 0 时写入端每发送这么多字节明文换用下一个密钥
+func WrapTunnel(r io.Reader, w io.Writer, password string, salt []byte, opts HandshakeOptions, role cipher.Role) (io.Reader, io.Writer, error) {
+	if opts.Plaintext {
+		return r, w, nil
+	}
+
+	cipherInstance, err := cipher.NewCipherSuite(password, salt, opts.Cipher, role)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Obfuscate {
+		r = NewObfuscatedReader(r)
+		w = NewObfuscatedWriter(w)
+	}
+	secureWriter := cipher.NewSecureWriter(w, cipherInstance)
+	secureWriter.SetRekeyAfter(opts.RekeyAfter)
+	secureWriter.SetPeerEcho(opts.Keepalive)
+	secureReader := cipher.NewSecureReader(r, cipherInstance)
+	secureReader.SetReplyWriter(secureWriter)
+	return secureReader, secureWriter, nil
+}
+
+// StartKeepalive 在 WrapTunnel 返回的 r、w 上启动 keepalive，见 cipher.Keepalive
+// plaintext 隧道或对端不能应答 ping 时不做任何事
+func StartKeepalive(r io.Reader, w io.Writer, interval, timeout time.Duration, dead func()) {
+	sr, ok := r.(*cipher.SecureReader)
+	if !ok {
+		return
+	}
+	sw, ok := w.(*cipher.SecureWriter)
+	if !ok {
+		return
+	}
+	cipher.Keepalive(sr, sw, interval, timeout, dead)
+}
@@ -0,0 +1,128 @@
+// Package proxyproto 解析 HAProxy PROXY protocol v1/v2 头部
+// 负载均衡器在转发的连接开头发送该头部，携带真实的客户端地址
+// 规范见 https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// v2 头部的固定签名
+var sigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// v1 头部最长 107 字节（含 CRLF）
+	maxV1Len = 107
+
+	// v2 命令：LOCAL 为负载均衡器自身的连接（如健康检查），PROXY 为转发的连接
+	cmdLocal = 0x0
+	cmdProxy = 0x1
+
+	// v2 地址族与传输协议
+	familyTCP4 = 0x11
+	familyTCP6 = 0x21
+)
+
+// ErrNoHeader 连接开头不是 PROXY protocol 头部
+var ErrNoHeader = errors.New("missing PROXY protocol header")
+
+// ReadHeader 从 r 读取 PROXY protocol 头部，只读取头部本身，不多读后续数据
+// 返回头部中的源地址；LOCAL 命令、UNKNOWN 协议或不支持的地址族返回 nil，表示使用连接本身的地址
+func ReadHeader(r io.Reader) (*net.TCPAddr, error) {
+	// v1 最短的头部 "PROXY UNKNOWN\r\n" 也长于 v2 签名，先读签名长度的数据再区分版本
+	head := make([]byte, len(sigV2))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	switch {
+	case bytes.Equal(head, sigV2):
+		return readV2(r)
+	case bytes.HasPrefix(head, []byte("PROXY ")):
+		return readV1(r, head)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+// readV1 解析文本格式：PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n
+func readV1(r io.Reader, head []byte) (*net.TCPAddr, error) {
+	line := head
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Len {
+			return nil, fmt.Errorf("PROXY protocol v1 header too long")
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY protocol v1 header %q", line)
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil || addr.Is4() != (fields[1] == "TCP4") || addr.Zone() != "" {
+		return nil, fmt.Errorf("malformed PROXY protocol v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PROXY protocol v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readV2 解析二进制格式：[签名(12)][版本与命令(1)][地址族(1)][长度(2)][地址(长度)]
+func readV2(r io.Reader) (*net.TCPAddr, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	if hdr[0]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", hdr[0]>>4)
+	}
+	// 地址之后可能带有 TLV 扩展，整段读完后忽略
+	body := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+
+	switch hdr[0] & 0x0f {
+	case cmdLocal:
+		return nil, nil
+	case cmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol command %d", hdr[0]&0x0f)
+	}
+
+	var src netip.Addr
+	var port []byte
+	switch hdr[1] {
+	case familyTCP4:
+		if len(body) < 12 {
+			return nil, fmt.Errorf("PROXY protocol v2 TCP4 address too short")
+		}
+		src, port = netip.AddrFrom4([4]byte(body[0:4])), body[8:10]
+	case familyTCP6:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("PROXY protocol v2 TCP6 address too short")
+		}
+		src, port = netip.AddrFrom16([16]byte(body[0:16])), body[32:34]
+	default:
+		// UDP、Unix 套接字等地址无法作为 TCP 客户端地址，使用连接本身的地址
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, binary.BigEndian.Uint16(port))), nil
+}
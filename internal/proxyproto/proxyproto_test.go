@@ -0,0 +1,111 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// v2Header 构造 v2 头部，body 为地址及可选的 TLV
+func v2Header(cmd, family byte, body []byte) []byte {
+	h := append([]byte(nil), sigV2...)
+	h = append(h, 0x20|cmd, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(body)))
+	return append(h, body...)
+}
+
+// v2Addrs 构造 v2 地址段：[源地址][目的地址][源端口][目的端口]
+func v2Addrs(src, dst netip.AddrPort) []byte {
+	var b []byte
+	b = append(b, src.Addr().AsSlice()...)
+	b = append(b, dst.Addr().AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	return binary.BigEndian.AppendUint16(b, dst.Port())
+}
+
+func TestReadHeader(t *testing.T) {
+	src4 := netip.MustParseAddrPort("198.51.100.7:51234")
+	dst4 := netip.MustParseAddrPort("192.0.2.1:8081")
+	src6 := netip.MustParseAddrPort("[2001:db8::7]:51234")
+	dst6 := netip.MustParseAddrPort("[2001:db8::1]:8081")
+	tlv := []byte{0x04, 0x00, 0x02, 'o', 'k'} // PP2_TYPE_NOOP
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string // 空表示使用连接本身的地址
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 198.51.100.7 192.0.2.1 51234 8081\r\n"), "198.51.100.7:51234"},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51234 8081\r\n"), "[2001:db8::7]:51234"},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), ""},
+		{"v1 UNKNOWN with addresses", []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), ""},
+		{"v2 TCP4", v2Header(cmdProxy, familyTCP4, v2Addrs(src4, dst4)), "198.51.100.7:51234"},
+		{"v2 TCP6", v2Header(cmdProxy, familyTCP6, v2Addrs(src6, dst6)), "[2001:db8::7]:51234"},
+		{"v2 TCP4 with TLV", v2Header(cmdProxy, familyTCP4, append(v2Addrs(src4, dst4), tlv...)), "198.51.100.7:51234"},
+		{"v2 LOCAL", v2Header(cmdLocal, 0x00, nil), ""},
+		{"v2 UDP4", v2Header(cmdProxy, 0x12, v2Addrs(src4, dst4)), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 头部之后紧跟握手数据，不能被多读
+			r := bytes.NewReader(append(bytes.Clone(tt.header), "handshake"...))
+			addr, err := ReadHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Fatalf("source = %q, want %q", got, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "handshake" {
+				t.Fatalf("left %q after the header, want the following data untouched", rest)
+			}
+		})
+	}
+}
+
+func TestReadHeaderRejects(t *testing.T) {
+	src4 := netip.MustParseAddrPort("198.51.100.7:51234")
+	dst4 := netip.MustParseAddrPort("192.0.2.1:8081")
+
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"v1 family mismatch", []byte("PROXY TCP4 2001:db8::7 2001:db8::1 1 2\r\n")},
+		{"v1 bad port", []byte("PROXY TCP4 198.51.100.7 192.0.2.1 70000 8081\r\n")},
+		{"v1 missing field", []byte("PROXY TCP4 198.51.100.7 192.0.2.1 51234\r\n")},
+		{"v1 zone", []byte("PROXY TCP6 fe80::1%eth0 fe80::2 1 2\r\n")},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", maxV1Len) + "\r\n")},
+		{"v1 truncated", []byte("PROXY TCP4 198.51.100.7")},
+		{"v2 bad version", append(append(bytes.Clone(sigV2), 0x11, familyTCP4, 0, 12), v2Addrs(src4, dst4)...)},
+		{"v2 bad command", v2Header(0x2, familyTCP4, v2Addrs(src4, dst4))},
+		{"v2 short address", v2Header(cmdProxy, familyTCP4, v2Addrs(src4, dst4)[:8])},
+		{"v2 truncated body", v2Header(cmdProxy, familyTCP4, v2Addrs(src4, dst4))[:20]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := ReadHeader(bytes.NewReader(tt.header))
+			if err == nil {
+				t.Fatalf("accepted, source %v", addr)
+			}
+			if errors.Is(err, ErrNoHeader) {
+				t.Fatalf("err = %v, want a malformed header error", err)
+			}
+		})
+	}
+}
+
+func TestReadHeaderMissing(t *testing.T) {
+	// 没有 PROXY 头部的直接连接（如客户端握手）
+	if _, err := ReadHeader(bytes.NewReader(bytes.Repeat([]byte{0x04}, 64))); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("err = %v, want ErrNoHeader", err)
+	}
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketBurst(t *testing.T) {
+	b := NewBucket(1, 3)
+	for i := range 3 {
+		if !b.Allow() {
+			t.Fatalf("request %d within burst was rejected", i+1)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("request beyond burst was allowed")
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	b := NewBucket(10, 2)
+	b.Allow()
+	b.Allow()
+
+	// 0.25 秒补充 2.5 个令牌，但不超过 burst
+	b.mu.Lock()
+	b.refill(b.last.Add(250 * time.Millisecond))
+	tokens := b.tokens
+	b.mu.Unlock()
+	if tokens != 2 {
+		t.Fatalf("tokens after refill = %v, want burst 2", tokens)
+	}
+
+	b.Allow()
+	b.Allow()
+	b.mu.Lock()
+	b.refill(b.last.Add(100 * time.Millisecond))
+	tokens = b.tokens
+	b.mu.Unlock()
+	if tokens < 0.99 || tokens > 1.01 {
+		t.Fatalf("tokens after 100ms at 10/s = %v, want 1", tokens)
+	}
+}
+
+func TestBucketRefillRealTime(t *testing.T) {
+	b := NewBucket(50, 1)
+	if !b.Allow() {
+		t.Fatal("first request rejected")
+	}
+	if b.Allow() {
+		t.Fatal("second request allowed before refill")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("request rejected after the refill interval")
+	}
+}
+
+func TestKeyedLimiterPerKey(t *testing.T) {
+	l := NewKeyedLimiter(1, 2)
+	for range 2 {
+		if !l.Allow("192.0.2.1") {
+			t.Fatal("request within burst was rejected")
+		}
+	}
+	if l.Allow("192.0.2.1") {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if !l.Allow("192.0.2.2") {
+		t.Fatal("another key was limited by the first key's bucket")
+	}
+}
+
+func TestKeyedLimiterSweep(t *testing.T) {
+	l := NewKeyedLimiter(1, 2)
+	l.Allow("idle")
+	l.Allow("busy")
+	l.Allow("busy")
+
+	// 1.5 秒后 idle 已补满，busy 仍缺一个令牌
+	now := time.Now().Add(1500 * time.Millisecond)
+	l.mu.Lock()
+	l.sweep(now)
+	_, idle := l.buckets["idle"]
+	_, busy := l.buckets["busy"]
+	l.mu.Unlock()
+	if idle {
+		t.Error("full bucket was not evicted")
+	}
+	if !busy {
+		t.Error("bucket still refilling was evicted")
+	}
+}
+
+func TestKeyedLimiterSweepOnAllow(t *testing.T) {
+	l := NewKeyedLimiter(100, 1)
+	l.Allow("a")
+
+	l.mu.Lock()
+	l.lastSweep = time.Now().Add(-sweepInterval)
+	l.buckets["a"].last = time.Now().Add(-time.Second)
+	l.mu.Unlock()
+
+	l.Allow("b")
+	l.mu.Lock()
+	_, ok := l.buckets["a"]
+	n := len(l.buckets)
+	l.mu.Unlock()
+	if ok || n != 1 {
+		t.Fatalf("after sweep buckets = %d (a present: %v), want only b", n, ok)
+	}
+}
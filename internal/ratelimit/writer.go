@@ -0,0 +1,49 @@
+package ratelimit
+
+import "io"
+
+// 限速写入器的最小突发字节数，避免写入被切得过碎
+const minWriterBurst = 16 * 1024
+
+// Writer 限速写入器，将大块写入拆分为不超过突发量的小块依次写出
+type Writer struct {
+	dst    io.Writer
+	bucket *Bucket
+	chunk  int
+}
+
+// NewWriter 创建限速写入器，bytesPerSec 为 0 时直接返回 dst
+func NewWriter(dst io.Writer, bytesPerSec float64) io.Writer {
+	if bytesPerSec <= 0 {
+		return dst
+	}
+
+	burst := int(bytesPerSec)
+	if burst < minWriterBurst {
+		burst = minWriterBurst
+	}
+
+	bucket := NewBucket(bytesPerSec, burst)
+	bucket.tokens = 0 // 从空桶开始，避免首个突发超出速率
+
+	return &Writer{dst: dst, bucket: bucket, chunk: burst}
+}
+
+// Write 实现 io.Writer
+func (w *Writer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		size := len(p)
+		if size > w.chunk {
+			size = w.chunk
+		}
+
+		w.bucket.WaitN(size)
+		written, err := w.dst.Write(p[:size])
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[size:]
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// recordWriter 记录每次写入的大小
+type recordWriter struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.sizes = append(w.sizes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestNewWriterZeroLimitIsNoop(t *testing.T) {
+	var buf bytes.Buffer
+	for _, limit := range []float64{0, -1} {
+		if w := NewWriter(&buf, limit); w != &buf {
+			t.Errorf("NewWriter(dst, %v) = %T, want dst unchanged", limit, w)
+		}
+	}
+}
+
+func TestWriterStartsEmpty(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, 1024*1024).(*Writer)
+	if w.bucket.tokens != 0 {
+		t.Fatalf("initial tokens = %v, want 0", w.bucket.tokens)
+	}
+
+	// 空桶时首次写入也要等待：1 MiB/s 下 100 KiB 至少 ~100ms
+	start := time.Now()
+	w.Write(make([]byte, 100*1024))
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("first write took %v, want at least ~100ms", elapsed)
+	}
+}
+
+func TestWriterThroughput(t *testing.T) {
+	const (
+		rate = 64 * 1024 // bytes/s
+		size = 80 * 1024
+	)
+	dst := &recordWriter{}
+	w := NewWriter(dst, rate)
+
+	payload := bytes.Repeat([]byte("x"), size)
+	start := time.Now()
+	n, err := w.Write(payload)
+	elapsed := time.Since(start)
+	if err != nil || n != size {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if !bytes.Equal(dst.Bytes(), payload) {
+		t.Fatal("written data differs from payload")
+	}
+
+	want := time.Duration(float64(size) / rate * float64(time.Second))
+	if elapsed < want*95/100 {
+		t.Fatalf("transfer of %d bytes at %d B/s took %v, want at least %v", size, rate, elapsed, want)
+	}
+	for _, s := range dst.sizes {
+		if s > rate {
+			t.Fatalf("chunk of %d bytes exceeds burst %d", s, rate)
+		}
+	}
+	if len(dst.sizes) != 2 {
+		t.Fatalf("write split into %d chunks, want 2", len(dst.sizes))
+	}
+}
@@ -0,0 +1,176 @@
+package relay
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// BatchDelay 合并写入时数据最多延迟发送的时间
+	BatchDelay = time.Millisecond
+
+	// batchSize 缓存达到该长度时立即发送，不小于该长度的写入在缓存为空时直接发送
+	batchSize = 16 * 1024
+)
+
+// BatchConn 合并突发传输中的连续小写入，减少系统调用和 TCP 小包
+// 空闲后的第一次写入立即发送，交互流量（如按键）没有额外延迟；
+// 距上次发送不足 delay 的写入先缓存，最迟 delay 后或缓存达到 batchSize 时一起发送
+type BatchConn struct {
+	net.Conn
+	delay time.Duration
+
+	mu        sync.Mutex
+	buf       []byte
+	lastFlush time.Time
+	timer     *time.Timer
+	err       error // 后台发送失败的错误，由下一次 Write 返回
+
+	// 后台发送不经过调用方设置写截止时间的 Write，需自行设置写超时
+	writeTimeout time.Duration
+	deadlineMu   sync.Mutex
+	deadline     time.Time // 调用方设置的写截止时间，后台发送结束后恢复
+	flushing     bool      // 后台发送进行中，期间调用方清除截止时间推迟到发送结束
+}
+
+// NewBatchConn 包装 conn，delay 为缓存数据最长的等待时间
+func NewBatchConn(conn net.Conn, delay time.Duration) *BatchConn {
+	return &BatchConn{Conn: conn, delay: delay}
+}
+
+// WithWriteTimeout 后台发送缓存数据时使用的写超时：对端不再读取时发送在 timeout 后失败，
+// 不会持有锁一直阻塞，错误由下一次 Write 返回；timeout 为 0 时不启用
+func (c *BatchConn) WithWriteTimeout(timeout time.Duration) *BatchConn {
+	c.writeTimeout = timeout
+	return c
+}
+
+// SetDeadline 同时设置读写截止时间，写截止时间的处理见 SetWriteDeadline
+func (c *BatchConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetWriteDeadline 设置写截止时间；后台发送进行中时清除截止时间推迟到发送结束，
+// 避免调用方（如每次写入后清除截止时间的 IdleTimeout.Writer）去掉后台发送的写超时
+func (c *BatchConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.deadline = t
+	if c.flushing && t.IsZero() {
+		return nil
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Write 实现 io.Writer，数据可能在返回后才真正写入连接
+func (c *BatchConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if len(c.buf) == 0 && (len(p) >= batchSize || time.Since(c.lastFlush) >= c.delay) {
+		n, err := c.Conn.Write(p)
+		c.lastFlush = time.Now()
+		return n, err
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= batchSize {
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	// 缓存从空变为非空时开始计时
+	if len(c.buf) == len(p) {
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.delay, c.timerFlush)
+		} else {
+			c.timer.Reset(c.delay)
+		}
+	}
+	return len(p), nil
+}
+
+// Flush 立即发送缓存的数据
+func (c *BatchConn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked()
+}
+
+// Close 发送缓存的数据后关闭连接
+// 另一个 goroutine 正阻塞在写入时不再等待，直接关闭连接使其返回
+func (c *BatchConn) Close() error {
+	if c.mu.TryLock() {
+		c.flushLocked()
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.mu.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+// CloseWrite 发送缓存的数据后半关闭连接，底层连接不支持半关闭时返回错误
+func (c *BatchConn) CloseWrite() error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection does not support half-close")
+	}
+	return cw.CloseWrite()
+}
+
+// timerFlush 在 delay 到期后发送缓存的数据，设置了写超时时发送期间使用该超时
+func (c *BatchConn) timerFlush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writeTimeout <= 0 || len(c.buf) == 0 {
+		c.flushLocked()
+		return
+	}
+
+	c.deadlineMu.Lock()
+	c.flushing = true
+	if c.deadline.IsZero() {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	c.deadlineMu.Unlock()
+
+	c.flushLocked()
+
+	c.deadlineMu.Lock()
+	c.flushing = false
+	c.Conn.SetWriteDeadline(c.deadline)
+	c.deadlineMu.Unlock()
+}
+
+// flushLocked 发送缓存的数据，调用方需持有锁
+func (c *BatchConn) flushLocked() error {
+	if c.err != nil {
+		return c.err
+	}
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	_, err := c.Conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	c.lastFlush = time.Now()
+	if err != nil {
+		c.err = err
+	}
+	return err
+}
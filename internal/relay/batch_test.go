@@ -0,0 +1,201 @@
+package relay
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordConn 记录每次 Write 的数据，不实际发送
+type recordConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, bytes.Clone(p))
+	return len(p), nil
+}
+
+func (c *recordConn) Close() error { return nil }
+
+// sent 返回已发送的写入次数与全部数据
+func (c *recordConn) sent() (int, []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.writes), bytes.Join(c.writes, nil)
+}
+
+func TestBatchConnFirstWriteIsImmediate(t *testing.T) {
+	conn := &recordConn{}
+	bc := NewBatchConn(conn, time.Hour)
+
+	if _, err := bc.Write([]byte("k")); err != nil {
+		t.Fatal(err)
+	}
+	if n, data := conn.sent(); n != 1 || string(data) != "k" {
+		t.Fatalf("sent %d writes %q, want the keystroke sent immediately", n, data)
+	}
+}
+
+func TestBatchConnCoalescesBurst(t *testing.T) {
+	conn := &recordConn{}
+	bc := NewBatchConn(conn, 20*time.Millisecond)
+
+	var want []byte
+	for i := range 100 {
+		p := bytes.Repeat([]byte{byte(i)}, 100)
+		if _, err := bc.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, p...)
+	}
+	// 第一次写入立即发送，其余合并后在 delay 内发送
+	if n, _ := conn.sent(); n != 1 {
+		t.Fatalf("%d writes before the delay expired, want 1", n)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n, data := conn.sent()
+		if bytes.Equal(data, want) {
+			if n > 2 {
+				t.Fatalf("burst sent in %d writes, want 2", n)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("buffered data not flushed after the delay: sent %d of %d bytes", len(data), len(want))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBatchConnFlushesAtBatchSize(t *testing.T) {
+	conn := &recordConn{}
+	bc := NewBatchConn(conn, time.Hour)
+
+	bc.Write([]byte("first"))
+	chunk := make([]byte, 1024)
+	for range batchSize / len(chunk) {
+		if _, err := bc.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, data := conn.sent(); n != 2 || len(data) != len("first")+batchSize {
+		t.Fatalf("sent %d writes with %d bytes, want the full batch flushed", n, len(data))
+	}
+}
+
+func TestBatchConnCloseFlushes(t *testing.T) {
+	conn := &recordConn{}
+	bc := NewBatchConn(conn, time.Hour)
+
+	bc.Write([]byte("a"))
+	bc.Write([]byte("b"))
+	if err := bc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, data := conn.sent(); string(data) != "ab" {
+		t.Fatalf("sent %q before close, want %q", data, "ab")
+	}
+}
+
+// BenchmarkKeystrokeRoundTrip 在回环 TCP 连接上测量单字节写入并等待回显的往返时间
+// 回环地址的往返时间小于 BatchDelay，batch 模式下每次按键都会被缓存最多 BatchDelay，对应 low_latency 关闭时的最坏情况
+func TestBatchConnTimerFlushWriteTimeout(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+	c, peer := net.Pipe()
+	defer peer.Close()
+	bc := NewBatchConn(c, 10*time.Millisecond).WithWriteTimeout(writeTimeout)
+	defer bc.Close()
+	// 与转发时一样经 IdleTimeout.Writer 写入，每次写入后清除截止时间
+	w := NewIdleTimeout(0, bc).WithWriteTimeout(writeTimeout).Writer(bc, bc)
+
+	// 对端读完第一次立即发送的数据后不再读取
+	go peer.Read(make([]byte, 1))
+	if _, err := w.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// 随后的写入先缓存，由后台在 delay 后发送并阻塞在对端
+	if _, err := w.Write([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	// 后台发送期间调用方清除截止时间，不能去掉后台发送的写超时
+	bc.SetWriteDeadline(time.Time{})
+
+	// 之后不经过 Writer 的发送（如半关闭前的 Flush）不设置截止时间，只能等待后台发送结束
+	done := make(chan error, 1)
+	go func() {
+		done <- bc.Flush()
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("flush succeeded although the peer never read the buffered data")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("background flush to a non-reading peer blocked the connection")
+	}
+}
+
+func BenchmarkKeystrokeRoundTrip(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	for _, tt := range []struct {
+		name    string
+		noDelay bool
+		batch   bool
+	}{
+		{"nodelay", true, false},
+		{"nagle", false, false},
+		{"nodelay+batch", true, true},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			tcpConn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			tcpConn.(*net.TCPConn).SetNoDelay(tt.noDelay)
+			var conn net.Conn = tcpConn
+			if tt.batch {
+				conn = NewBatchConn(tcpConn, BatchDelay)
+			}
+			defer conn.Close()
+
+			key := []byte{'k'}
+			echo := make([]byte, 1)
+			for b.Loop() {
+				if _, err := conn.Write(key); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(conn, echo); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package relay
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// IdleTimeout 维护隧道的空闲超时：任一方向读到数据都会顺延两端连接的截止时间
+// 设置了写超时时只顺延读截止时间，写截止时间由 Writer 在每次写入前单独设置
+type IdleTimeout struct {
+	timeout      time.Duration
+	writeTimeout time.Duration
+	conns        []net.Conn
+}
+
+// NewIdleTimeout 为隧道两端的连接设置空闲超时，timeout 为 0 时清除截止时间
+func NewIdleTimeout(timeout time.Duration, conns ...net.Conn) *IdleTimeout {
+	t := &IdleTimeout{timeout: timeout, conns: conns}
+	if timeout > 0 {
+		t.extend()
+	} else {
+		for _, c := range conns {
+			c.SetDeadline(time.Time{})
+		}
+	}
+	return t
+}
+
+// WithWriteTimeout 为每次写入单独设置超时：对端长时间不读取（TCP 零窗口）时，
+// 写入在 timeout 内没有完成即失败并结束隧道，与另一方向是否有数据无关；timeout 为 0 时不启用
+func (t *IdleTimeout) WithWriteTimeout(timeout time.Duration) *IdleTimeout {
+	if timeout <= 0 {
+		return t
+	}
+	t.writeTimeout = timeout
+	for _, c := range t.conns {
+		c.SetWriteDeadline(time.Time{})
+	}
+	return t
+}
+
+// Writer 包装写往 conn 的一端（conn 本身或其上的加密写入器），每次写入前设置 conn 的写截止时间
+func (t *IdleTimeout) Writer(w io.Writer, conn net.Conn) io.Writer {
+	if t.writeTimeout <= 0 {
+		return w
+	}
+	return &deadlineWriter{dst: w, conn: conn, timeout: t.writeTimeout}
+}
+
+// Reader 包装隧道一个方向的数据源，每次读到数据时顺延截止时间
+func (t *IdleTimeout) Reader(r io.Reader) io.Reader {
+	if t.timeout <= 0 {
+		return r
+	}
+	return &idleReader{src: r, t: t}
+}
+
+// extend 顺延所有连接的截止时间
+func (t *IdleTimeout) extend() {
+	deadline := time.Now().Add(t.timeout)
+	for _, c := range t.conns {
+		if t.writeTimeout > 0 {
+			c.SetReadDeadline(deadline)
+		} else {
+			c.SetDeadline(deadline)
+		}
+	}
+}
+
+type idleReader struct {
+	src io.Reader
+	t   *IdleTimeout
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 {
+		r.t.extend()
+	}
+	return n, err
+}
+
+// deadlineWriter 每次写入前把 conn 的写截止时间设为 timeout 之后，写入完成后清除，
+// 之后不经过 Write 的写入（如 CloseWrite 发送的结束包）不会因过期的截止时间失败
+type deadlineWriter struct {
+	dst     io.Writer
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	n, err := w.dst.Write(p)
+	w.conn.SetWriteDeadline(time.Time{})
+	return n, err
+}
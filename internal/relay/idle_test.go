@@ -0,0 +1,46 @@
+package relay
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriteTimeout(t *testing.T) {
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+
+	idle := NewIdleTimeout(time.Hour, c).WithWriteTimeout(50 * time.Millisecond)
+	w := idle.Writer(c, c)
+
+	// 对端不读取，写入在写超时后失败；空闲超时还远未到期
+	start := time.Now()
+	if _, err := w.Write([]byte("stuck")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("write to a peer that never reads: %v, want os.ErrDeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("write failed after %v, want about the write timeout", elapsed)
+	}
+
+	// 写入之间清除截止时间，对端恢复读取后直接写入 conn 也不会因过期的截止时间失败
+	time.Sleep(100 * time.Millisecond)
+	go io.ReadFull(peer, make([]byte, 2))
+	if _, err := c.Write([]byte("ok")); err != nil {
+		t.Fatalf("write after the timeout was cleared: %v", err)
+	}
+}
+
+func TestWriteTimeoutDisabled(t *testing.T) {
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+
+	idle := NewIdleTimeout(0, c).WithWriteTimeout(0)
+	if w := idle.Writer(c, c); w != io.Writer(c) {
+		t.Fatalf("Writer wrapped the conn without a write timeout: %T", w)
+	}
+}
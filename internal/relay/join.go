@@ -0,0 +1,106 @@
+package relay
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Stream 隧道的一个方向：从 Src 读取并写入 Dst
+type Stream struct {
+	Name string // 方向名称，如 "up"（客户端到目标）、"down"，用于日志
+
+	Dst io.Writer // 写入端，可以带限速等包装
+	Src io.Reader
+
+	// Peer Src 读到 EOF 后需要半关闭写方向的一端（*net.TCPConn、*cipher.SecureWriter 等）
+	// 为 nil 或不支持半关闭时，该方向结束即结束整个隧道
+	Peer io.Writer
+
+	N   int64 // 已转发的字节数，Join 返回后有效
+	Err error // 该方向结束的原因，Join 返回后有效；正常结束为 nil，无法半关闭为 io.EOF
+
+	// Count 不为 nil 时转发过程中实时累加已转发的字节数，供管理接口查看
+	Count *atomic.Int64
+
+	endedFirst bool // 是否先于另一方向结束
+}
+
+// Join 双向转发直到两个方向都结束
+// 一个方向正常结束时半关闭对端，另一方向继续转发；任一方向出错或无法半关闭时调用 closeAll 使另一方向退出
+// 返回第一个错误，无法半关闭时为 io.EOF
+func Join(a, b *Stream, closeAll func()) error {
+	done := make(chan *Stream, 2)
+	for _, s := range []*Stream{a, b} {
+		go func(s *Stream) {
+			s.Err = s.run()
+			done <- s
+		}(s)
+	}
+
+	var first error
+	for i := 0; i < 2; i++ {
+		s := <-done
+		s.endedFirst = i == 0
+		if s.Err != nil && first == nil {
+			first = s.Err
+			closeAll()
+		}
+	}
+	return first
+}
+
+// LogAttrs 返回 Join 结束后先结束的方向以及两个方向各自的字节数与错误，用于隧道结束时的日志
+// 字段名以 Stream.Name 为前缀，如 up_bytes、down_error；无法半关闭（io.EOF）不算错误
+func LogAttrs(a, b *Stream) []any {
+	first, second := a, b
+	if b.endedFirst {
+		first, second = b, a
+	}
+
+	attrs := []any{"ended_first", first.Name}
+	for _, s := range []*Stream{first, second} {
+		attrs = append(attrs, s.Name+"_bytes", s.N)
+		if s.Err != nil && s.Err != io.EOF {
+			attrs = append(attrs, s.Name+"_error", s.Err)
+		}
+	}
+	return attrs
+}
+
+// run 转发直到 Src 结束，读到 EOF 时半关闭 Peer
+func (s *Stream) run() error {
+	dst := s.Dst
+	if s.Count != nil {
+		dst = &countWriter{w: dst, n: s.Count}
+	}
+	n, err := Copy(dst, s.Src)
+	s.N = n
+	if err != nil {
+		return err
+	}
+	if !CloseWrite(s.Peer) {
+		return io.EOF
+	}
+	return nil
+}
+
+// CloseWrite 半关闭 w 的写方向，w 不支持半关闭或失败时返回 false
+func CloseWrite(w io.Writer) bool {
+	cw, ok := w.(interface{ CloseWrite() error })
+	if !ok {
+		return false
+	}
+	return cw.CloseWrite() == nil
+}
+
+// countWriter 将写入的字节数累加到 n
+type countWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
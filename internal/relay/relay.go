@@ -0,0 +1,27 @@
+package relay
+
+import (
+	"io"
+	"sync"
+)
+
+// BufferSize 转发缓冲区大小
+// 不能超过 SecureWriter 单个数据包可承载的明文长度，保持与 io.Copy 默认值一致
+const BufferSize = 32 * 1024
+
+// bufPool 转发缓冲区池，存放 *[]byte 以避免装箱分配
+var bufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, BufferSize)
+		return &buf
+	},
+}
+
+// Copy 使用池化缓冲区将 src 复制到 dst，语义同 io.Copy
+// 每次调用独占一个缓冲区，隧道的两个方向应分别调用
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+
+	return io.CopyBuffer(dst, src, *buf)
+}
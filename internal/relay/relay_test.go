@@ -0,0 +1,42 @@
+package relay
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// onlyReader 隐藏 WriterTo 等接口，迫使 io.Copy 分配缓冲区
+type onlyReader struct{ io.Reader }
+
+// onlyWriter 隐藏 ReaderFrom 等接口
+type onlyWriter struct{ io.Writer }
+
+func TestCopy(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), BufferSize/5) // 两个缓冲区长度
+	var dst bytes.Buffer
+	n, err := Copy(onlyWriter{&dst}, onlyReader{bytes.NewReader(payload)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(payload)) || !bytes.Equal(dst.Bytes(), payload) {
+		t.Fatalf("copied %d bytes, want %d identical bytes", n, len(payload))
+	}
+}
+
+// 每次迭代模拟一条短连接隧道的一个方向：复制少量数据后结束
+func BenchmarkCopyShortLived(b *testing.B) {
+	payload := make([]byte, 512)
+	b.Run("io.Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			io.Copy(onlyWriter{io.Discard}, onlyReader{bytes.NewReader(payload)})
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			Copy(onlyWriter{io.Discard}, onlyReader{bytes.NewReader(payload)})
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package relay
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// TuneTCP 设置 TCP_NODELAY 与 TCP keepalive
+// keepAlive 为 0 时关闭 keepalive；conn 不是 TCP 连接时记录日志并跳过
+// 包装过的连接可以实现 NetConn() 返回底层连接（与 tls.Conn 相同）
+func TuneTCP(conn net.Conn, keepAlive time.Duration, noDelay bool, log *slog.Logger) {
+	if wrapped, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapped.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		log.Debug("Not a TCP connection, skipping socket options", "type", fmt.Sprintf("%T", conn))
+		return
+	}
+
+	// Go 默认已为 TCP 连接开启 TCP_NODELAY，只在明确关闭时修改
+	if !noDelay {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			log.Debug("Failed to disable TCP_NODELAY", "error", err)
+		}
+	}
+
+	if keepAlive > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			log.Debug("Failed to enable TCP keepalive", "error", err)
+			return
+		}
+		if err := tcpConn.SetKeepAlivePeriod(keepAlive); err != nil {
+			log.Debug("Failed to set TCP keepalive period", "error", err)
+		}
+	} else if err := tcpConn.SetKeepAlive(false); err != nil {
+		log.Debug("Failed to disable TCP keepalive", "error", err)
+	}
+}
@@ -0,0 +1,286 @@
+// Package sniff 根据隧道开头的数据识别客户端使用的协议，用于排查问题
+// 只观察经过的数据，不修改也不额外读取数据流
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Limit 最多观察的字节数，足以容纳常见的 TLS ClientHello（包括后量子密钥交换）
+const Limit = 4096
+
+// 识别出的协议
+const (
+	ProtocolTLS     = "tls"
+	ProtocolHTTP    = "http"
+	ProtocolHTTP2   = "http2"
+	ProtocolSSH     = "ssh"
+	ProtocolUnknown = "unknown"
+)
+
+// Result 协议识别结果
+type Result struct {
+	Protocol string
+	Version  string   // TLS 版本、HTTP 版本或 SSH 标识
+	SNI      string   // TLS ClientHello 中的服务器名称
+	ALPN     []string // TLS ClientHello 中的 ALPN 协议列表
+	Method   string   // HTTP 请求方法
+	Head     []byte   // 无法识别时开头的数据
+}
+
+// LogAttrs 返回用于日志的字段，只包含非空的部分
+func (r Result) LogAttrs() []any {
+	attrs := []any{"protocol", r.Protocol}
+	if r.Version != "" {
+		attrs = append(attrs, "version", r.Version)
+	}
+	if r.SNI != "" {
+		attrs = append(attrs, "sni", r.SNI)
+	}
+	if len(r.ALPN) > 0 {
+		attrs = append(attrs, "alpn", strings.Join(r.ALPN, ","))
+	}
+	if r.Method != "" {
+		attrs = append(attrs, "method", r.Method)
+	}
+	if len(r.Head) > 0 {
+		attrs = append(attrs, "head", hex.EncodeToString(r.Head))
+	}
+	return attrs
+}
+
+// headLen 无法识别时记录的开头字节数
+const headLen = 16
+
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// http2Preface HTTP/2 明文连接的客户端前言
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Classify 识别 b 中的协议；数据不足以得出结论时 complete 为 false，调用方可以在收到更多数据后重试
+func Classify(b []byte) (r Result, complete bool) {
+	switch {
+	case len(b) == 0:
+		return Result{Protocol: ProtocolUnknown}, false
+	case b[0] == 0x16:
+		return classifyTLS(b)
+	case bytes.HasPrefix(b, []byte("SSH-")):
+		return classifySSH(b)
+	case hasPrefixOf(b, http2Preface):
+		if len(b) < len(http2Preface) {
+			return Result{Protocol: ProtocolHTTP2}, false
+		}
+		return Result{Protocol: ProtocolHTTP2, Version: "HTTP/2.0"}, true
+	}
+
+	for _, method := range httpMethods {
+		prefix := method + " "
+		if hasPrefixOf(b, prefix) {
+			if len(b) < len(prefix) {
+				return Result{Protocol: ProtocolUnknown}, false
+			}
+			return classifyHTTP(b, method)
+		}
+	}
+	return unknown(b), true
+}
+
+// hasPrefixOf b 是 s 的前缀或以 s 开头
+func hasPrefixOf(b []byte, s string) bool {
+	n := min(len(b), len(s))
+	return string(b[:n]) == s[:n]
+}
+
+func unknown(b []byte) Result {
+	return Result{Protocol: ProtocolUnknown, Head: bytes.Clone(b[:min(len(b), headLen)])}
+}
+
+// classifyHTTP 从请求行中取出 HTTP 版本，请求行不完整时等待更多数据
+func classifyHTTP(b []byte, method string) (Result, bool) {
+	r := Result{Protocol: ProtocolHTTP, Method: method}
+	end := bytes.IndexByte(b, '\n')
+	if end < 0 {
+		return r, false
+	}
+	line := strings.TrimRight(string(b[:end]), "\r")
+	if i := strings.LastIndexByte(line, ' '); i >= 0 && strings.HasPrefix(line[i+1:], "HTTP/") {
+		r.Version = line[i+1:]
+	}
+	return r, true
+}
+
+// classifySSH 取出 SSH 标识行，如 SSH-2.0-OpenSSH_9.6
+func classifySSH(b []byte) (Result, bool) {
+	r := Result{Protocol: ProtocolSSH}
+	end := bytes.IndexByte(b, '\n')
+	if end < 0 {
+		return r, false
+	}
+	r.Version = strings.TrimRight(string(b[:end]), "\r")
+	return r, true
+}
+
+// classifyTLS 解析 TLS 记录中的 ClientHello，取出版本、SNI 与 ALPN
+// ClientHello 跨越多个记录的情况很少见，只解析第一个记录
+func classifyTLS(b []byte) (Result, bool) {
+	r := Result{Protocol: ProtocolTLS}
+	if len(b) < 5 {
+		return r, false
+	}
+	if b[1] != 3 {
+		return unknown(b), true
+	}
+	recordLen := int(binary.BigEndian.Uint16(b[3:5]))
+	if len(b) < 5+recordLen {
+		return r, false
+	}
+	hello, ok := parseClientHello(b[5 : 5+recordLen])
+	if !ok {
+		r.Version = tlsVersion(binary.BigEndian.Uint16(b[1:3]))
+		return r, true
+	}
+	hello.Protocol = ProtocolTLS
+	return hello, true
+}
+
+// parseClientHello 解析握手消息，不是 ClientHello 或格式错误时返回 false
+func parseClientHello(msg []byte) (Result, bool) {
+	var r Result
+	p := parser{b: msg}
+	if p.u8() != 1 { // client_hello
+		return r, false
+	}
+	p.skip(3) // 握手消息长度
+	version := p.u16()
+	p.skip(32) // random
+	p.skip(int(p.u8()))
+	p.skip(int(p.u16()))
+	p.skip(int(p.u8()))
+	if p.bad {
+		return r, false
+	}
+	r.Version = tlsVersion(version)
+
+	exts := parser{b: p.bytes(int(p.u16()))}
+	for !exts.bad && len(exts.b) > 0 {
+		typ := exts.u16()
+		data := parser{b: exts.bytes(int(exts.u16()))}
+		switch typ {
+		case 0x0000: // server_name
+			list := parser{b: data.bytes(int(data.u16()))}
+			for !list.bad && len(list.b) > 0 {
+				nameType := list.u8()
+				name := list.bytes(int(list.u16()))
+				if nameType == 0 && !list.bad {
+					r.SNI = string(name)
+				}
+			}
+		case 0x0010: // application_layer_protocol_negotiation
+			list := parser{b: data.bytes(int(data.u16()))}
+			for !list.bad && len(list.b) > 0 {
+				if proto := list.bytes(int(list.u8())); !list.bad {
+					r.ALPN = append(r.ALPN, string(proto))
+				}
+			}
+		case 0x002b: // supported_versions，取最高的已知版本
+			list := parser{b: data.bytes(int(data.u8()))}
+			for !list.bad && len(list.b) > 0 {
+				if v := list.u16(); !list.bad && v > version && v <= 0x0304 {
+					version = v
+					r.Version = tlsVersion(v)
+				}
+			}
+		}
+	}
+	return r, true
+}
+
+func tlsVersion(v uint16) string {
+	switch v {
+	case 0x0300:
+		return "SSL 3.0"
+	case 0x0301:
+		return "TLS 1.0"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0304:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
+
+// parser 按顺序读取字段，越界后 bad 为 true，之后读取的值均为零
+type parser struct {
+	b   []byte
+	bad bool
+}
+
+func (p *parser) bytes(n int) []byte {
+	if p.bad || n > len(p.b) {
+		p.bad = true
+		return nil
+	}
+	v := p.b[:n]
+	p.b = p.b[n:]
+	return v
+}
+
+func (p *parser) skip(n int) {
+	p.bytes(n)
+}
+
+func (p *parser) u8() byte {
+	if v := p.bytes(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (p *parser) u16() uint16 {
+	if v := p.bytes(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+// Reader 包装隧道一个方向的数据源，观察开头最多 Limit 字节，识别出协议后记录一条日志
+// 数据原样返回给调用方，识别完成后不再复制数据
+type Reader struct {
+	src io.Reader
+	log *slog.Logger
+	buf []byte
+}
+
+// NewReader 创建观察 src 的读取器，log 通常已带有目标地址等字段
+func NewReader(src io.Reader, log *slog.Logger) *Reader {
+	return &Reader{src: src, log: log, buf: make([]byte, 0, 512)}
+}
+
+// Read 实现 io.Reader
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if r.log != nil && (n > 0 || err != nil) {
+		r.buf = append(r.buf, p[:min(n, Limit-len(r.buf))]...)
+		result, complete := Classify(r.buf)
+		if complete || len(r.buf) >= Limit || err != nil {
+			if len(r.buf) > 0 {
+				if !complete && result.Protocol == ProtocolUnknown {
+					result = unknown(r.buf)
+				}
+				r.log.Info("Tunnel protocol detected", result.LogAttrs()...)
+			}
+			r.log = nil
+			r.buf = nil
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,152 @@
+package sniff
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// clientHello 返回 crypto/tls 客户端发送的第一个 TLS 记录
+func clientHello(t *testing.T, serverName string, alpn ...string) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(5 * time.Second))
+
+	client := tls.Client(c, &tls.Config{ServerName: serverName, NextProtos: alpn})
+	go func() {
+		client.Handshake()
+		c.Close()
+	}()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(s, header); err != nil {
+		t.Fatal(err)
+	}
+	record := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+	if _, err := io.ReadFull(s, record); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, record...)
+}
+
+func TestClassifyTLS(t *testing.T) {
+	hello := clientHello(t, "example.com", "h2", "http/1.1")
+
+	r, complete := Classify(hello)
+	if !complete {
+		t.Fatal("complete ClientHello classified as incomplete")
+	}
+	if r.Protocol != ProtocolTLS || r.SNI != "example.com" || r.Version != "TLS 1.3" {
+		t.Fatalf("Classify = %+v, want TLS 1.3 to example.com", r)
+	}
+	if !slices.Equal(r.ALPN, []string{"h2", "http/1.1"}) {
+		t.Fatalf("ALPN = %v, want [h2 http/1.1]", r.ALPN)
+	}
+
+	// ClientHello 分多次到达时等待完整的记录
+	for _, n := range []int{1, 4, 5, len(hello) / 2, len(hello) - 1} {
+		if r, complete := Classify(hello[:n]); complete || r.Protocol != ProtocolTLS {
+			t.Fatalf("first %d bytes: %+v, complete %v; want an incomplete TLS result", n, r, complete)
+		}
+	}
+}
+
+func TestClassifyHTTP(t *testing.T) {
+	req := []byte("POST /api HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n")
+
+	r, complete := Classify(req)
+	if !complete || r.Protocol != ProtocolHTTP || r.Method != "POST" || r.Version != "HTTP/1.1" {
+		t.Fatalf("Classify = %+v, complete %v; want POST HTTP/1.1", r, complete)
+	}
+	for _, n := range []int{2, 5, 15} {
+		if _, complete := Classify(req[:n]); complete {
+			t.Fatalf("first %d bytes of the request line classified as complete", n)
+		}
+	}
+}
+
+func TestClassifyOther(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Result
+		wantHex string
+	}{
+		{"ssh", "SSH-2.0-OpenSSH_9.6\r\n", Result{Protocol: ProtocolSSH, Version: "SSH-2.0-OpenSSH_9.6"}, ""},
+		{"http2", http2Preface + "\x00\x00", Result{Protocol: ProtocolHTTP2, Version: "HTTP/2.0"}, ""},
+		{"binary", "\x00\x01\x02\x03", Result{Protocol: ProtocolUnknown}, "00010203"},
+		{"lowercase method", "get / HTTP/1.1\r\n", Result{Protocol: ProtocolUnknown}, "676574202f20485454502f312e310d0a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, complete := Classify([]byte(tt.data))
+			if !complete {
+				t.Fatal("classified as incomplete")
+			}
+			if r.Protocol != tt.want.Protocol || r.Version != tt.want.Version {
+				t.Fatalf("Classify = %+v, want %+v", r, tt.want)
+			}
+			if got := attr(r.LogAttrs(), "head"); got != tt.wantHex {
+				t.Fatalf("head = %q, want %q", got, tt.wantHex)
+			}
+		})
+	}
+}
+
+// attr 返回日志字段中 key 对应的值，不存在时为空
+func attr(attrs []any, key string) string {
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i] == key {
+			return attrs[i+1].(string)
+		}
+	}
+	return ""
+}
+
+func TestReaderPassesDataThrough(t *testing.T) {
+	hello := clientHello(t, "sniff.example", "h2")
+	data := append(hello, []byte("application data after the hello")...)
+
+	var logs bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logs, nil))
+	// 每次只读一个字节，识别需要跨多次 Read 累积数据
+	got, err := io.ReadAll(NewReader(iotest.OneByteReader(bytes.NewReader(data)), log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("sniffing reader changed the stream")
+	}
+
+	out := logs.String()
+	if n := strings.Count(out, "Tunnel protocol detected"); n != 1 {
+		t.Fatalf("logged %d times, want once:\n%s", n, out)
+	}
+	for _, want := range []string{"protocol=tls", "sni=sniff.example", "alpn=h2"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("log %q does not contain %s", out, want)
+		}
+	}
+}
+
+func TestReaderLogsShortStream(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logs, nil))
+
+	// 连接在识别完成前结束时，仍记录已收到的开头
+	if _, err := io.ReadAll(NewReader(strings.NewReader("GET /"), log)); err != nil {
+		t.Fatal(err)
+	}
+	if out := logs.String(); !strings.Contains(out, "protocol=http") || !strings.Contains(out, "method=GET") {
+		t.Fatalf("log = %q, want an HTTP GET entry", out)
+	}
+}
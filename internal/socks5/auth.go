@@ -0,0 +1,104 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+var (
+	// ErrGSSAPIRequired is returned when the proxy insists on GSSAPI authentication
+	// (RFC 1961) and no GSSAPI Authenticator has been registered
+	ErrGSSAPIRequired = errors.New("SOCKS5 proxy requires GSSAPI authentication, which is not available")
+
+	// ErrCredentialsRequired is returned when the proxy insists on
+	// username/password authentication but no credentials were configured
+	ErrCredentialsRequired = errors.New("SOCKS5 proxy requires username/password authentication, but no credentials are configured")
+
+	// ErrAuthRejected is returned when the proxy rejects the username/password
+	ErrAuthRejected = errors.New("SOCKS5 authentication failed")
+)
+
+// Authenticator implements one SOCKS5 authentication method. Authenticate runs
+// the method-specific sub-negotiation after the proxy has selected Method.
+type Authenticator interface {
+	Method() byte
+	Authenticate(conn net.Conn) error
+}
+
+var (
+	extraAuthMu         sync.RWMutex
+	extraAuthenticators []Authenticator
+)
+
+// RegisterAuthenticator makes an additional authentication method, such as a
+// Kerberos-backed GSSAPI implementation, available to every dial. Registered
+// methods are offered after no-auth and username/password. Registering a
+// method twice replaces the earlier Authenticator in place.
+func RegisterAuthenticator(a Authenticator) {
+	extraAuthMu.Lock()
+	defer extraAuthMu.Unlock()
+
+	for i, existing := range extraAuthenticators {
+		if existing.Method() == a.Method() {
+			extraAuthenticators[i] = a
+			return
+		}
+	}
+	extraAuthenticators = append(extraAuthenticators, a)
+}
+
+// offeredAuthenticators returns the authenticators to offer, in preference order
+func offeredAuthenticators(username, password string) []Authenticator {
+	authenticators := []Authenticator{noAuthAuthenticator{}} // Always offer no authentication
+
+	if username != "" || password != "" {
+		authenticators = append(authenticators, passwordAuthenticator{username: username, password: password})
+	}
+
+	extraAuthMu.RLock()
+	defer extraAuthMu.RUnlock()
+	for _, a := range extraAuthenticators {
+		if method := a.Method(); method != AuthNone && method != AuthPassword {
+			authenticators = append(authenticators, a)
+		}
+	}
+
+	return authenticators
+}
+
+// noAuthAuthenticator implements the "no authentication required" method
+type noAuthAuthenticator struct{}
+
+func (noAuthAuthenticator) Method() byte { return AuthNone }
+
+func (noAuthAuthenticator) Authenticate(conn net.Conn) error { return nil }
+
+// passwordAuthenticator implements username/password authentication (RFC 1929)
+type passwordAuthenticator struct {
+	username string
+	password string
+}
+
+func (a passwordAuthenticator) Method() byte { return AuthPassword }
+
+func (a passwordAuthenticator) Authenticate(conn net.Conn) error {
+	return authenticate(conn, a.username, a.password)
+}
+
+// methodName returns a human-readable name of a SOCKS5 authentication method
+func methodName(method byte) string {
+	switch method {
+	case AuthNone:
+		return "no authentication"
+	case AuthGSSAPI:
+		return "GSSAPI"
+	case AuthPassword:
+		return "username/password"
+	case AuthNoAcceptable:
+		return "no acceptable methods"
+	default:
+		return fmt.Sprintf("method 0x%02x", method)
+	}
+}
@@ -0,0 +1,170 @@
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGSSAPIRequired(t *testing.T) {
+	proxy := newMockProxy(t, func(p *mockProxy) {
+		p.selectMethod = func([]byte) byte { return AuthGSSAPI }
+	})
+
+	_, err := DialWithAuth(proxy.addr(), "example.com:80", "", "", 5*time.Second)
+	if !errors.Is(err, ErrGSSAPIRequired) {
+		t.Fatalf("err = %v, want ErrGSSAPIRequired", err)
+	}
+}
+
+func TestNoAcceptableMethodsHintsGSSAPI(t *testing.T) {
+	proxy := newMockProxy(t, func(p *mockProxy) {
+		p.selectMethod = func([]byte) byte { return AuthNoAcceptable }
+	})
+
+	_, err := DialWithAuth(proxy.addr(), "example.com:80", "", "", 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "GSSAPI") {
+		t.Fatalf("err = %v, want a hint about GSSAPI", err)
+	}
+}
+
+// gssapiStub 模拟 GSSAPI 子协商的 Authenticator，只交换一个固定令牌
+type gssapiStub struct{}
+
+func (gssapiStub) Method() byte { return AuthGSSAPI }
+
+func (gssapiStub) Authenticate(conn net.Conn) error {
+	if _, err := conn.Write([]byte("token")); err != nil {
+		return err
+	}
+	ack := make([]byte, 2)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return err
+	}
+	if string(ack) != "ok" {
+		return errors.New("token rejected")
+	}
+	return nil
+}
+
+func TestRegisteredAuthenticator(t *testing.T) {
+	extraAuthMu.Lock()
+	saved := extraAuthenticators
+	extraAuthenticators = nil
+	extraAuthMu.Unlock()
+	t.Cleanup(func() {
+		extraAuthMu.Lock()
+		extraAuthenticators = saved
+		extraAuthMu.Unlock()
+	})
+	RegisterAuthenticator(gssapiStub{})
+
+	// 代理读取 GSSAPI 令牌后立即关闭，只检查方法协商和子协商是否经过注册的实现
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	offeredCh := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		offered := make([]byte, header[1])
+		io.ReadFull(conn, offered)
+		offeredCh <- offered
+		conn.Write([]byte{Version5, AuthGSSAPI})
+		token := make([]byte, 5)
+		io.ReadFull(conn, token)
+		if string(token) == "token" {
+			conn.Write([]byte("no"))
+		}
+	}()
+
+	_, err = DialWithAuth(ln.Addr().String(), "example.com:80", "", "", 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "token rejected") {
+		t.Fatalf("err = %v, want the registered authenticator's error", err)
+	}
+	if offered := <-offeredCh; !bytes.Equal(offered, []byte{AuthNone, AuthGSSAPI}) {
+		t.Fatalf("offered methods = %v, want no-auth and GSSAPI", offered)
+	}
+}
+
+// preferPassword 客户端提供用户名/密码时选择它，否则选择无需认证
+func preferPassword(offered []byte) byte {
+	if bytes.IndexByte(offered, AuthPassword) >= 0 {
+		return AuthPassword
+	}
+	return AuthNone
+}
+
+func TestPasswordRequiredWithoutCredentials(t *testing.T) {
+	proxy := newMockProxy(t, func(p *mockProxy) {
+		p.selectMethod = func([]byte) byte { return AuthPassword }
+	})
+
+	_, err := DialWithAuth(proxy.addr(), "example.com:80", "", "", 5*time.Second)
+	if !errors.Is(err, ErrCredentialsRequired) {
+		t.Fatalf("err = %v, want ErrCredentialsRequired", err)
+	}
+}
+
+func TestPasswordAccepted(t *testing.T) {
+	echo := startEcho(t)
+	proxy := newMockProxy(t, func(p *mockProxy) {
+		p.selectMethod = preferPassword
+		p.username, p.password = "alice", "secret"
+	})
+
+	conn, err := DialWithAuth(proxy.addr(), echo, "alice", "secret", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestPasswordRejectedRetriesNoAuth(t *testing.T) {
+	echo := startEcho(t)
+	proxy := newMockProxy(t, func(p *mockProxy) {
+		p.selectMethod = preferPassword
+		p.username, p.password = "alice", "secret"
+	})
+
+	conn, err := DialWithAuth(proxy.addr(), echo, "alice", "wrong", 5*time.Second)
+	if err != nil {
+		t.Fatalf("retry with no-auth failed: %v", err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+
+	// 只有重试的连接发出了 CONNECT 请求
+	if got := <-proxy.targets; got != echo {
+		t.Fatalf("CONNECT target = %q, want %q", got, echo)
+	}
+	select {
+	case extra := <-proxy.targets:
+		t.Fatalf("unexpected second CONNECT to %q", extra)
+	default:
+	}
+}
+
+func TestPasswordRejectedWithoutNoAuth(t *testing.T) {
+	proxy := newMockProxy(t, func(p *mockProxy) {
+		p.selectMethod = passwordOnly
+		p.username, p.password = "alice", "secret"
+	})
+
+	_, err := DialWithAuth(proxy.addr(), "example.com:80", "alice", "wrong", 5*time.Second)
+	if !errors.Is(err, ErrAuthRejected) {
+		t.Fatalf("err = %v, want ErrAuthRejected", err)
+	}
+}
@@ -1,9 +1,14 @@
 package socks5
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 	"time"
@@ -14,9 +19,9 @@ const (
 	Version5 = 0x05
 
 	// Authentication methods
-	AuthNone     = 0x00
-	AuthGSSAPI   = 0x01
-	AuthPassword = 0x02
+	AuthNone         = 0x00
+	AuthGSSAPI       = 0x01
+	AuthPassword     = 0x02
 	AuthNoAcceptable = 0xFF
 
 	// Commands
@@ -24,6 +29,10 @@ const (
 	CmdBind         = 0x02
 	CmdUDPAssociate = 0x03
 
+	// Tor extensions: resolve a name (or an address) without opening a connection
+	CmdResolve    = 0xF0
+	CmdResolvePTR = 0xF1
+
 	// Address types
 	AtypIPv4   = 0x01
 	AtypDomain = 0x03
@@ -48,66 +57,243 @@ const (
 // password: Password for authentication (empty string for no auth)
 // timeout: Connection timeout
 func DialWithAuth(proxyAddr, targetAddr, username, password string, timeout time.Duration) (net.Conn, error) {
-	// Connect to SOCKS5 proxy
+	conn, _, err := DialWithAuthEx(proxyAddr, targetAddr, username, password, timeout)
+	return conn, err
+}
+
+// DialWithAuthEx is like DialWithAuth but also returns the BND.ADDR/BND.PORT
+// reported by the proxy in its CONNECT reply. If the proxy reports a domain
+// name instead of an IP address, the returned AddrPort is the zero value.
+func DialWithAuthEx(proxyAddr, targetAddr, username, password string, timeout time.Duration) (net.Conn, netip.AddrPort, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return dialContext(ctx, proxyAddr, targetAddr, username, password)
+}
+
+// DialContext connects to targetAddr through a SOCKS5 proxy with optional authentication.
+// Cancelling ctx aborts both the TCP dial and the SOCKS5 handshake; the deadline
+// of ctx, if any, bounds the whole process.
+func DialContext(ctx context.Context, proxyAddr, targetAddr, username, password string) (net.Conn, error) {
+	conn, _, err := dialContext(ctx, proxyAddr, targetAddr, username, password)
+	return conn, err
+}
+
+// dialContext implements DialContext and DialWithAuthEx
+func dialContext(ctx context.Context, proxyAddr, targetAddr, username, password string) (net.Conn, netip.AddrPort, error) {
+	return dialChainContext(ctx, []Hop{{Addr: proxyAddr, Username: username, Password: password}}, targetAddr)
+}
+
+// dialChainContext dials the first hop directly and tunnels each following
+// hop, and finally targetAddr, through the previous one. It returns the bind
+// address reported by the last hop.
+func dialChainContext(ctx context.Context, hops []Hop, targetAddr string) (net.Conn, netip.AddrPort, error) {
+	if len(hops) == 0 {
+		return nil, netip.AddrPort{}, fmt.Errorf("no SOCKS5 proxy specified")
+	}
+
+	conn, bindAddr, failed, err := dialChainOnce(ctx, hops, targetAddr)
+	if err == nil || !errors.Is(err, ErrAuthRejected) || ctx.Err() != nil {
+		return conn, bindAddr, err
+	}
+
+	// The proxy chose username/password over no-auth and then rejected our
+	// credentials. RFC 1929 requires it to close the connection, so retry the
+	// whole chain offering only no-auth for that hop.
+	retryHops := append([]Hop(nil), hops...)
+	retryHops[failed].Username = ""
+	retryHops[failed].Password = ""
+	conn, bindAddr, _, retryErr := dialChainOnce(ctx, retryHops, targetAddr)
+	if retryErr != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	return conn, bindAddr, nil
+}
+
+// dialChainOnce performs a single attempt of dialChainContext. On handshake
+// failure it also returns the index of the hop that failed.
+func dialChainOnce(ctx context.Context, hops []Hop, targetAddr string) (net.Conn, netip.AddrPort, int, error) {
+
+	// Connect to the first SOCKS5 proxy
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hops[0].Addr)
+	if err != nil {
+		return nil, netip.AddrPort{}, 0, fmt.Errorf("failed to connect to SOCKS5 proxy: %w", err)
+	}
+
+	// Bound the handshake by the context deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Interrupt blocked handshake reads/writes when ctx is cancelled
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	// Perform one SOCKS5 handshake per hop, each asking for the next hop
+	var bindAddr netip.AddrPort
+	failed := 0
+	for i, hop := range hops {
+		next := targetAddr
+		if i+1 < len(hops) {
+			next = hops[i+1].Addr
+		}
+
+		bindAddr, err = performHandshake(conn, next, hop.Username, hop.Password)
+		if err != nil {
+			failed = i
+			if len(hops) > 1 {
+				err = fmt.Errorf("hop %d (%s): %w", i+1, hop.Addr, err)
+			}
+			break
+		}
+	}
+	close(done)
+	<-watcherDone
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		conn.Close()
+		return nil, netip.AddrPort{}, failed, fmt.Errorf("SOCKS5 handshake aborted: %w", ctxErr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, netip.AddrPort{}, failed, err
+	}
+
+	// Clear deadline after successful handshake
+	conn.SetDeadline(time.Time{})
+
+	return conn, bindAddr, 0, nil
+}
+
+// Binding is a pending SOCKS5 BIND request. The proxy is listening on Addr
+// and Accept waits for the peer to connect to it.
+type Binding struct {
+	conn net.Conn
+	addr netip.AddrPort
+}
+
+// BindWithAuth sends a BIND request through a SOCKS5 proxy with optional authentication
+// and returns once the proxy has reported the address it is listening on.
+// The returned Binding must be closed if Accept is never called.
+func BindWithAuth(proxyAddr, username, password string, timeout time.Duration) (*Binding, error) {
 	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SOCKS5 proxy: %w", err)
 	}
 
-	// Set deadline for the entire handshake process
 	if timeout > 0 {
 		conn.SetDeadline(time.Now().Add(timeout))
 	}
 
-	// Perform SOCKS5 handshake
-	if err := performHandshake(conn, targetAddr, username, password); err != nil {
+	if err := negotiateAndAuthenticate(conn, username, password); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	// Clear deadline after successful handshake
+	// DST.ADDR is the expected peer; we accept any peer
+	if err := sendRequest(conn, CmdBind, "0.0.0.0:0"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// First reply: the address the proxy is listening on
+	bindAddr, err := readReply(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("BIND failed: %w", err)
+	}
+
+	// The peer may take arbitrarily long to connect
 	if timeout > 0 {
 		conn.SetDeadline(time.Time{})
 	}
 
-	return conn, nil
+	return &Binding{conn: conn, addr: bindAddr}, nil
 }
 
-// performHandshake executes the complete SOCKS5 handshake sequence
-func performHandshake(conn net.Conn, targetAddr, username, password string) error {
-	// Step 1: Negotiate authentication method
-	authMethod, err := negotiateAuth(conn, username, password)
+// Addr returns the address the proxy is listening on for the peer
+func (b *Binding) Addr() netip.AddrPort {
+	return b.addr
+}
+
+// Accept waits for the second BIND reply, sent by the proxy once the peer
+// has connected, and returns the relayed connection and the peer address.
+// Closing the Binding aborts a pending Accept.
+func (b *Binding) Accept() (net.Conn, netip.AddrPort, error) {
+	peerAddr, err := readReply(b.conn)
 	if err != nil {
-		return err
+		b.conn.Close()
+		return nil, netip.AddrPort{}, fmt.Errorf("BIND accept failed: %w", err)
 	}
 
-	// Step 2: Authenticate if required
-	if authMethod == AuthPassword {
-		if err := authenticate(conn, username, password); err != nil {
-			return err
-		}
+	return b.conn, peerAddr, nil
+}
+
+// Close closes the connection to the proxy
+func (b *Binding) Close() error {
+	return b.conn.Close()
+}
+
+// performHandshake executes the complete SOCKS5 handshake sequence
+// and returns the bind address reported by the proxy
+func performHandshake(conn net.Conn, targetAddr, username, password string) (netip.AddrPort, error) {
+	// Step 1 & 2: Negotiate authentication method and authenticate if required
+	if err := negotiateAndAuthenticate(conn, username, password); err != nil {
+		return netip.AddrPort{}, err
 	}
 
 	// Step 3: Send CONNECT request
-	if err := sendConnectRequest(conn, targetAddr); err != nil {
+	return sendConnectRequest(conn, targetAddr)
+}
+
+// negotiateAndAuthenticate negotiates the authentication method and runs
+// the sub-negotiation of whichever method the proxy selected
+func negotiateAndAuthenticate(conn net.Conn, username, password string) error {
+	authenticators := offeredAuthenticators(username, password)
+
+	methods := make([]byte, len(authenticators))
+	for i, a := range authenticators {
+		methods[i] = a.Method()
+	}
+
+	authMethod, err := negotiateAuth(conn, methods)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	for _, a := range authenticators {
+		if a.Method() == authMethod {
+			return a.Authenticate(conn)
+		}
+	}
+
+	// The proxy picked a method we never offered
+	switch authMethod {
+	case AuthGSSAPI:
+		return ErrGSSAPIRequired
+	case AuthPassword:
+		return ErrCredentialsRequired
+	}
+	return fmt.Errorf("proxy selected unsupported authentication method: %s", methodName(authMethod))
 }
 
 // negotiateAuth sends authentication method negotiation (RFC 1928)
 // Client sends: [VER][NMETHODS][METHODS...]
 // Server replies: [VER][METHOD]
-func negotiateAuth(conn net.Conn, username, password string) (byte, error) {
-	// Determine which auth methods to offer
-	var methods []byte
-	methods = append(methods, AuthNone) // Always offer no authentication
-
-	if username != "" || password != "" {
-		methods = append(methods, AuthPassword) // Offer username/password auth
-	}
-
+func negotiateAuth(conn net.Conn, methods []byte) (byte, error) {
 	// Build negotiation request
 	req := []byte{
 		Version5,
@@ -131,7 +317,15 @@ func negotiateAuth(conn net.Conn, username, password string) (byte, error) {
 	}
 
 	if resp[1] == AuthNoAcceptable {
-		return 0, fmt.Errorf("no acceptable authentication methods")
+		offered := make([]string, len(methods))
+		for i, m := range methods {
+			offered[i] = methodName(m)
+		}
+		hint := ""
+		if !bytes.Contains(methods, []byte{AuthGSSAPI}) {
+			hint = "; the proxy may require GSSAPI, which needs an Authenticator registered via RegisterAuthenticator"
+		}
+		return 0, fmt.Errorf("no acceptable authentication methods (offered: %s)%s", strings.Join(offered, ", "), hint)
 	}
 
 	return resp[1], nil
@@ -170,16 +364,30 @@ func authenticate(conn net.Conn, username, password string) error {
 	}
 
 	if resp[1] != 0x00 {
-		return fmt.Errorf("authentication failed: status code %d", resp[1])
+		return fmt.Errorf("%w: status code %d", ErrAuthRejected, resp[1])
 	}
 
 	return nil
 }
 
 // sendConnectRequest sends SOCKS5 CONNECT command (RFC 1928)
+// and returns the bind address from the server reply
+func sendConnectRequest(conn net.Conn, targetAddr string) (netip.AddrPort, error) {
+	if err := sendRequest(conn, CmdConnect, targetAddr); err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	bindAddr, err := readReply(conn)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("CONNECT failed: %w", err)
+	}
+
+	return bindAddr, nil
+}
+
+// sendRequest sends a SOCKS5 request for the given command
 // Client sends: [VER][CMD][RSV][ATYP][DST.ADDR][DST.PORT]
-// Server replies: [VER][REP][RSV][ATYP][BND.ADDR][BND.PORT]
-func sendConnectRequest(conn net.Conn, targetAddr string) error {
+func sendRequest(conn net.Conn, cmd byte, targetAddr string) error {
 	// Parse target address (host:port)
 	host, portStr, err := net.SplitHostPort(targetAddr)
 	if err != nil {
@@ -187,14 +395,14 @@ func sendConnectRequest(conn net.Conn, targetAddr string) error {
 	}
 
 	port, err := strconv.Atoi(portStr)
-	if err != nil || port < 1 || port > 65535 {
+	if err != nil || port < 0 || port > 65535 || (cmd == CmdConnect && port == 0) {
 		return fmt.Errorf("invalid port: %s", portStr)
 	}
 
-	// Build CONNECT request
+	// Build request
 	req := []byte{
 		Version5,
-		CmdConnect,
+		cmd,
 		0x00, // Reserved
 	}
 
@@ -223,27 +431,31 @@ func sendConnectRequest(conn net.Conn, targetAddr string) error {
 	// Add port (big-endian)
 	req = append(req, byte(port>>8), byte(port&0xff))
 
-	// Send CONNECT request
+	// Send request
 	if _, err := conn.Write(req); err != nil {
-		return fmt.Errorf("failed to send CONNECT request: %w", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 
-	// Read server reply
+	return nil
+}
+
+// readReply reads a SOCKS5 reply and returns the bind address
+// Server replies: [VER][REP][RSV][ATYP][BND.ADDR][BND.PORT]
+func readReply(conn net.Conn) (netip.AddrPort, error) {
 	resp := make([]byte, 4)
 	if _, err := io.ReadFull(conn, resp); err != nil {
-		return fmt.Errorf("failed to read CONNECT response: %w", err)
+		return netip.AddrPort{}, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if resp[0] != Version5 {
-		return fmt.Errorf("invalid SOCKS version in response: %d", resp[0])
+		return netip.AddrPort{}, fmt.Errorf("invalid SOCKS version in response: %d", resp[0])
 	}
 
 	// Check reply code
 	if resp[1] != ReplySuccess {
-		return fmt.Errorf("SOCKS5 connection failed: %s", replyCodeString(resp[1]))
+		return netip.AddrPort{}, &ReplyError{Code: resp[1]}
 	}
 
-	// Read and discard bind address (we don't need it)
 	atyp := resp[3]
 	var addrLen int
 	switch atyp {
@@ -255,20 +467,36 @@ func sendConnectRequest(conn net.Conn, targetAddr string) error {
 		// Read domain length first
 		lenBuf := make([]byte, 1)
 		if _, err := io.ReadFull(conn, lenBuf); err != nil {
-			return fmt.Errorf("failed to read domain length: %w", err)
+			return netip.AddrPort{}, fmt.Errorf("failed to read domain length: %w", err)
 		}
 		addrLen = int(lenBuf[0])
 	default:
-		return fmt.Errorf("unsupported address type: %d", atyp)
+		return netip.AddrPort{}, fmt.Errorf("unsupported address type: %d", atyp)
 	}
 
 	// Read bind address and port
 	bindAddrPort := make([]byte, addrLen+2) // address + 2-byte port
 	if _, err := io.ReadFull(conn, bindAddrPort); err != nil {
-		return fmt.Errorf("failed to read bind address and port: %w", err)
+		return netip.AddrPort{}, fmt.Errorf("failed to read bind address and port: %w", err)
 	}
 
-	return nil
+	// Domain names cannot be represented as netip.AddrPort
+	if atyp == AtypDomain {
+		return netip.AddrPort{}, nil
+	}
+
+	addr, _ := netip.AddrFromSlice(bindAddrPort[:addrLen])
+	port := binary.BigEndian.Uint16(bindAddrPort[addrLen:])
+	return netip.AddrPortFrom(addr, port), nil
+}
+
+// ReplyError is returned when the proxy answers a request with a failure reply code
+type ReplyError struct {
+	Code byte
+}
+
+func (e *ReplyError) Error() string {
+	return "SOCKS5 request failed: " + replyCodeString(e.Code)
 }
 
 // replyCodeString returns a human-readable description of SOCKS5 reply codes
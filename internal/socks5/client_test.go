@@ -0,0 +1,284 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// mockProxy 测试用的 SOCKS5 代理，CONNECT 成功时连接请求的目标并转发数据
+type mockProxy struct {
+	ln net.Listener
+
+	// selectMethod 从客户端提供的认证方法中选择一个，为 nil 时选择无需认证（未提供时拒绝）
+	selectMethod func(offered []byte) byte
+	// username、password 选择用户名/密码认证时接受的凭据
+	username, password string
+	// reply CONNECT 的应答码
+	reply byte
+	// bind 成功应答中的绑定地址，无效时为 0.0.0.0:0
+	bind netip.AddrPort
+
+	// targets 收到的 CONNECT 目标
+	targets chan string
+}
+
+// newMockProxy 启动 mockProxy，configure 在开始接受连接前修改其设置
+func newMockProxy(t *testing.T, configure func(*mockProxy)) *mockProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProxy{ln: ln, targets: make(chan string, 16)}
+	if configure != nil {
+		configure(p)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *mockProxy) addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *mockProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	offered := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, offered); err != nil {
+		return
+	}
+
+	method := byte(AuthNoAcceptable)
+	if p.selectMethod != nil {
+		method = p.selectMethod(offered)
+	} else if bytes.IndexByte(offered, AuthNone) >= 0 {
+		method = AuthNone
+	}
+	conn.Write([]byte{Version5, method})
+
+	switch method {
+	case AuthNone:
+	case AuthPassword:
+		if !p.checkPassword(conn) {
+			return
+		}
+	default:
+		return
+	}
+
+	target, err := readMockRequest(conn)
+	if err != nil {
+		return
+	}
+	p.targets <- target
+
+	if p.reply != ReplySuccess {
+		conn.Write([]byte{Version5, p.reply, 0, AtypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", target, 5*time.Second)
+	if err != nil {
+		conn.Write([]byte{Version5, ReplyHostUnreachable, 0, AtypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+
+	reply := []byte{Version5, ReplySuccess, 0}
+	switch {
+	case p.bind.Addr().Is4():
+		reply = append(append(reply, AtypIPv4), p.bind.Addr().AsSlice()...)
+	case p.bind.Addr().Is6():
+		reply = append(append(reply, AtypIPv6), p.bind.Addr().AsSlice()...)
+	default:
+		reply = append(reply, AtypIPv4, 0, 0, 0, 0)
+	}
+	reply = binary.BigEndian.AppendUint16(reply, p.bind.Port())
+	if _, err := conn.Write(reply); err != nil {
+		return
+	}
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+// checkPassword 处理 RFC 1929 认证，凭据不符时应答失败
+func (p *mockProxy) checkPassword(conn net.Conn) bool {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return false
+	}
+	username := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return false
+	}
+	password := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return false
+	}
+	if string(username) != p.username || string(password) != p.password {
+		conn.Write([]byte{0x01, 0x01})
+		return false
+	}
+	conn.Write([]byte{0x01, 0x00})
+	return true
+}
+
+// readMockRequest 读取 SOCKS5 请求，返回 host:port 形式的目标
+func readMockRequest(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	var host string
+	switch header[3] {
+	case AtypIPv4, AtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if header[3] == AtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case AtypDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// startEcho 启动回显服务，返回其地址
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// assertEcho 经 conn 发送数据并检查回显
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	msg := []byte("hello through socks5")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(msg))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("echo = %q, want %q", got, msg)
+	}
+}
+
+func TestDialWithAuthExReturnsBindAddress(t *testing.T) {
+	echo := startEcho(t)
+	tests := []struct {
+		name string
+		bind netip.AddrPort
+	}{
+		{"IPv4", netip.MustParseAddrPort("203.0.113.7:4242")},
+		{"IPv6", netip.MustParseAddrPort("[2001:db8::17]:65000")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newMockProxy(t, func(p *mockProxy) { p.bind = tt.bind })
+
+			conn, bind, err := DialWithAuthEx(proxy.addr(), echo, "", "", 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if bind != tt.bind {
+				t.Fatalf("bind address = %v, want %v", bind, tt.bind)
+			}
+			assertEcho(t, conn)
+		})
+	}
+}
+
+func TestDialWithAuthExReplyError(t *testing.T) {
+	proxy := newMockProxy(t, func(p *mockProxy) { p.reply = ReplyConnectionRefused })
+
+	_, _, err := DialWithAuthEx(proxy.addr(), "example.com:80", "", "", 5*time.Second)
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != ReplyConnectionRefused {
+		t.Fatalf("err = %v, want ReplyError with code %d", err, ReplyConnectionRefused)
+	}
+	if got := <-proxy.targets; got != "example.com:80" {
+		t.Fatalf("proxy received target %q", got)
+	}
+}
+
+func TestReadReplyDomainBindAddress(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		reply := []byte{Version5, ReplySuccess, 0, AtypDomain, 11}
+		reply = append(reply, "example.com"...)
+		server.Write(append(reply, 0x1f, 0x90))
+	}()
+
+	bind, err := readReply(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bind.IsValid() {
+		t.Fatalf("bind address = %v, want zero value for a domain name", bind)
+	}
+}
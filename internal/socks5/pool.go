@@ -0,0 +1,174 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pool keeps authenticated connections to a SOCKS5 proxy warm so that a
+// CONNECT only costs one round trip instead of a TCP dial, method negotiation
+// and authentication.
+//
+// A CONNECT turns the proxy connection into a byte stream to the target, so
+// streams themselves cannot be returned to the pool; only the dial and
+// authentication phase is amortized. Each connection handed out is replaced
+// in the background.
+type Pool struct {
+	proxyAddr   string
+	username    string
+	password    string
+	timeout     time.Duration
+	maxIdle     int
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	idle    []idleConn
+	filling int
+	closed  bool
+}
+
+// idleConn is an authenticated proxy connection waiting for a request
+type idleConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// NewPool creates a pool of at most maxIdle warm connections to proxyAddr.
+// Idle connections older than idleTimeout are discarded (0 means no limit);
+// timeout bounds the dial and each handshake.
+func NewPool(proxyAddr, username, password string, maxIdle int, idleTimeout, timeout time.Duration) *Pool {
+	p := &Pool{
+		proxyAddr:   proxyAddr,
+		username:    username,
+		password:    password,
+		timeout:     timeout,
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+	p.refill()
+	return p
+}
+
+// Dial connects to targetAddr through the proxy, using a warm connection if available
+func (p *Pool) Dial(targetAddr string) (net.Conn, error) {
+	if conn := p.get(); conn != nil {
+		p.refill()
+
+		c, err := p.connect(conn, targetAddr)
+		if err == nil {
+			return c, nil
+		}
+
+		// The proxy rejected the request itself; a fresh connection won't help
+		var replyErr *ReplyError
+		if errors.As(err, &replyErr) {
+			return nil, err
+		}
+		// Otherwise the idle connection was most likely closed by the proxy
+	}
+
+	conn, err := p.dialAuthenticated()
+	if err != nil {
+		return nil, err
+	}
+	return p.connect(conn, targetAddr)
+}
+
+// Close closes all idle connections; subsequent Dials still work but bypass the pool
+func (p *Pool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, ic := range idle {
+		ic.conn.Close()
+	}
+}
+
+// get takes the most recently used idle connection, dropping expired ones
+func (p *Pool) get() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.idle) > 0 {
+		ic := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if p.idleTimeout > 0 && time.Since(ic.since) > p.idleTimeout {
+			ic.conn.Close()
+			continue
+		}
+		return ic.conn
+	}
+	return nil
+}
+
+// refill starts background dials until the pool holds maxIdle connections
+func (p *Pool) refill() {
+	p.mu.Lock()
+	n := p.maxIdle - len(p.idle) - p.filling
+	if p.closed || n <= 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.filling += n
+	p.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		go func() {
+			conn, err := p.dialAuthenticated()
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.filling--
+			if err != nil {
+				return
+			}
+			if p.closed || len(p.idle) >= p.maxIdle {
+				conn.Close()
+				return
+			}
+			p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+		}()
+	}
+}
+
+// dialAuthenticated dials the proxy and completes method negotiation and authentication
+func (p *Pool) dialAuthenticated() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.proxyAddr, p.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS5 proxy: %w", err)
+	}
+
+	if p.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+	}
+
+	if err := negotiateAndAuthenticate(conn, p.username, p.password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect sends CONNECT on an authenticated connection, closing it on failure
+func (p *Pool) connect(conn net.Conn, targetAddr string) (net.Conn, error) {
+	if p.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+	}
+
+	if _, err := sendConnectRequest(conn, targetAddr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
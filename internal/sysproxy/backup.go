@@ -0,0 +1,68 @@
+package sysproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// backupFileName 修改系统代理前保存原设置的文件名
+const backupFileName = "sysproxy-backup.json"
+
+// BackupPath 返回保存原系统代理设置的文件路径，位于用户配置目录下
+// 进程被强制结束时该文件会保留下来，下次启动据此恢复
+func BackupPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user config directory: %w", err)
+	}
+	return filepath.Join(dir, "go-proxy-eins", backupFileName), nil
+}
+
+// SaveBackup 将原系统代理设置写入 path，先写临时文件再重命名，避免崩溃时留下不完整的文件
+func SaveBackup(path string, config *ProxyConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write proxy backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write proxy backup: %w", err)
+	}
+	return nil
+}
+
+// LoadBackup 读取 SaveBackup 保存的设置，文件不存在时返回 nil, nil
+func LoadBackup(path string) (*ProxyConfig, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy backup: %w", err)
+	}
+
+	config := &ProxyConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse proxy backup %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// RemoveBackup 删除备份文件，文件不存在时不报错
+func RemoveBackup(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove proxy backup: %w", err)
+	}
+	return nil
+}
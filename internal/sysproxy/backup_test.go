@@ -0,0 +1,114 @@
+package sysproxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupSaveLoad(t *testing.T) {
+	// 目录不存在时自动创建
+	path := filepath.Join(t.TempDir(), "go-proxy-eins", backupFileName)
+	want := &ProxyConfig{
+		Enabled:       true,
+		Server:        "http=127.0.0.1:8080;socks=127.0.0.1:1080",
+		Override:      "localhost;<local>",
+		AutoConfigURL: "http://127.0.0.1:1081/auto.pac",
+	}
+	if err := SaveBackup(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+
+	got, err := LoadBackup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || *got != *want {
+		t.Fatalf("LoadBackup = %+v, want %+v", got, want)
+	}
+
+	// 再次保存时整体替换
+	if err := SaveBackup(path, &ProxyConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := LoadBackup(path); err != nil || got == nil || *got != (ProxyConfig{}) {
+		t.Fatalf("LoadBackup after overwrite = %+v, %v; want empty settings", got, err)
+	}
+}
+
+func TestBackupRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), backupFileName)
+	if err := SaveBackup(path, &ProxyConfig{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveBackup(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := LoadBackup(path); got != nil || err != nil {
+		t.Fatalf("LoadBackup after remove = %+v, %v; want nil, nil", got, err)
+	}
+
+	// 已不存在时不报错
+	if err := RemoveBackup(path); err != nil {
+		t.Fatalf("RemoveBackup of a missing file: %v", err)
+	}
+}
+
+func TestBackupLeftover(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+	t.Setenv("HOME", dir)
+	path, err := BackupPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(path, dir) || filepath.Base(path) != backupFileName {
+		t.Fatalf("BackupPath = %s, want %s under the user config directory %s", path, backupFileName, dir)
+	}
+
+	// 正常启动时没有备份文件
+	if got, err := LoadBackup(path); got != nil || err != nil {
+		t.Fatalf("LoadBackup before any run = %+v, %v; want nil, nil", got, err)
+	}
+
+	// 上次运行保存了备份但没有正常退出删除，下次启动读到原设置
+	original := &ProxyConfig{Enabled: true, Server: "proxy.example:3128"}
+	if err := SaveBackup(path, original); err != nil {
+		t.Fatal(err)
+	}
+	next, err := BackupPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leftover, err := LoadBackup(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leftover == nil || *leftover != *original {
+		t.Fatalf("leftover backup = %+v, want %+v", leftover, original)
+	}
+}
+
+func TestBackupCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), backupFileName)
+	// 如磁盘写满时只写入了一部分
+	if err := os.WriteFile(path, []byte(`{"Enabled": true, "Ser`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := LoadBackup(path); err == nil {
+		t.Fatalf("LoadBackup of a corrupt file = %+v, want an error", got)
+	}
+
+	// 损坏的文件可以删除，之后视为没有备份
+	if err := RemoveBackup(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := LoadBackup(path); got != nil || err != nil {
+		t.Fatalf("LoadBackup after remove = %+v, %v; want nil, nil", got, err)
+	}
+}
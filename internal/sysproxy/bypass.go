@@ -0,0 +1,189 @@
+package sysproxy
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"go-proxy-eins/internal/logger"
+)
+
+// BypassLocal 表示不带点的主机名（局域网内的短名称），对应 Windows ProxyOverride 的 <local>
+// GNOME 与 KDE 没有对应写法，设置时忽略
+const BypassLocal = "<local>"
+
+// DefaultBypass 未配置 proxy_bypass 时不走系统代理的地址：本机、IPv4 私有网段、
+// IPv6 回环、链路本地 (fe80::/10) 与唯一本地地址 (fc00::/7)
+var DefaultBypass = []string{
+	"localhost",
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1",
+	"fe80::/10",
+	"fc00::/7",
+	BypassLocal,
+}
+
+// Bypass SetProxy 设置的不走代理的地址，为 nil 时使用 DefaultBypass
+var Bypass []string
+
+func bypassEntries() []string {
+	if Bypass == nil {
+		return DefaultBypass
+	}
+	return Bypass
+}
+
+// ValidateBypass 检查 proxy_bypass 的每一项：IP 地址、CIDR、域名（可带 * 通配符）或 <local>
+func ValidateBypass(entries []string) error {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			return fmt.Errorf("invalid proxy_bypass entry: empty")
+		case entry == BypassLocal:
+		case strings.Contains(entry, "/"):
+			if _, err := netip.ParsePrefix(entry); err != nil {
+				return fmt.Errorf("invalid proxy_bypass entry %q: %w", entry, err)
+			}
+		case strings.ContainsAny(entry, " ,;'[]"):
+			return fmt.Errorf("invalid proxy_bypass entry %q", entry)
+		}
+	}
+	return nil
+}
+
+// formatGNOMEBypass 生成 gsettings org.gnome.system.proxy ignore-hosts 的值，如 ['localhost', 'fe80::/10']
+func formatGNOMEBypass(entries []string) string {
+	var items []string
+	for _, entry := range linuxBypass(entries) {
+		items = append(items, "'"+entry+"'")
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// formatKDEBypass 生成 kioslaverc NoProxyFor 的值，逗号分隔
+func formatKDEBypass(entries []string) string {
+	return strings.Join(linuxBypass(entries), ",")
+}
+
+// linuxBypass GNOME 与 KDE 直接支持 IPv4/IPv6 地址和 CIDR，只需去掉 <local>
+func linuxBypass(entries []string) []string {
+	var out []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry == BypassLocal {
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// formatWindowsBypass 生成 ProxyOverride 的值，分号分隔
+// ProxyOverride 只支持 * 通配符，CIDR 按位数展开为通配符：IPv4 以点分段展开 (172.16.0.0/12 为 172.16.* 至 172.31.*)，
+// IPv6 以带方括号的首段十六进制数字展开 (fe80::/10 为 [fe8*] 至 [feb*])；
+// IPv6 前缀超过 16 位或首段以 0 开头时无法用通配符表示，只能写完整地址 (/128)，其它前缀忽略
+func formatWindowsBypass(entries []string) string {
+	var items []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			patterns := windowsPrefixPatterns(prefix.Masked())
+			if patterns == nil {
+				logger.Log.Warn("proxy_bypass entry can't be expressed in ProxyOverride, ignoring", "entry", entry)
+			}
+			items = append(items, patterns...)
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil && addr.Is6() {
+			items = append(items, "["+addr.String()+"]")
+			continue
+		}
+		items = append(items, entry)
+	}
+	return strings.Join(items, ";")
+}
+
+// windowsPrefixPatterns 将 CIDR 展开为 ProxyOverride 通配符
+func windowsPrefixPatterns(prefix netip.Prefix) []string {
+	addr, bits := prefix.Addr(), prefix.Bits()
+	if addr.Is4() {
+		if bits == 32 {
+			return []string{addr.String()}
+		}
+		if bits == 0 {
+			return []string{"*"}
+		}
+		// 完整的字节直接写出，剩余位数所在的字节枚举所有取值
+		octets := addr.As4()
+		full, rest := bits/8, bits%8
+		head := make([]string, 0, full)
+		for _, o := range octets[:full] {
+			head = append(head, fmt.Sprint(o))
+		}
+		if rest == 0 {
+			return []string{strings.Join(head, ".") + ".*"}
+		}
+		var patterns []string
+		base := int(octets[full])
+		for i := 0; i < 1<<(8-rest); i++ {
+			patterns = append(patterns, strings.Join(append(head, fmt.Sprint(base+i)), ".")+".*")
+		}
+		return patterns
+	}
+
+	if bits == 128 {
+		return []string{"[" + addr.String() + "]"}
+	}
+	first := addr.As16()
+	group := uint16(first[0])<<8 | uint16(first[1])
+	if bits == 0 || bits > 16 || group>>12 == 0 {
+		return nil
+	}
+	// 首段的十六进制数字，完整的数字直接写出，剩余位数所在的数字枚举所有取值
+	digits := fmt.Sprintf("%04x", group)
+	full, rest := bits/4, bits%4
+	if rest == 0 {
+		return []string{"[" + digits[:full] + "*]"}
+	}
+	var patterns []string
+	base := int(group>>(12-4*full)) & 0xf
+	for i := 0; i < 1<<(4-rest); i++ {
+		patterns = append(patterns, fmt.Sprintf("[%s%x*]", digits[:full], base+i))
+	}
+	return patterns
+}
+
+// mergeOverride 合并原有的 ProxyOverride 与本程序的排除列表
+// 保留原有条目及其顺序，追加其中没有的条目（不区分大小写去重），<local> 放在最后
+// 进程异常退出时系统中留下的排除列表仍包含用户自己的条目，再次启动时也不会重复追加
+func mergeOverride(existing, ours string) string {
+	var items []string
+	seen := make(map[string]bool)
+	local := false
+	for _, list := range []string{existing, ours} {
+		for _, item := range strings.Split(list, ";") {
+			item = strings.TrimSpace(item)
+			key := strings.ToLower(item)
+			if item == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if key == BypassLocal {
+				local = true
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+	if local {
+		items = append(items, BypassLocal)
+	}
+	return strings.Join(items, ";")
+}
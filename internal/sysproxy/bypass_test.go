@@ -0,0 +1,117 @@
+package sysproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatWindowsBypassDefault(t *testing.T) {
+	want := "localhost;127.*;10.*;" +
+		"172.16.*;172.17.*;172.18.*;172.19.*;172.20.*;172.21.*;172.22.*;172.23.*;" +
+		"172.24.*;172.25.*;172.26.*;172.27.*;172.28.*;172.29.*;172.30.*;172.31.*;" +
+		"192.168.*;[::1];[fe8*];[fe9*];[fea*];[feb*];[fc*];[fd*];<local>"
+	if got := formatWindowsBypass(DefaultBypass); got != want {
+		t.Fatalf("formatWindowsBypass(DefaultBypass) =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWindowsPrefixPatterns(t *testing.T) {
+	tests := []struct {
+		entry, want string
+	}{
+		{"192.0.2.7/32", "192.0.2.7"},
+		{"0.0.0.0/0", "*"},
+		{"100.64.0.0/10", "100.64.*;100.65.*;100.66.*;100.67.*;100.68.*;100.69.*;100.70.*;100.71.*;" +
+			"100.72.*;100.73.*;100.74.*;100.75.*;100.76.*;100.77.*;100.78.*;100.79.*;" +
+			"100.80.*;100.81.*;100.82.*;100.83.*;100.84.*;100.85.*;100.86.*;100.87.*;" +
+			"100.88.*;100.89.*;100.90.*;100.91.*;100.92.*;100.93.*;100.94.*;100.95.*;" +
+			"100.96.*;100.97.*;100.98.*;100.99.*;100.100.*;100.101.*;100.102.*;100.103.*;" +
+			"100.104.*;100.105.*;100.106.*;100.107.*;100.108.*;100.109.*;100.110.*;100.111.*;" +
+			"100.112.*;100.113.*;100.114.*;100.115.*;100.116.*;100.117.*;100.118.*;100.119.*;" +
+			"100.120.*;100.121.*;100.122.*;100.123.*;100.124.*;100.125.*;100.126.*;100.127.*"},
+		{"10.1.2.3/8", "10.*"}, // 主机位被清零
+		{"2001:db8::1/128", "[2001:db8::1]"},
+		{"2000::/3", "[2*];[3*]"},
+		{"fe80::/16", "[fe80*]"},
+		{"2001:db8::/32", ""}, // 超过 16 位，无法用通配符表示
+		{"::/0", ""},
+		{"::1/127", ""},
+		{"::ffff:0:0/96", ""}, // 首段以 0 开头
+	}
+	for _, tt := range tests {
+		if got := formatWindowsBypass([]string{tt.entry}); got != tt.want {
+			t.Errorf("formatWindowsBypass(%q) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLinuxBypass(t *testing.T) {
+	entries := []string{"localhost", " 10.0.0.0/8 ", "fe80::/10", "::1", BypassLocal, "*.corp.example", ""}
+
+	if got, want := formatGNOMEBypass(entries), "['localhost', '10.0.0.0/8', 'fe80::/10', '::1', '*.corp.example']"; got != want {
+		t.Errorf("formatGNOMEBypass = %s, want %s", got, want)
+	}
+	if got, want := formatKDEBypass(entries), "localhost,10.0.0.0/8,fe80::/10,::1,*.corp.example"; got != want {
+		t.Errorf("formatKDEBypass = %s, want %s", got, want)
+	}
+
+	// 默认列表在两种桌面上都包含 IPv6 回环、链路本地与 ULA
+	for _, v6 := range []string{"'::1'", "'fe80::/10'", "'fc00::/7'"} {
+		if !strings.Contains(formatGNOMEBypass(DefaultBypass), v6) {
+			t.Errorf("default GNOME ignore-hosts lacks %s", v6)
+		}
+	}
+}
+
+func TestValidateBypass(t *testing.T) {
+	if err := ValidateBypass([]string{"localhost", "*.example.com", "192.168.0.0/16", "fc00::/7", "::1", BypassLocal}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"", " ", "10.0.0.0/33", "fe80::/129", "a b", "a;b", "a,b", "'quoted'", "[::1]"} {
+		if err := ValidateBypass([]string{entry}); err == nil {
+			t.Errorf("ValidateBypass(%q) succeeded", entry)
+		}
+	}
+}
+
+func TestMergeOverride(t *testing.T) {
+	tests := []struct {
+		existing, ours, want string
+	}{
+		{"", "localhost;127.*;<local>", "localhost;127.*;<local>"},
+		{"*.corp.example;<local>", "localhost;127.*;<local>", "*.corp.example;localhost;127.*;<local>"},
+		// 上次异常退出时留下的列表已包含我们的条目，不会重复追加
+		{"*.corp.example;LOCALHOST;127.*;<local>", "localhost;127.*;[fe8*];<local>", "*.corp.example;LOCALHOST;127.*;[fe8*];<local>"},
+		{" a ; ;b", "", "a;b"},
+	}
+	for _, tt := range tests {
+		if got := mergeOverride(tt.existing, tt.ours); got != tt.want {
+			t.Errorf("mergeOverride(%q, %q) = %q, want %q", tt.existing, tt.ours, got, tt.want)
+		}
+	}
+}
+
+// TestMergeOverrideAfterCrash 进程异常退出后留下合并过的列表，再次启动时合并结果不变，用户条目不丢失
+func TestMergeOverrideAfterCrash(t *testing.T) {
+	user := "*.corp.example;10.*;intranet"
+	ours := formatWindowsBypass(DefaultBypass)
+
+	first := mergeOverride(user, ours)
+	if !strings.HasPrefix(first, user+";") {
+		t.Fatalf("merged list %q does not keep the user's entries first", first)
+	}
+	if !strings.HasSuffix(first, ";"+BypassLocal) {
+		t.Fatalf("merged list %q does not end with %s", first, BypassLocal)
+	}
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(first, ";") {
+		if seen[strings.ToLower(item)] {
+			t.Fatalf("merged list %q repeats %q", first, item)
+		}
+		seen[strings.ToLower(item)] = true
+	}
+
+	if again := mergeOverride(first, ours); again != first {
+		t.Fatalf("merging again changed the list:\n%s\n%s", first, again)
+	}
+}
@@ -0,0 +1,9 @@
+package sysproxy
+
+// ProxyConfig 代理配置信息，各平台共用，SaveBackup 以 JSON 保存
+type ProxyConfig struct {
+	Enabled       bool
+	Server        string // Windows 上为 ProxyServer 原值，可能是多协议形式，恢复时原样写回
+	Override      string
+	AutoConfigURL string
+}
@@ -0,0 +1,5 @@
+package sysproxy
+
+// DryRun 为 true 时 SetHTTPProxy、RestoreProxy、DisableProxy 只在日志中记录将要执行的命令或注册表写入，不修改系统设置
+// 读取当前设置（GetCurrentProxy）不受影响
+var DryRun bool
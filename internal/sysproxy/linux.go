@@ -4,17 +4,21 @@ package sysproxy
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"strings"
+
+	"go-proxy-eins/internal/logger"
 )
 
-// ProxyConfig 代理配置信息
-type ProxyConfig struct {
-	Enabled       bool
-	Server        string
-	Override      string
-	AutoConfigURL string
+// run 执行修改系统代理设置的命令，DryRun 时只记录将要执行的命令
+func run(name string, args ...string) error {
+	if DryRun {
+		logger.Log.Info("Dry run: would execute", "command", name, "args", args)
+		return nil
+	}
+	return exec.Command(name, args...).Run()
 }
 
 // detectDesktopEnvironment 检测当前桌面环境
@@ -92,7 +96,7 @@ func getGNOMEProxy() (*ProxyConfig, error) {
 			if portOutput, err := cmd.Output(); err == nil {
 				port := strings.Trim(string(portOutput), "'\n ")
 				if host != "" && port != "" {
-					config.Server = fmt.Sprintf("%s:%s", host, port)
+					config.Server = net.JoinHostPort(host, port)
 				}
 			}
 		}
@@ -159,106 +163,108 @@ func getKDEProxy() (*ProxyConfig, error) {
 
 // SetHTTPProxy 设置系统 HTTP 代理
 func SetHTTPProxy(addr string) error {
+	return SetProxy(Proxies{HTTP: addr, HTTPS: addr})
+}
+
+// SetProxy 按协议设置系统代理，未选择的协议清空代理地址
+func SetProxy(proxies Proxies) error {
+	if proxies == (Proxies{}) {
+		return fmt.Errorf("no proxy protocol selected")
+	}
+
 	de := detectDesktopEnvironment()
-	
+
 	switch de {
 	case "gnome":
-		return setGNOMEProxy(addr)
+		return setGNOMEProxy(proxies)
 	case "kde":
-		return setKDEProxy(addr)
+		return setKDEProxy(proxies)
 	default:
 		// 对于未知环境，返回友好的错误消息
 		return fmt.Errorf("automatic proxy configuration is not supported on this desktop environment.\n" +
-			"Please manually configure your system/browser to use HTTP proxy: %s", addr)
+			"Please manually configure your system/browser to use proxy: %s", FormatProxyServer(proxies))
 	}
 }
 
 // setGNOMEProxy 设置 GNOME 代理
-func setGNOMEProxy(addr string) error {
+func setGNOMEProxy(proxies Proxies) error {
 	// 检查 gsettings 是否可用
 	if _, err := exec.LookPath("gsettings"); err != nil {
-		return fmt.Errorf("gsettings not found. Please manually configure your proxy to: %s", addr)
-	}
-	
-	// 解析地址
-	parts := strings.Split(addr, ":")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid proxy address format: %s", addr)
+		return fmt.Errorf("gsettings not found. Please manually configure your proxy to: %s", FormatProxyServer(proxies))
 	}
-	host := parts[0]
-	port := parts[1]
-	
+
 	// 设置代理模式为手动
-	cmd := exec.Command("gsettings", "set", "org.gnome.system.proxy", "mode", "manual")
-	if err := cmd.Run(); err != nil {
+	if err := run("gsettings", "set", "org.gnome.system.proxy", "mode", "manual"); err != nil {
 		return fmt.Errorf("failed to set proxy mode: %w", err)
 	}
-	
-	// 设置 HTTP 代理主机
-	cmd = exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "host", host)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set proxy host: %w", err)
-	}
-	
-	// 设置 HTTP 代理端口
-	cmd = exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "port", port)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set proxy port: %w", err)
-	}
-	
-	// 同时设置 HTTPS 代理
-	cmd = exec.Command("gsettings", "set", "org.gnome.system.proxy.https", "host", host)
-	cmd.Run()
-	cmd = exec.Command("gsettings", "set", "org.gnome.system.proxy.https", "port", port)
-	cmd.Run()
-	
-	// 设置忽略列表（本地地址不走代理）
-	ignoreHosts := "['localhost', '127.0.0.0/8', '10.0.0.0/8', '172.16.0.0/12', '192.168.0.0/16']"
-	cmd = exec.Command("gsettings", "set", "org.gnome.system.proxy", "ignore-hosts", ignoreHosts)
-	if err := cmd.Run(); err != nil {
-		// 忽略此错误，不是致命的
+
+	// 设置各协议的代理主机与端口，主机为空表示该协议不使用代理
+	for _, item := range []struct{ schema, addr string }{
+		{"org.gnome.system.proxy.http", proxies.HTTP},
+		{"org.gnome.system.proxy.https", proxies.HTTPS},
+		{"org.gnome.system.proxy.socks", proxies.SOCKS},
+	} {
+		host, port := "", "0"
+		if item.addr != "" {
+			var err error
+			host, port, err = net.SplitHostPort(item.addr)
+			if err != nil {
+				return fmt.Errorf("invalid proxy address format: %s", item.addr)
+			}
+		}
+		if err := run("gsettings", "set", item.schema, "host", host); err != nil {
+			return fmt.Errorf("failed to set proxy host: %w", err)
+		}
+		if err := run("gsettings", "set", item.schema, "port", port); err != nil {
+			return fmt.Errorf("failed to set proxy port: %w", err)
+		}
 	}
-	
+
+	// 设置忽略列表（本地地址不走代理），失败不是致命的
+	run("gsettings", "set", "org.gnome.system.proxy", "ignore-hosts", formatGNOMEBypass(bypassEntries()))
+
 	return nil
 }
 
 // setKDEProxy 设置 KDE 代理
-func setKDEProxy(addr string) error {
+func setKDEProxy(proxies Proxies) error {
 	// 检查 kwriteconfig5 是否可用
 	kwriteconfig := "kwriteconfig5"
 	if _, err := exec.LookPath(kwriteconfig); err != nil {
 		// 尝试 kwriteconfig (旧版本)
 		if _, err := exec.LookPath("kwriteconfig"); err != nil {
-			return fmt.Errorf("kwriteconfig not found. Please manually configure your proxy to: %s", addr)
+			return fmt.Errorf("kwriteconfig not found. Please manually configure your proxy to: %s", FormatProxyServer(proxies))
 		}
 		kwriteconfig = "kwriteconfig"
 	}
-	
+
 	// 设置代理类型为手动 (1)
-	cmd := exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "1")
-	if err := cmd.Run(); err != nil {
+	if err := run(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "1"); err != nil {
 		return fmt.Errorf("failed to set proxy type: %w", err)
 	}
-	
-	// 设置 HTTP 代理
-	cmd = exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "httpProxy", "http://"+addr)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set HTTP proxy: %w", err)
+
+	// 设置各协议的代理，值为空表示该协议不使用代理
+	for _, item := range []struct{ key, scheme, addr string }{
+		{"httpProxy", "http://", proxies.HTTP},
+		{"httpsProxy", "http://", proxies.HTTPS},
+		{"socksProxy", "socks://", proxies.SOCKS},
+	} {
+		value := ""
+		if item.addr != "" {
+			value = item.scheme + item.addr
+		}
+		if err := run(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", item.key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", item.key, err)
+		}
 	}
-	
-	// 设置 HTTPS 代理
-	cmd = exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "httpsProxy", "http://"+addr)
-	cmd.Run()
-	
+
 	// 设置忽略列表
-	cmd = exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "NoProxyFor", 
-		"localhost,127.0.0.1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16")
-	cmd.Run()
-	
+	run(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "NoProxyFor",
+		formatKDEBypass(bypassEntries()))
+
 	// 通知 KDE 重新加载配置
-	cmd = exec.Command("dbus-send", "--type=signal", "/KIO/Scheduler", "org.kde.KIO.Scheduler.reparseSlaveConfiguration", "string:''")
-	cmd.Run()
-	
+	run("dbus-send", "--type=signal", "/KIO/Scheduler", "org.kde.KIO.Scheduler.reparseSlaveConfiguration", "string:''")
+
 	return nil
 }
 
@@ -288,8 +294,7 @@ func restoreGNOMEProxy(config *ProxyConfig) error {
 	
 	if !config.Enabled {
 		// 禁用代理
-		cmd := exec.Command("gsettings", "set", "org.gnome.system.proxy", "mode", "none")
-		return cmd.Run()
+		return run("gsettings", "set", "org.gnome.system.proxy", "mode", "none")
 	}
 	
 	// 恢复手动代理
@@ -299,14 +304,11 @@ func restoreGNOMEProxy(config *ProxyConfig) error {
 			host := parts[0]
 			port := parts[1]
 			
-			cmd := exec.Command("gsettings", "set", "org.gnome.system.proxy", "mode", "manual")
-			cmd.Run()
+			run("gsettings", "set", "org.gnome.system.proxy", "mode", "manual")
 			
-			cmd = exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "host", host)
-			cmd.Run()
+			run("gsettings", "set", "org.gnome.system.proxy.http", "host", host)
 			
-			cmd = exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "port", port)
-			cmd.Run()
+			run("gsettings", "set", "org.gnome.system.proxy.http", "port", port)
 		}
 	}
 	
@@ -325,17 +327,14 @@ func restoreKDEProxy(config *ProxyConfig) error {
 	
 	if !config.Enabled {
 		// 禁用代理 (ProxyType=0)
-		cmd := exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "0")
-		return cmd.Run()
+		return run(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "0")
 	}
 	
 	// 恢复手动代理
 	if config.Server != "" {
-		cmd := exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "1")
-		cmd.Run()
+		run(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "1")
 		
-		cmd = exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "httpProxy", config.Server)
-		cmd.Run()
+		run(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "httpProxy", config.Server)
 	}
 	
 	return nil
@@ -350,8 +349,7 @@ func DisableProxy() error {
 		if _, err := exec.LookPath("gsettings"); err != nil {
 			return nil
 		}
-		cmd := exec.Command("gsettings", "set", "org.gnome.system.proxy", "mode", "none")
-		return cmd.Run()
+		return run("gsettings", "set", "org.gnome.system.proxy", "mode", "none")
 		
 	case "kde":
 		kwriteconfig := "kwriteconfig5"
@@ -361,8 +359,7 @@ func DisableProxy() error {
 			}
 			kwriteconfig = "kwriteconfig"
 		}
-		cmd := exec.Command(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "0")
-		return cmd.Run()
+		return run(kwriteconfig, "--file", "kioslaverc", "--group", "Proxy Settings", "--key", "ProxyType", "0")
 		
 	default:
 		return nil
@@ -0,0 +1,76 @@
+package sysproxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 系统代理可以设置的协议，用于 system_proxy 配置
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	ProtocolSOCKS = "socks"
+)
+
+// Proxies 各协议使用的代理地址（host:port），为空表示该协议不使用代理
+type Proxies struct {
+	HTTP  string
+	HTTPS string
+	SOCKS string
+}
+
+// ParseProxyServer 解析 Windows ProxyServer 注册表值
+// 支持单个地址 "host:port"（HTTP 与 HTTPS 共用）和 "http=host:port;https=host:port;socks=host:port" 形式，
+// 后者中的 ftp 等其它协议会被忽略
+func ParseProxyServer(s string) (Proxies, error) {
+	var p Proxies
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return p, nil
+	}
+	if !strings.Contains(s, "=") {
+		p.HTTP, p.HTTPS = s, s
+		return p, nil
+	}
+
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		protocol, addr, ok := strings.Cut(item, "=")
+		addr = strings.TrimSpace(addr)
+		if !ok || addr == "" {
+			return Proxies{}, fmt.Errorf("invalid proxy server entry %q", item)
+		}
+		switch strings.ToLower(strings.TrimSpace(protocol)) {
+		case ProtocolHTTP:
+			p.HTTP = addr
+		case ProtocolHTTPS:
+			p.HTTPS = addr
+		case ProtocolSOCKS:
+			p.SOCKS = addr
+		}
+	}
+	return p, nil
+}
+
+// FormatProxyServer 生成 Windows ProxyServer 注册表值
+// 只有 HTTP 与 HTTPS 且地址相同时使用单个地址的形式，与只设置 HTTP 代理时的旧格式一致
+func FormatProxyServer(p Proxies) string {
+	if p.HTTP != "" && p.HTTP == p.HTTPS && p.SOCKS == "" {
+		return p.HTTP
+	}
+
+	var items []string
+	if p.HTTP != "" {
+		items = append(items, ProtocolHTTP+"="+p.HTTP)
+	}
+	if p.HTTPS != "" {
+		items = append(items, ProtocolHTTPS+"="+p.HTTPS)
+	}
+	if p.SOCKS != "" {
+		items = append(items, ProtocolSOCKS+"="+p.SOCKS)
+	}
+	return strings.Join(items, ";")
+}
@@ -0,0 +1,65 @@
+package sysproxy
+
+import "testing"
+
+func TestParseProxyServer(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Proxies
+	}{
+		{"", Proxies{}},
+		{"127.0.0.1:8080", Proxies{HTTP: "127.0.0.1:8080", HTTPS: "127.0.0.1:8080"}},
+		{
+			"http=127.0.0.1:8080;https=127.0.0.1:8443;socks=127.0.0.1:1080",
+			Proxies{HTTP: "127.0.0.1:8080", HTTPS: "127.0.0.1:8443", SOCKS: "127.0.0.1:1080"},
+		},
+		{"socks=127.0.0.1:1080", Proxies{SOCKS: "127.0.0.1:1080"}},
+		{" HTTP = proxy:80 ; ftp=proxy:21 ;", Proxies{HTTP: "proxy:80"}},
+		{"http=[::1]:8080;socks=[::1]:1080", Proxies{HTTP: "[::1]:8080", SOCKS: "[::1]:1080"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseProxyServer(tt.in)
+		if err != nil {
+			t.Errorf("ParseProxyServer(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseProxyServer(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseProxyServerInvalid(t *testing.T) {
+	for _, in := range []string{"http=", "http=proxy:80;socks", "socks= "} {
+		if got, err := ParseProxyServer(in); err == nil {
+			t.Errorf("ParseProxyServer(%q) = %+v, want error", in, got)
+		}
+	}
+}
+
+func TestFormatProxyServer(t *testing.T) {
+	tests := []struct {
+		in   Proxies
+		want string
+	}{
+		{Proxies{}, ""},
+		{Proxies{HTTP: "127.0.0.1:8080", HTTPS: "127.0.0.1:8080"}, "127.0.0.1:8080"},
+		{Proxies{HTTP: "127.0.0.1:8080"}, "http=127.0.0.1:8080"},
+		{
+			Proxies{HTTP: "127.0.0.1:8080", HTTPS: "127.0.0.1:8080", SOCKS: "127.0.0.1:1080"},
+			"http=127.0.0.1:8080;https=127.0.0.1:8080;socks=127.0.0.1:1080",
+		},
+		{Proxies{SOCKS: "127.0.0.1:1080"}, "socks=127.0.0.1:1080"},
+	}
+	for _, tt := range tests {
+		got := FormatProxyServer(tt.in)
+		if got != tt.want {
+			t.Errorf("FormatProxyServer(%+v) = %q, want %q", tt.in, got, tt.want)
+		}
+		// 备份与恢复依赖格式化后能解析回同样的设置
+		back, err := ParseProxyServer(got)
+		if err != nil || back != tt.in {
+			t.Errorf("ParseProxyServer(%q) = %+v, %v, want %+v", got, back, err, tt.in)
+		}
+	}
+}
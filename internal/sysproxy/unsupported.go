@@ -0,0 +1,36 @@
+//go:build !linux && !windows
+
+package sysproxy
+
+import (
+	"errors"
+	"runtime"
+)
+
+// errUnsupported 当前平台不支持自动设置系统代理，需要手动配置或使用 PAC
+var errUnsupported = errors.New("system proxy configuration is not supported on " + runtime.GOOS)
+
+// GetCurrentProxy 获取当前系统代理设置
+func GetCurrentProxy() (*ProxyConfig, error) {
+	return nil, errUnsupported
+}
+
+// SetHTTPProxy 设置 HTTP 与 HTTPS 代理
+func SetHTTPProxy(addr string) error {
+	return SetProxy(Proxies{HTTP: addr, HTTPS: addr})
+}
+
+// SetProxy 按协议设置系统代理
+func SetProxy(proxies Proxies) error {
+	return errUnsupported
+}
+
+// RestoreProxy 恢复代理设置
+func RestoreProxy(config *ProxyConfig) error {
+	return errUnsupported
+}
+
+// DisableProxy 禁用系统代理
+func DisableProxy() error {
+	return errUnsupported
+}
@@ -4,6 +4,8 @@ package sysproxy
 
 import (
 	"fmt"
+
+	"go-proxy-eins/internal/logger"
 	"golang.org/x/sys/windows/registry"
 )
 
@@ -11,14 +13,6 @@ const (
 	internetSettingsPath = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
 )
 
-// ProxyConfig 代理配置信息
-type ProxyConfig struct {
-	Enabled       bool
-	Server        string
-	Override      string
-	AutoConfigURL string
-}
-
 // GetCurrentProxy 获取当前系统代理设置
 func GetCurrentProxy() (*ProxyConfig, error) {
 	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsPath, registry.QUERY_VALUE)
@@ -54,9 +48,19 @@ func GetCurrentProxy() (*ProxyConfig, error) {
 
 // SetHTTPProxy 设置系统 HTTP 代理
 func SetHTTPProxy(addr string) error {
-	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsPath, registry.SET_VALUE)
+	return SetProxy(Proxies{HTTP: addr, HTTPS: addr})
+}
+
+// SetProxy 按协议设置系统代理，ProxyServer 写为 "http=...;https=...;socks=..." 形式
+func SetProxy(proxies Proxies) error {
+	server := FormatProxyServer(proxies)
+	if server == "" {
+		return fmt.Errorf("no proxy protocol selected")
+	}
+
+	key, err := openSettingsKey()
 	if err != nil {
-		return fmt.Errorf("failed to open registry key: %w", err)
+		return err
 	}
 	defer key.Close()
 
@@ -66,12 +70,15 @@ func SetHTTPProxy(addr string) error {
 	}
 
 	// 设置代理服务器地址
-	if err := key.SetStringValue("ProxyServer", addr); err != nil {
+	if err := key.SetStringValue("ProxyServer", server); err != nil {
 		return fmt.Errorf("failed to set ProxyServer: %w", err)
 	}
 
-	// 设置代理排除列表（本地地址不走代理）
-	override := "localhost;127.*;10.*;172.16.*;172.17.*;172.18.*;172.19.*;172.20.*;172.21.*;172.22.*;172.23.*;172.24.*;172.25.*;172.26.*;172.27.*;172.28.*;172.29.*;172.30.*;172.31.*;192.168.*;<local>"
+	// 设置代理排除列表（本地地址不走代理），保留用户原有的条目
+	override := formatWindowsBypass(bypassEntries())
+	if current, err := GetCurrentProxy(); err == nil {
+		override = mergeOverride(current.Override, override)
+	}
 	if err := key.SetStringValue("ProxyOverride", override); err != nil {
 		return fmt.Errorf("failed to set ProxyOverride: %w", err)
 	}
@@ -86,9 +93,9 @@ func SetHTTPProxy(addr string) error {
 
 // RestoreProxy 恢复代理设置
 func RestoreProxy(config *ProxyConfig) error {
-	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsPath, registry.SET_VALUE)
+	key, err := openSettingsKey()
 	if err != nil {
-		return fmt.Errorf("failed to open registry key: %w", err)
+		return err
 	}
 	defer key.Close()
 
@@ -139,9 +146,9 @@ func RestoreProxy(config *ProxyConfig) error {
 
 // DisableProxy 禁用系统代理
 func DisableProxy() error {
-	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsPath, registry.SET_VALUE)
+	key, err := openSettingsKey()
 	if err != nil {
-		return fmt.Errorf("failed to open registry key: %w", err)
+		return err
 	}
 	defer key.Close()
 
@@ -158,8 +165,60 @@ func DisableProxy() error {
 	return nil
 }
 
+// settingsKey 以写权限打开的 Internet Settings 注册表键，DryRun 时只记录将要进行的写入
+type settingsKey struct {
+	key registry.Key
+}
+
+// openSettingsKey 打开 Internet Settings 注册表键用于写入，DryRun 时不打开
+func openSettingsKey() (*settingsKey, error) {
+	if DryRun {
+		return &settingsKey{}, nil
+	}
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsPath, registry.SET_VALUE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry key: %w", err)
+	}
+	return &settingsKey{key: key}, nil
+}
+
+func (k *settingsKey) SetDWordValue(name string, value uint32) error {
+	if DryRun {
+		logger.Log.Info("Dry run: would set registry value", "key", `HKCU\`+internetSettingsPath, "name", name, "value", value)
+		return nil
+	}
+	return k.key.SetDWordValue(name, value)
+}
+
+func (k *settingsKey) SetStringValue(name, value string) error {
+	if DryRun {
+		logger.Log.Info("Dry run: would set registry value", "key", `HKCU\`+internetSettingsPath, "name", name, "value", value)
+		return nil
+	}
+	return k.key.SetStringValue(name, value)
+}
+
+func (k *settingsKey) DeleteValue(name string) error {
+	if DryRun {
+		logger.Log.Info("Dry run: would delete registry value", "key", `HKCU\`+internetSettingsPath, "name", name)
+		return nil
+	}
+	return k.key.DeleteValue(name)
+}
+
+func (k *settingsKey) Close() error {
+	if DryRun {
+		return nil
+	}
+	return k.key.Close()
+}
+
 // notifyProxyChange 通知系统代理设置已更改
 func notifyProxyChange() error {
+	if DryRun {
+		logger.Log.Info("Dry run: would notify WinINet of the proxy change")
+		return nil
+	}
 	// 使用 Windows API 通知系统刷新 Internet 设置
 	// 这会让浏览器和其他应用程序立即感知代理更改
 	return notifyWinInetProxyChange()
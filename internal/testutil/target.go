@@ -0,0 +1,137 @@
+// Package testutil 提供运行在回环地址上的测试目标服务：回显、吸收与数据源
+// 用于端到端测试和吞吐量测量，不依赖外部网络
+package testutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+)
+
+// Target 在 127.0.0.1 随机端口上运行的测试目标服务，每个连接由独立的 goroutine 处理
+type Target struct {
+	listener net.Listener
+	handle   func(net.Conn)
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Echo 回显：收到的数据原样写回，直到对端半关闭
+func Echo(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+// Sink 吸收：读取并丢弃收到的数据，对端半关闭后回写收到的字节数（8 字节大端）
+// 发送方据此确认数据完整到达目标，而不只是写入了本地缓冲区
+func Sink(conn net.Conn) {
+	n, _ := io.Copy(io.Discard, conn)
+	binary.Write(conn, binary.BigEndian, uint64(n))
+}
+
+// Source 数据源：发送 size 字节 NewGenerator 生成的数据
+// 数据不可压缩，开启 compress 时测得的也是实际的加密与转发速度
+func Source(size int64) func(net.Conn) {
+	return func(conn net.Conn) {
+		io.Copy(conn, io.LimitReader(NewGenerator(), size))
+	}
+}
+
+// NewEcho 启动 Echo 服务
+func NewEcho() (*Target, error) {
+	return Start(Echo)
+}
+
+// NewSink 启动 Sink 服务
+func NewSink() (*Target, error) {
+	return Start(Sink)
+}
+
+// NewSource 启动 Source 服务，每个连接发送 size 字节后关闭
+func NewSource(size int64) (*Target, error) {
+	return Start(Source(size))
+}
+
+// Serve 在已有的监听器上用 handle 处理每个连接，处理结束后关闭连接；监听器关闭时返回
+func Serve(listener net.Listener, handle func(net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// Start 在 127.0.0.1 随机端口上启动用 handle 处理连接的服务
+func Start(handle func(net.Conn)) (*Target, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for test target: %w", err)
+	}
+	t := &Target{listener: listener, handle: handle, conns: make(map[net.Conn]struct{})}
+	t.wg.Add(1)
+	go t.serve()
+	return t, nil
+}
+
+// Addr 返回服务监听的 host:port
+func (t *Target) Addr() string {
+	return t.listener.Addr().String()
+}
+
+// Close 停止接受连接，关闭所有进行中的连接并等待处理结束
+func (t *Target) Close() error {
+	err := t.listener.Close()
+	t.mu.Lock()
+	t.closed = true
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+	return err
+}
+
+func (t *Target) serve() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.conns[conn] = struct{}{}
+		t.wg.Add(1)
+		t.mu.Unlock()
+
+		go func() {
+			defer t.wg.Done()
+			defer func() {
+				t.mu.Lock()
+				delete(t.conns, conn)
+				t.mu.Unlock()
+				conn.Close()
+			}()
+			t.handle(conn)
+		}()
+	}
+}
+
+// NewGenerator 返回无限长的伪随机数据流，种子固定，每次生成的数据相同
+func NewGenerator() io.Reader {
+	return rand.NewChaCha8([32]byte{})
+}
@@ -0,0 +1,23 @@
+// Package version 保存构建信息，通过 -ldflags -X 在编译时注入：
+//
+//	go build -ldflags "-X go-proxy-eins/internal/version.Version=v1.2.0 \
+//	  -X go-proxy-eins/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X go-proxy-eins/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// String 返回包含版本、提交、构建时间与运行时信息的描述
+func String(name string) string {
+	return fmt.Sprintf("%s %s (commit %s, built %s, %s %s/%s)",
+		name, Version, Commit, Date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go-proxy-eins/internal/conntrack"
+	"go-proxy-eins/internal/logger"
+)
+
+// startAdmin 启动管理接口：GET /connections 列出正在转发的隧道，DELETE /connections/{id} 关闭一条隧道，
+// GET /destinations 按目标主机汇总的流量排行，GET /stats 累计统计
+// 接口没有认证，关闭返回的监听器即停止服务
+func startAdmin(addr string, conns *conntrack.Registry) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on admin address %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	conntrack.Register(mux, conns)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	logger.Log.Info("Admin endpoint is running", "address", listener.Addr())
+	go server.Serve(listener)
+	return listener, nil
+}
+
+// AdminAddr 返回管理接口的实际监听地址，未启用时为 nil
+func (s *Server) AdminAddr() net.Addr {
+	if s.adminListener == nil {
+		return nil
+	}
+	return s.adminListener.Addr()
+}
+
+// stopAdmin 关闭管理接口，可重复调用
+func (s *Server) stopAdmin() {
+	if s.adminListener != nil {
+		s.adminListener.Close()
+	}
+}
+
+// AdminAddr 返回管理接口的实际监听地址，未启用时为 nil
+func (p *LocalProxy) AdminAddr() net.Addr {
+	if p.adminListener == nil {
+		return nil
+	}
+	return p.adminListener.Addr()
+}
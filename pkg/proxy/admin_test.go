@@ -0,0 +1,106 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/conntrack"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// listConnections 通过管理接口获取正在转发的隧道
+func listConnections(t *testing.T, admin net.Addr) []conntrack.Info {
+	t.Helper()
+	resp, err := http.Get("http://" + admin.String() + "/connections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /connections: status %d", resp.StatusCode)
+	}
+	var infos []conntrack.Info
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		t.Fatal(err)
+	}
+	return infos
+}
+
+// killConnection 通过管理接口关闭一条隧道，返回状态码
+func killConnection(t *testing.T, admin net.Addr, id string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodDelete, "http://"+admin.String()+"/connections/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// 用 -race 运行：列出与关闭隧道时转发仍在进行
+func TestAdminListAndKillConnection(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.AdminAddr = "127.0.0.1:0"
+		local.AdminAddr = "127.0.0.1:0"
+	}))
+
+	conn, err := h.DialTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	assertConnEcho(t, conn)
+
+	for name, admin := range map[string]net.Addr{"local": h.Local.AdminAddr(), "server": h.Server.AdminAddr()} {
+		infos := listConnections(t, admin)
+		if len(infos) != 1 {
+			t.Fatalf("%s lists %d connections, want 1: %+v", name, len(infos), infos)
+		}
+		if infos[0].ID == "" || infos[0].Target != h.TargetAddr() {
+			t.Fatalf("%s lists %+v, want the tunnel to %s", name, infos[0], h.TargetAddr())
+		}
+		if infos[0].BytesUp == 0 || infos[0].BytesDown == 0 {
+			t.Fatalf("%s reports no traffic for an active tunnel: %+v", name, infos[0])
+		}
+	}
+
+	// 关闭隧道会中断阻塞在读取上的转发，客户端连接随之关闭
+	id := listConnections(t, h.Local.AdminAddr())[0].ID
+	if code := killConnection(t, h.Local.AdminAddr(), id); code != http.StatusNoContent {
+		t.Fatalf("DELETE /connections/%s: status %d", id, code)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read succeeded on a killed tunnel")
+	} else if isTimeout(err) {
+		t.Fatalf("killed tunnel was not closed: %v", err)
+	}
+
+	for name, admin := range map[string]net.Addr{"local": h.Local.AdminAddr(), "server": h.Server.AdminAddr()} {
+		eventually(t, func() error {
+			if infos := listConnections(t, admin); len(infos) != 0 {
+				return fmt.Errorf("%s still lists %d connections", name, len(infos))
+			}
+			return nil
+		})
+	}
+	if code := killConnection(t, h.Local.AdminAddr(), id); code != http.StatusNotFound {
+		t.Fatalf("DELETE of a closed connection: status %d, want 404", code)
+	}
+}
+
+// isTimeout 判断 err 是否为读写超时
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
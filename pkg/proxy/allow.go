@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"go-proxy-eins/internal/logger"
+)
+
+// loopbackOnly 未配置 client_allow 且监听在非回环地址时允许的客户端来源
+var loopbackOnly = clientFilter{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+// clientFilter 允许连接本地代理入口的客户端地址段，nil 表示不限制
+type clientFilter []netip.Prefix
+
+// parseClientAllow 解析 client_allow，每项为 IPv4/IPv6 地址或 CIDR
+func parseClientAllow(entries []string) (clientFilter, error) {
+	var filter clientFilter
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client_allow entry %q: %w", entry, err)
+			}
+			filter = append(filter, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client_allow entry %q: must be an IP address or CIDR", entry)
+		}
+		filter = append(filter, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return filter, nil
+}
+
+// clientFilterFor 返回监听器使用的来源过滤
+// 配置了 client_allow 时所有 TCP 入口都使用它；否则监听在非回环地址的入口只允许本机连接
+// Unix 套接字由文件权限控制访问，不过滤
+func (p *LocalProxy) clientFilterFor(listener net.Listener, kind string, allow clientFilter) clientFilter {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if allow != nil {
+		return allow
+	}
+	if addr.IP.IsLoopback() {
+		return nil
+	}
+	logger.Log.Warn(kind+" listener is on a non-loopback address but client_allow is not set, accepting loopback clients only",
+		"address", addr)
+	return loopbackOnly
+}
+
+// allows 判断来源地址是否允许连接，非 TCP 地址总是允许
+func (f clientFilter) allows(remote net.Addr) bool {
+	if f == nil {
+		return true
+	}
+	tcp, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	addr := tcp.AddrPort().Addr().Unmap()
+	for _, prefix := range f {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFilteredLocal 在 listen 上启动只开 SOCKS5 入口的本地代理，服务器不可达（只测试接受阶段）
+func startFilteredLocal(t *testing.T, listen string, allow []string) *LocalProxy {
+	t.Helper()
+	p := NewLocalProxy(&LocalConfig{
+		LocalAddr:   []string{listen},
+		Server:      "127.0.0.1:1",
+		Password:    "test",
+		ClientAllow: allow,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.Start(ctx); err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		p.Close()
+	})
+	return p
+}
+
+// socksGreetingFrom 从 source 连接 addr 并发送 SOCKS5 方法协商，返回是否收到应答
+// 被过滤的连接在协商前关闭，读取返回 EOF 或连接重置
+func socksGreetingFrom(t *testing.T, source, addr string) bool {
+	t.Helper()
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(source)}, Timeout: 5 * time.Second}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	return err == nil
+}
+
+func TestClientAllow(t *testing.T) {
+	// 127.0.0.0/8 都是回环地址，用不同的源地址模拟不同的客户端
+	tests := []struct {
+		name   string
+		listen string
+		allow  []string
+		source string
+		want   bool
+	}{
+		{"listed address", "127.0.0.1:0", []string{"127.0.0.1"}, "127.0.0.1", true},
+		{"unlisted address", "127.0.0.1:0", []string{"127.0.0.1"}, "127.0.0.2", false},
+		{"inside CIDR", "127.0.0.1:0", []string{"127.0.0.0/30"}, "127.0.0.3", true},
+		{"outside CIDR", "127.0.0.1:0", []string{"127.0.0.0/30"}, "127.0.0.4", false},
+		{"loopback listener without client_allow", "127.0.0.1:0", nil, "127.0.0.2", true},
+		{"any-address listener without client_allow", "0.0.0.0:0", nil, "127.0.0.2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := startFilteredLocal(t, tt.listen, tt.allow)
+			_, port, _ := net.SplitHostPort(p.SOCKS5Addr().String())
+			if got := socksGreetingFrom(t, tt.source, net.JoinHostPort("127.0.0.1", port)); got != tt.want {
+				t.Fatalf("client %s answered = %v, want %v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientFilterFor(t *testing.T) {
+	p := NewLocalProxy(&LocalConfig{})
+	listen := func(addr string) net.Listener {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+	lan := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 50000}
+
+	// 非回环监听且未配置 client_allow 时只允许本机
+	if f := p.clientFilterFor(listen("0.0.0.0:0"), "SOCKS5", nil); f.allows(lan) || !f.allows(&net.TCPAddr{IP: net.IPv6loopback}) {
+		t.Fatalf("default filter on 0.0.0.0 = %v, want loopback only", f)
+	}
+	if f := p.clientFilterFor(listen("127.0.0.1:0"), "SOCKS5", nil); f != nil {
+		t.Fatalf("filter on a loopback listener = %v, want none", f)
+	}
+
+	allow, err := parseClientAllow([]string{" 192.168.1.0/24", "::ffff:10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := p.clientFilterFor(listen("127.0.0.1:0"), "SOCKS5", allow)
+	if !f.allows(lan) || !f.allows(&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}) {
+		t.Fatalf("filter %v rejects listed clients", f)
+	}
+	if f.allows(&net.TCPAddr{IP: net.ParseIP("192.168.2.1")}) {
+		t.Fatalf("filter %v allows an unlisted client", f)
+	}
+
+	for _, entry := range []string{"localhost", "10.0.0.0/33", "10.0.0.1/"} {
+		if _, err := parseClientAllow([]string{entry}); err == nil {
+			t.Errorf("parseClientAllow(%q) succeeded", entry)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"go-proxy-eins/internal/testutil"
+)
+
+// benchmarkPassword 未配置密码时本机吞吐量测量使用的密码
+const benchmarkPassword = "go-proxy-eins benchmark"
+
+// BenchmarkResult 本机回环隧道的吞吐量测量结果
+type BenchmarkResult struct {
+	Size     int64         // 每个方向传输的字节数
+	Upload   time.Duration // 经隧道发送到目标的耗时
+	Download time.Duration // 经隧道从目标接收的耗时
+}
+
+// UploadRate 上传速度（MB/s）
+func (r *BenchmarkResult) UploadRate() float64 {
+	return rate(r.Size, r.Upload)
+}
+
+// DownloadRate 下载速度（MB/s）
+func (r *BenchmarkResult) DownloadRate() float64 {
+	return rate(r.Size, r.Download)
+}
+
+func rate(size int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / (1 << 20) / d.Seconds()
+}
+
+// Benchmark 在回环地址上启动使用 cfg 加密、混淆、压缩等隧道设置的服务端，经 Dialer 向
+// testutil.Sink 发送、从 testutil.Source 接收各 size 字节，测量不受外部网络影响的隧道吞吐量
+// 上游代理、端口过滤、带宽限制等与隧道本身无关的设置不生效
+func Benchmark(cfg *ServerConfig, size int64) (*BenchmarkResult, error) {
+	password := cfg.Password
+	if password == "" {
+		password = benchmarkPassword
+	}
+
+	serverCfg := &ServerConfig{
+		ListenAddr:   "127.0.0.1",
+		Password:     password,
+		Timeout:      cfg.Timeout,
+		Obfuscate:    cfg.Obfuscate,
+		Compress:     cfg.Compress,
+		Plaintext:    cfg.Plaintext,
+		Cipher:       cfg.Cipher,
+		RekeyAfter:   cfg.RekeyAfter,
+		TCPKeepAlive: cfg.TCPKeepAlive,
+		TCPNoDelay:   cfg.TCPNoDelay,
+		LowLatency:   cfg.LowLatency,
+	}
+	srv, err := NewServer(serverCfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		return nil, err
+	}
+	defer srv.Close()
+
+	dialer, err := NewDialer(&LocalConfig{
+		Server:       srv.Addr().String(),
+		Password:     password,
+		Timeout:      cfg.Timeout,
+		Obfuscate:    cfg.Obfuscate,
+		Compress:     cfg.Compress,
+		Plaintext:    cfg.Plaintext,
+		Cipher:       cfg.Cipher,
+		RekeyAfter:   cfg.RekeyAfter,
+		TCPKeepAlive: cfg.TCPKeepAlive,
+		TCPNoDelay:   cfg.TCPNoDelay,
+		LowLatency:   cfg.LowLatency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BenchmarkResult{Size: size}
+	if result.Upload, err = benchmarkUpload(dialer, size); err != nil {
+		return nil, fmt.Errorf("upload: %w", err)
+	}
+	if result.Download, err = benchmarkDownload(dialer, size); err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	return result, nil
+}
+
+// benchmarkUpload 向 Sink 发送 size 字节，直到 Sink 确认全部收到
+func benchmarkUpload(dialer *Dialer, size int64) (time.Duration, error) {
+	sink, err := testutil.NewSink()
+	if err != nil {
+		return 0, err
+	}
+	defer sink.Close()
+
+	conn, err := dialer.Dial("tcp", sink.Addr())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := io.Copy(conn, io.LimitReader(testutil.NewGenerator(), size)); err != nil {
+		return 0, err
+	}
+	if err := conn.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		return 0, err
+	}
+	var received uint64
+	if err := binary.Read(conn, binary.BigEndian, &received); err != nil {
+		return 0, fmt.Errorf("failed to read sink count: %w", err)
+	}
+	elapsed := time.Since(start)
+	if received != uint64(size) {
+		return 0, fmt.Errorf("target received %d bytes, sent %d", received, size)
+	}
+	return elapsed, nil
+}
+
+// benchmarkDownload 从 Source 接收 size 字节
+func benchmarkDownload(dialer *Dialer, size int64) (time.Duration, error) {
+	source, err := testutil.NewSource(size)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	conn, err := dialer.Dial("tcp", source.Addr())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, conn)
+	elapsed := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	if n != size {
+		return 0, fmt.Errorf("received %d bytes, expected %d", n, size)
+	}
+	return elapsed, nil
+}
+
+// BenchmarkStep 吞吐量测量报告中一个方向的结果
+type BenchmarkStep struct {
+	MS   float64 `json:"ms"`       // 耗时（毫秒）
+	Rate float64 `json:"mb_per_s"` // 速度（MB/s）
+}
+
+// BenchmarkReport 吞吐量测量结果的 JSON 格式（-selftest -json），供脚本使用
+// 字段名与含义保持稳定，以后只增加字段
+type BenchmarkReport struct {
+	Cipher    string         `json:"cipher"`
+	Obfuscate bool           `json:"obfuscate"`
+	Compress  bool           `json:"compress"`
+	Plaintext bool           `json:"plaintext"`
+	Size      int64          `json:"size"`     // 每个方向传输的字节数
+	Upload    *BenchmarkStep `json:"upload"`   // 测量失败时为 null
+	Download  *BenchmarkStep `json:"download"` // 测量失败时为 null
+	Result    string         `json:"result"`   // ok 或 fail
+	Error     string         `json:"error,omitempty"`
+}
+
+// NewBenchmarkReport 根据 Benchmark 的返回值生成报告
+func NewBenchmarkReport(cfg *ServerConfig, size int64, result *BenchmarkResult, err error) *BenchmarkReport {
+	report := &BenchmarkReport{
+		Cipher:    cfg.GetCipher(),
+		Obfuscate: cfg.Obfuscate,
+		Compress:  cfg.Compress,
+		Plaintext: cfg.Plaintext,
+		Size:      size,
+		Result:    StatusOK,
+	}
+	if err != nil {
+		report.Result = StatusFail
+		report.Error = err.Error()
+		return report
+	}
+	report.Upload = &BenchmarkStep{MS: milliseconds(result.Upload), Rate: result.UploadRate()}
+	report.Download = &BenchmarkStep{MS: milliseconds(result.Download), Rate: result.DownloadRate()}
+	return report
+}
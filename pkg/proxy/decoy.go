@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go-proxy-eins/internal/config"
+)
+
+// decoyLinger 发送诱饵响应后继续读取并丢弃对端数据的最长时间
+// 直接关闭仍有未读数据的连接会发出 RST，对端可能收不到诱饵响应
+const decoyLinger = 2 * time.Second
+
+// decoyHTTPBody 与 nginx 默认 404 页面相同
+const decoyHTTPBody = "<html>\r\n<head><title>404 Not Found</title></head>\r\n<body>\r\n<center><h1>404 Not Found</h1></center>\r\n<hr><center>nginx</center>\r\n</body>\r\n</html>\r\n"
+
+// decoySSHBanner 常见 OpenSSH 版本的标识行
+const decoySSHBanner = "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13.5\r\n"
+
+// decoyResponse 返回握手认证失败时发送的诱饵数据，decoy 为 none 或空时返回 nil
+func decoyResponse(decoy string) []byte {
+	switch decoy {
+	case config.DecoyHTTP:
+		return fmt.Appendf(nil, "HTTP/1.1 404 Not Found\r\n"+
+			"Server: nginx\r\n"+
+			"Date: %s\r\n"+
+			"Content-Type: text/html\r\n"+
+			"Content-Length: %d\r\n"+
+			"Connection: close\r\n"+
+			"\r\n%s",
+			time.Now().UTC().Format(http.TimeFormat), len(decoyHTTPBody), decoyHTTPBody)
+	case config.DecoySSH:
+		return []byte(decoySSHBanner)
+	default:
+		return nil
+	}
+}
+
+// sendDecoy 向未通过认证的连接发送诱饵响应并半关闭，让端口看起来像普通的 Web 或 SSH 服务
+func sendDecoy(conn net.Conn, response []byte) {
+	conn.SetDeadline(time.Now().Add(decoyLinger))
+	if _, err := conn.Write(response); err != nil {
+		return
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,98 @@
+package proxy_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// probe 以扫描器的方式连接服务端：发送 data 后读取服务端返回的全部数据
+func probe(t *testing.T, addr string, data []byte) []byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil && len(got) == 0 {
+		t.Fatalf("read: %v", err)
+	}
+	return got
+}
+
+// wrongPasswordHandshake 返回用错误密码构造的完整握手数据
+func wrongPasswordHandshake(t *testing.T) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	go protocol.ClientHandshake(client, "wrong", protocol.HandshakeOptions{})
+	buf := make([]byte, protocol.HandshakeLen)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	return buf
+}
+
+func TestServerDecoy(t *testing.T) {
+	// 未认证的探测：HTTP 请求的首字节不是支持的协议版本
+	scan := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	t.Run("http", func(t *testing.T) {
+		h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			server.Decoy = "http"
+		}))
+		for name, data := range map[string][]byte{"scan": scan, "wrong password": wrongPasswordHandshake(t)} {
+			got := probe(t, h.Server.Addr().String(), data)
+			resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(string(got))), nil)
+			if err != nil {
+				t.Fatalf("%s: decoy %q is not an HTTP response: %v", name, got, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Server") != "nginx" || !strings.Contains(string(body), "404 Not Found") {
+				t.Fatalf("%s: decoy %d %v %q, want an nginx 404", name, resp.StatusCode, resp.Header, body)
+			}
+		}
+	})
+
+	t.Run("ssh", func(t *testing.T) {
+		h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			server.Decoy = "ssh"
+		}))
+		got := probe(t, h.Server.Addr().String(), wrongPasswordHandshake(t))
+		if !strings.HasPrefix(string(got), "SSH-2.0-") || !strings.HasSuffix(string(got), "\r\n") {
+			t.Fatalf("decoy %q, want an SSH banner", got)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		h := startHarness(t)
+		// 不配置诱饵时只回复 1 字节的认证失败
+		if got := probe(t, h.Server.Addr().String(), wrongPasswordHandshake(t)); string(got) != "\x01" {
+			t.Fatalf("reply %q, want the auth failure byte", got)
+		}
+	})
+
+	t.Run("authenticated client unaffected", func(t *testing.T) {
+		h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			server.Decoy = "http"
+		}))
+		if err := h.RoundTrip([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/protocol"
+)
+
+// startConnect 在后台握手并请求连接 target，返回的通道收到应答状态或错误
+func startConnect(s *Server, target string) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		salt, opts, err := protocol.ClientHandshake(conn, s.cfg.Password, protocol.HandshakeOptions{})
+		if err != nil {
+			done <- err
+			return
+		}
+		r, w, err := protocol.WrapTunnel(conn, conn, s.cfg.Password, salt, opts, cipher.RoleClient)
+		if err != nil {
+			done <- err
+			return
+		}
+		if err := protocol.WriteTarget(w, target); err != nil {
+			done <- err
+			return
+		}
+		status, _, err := protocol.ReadConnectStatus(r)
+		if err == nil && status != protocol.ConnectOK {
+			err = errors.New(protocol.ConnectStatusText(status))
+		}
+		done <- err
+	}()
+	return done
+}
+
+func TestMaxConcurrentHandshakes(t *testing.T) {
+	const slots = 2
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	s, err := NewServer(&ServerConfig{Password: "test", ListenAddr: "127.0.0.1", MaxConcurrentHandshakes: slots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 占满所有密钥派生名额
+	for range slots {
+		if !s.acquireDerive() {
+			t.Fatal("free slot not acquired")
+		}
+	}
+
+	// 下一个握手等待名额，deriveWait 后被拒绝
+	start := time.Now()
+	rejected := startConnect(s, target.Addr().String())
+	select {
+	case err := <-rejected:
+		t.Fatalf("handshake finished while all slots were taken: %v", err)
+	case <-time.After(deriveWait / 2):
+	}
+	select {
+	case err := <-rejected:
+		if err == nil {
+			t.Fatal("handshake succeeded without a free slot")
+		}
+		if elapsed := time.Since(start); elapsed < deriveWait {
+			t.Fatalf("rejected after %v, want at least %v", elapsed, deriveWait)
+		}
+	case <-time.After(deriveWait + 5*time.Second):
+		t.Fatal("waiting handshake was never rejected")
+	}
+
+	// 等待中的握手在名额归还后继续
+	waiting := startConnect(s, target.Addr().String())
+	select {
+	case err := <-waiting:
+		t.Fatalf("handshake finished while all slots were taken: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	s.releaseDerive()
+	select {
+	case err := <-waiting:
+		if err != nil {
+			t.Fatalf("handshake after a slot was released: %v", err)
+		}
+	case <-time.After(deriveWait / 2):
+		t.Fatal("waiting handshake did not proceed after a slot was released")
+	}
+}
@@ -0,0 +1,380 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/protocol"
+)
+
+// startDNS 启动只回答 A 与 AAAA 查询的 UDP DNS 服务，所有名称都解析为 v4 与 v6
+func startDNS(t *testing.T, v4, v6 net.IP) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := dnsReply(buf[:n], v4, v6); reply != nil {
+				pc.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// dnsReply 按查询类型构造应答，只支持单个问题
+func dnsReply(query []byte, v4, v6 net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// 跳过问题中的名称
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5 // 结尾的 0、类型与类别
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[end-4:])
+
+	var rdata []byte
+	switch qtype {
+	case 1: // A
+		rdata = v4.To4()
+	case 28: // AAAA
+		rdata = v6.To16()
+	}
+
+	reply := append([]byte(nil), query[:2]...)    // ID
+	reply = append(reply, 0x81, 0x80, 0x00, 0x01) // 标准应答，1 个问题
+	reply = binary.BigEndian.AppendUint16(reply, uint16(min(len(rdata), 1)))
+	reply = append(reply, 0, 0, 0, 0)
+	reply = append(reply, query[12:end]...)
+	if rdata != nil {
+		reply = append(reply, 0xc0, 0x0c) // 指向问题中的名称
+		reply = binary.BigEndian.AppendUint16(reply, qtype)
+		reply = append(reply, 0x00, 0x01, 0, 0, 0, 60)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(len(rdata)))
+		reply = append(reply, rdata...)
+	}
+	return reply
+}
+
+func TestDirectDialFallsBackFromHangingFamily(t *testing.T) {
+	target, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+
+	dns := startDNS(t, net.IPv4(127, 0, 0, 1), net.IPv6loopback)
+	s, err := NewServer(&ServerConfig{
+		Password:          "test",
+		Resolver:          "udp://" + dns,
+		ConnectTimeout:    10,
+		DialFallbackDelay: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// IPv6 连接一直挂起，模拟 IPv6 出口不通但不返回错误的网络
+	var hung atomic.Bool
+	s.dialer.ControlContext = func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+		if network == "tcp6" {
+			hung.Store(true)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	start := time.Now()
+	conn, err := s.dialer.Dial("tcp", net.JoinHostPort("dualstack.test", port))
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if !hung.Load() {
+		t.Skip("IPv4 was tried first on this host, the IPv6 path was not exercised")
+	}
+	if conn.RemoteAddr().(*net.TCPAddr).IP.To4() == nil {
+		t.Fatalf("connected to %v, want the IPv4 address", conn.RemoteAddr())
+	}
+	if elapsed > 2*time.Second {
+		t.Fatalf("dial took %v, want the IPv4 fallback shortly after the 50ms delay", elapsed)
+	}
+}
+
+func TestServerSourceAddr(t *testing.T) {
+	for _, source := range []string{"127.0.0.1", "::1"} {
+		t.Run(source, func(t *testing.T) {
+			target, err := net.Listen("tcp", net.JoinHostPort(source, "0"))
+			if err != nil {
+				t.Skipf("%s is not available: %v", source, err)
+			}
+			defer target.Close()
+
+			s, err := NewServer(&ServerConfig{Password: "test", SourceAddr: source})
+			if err != nil {
+				t.Fatal(err)
+			}
+			local, ok := s.dialer.LocalAddr.(*net.TCPAddr)
+			if !ok || !local.IP.Equal(net.ParseIP(source)) || local.Port != 0 {
+				t.Fatalf("dialer.LocalAddr = %v, want %s with an ephemeral port", s.dialer.LocalAddr, source)
+			}
+
+			conn, err := s.dialer.Dial("tcp", target.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(local.IP) {
+				t.Fatalf("connection source = %v, want %s", ip, source)
+			}
+		})
+	}
+}
+
+func TestServerSourceAddrInvalid(t *testing.T) {
+	// 192.0.2.1 属于文档保留地址，不会分配给本机网卡
+	for _, source := range []string{"not-an-ip", "127.0.0.1:80", "192.0.2.1"} {
+		if _, err := NewServer(&ServerConfig{Password: "test", SourceAddr: source}); err == nil {
+			t.Errorf("NewServer with source_addr %q succeeded, want error", source)
+		}
+	}
+}
+
+// connectStatusVia 启动服务端，握手后请求连接 target，返回服务端的连接应答状态
+func connectStatusVia(t *testing.T, s *Server, target string) byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	salt, opts, err := protocol.ClientHandshake(conn, s.cfg.Password, protocol.HandshakeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := protocol.WrapTunnel(conn, conn, s.cfg.Password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.WriteTarget(w, target); err != nil {
+		t.Fatal(err)
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(r, status); err != nil {
+		t.Fatal(err)
+	}
+	return status[0]
+}
+
+func TestServerDialNetwork(t *testing.T) {
+	v4, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v4.Close()
+	v6, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	}
+	defer v6.Close()
+
+	tests := []struct {
+		network          string
+		allowed, refused net.Listener
+	}{
+		{"tcp4", v4, v6},
+		{"tcp6", v6, v4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			s, err := NewServer(&ServerConfig{Password: "test", ListenAddr: "127.0.0.1", DialNetwork: tt.network})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var used sync.Map
+			s.dialer.ControlContext = func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+				used.Store(network, true)
+				return nil
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := s.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			if status := connectStatusVia(t, s, tt.allowed.Addr().String()); status != protocol.ConnectOK {
+				t.Fatalf("%s target: status %d, want ConnectOK", tt.allowed.Addr(), status)
+			}
+			if status := connectStatusVia(t, s, tt.refused.Addr().String()); status == protocol.ConnectOK {
+				t.Fatalf("%s target connected although dial_network is %s", tt.refused.Addr(), tt.network)
+			}
+			if _, ok := used.Load(tt.network); !ok {
+				t.Fatalf("dialer never used %s", tt.network)
+			}
+			used.Range(func(network, _ any) bool {
+				if network != tt.network {
+					t.Errorf("dialer used network %s, want only %s", network, tt.network)
+				}
+				return true
+			})
+		})
+	}
+}
+
+func TestIPv6TargetDialedBracketed(t *testing.T) {
+	s, err := NewServer(&ServerConfig{Password: "test", ListenAddr: "127.0.0.1", ConnectTimeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialed := make(chan string, 2)
+	s.dialer.ControlContext = func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+		dialed <- address
+		// 文档地址不可达，记录后直接放弃连接
+		return syscall.ECONNREFUSED
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p := NewLocalProxy(&LocalConfig{
+		LocalAddr:     []string{"127.0.0.1:0"},
+		HTTPProxyAddr: []string{"127.0.0.1:0"},
+		Server:        s.Addr().String(),
+		Password:      "test",
+	})
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	dial := func(addr string) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		return conn
+	}
+
+	// SOCKS5 CONNECT，地址类型为 IPv6
+	conn := dial(p.SOCKS5Addr().String())
+	defer conn.Close()
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	req := append([]byte{0x05, 0x01, 0x00, 0x04}, net.ParseIP("2001:db8::1").To16()...)
+	conn.Write(binary.BigEndian.AppendUint16(req, 443))
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-dialed; got != "[2001:db8::1]:443" {
+		t.Fatalf("SOCKS5: server dialed %q, want [2001:db8::1]:443", got)
+	}
+
+	// HTTP CONNECT 的目标同样保留方括号
+	conn = dial(p.HTTPAddr().String())
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT [2001:db8::2]:8443 HTTP/1.1\r\nHost: [2001:db8::2]:8443\r\n\r\n")
+	if _, err := io.ReadFull(conn, make([]byte, len("HTTP/1.1 "))); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-dialed; got != "[2001:db8::2]:8443" {
+		t.Fatalf("HTTP CONNECT: server dialed %q, want [2001:db8::2]:8443", got)
+	}
+}
+
+func TestServerConnectTimeout(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	tests := []struct {
+		name                   string
+		handshake, connect     int
+		delay                  time.Duration // 连接目标的耗时，0 表示目标一直不接受连接
+		want                   byte
+		minElapsed, maxElapsed time.Duration
+	}{
+		// 目标一直不接受连接时在 connect_timeout 后失败，不等待更长的 handshake_timeout
+		{"never accepts", 30, 1, 0, protocol.ConnectTimeout, time.Second, 5 * time.Second},
+		// 连接目标的耗时超过 handshake_timeout 但在 connect_timeout 内时仍然成功
+		{"slow but within connect_timeout", 1, 5, 1500 * time.Millisecond, protocol.ConnectOK, 1500 * time.Millisecond, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewServer(&ServerConfig{Password: "test", ListenAddr: "127.0.0.1", HandshakeTimeout: tt.handshake, ConnectTimeout: tt.connect})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.dialer.ControlContext = func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+				if tt.delay == 0 {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				time.Sleep(tt.delay)
+				return nil
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := s.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			start := time.Now()
+			status := connectStatusVia(t, s, target.Addr().String())
+			elapsed := time.Since(start)
+			if status != tt.want {
+				t.Fatalf("status %d after %v, want %d", status, elapsed, tt.want)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Fatalf("status after %v, want between %v and %v", elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
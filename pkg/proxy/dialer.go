@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/relay"
+)
+
+// ConnectError 服务端无法连接目标，Status 为服务端返回的连接目标应答状态
+type ConnectError struct {
+	Target string
+	Status byte
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("connect to %s: %s", e.Target, protocol.ConnectStatusText(e.Status))
+}
+
+// Dialer 经代理服务器连接目标，不需要启动 SOCKS5/HTTP 监听
+// 实现了 golang.org/x/net/proxy 的 Dialer 与 ContextDialer 接口，可直接用于 http.Transport 等接受拨号函数的库
+// 每次拨号都新建一条到服务器的隧道；不使用熔断与 fallback_direct，失败时直接返回错误
+type Dialer struct {
+	cfg *LocalConfig
+}
+
+// NewDialer 创建拨号器，使用 cfg 中的服务器地址、密码、混淆与压缩等设置
+func NewDialer(cfg *LocalConfig) (*Dialer, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("server address is required")
+	}
+	if cfg.Password == "" {
+		return nil, errors.New("password is required")
+	}
+	return &Dialer{cfg: cfg}, nil
+}
+
+// Dial 经代理服务器连接 addr，超时为 handshake_timeout
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 经代理服务器连接 addr，返回的连接可直接读写目标的数据
+// ctx 取消或到期时中止连接；ctx 没有截止时间时使用 handshake_timeout
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+
+	cfg := d.cfg
+	if err := protocol.CheckTargetLen(addr, cfg.GetMaxTargetLen()); err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok && cfg.GetHandshakeTimeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.GetHandshakeTimeout())
+		defer cancel()
+	}
+
+	var nd net.Dialer
+	server, err := nd.DialContext(ctx, "tcp", cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	relay.TuneTCP(server, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), logger.Log)
+	if !cfg.LowLatency {
+		server = relay.NewBatchConn(server, relay.BatchDelay).WithWriteTimeout(cfg.GetWriteTimeout())
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		server.SetDeadline(deadline)
+	}
+
+	// ctx 取消时中断阻塞中的读写
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			server.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	conn, err := d.openTunnel(server, addr)
+	close(done)
+	<-watcherDone
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		server.Close()
+		return nil, fmt.Errorf("dial %s through proxy aborted: %w", addr, ctxErr)
+	}
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	// 握手阶段结束，之后的截止时间由调用方设置
+	server.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// openTunnel 在服务器连接上完成握手并请求连接 target
+func (d *Dialer) openTunnel(server net.Conn, target string) (net.Conn, error) {
+	cfg := d.cfg
+
+	opts := protocol.HandshakeOptions{Obfuscate: cfg.Obfuscate, Compress: cfg.Compress, Plaintext: cfg.Plaintext, Cipher: cfg.GetCipher(), RekeyAfter: cfg.GetRekeyAfter(), Fingerprint: cfg.ServerFingerprint, Negotiate: cfg.Negotiate}
+	salt, opts, err := protocol.ClientHandshake(server, cfg.Password, opts)
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	secureReader, secureWriter, err := protocol.WrapTunnel(server, server, cfg.Password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	if err := protocol.WriteTarget(secureWriter, target); err != nil {
+		return nil, fmt.Errorf("failed to send target address: %w", err)
+	}
+
+	status, _, err := protocol.ReadConnectStatus(secureReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server response: %w", err)
+	}
+	if status != protocol.ConnectOK {
+		return nil, &ConnectError{Target: target, Status: status}
+	}
+	protocol.StartKeepalive(secureReader, secureWriter, cfg.GetKeepaliveInterval(), cfg.GetKeepaliveTimeout(), func() {
+		logger.Log.Warn("Keepalive timeout, closing tunnel", "target", target)
+		server.Close()
+	})
+
+	// 压缩只用于转发阶段，位于加密层之上
+	conn := &tunnelConn{Conn: server, r: secureReader, w: secureWriter}
+	if cfg.Compress {
+		conn.r = protocol.NewCompressedReader(secureReader)
+		conn.w = protocol.NewCompressedWriter(secureWriter)
+	}
+	return conn, nil
+}
+
+// tunnelConn 经隧道到目标的连接，读写经过解密/加密，地址与截止时间使用服务器连接
+type tunnelConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write 按 relay.BufferSize 分块写入，单个加密数据包的明文长度有上限，调用方可以一次写入任意长度
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), relay.BufferSize)]
+		n, err := c.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// CloseWrite 通知对端本端已结束写入，服务端随之半关闭到目标的连接
+func (c *tunnelConn) CloseWrite() error {
+	cw, ok := c.w.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("tunnel does not support half-close")
+	}
+	return cw.CloseWrite()
+}
@@ -0,0 +1,54 @@
+package proxy_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+
+	"go-proxy-eins/pkg/proxy"
+)
+
+// 经 Dialer 让 http.Client 的所有连接走代理隧道
+func ExampleDialer() {
+	// 示例在进程内启动服务端与目标网站，实际使用时只需要服务器地址与密码
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, err := proxy.NewServer(&proxy.ServerConfig{ListenAddr: "127.0.0.1:0", Password: "secret"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello through the tunnel")
+	}))
+	defer site.Close()
+
+	dialer, err := proxy.NewDialer(&proxy.LocalConfig{
+		Server:   srv.Addr().String(),
+		Password: "secret",
+		Timeout:  30,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+
+	resp, err := client.Get(site.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(resp.Status, string(body))
+	// Output: 200 OK hello through the tunnel
+}
@@ -0,0 +1,86 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/pkg/proxy"
+)
+
+// newTestDialer 创建连接到测试链路服务端的 Dialer
+func newTestDialer(t *testing.T) *proxy.Dialer {
+	t.Helper()
+	h := startHarness(t)
+	dialer, err := proxy.NewDialer(&proxy.LocalConfig{
+		Server:   h.Server.Addr().String(),
+		Password: "proxytest",
+		Timeout:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialer
+}
+
+func TestDialerHTTPRoundTrip(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	}))
+	defer site.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: newTestDialer(t).DialContext},
+		Timeout:   10 * time.Second,
+	}
+
+	// 请求与应答的正文都超过单个加密数据包的明文上限
+	body := make([]byte, 3*cipher.MaxPayloadSize()+17)
+	rand.NewChaCha8([32]byte{}).Read(body)
+	resp, err := client.Post(site.URL, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("echoed %d bytes, want the %d bytes sent", len(got), len(body))
+	}
+}
+
+func TestDialerConnectError(t *testing.T) {
+	dialer := newTestDialer(t)
+
+	_, err := dialer.Dial("tcp", closedAddr(t))
+	var connectErr *proxy.ConnectError
+	if !errors.As(err, &connectErr) || connectErr.Status != protocol.ConnectRefused {
+		t.Fatalf("err = %v, want ConnectError with the refused status", err)
+	}
+}
+
+func TestDialerUnsupportedNetwork(t *testing.T) {
+	dialer := newTestDialer(t)
+
+	if _, err := dialer.DialContext(context.Background(), "udp", "127.0.0.1:53"); err == nil {
+		t.Fatal("udp dial succeeded")
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dnsMessageType DNS over HTTPS 请求与应答的 Content-Type（RFC 8484）
+const dnsMessageType = "application/dns-message"
+
+// newResolver 根据 resolver 配置创建解析器，为空时返回 nil 表示使用系统解析器
+// 支持 "1.1.1.1"、"udp://1.1.1.1:53"、"tcp://1.1.1.1"、"tls://1.1.1.1:853"（DoT）与 "https://1.1.1.1/dns-query"（DoH）
+// timeout 为连接 DNS 服务器的超时，单次查询的超时由调用方的 context 决定
+func newResolver(spec string, timeout time.Duration) (*net.Resolver, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	scheme, addr, ok := strings.Cut(spec, "://")
+	if !ok {
+		scheme, addr = "udp", spec
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var dial func(ctx context.Context, network, address string) (net.Conn, error)
+	switch strings.ToLower(scheme) {
+	case "udp", "tcp":
+		server, err := resolverAddr(addr, "53")
+		if err != nil {
+			return nil, err
+		}
+		forceTCP := strings.EqualFold(scheme, "tcp")
+		// 系统配置中的 DNS 服务器地址被忽略；udp 时沿用解析器选择的网络，应答被截断时会改用 TCP 重试
+		dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if forceTCP {
+				network = "tcp"
+			}
+			return dialer.DialContext(ctx, network, server)
+		}
+	case "tls":
+		server, err := resolverAddr(addr, "853")
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ := net.SplitHostPort(server)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return tlsDialer.DialContext(ctx, "tcp", server)
+		}
+	case "https":
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid resolver %q: expected https://host/path", spec)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+		client := &http.Client{Transport: transport}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, url: u.String()}, nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid resolver %q: scheme must be udp, tcp, tls or https", spec)
+	}
+
+	return &net.Resolver{PreferGo: true, Dial: dial}, nil
+}
+
+// resolverAddr 校验 DNS 服务器地址，不带端口时使用 port
+func resolverAddr(addr, port string) (string, error) {
+	server := withPort(addr, port)
+	host, p, err := net.SplitHostPort(server)
+	if err != nil || host == "" || p == "" || strings.Contains(addr, "/") {
+		return "", fmt.Errorf("invalid resolver address %q: expected host or host:port", addr)
+	}
+	return server, nil
+}
+
+// dohConn 把解析器按 TCP 格式（2 字节长度 + 消息）写入的查询通过 HTTPS POST 发送，应答以同样格式读出
+// 每个连接只用于一次查询，由 net.Resolver 在单个 goroutine 中使用
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	deadline time.Time
+	query    bytes.Buffer
+	reply    bytes.Buffer
+}
+
+func (c *dohConn) Write(p []byte) (int, error) {
+	return c.query.Write(p)
+}
+
+// Read 第一次读取时发送已写入的查询
+func (c *dohConn) Read(p []byte) (int, error) {
+	if c.reply.Len() == 0 {
+		if err := c.exchange(); err != nil {
+			return 0, err
+		}
+	}
+	return c.reply.Read(p)
+}
+
+func (c *dohConn) exchange() error {
+	b := c.query.Bytes()
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return io.ErrUnexpectedEOF
+	}
+	msg := c.query.Next(2 + int(binary.BigEndian.Uint16(b)))[2:]
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DNS over HTTPS server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 0xffff+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > 0xffff {
+		return errors.New("DNS over HTTPS reply too large")
+	}
+	c.reply.Write(binary.BigEndian.AppendUint16(nil, uint16(len(body))))
+	c.reply.Write(body)
+	return nil
+}
+
+func (c *dohConn) Close() error                      { return nil }
+func (c *dohConn) LocalAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr              { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error     { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error { c.deadline = t; return nil }
+func (c *dohConn) SetWriteDeadline(time.Time) error  { return nil }
+
+// dohAddr DoH 服务器 URL，实现 net.Addr
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
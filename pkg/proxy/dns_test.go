@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/protocol"
+)
+
+// startTCPDNS 启动 TCP DNS 服务，所有 A 查询都解析为 v4，queries 记录收到的查询数
+func startTCPDNS(t *testing.T, v4 net.IP) (addr string, queries *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	queries = new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					length := make([]byte, 2)
+					if _, err := io.ReadFull(conn, length); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(length))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					queries.Add(1)
+					reply := dnsReply(query, v4, nil)
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), queries
+}
+
+// startClosingTarget 启动接受连接后立即关闭的 IPv4 目标服务，返回端口
+func startClosingTarget(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func TestServerResolver(t *testing.T) {
+	port := startClosingTarget(t)
+	udp := startDNS(t, net.IPv4(127, 0, 0, 1), nil)
+	tcp, queries := startTCPDNS(t, net.IPv4(127, 0, 0, 1))
+
+	// 目标域名只有配置的 DNS 服务器能解析
+	for _, spec := range []string{udp, "udp://" + udp, "tcp://" + tcp} {
+		t.Run(spec, func(t *testing.T) {
+			s, err := NewServer(&ServerConfig{Password: "test", ListenAddr: "127.0.0.1", Resolver: spec, DialNetwork: "tcp4"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := s.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			before := queries.Load()
+			if status := connectStatusVia(t, s, net.JoinHostPort("only-in-mock.invalid", port)); status != protocol.ConnectOK {
+				t.Fatalf("status %d, want ConnectOK through the configured resolver", status)
+			}
+			if spec == "tcp://"+tcp && queries.Load() == before {
+				t.Fatal("TCP resolver was never queried")
+			}
+		})
+	}
+}
+
+func TestDoHConn(t *testing.T) {
+	var queries atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		queries.Add(1)
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(dnsReply(query, net.IPv4(192, 0, 2, 7), nil))
+	}))
+	defer srv.Close()
+
+	resolver := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return &dohConn{ctx: ctx, client: srv.Client(), url: srv.URL + "/dns-query"}, nil
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := resolver.LookupIP(ctx, "ip4", "doh.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 7)) {
+		t.Fatalf("LookupIP = %v, want [192.0.2.7]", ips)
+	}
+	if queries.Load() == 0 {
+		t.Fatal("DoH server was never queried")
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	if r, err := newResolver("", time.Second); r != nil || err != nil {
+		t.Fatalf("empty resolver = %v, %v; want the system resolver", r, err)
+	}
+	for _, spec := range []string{"1.1.1.1", "udp://1.1.1.1:5353", "tcp://[2606:4700::1111]", "tls://dns.example", "https://dns.example/dns-query"} {
+		if r, err := newResolver(spec, time.Second); r == nil || err != nil {
+			t.Errorf("newResolver(%q) = %v, %v", spec, r, err)
+		}
+	}
+	for _, spec := range []string{"quic://1.1.1.1", "udp://1.1.1.1/path", "https://", "tcp://:53"} {
+		if _, err := newResolver(spec, time.Second); err == nil {
+			t.Errorf("newResolver(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package proxy_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// httpConnect 通过本地 HTTP 代理入口发送 CONNECT，返回应答状态码与连接
+func httpConnect(t *testing.T, proxyAddr, target string) (int, net.Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusOK && br.Buffered() > 0 {
+		t.Fatalf("%d unexpected bytes after the CONNECT response", br.Buffered())
+	}
+	return resp.StatusCode, conn
+}
+
+// assertConnEcho 经 conn 发送数据并检查回显
+func assertConnEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	msg := []byte("degraded but working")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(msg) {
+		t.Fatalf("echo = %q, want %q", got, msg)
+	}
+}
+
+func TestFallbackDirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(server *proxy.ServerConfig, local *proxy.LocalConfig)
+	}{
+		{"server unreachable", func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			local.Server = closedAddr(t)
+		}},
+		{"handshake rejected", func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			local.Password = "wrong"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+				tt.configure(server, local)
+				local.FallbackDirect = true
+			}))
+
+			t.Run("SOCKS5", func(t *testing.T) {
+				conn, err := h.DialTarget()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				assertConnEcho(t, conn)
+			})
+			t.Run("HTTP CONNECT", func(t *testing.T) {
+				code, conn := httpConnect(t, h.Local.HTTPAddr().String(), h.TargetAddr())
+				if code != http.StatusOK {
+					t.Fatalf("CONNECT status = %d, want 200", code)
+				}
+				assertConnEcho(t, conn)
+			})
+		})
+	}
+}
+
+func TestFallbackDirectDisabled(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		local.Server = closedAddr(t)
+	}))
+
+	if conn, err := h.DialTarget(); err == nil {
+		conn.Close()
+		t.Fatal("SOCKS5 connection succeeded without fallback_direct")
+	}
+	if code, _ := httpConnect(t, h.Local.HTTPAddr().String(), h.TargetAddr()); code != http.StatusBadGateway {
+		t.Fatalf("CONNECT status = %d, want 502", code)
+	}
+}
+
+func TestFallbackDirectTargetDown(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		local.Server = closedAddr(t)
+		local.FallbackDirect = true
+	}))
+
+	// 直连目标同样失败时报告目标的错误
+	if code, _ := httpConnect(t, h.Local.HTTPAddr().String(), closedAddr(t)); code != http.StatusBadGateway {
+		t.Fatalf("CONNECT status = %d, want 502", code)
+	}
+}
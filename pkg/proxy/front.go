@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"go-proxy-eins/internal/protocol"
+)
+
+// frontMap front_map 规范化后的映射，键为小写的 host 或 host:port
+type frontMap map[string]string
+
+// newFrontMap 校验并规范化 front_map，键与值都可以是 host 或 host:port
+func newFrontMap(m map[string]string) (frontMap, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	fm := make(frontMap, len(m))
+	for front, backend := range m {
+		key := strings.ToLower(strings.TrimSpace(front))
+		if host, port, err := net.SplitHostPort(key); err == nil {
+			key = net.JoinHostPort(host, port)
+		} else {
+			key = strings.Trim(key, "[]")
+		}
+		if _, _, err := protocol.ParseTarget(withPort(key, "1")); err != nil {
+			return nil, fmt.Errorf("invalid front_map key %q: %w", front, err)
+		}
+
+		backend = strings.TrimSpace(backend)
+		if _, _, err := protocol.ParseTarget(withPort(backend, "1")); err != nil {
+			return nil, fmt.Errorf("invalid front_map backend %q for %q: %w", backend, front, err)
+		}
+		fm[key] = backend
+	}
+	return fm, nil
+}
+
+// rewrite 返回 target 映射后的实际目标，先匹配 host:port，再匹配 host
+// 映射值不带端口时沿用原端口
+func (fm frontMap) rewrite(target string) (string, bool) {
+	if fm == nil {
+		return target, false
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, false
+	}
+	host = strings.ToLower(host)
+
+	if backend, ok := fm[net.JoinHostPort(host, port)]; ok {
+		return withPort(backend, port), true
+	}
+	if backend, ok := fm[host]; ok {
+		return withPort(backend, port), true
+	}
+	return target, false
+}
+
+// withPort addr 不带端口时补上 port
+func withPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
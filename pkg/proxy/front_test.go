@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+
+	"go-proxy-eins/internal/protocol"
+)
+
+func TestFrontMapRewrite(t *testing.T) {
+	fm, err := newFrontMap(map[string]string{
+		"Front.Example":      "backend.example",
+		"front.example:8443": "other.example:443",
+		"[2001:db8::1]":      "[2001:db8::2]:80",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target, want string
+		mapped       bool
+	}{
+		{"front.example:443", "backend.example:443", true},
+		{"FRONT.example:80", "backend.example:80", true},
+		{"front.example:8443", "other.example:443", true}, // host:port 优先于 host
+		{"[2001:db8::1]:443", "[2001:db8::2]:80", true},
+		{"unmapped.example:443", "unmapped.example:443", false},
+		{"sub.front.example:443", "sub.front.example:443", false},
+	}
+	for _, tt := range tests {
+		got, mapped := fm.rewrite(tt.target)
+		if got != tt.want || mapped != tt.mapped {
+			t.Errorf("rewrite(%q) = %q, %v, want %q, %v", tt.target, got, mapped, tt.want, tt.mapped)
+		}
+	}
+
+	var none frontMap
+	if got, mapped := none.rewrite("front.example:443"); mapped || got != "front.example:443" {
+		t.Errorf("nil map rewrote to %q", got)
+	}
+}
+
+func TestFrontMapInvalid(t *testing.T) {
+	for _, m := range []map[string]string{
+		{"": "backend.example"},
+		{"front.example": ""},
+		{"front.example": "backend.example:notaport"},
+	} {
+		if _, err := newFrontMap(m); err == nil {
+			t.Errorf("newFrontMap(%v) succeeded", m)
+		}
+	}
+}
+
+func TestServerFrontMap(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Addr().String())
+
+	s, err := NewServer(&ServerConfig{
+		Password:   "test",
+		ListenAddr: "127.0.0.1",
+		FrontMap: map[string]string{
+			"front.example": backend.Addr().String(),
+			"cdn.example":   "127.0.0.1",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var dialed []string
+	s.dialer.ControlContext = func(ctx context.Context, network, address string, _ syscall.RawConn) error {
+		mu.Lock()
+		dialed = append(dialed, address)
+		mu.Unlock()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 映射的前置域名不做解析，直接连接后端；不带端口的后端沿用请求的端口
+	for _, target := range []string{"front.example:443", net.JoinHostPort("cdn.example", port)} {
+		if status := connectStatusVia(t, s, target); status != protocol.ConnectOK {
+			t.Fatalf("%s: status %d, want ConnectOK through the backend", target, status)
+		}
+	}
+	// 未映射的目标照常连接
+	if status := connectStatusVia(t, s, backend.Addr().String()); status != protocol.ConnectOK {
+		t.Fatalf("unmapped target: status %d, want ConnectOK", status)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, addr := range dialed {
+		if addr != backend.Addr().String() {
+			t.Errorf("server dialed %s, want only the backend %s", addr, backend.Addr())
+		}
+	}
+	if len(dialed) != 3 {
+		t.Errorf("server dialed %d times, want 3", len(dialed))
+	}
+}
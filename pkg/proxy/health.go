@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go-proxy-eins/internal/logger"
+)
+
+// startHealth 启动健康检查 HTTP 服务
+// /healthz 进程存活即返回 200；/readyz 在主监听器关闭（开始退出）后返回 503
+func (s *Server) startHealth(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on health address: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-s.closed:
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ready\n"))
+		}
+	})
+
+	s.health = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.healthListener = listener
+
+	logger.Log.Info("Health endpoint is running", "address", listener.Addr())
+	go s.health.Serve(listener)
+	return nil
+}
+
+// HealthAddr 返回健康检查服务的实际监听地址，未启用时为 nil
+func (s *Server) HealthAddr() net.Addr {
+	if s.healthListener == nil {
+		return nil
+	}
+	return s.healthListener.Addr()
+}
+
+// stopHealth 关闭健康检查服务
+func (s *Server) stopHealth() {
+	if s.health == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.health.Shutdown(ctx)
+}
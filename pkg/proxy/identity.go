@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"go-proxy-eins/internal/logger"
+)
+
+// identityPEMType 身份密钥文件的 PEM 块类型，内容为 PKCS#8 编码的 Ed25519 私钥
+// 与 openssl genpkey -algorithm ed25519 生成的文件格式相同
+const identityPEMType = "PRIVATE KEY"
+
+// loadIdentity 读取身份密钥文件，文件不存在时生成新密钥并以 0600 权限写入
+func loadIdentity(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createIdentity(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != identityPEMType {
+		return nil, fmt.Errorf("identity key %s: no %q PEM block", path, identityPEMType)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("identity key %s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity key %s: not an Ed25519 private key", path)
+	}
+	return ed, nil
+}
+
+// createIdentity 生成新的身份密钥并写入 path，path 已存在时失败，不会覆盖已有密钥
+func createIdentity(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode identity key: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity key: %w", err)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: identityPEMType, Bytes: der}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write identity key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write identity key: %w", err)
+	}
+
+	logger.Log.Info("Generated new server identity key", "path", path)
+	return key, nil
+}
@@ -0,0 +1,80 @@
+package proxy_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+func TestServerIdentityKeyPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.pem")
+
+	first, err := proxy.NewServer(&proxy.ServerConfig{Password: "test", IdentityKey: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("identity key not generated: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("identity key mode = %v, want 0600", perm)
+	}
+
+	// 重启后读取同一密钥，客户端固定的指纹继续有效
+	second, err := proxy.NewServer(&proxy.ServerConfig{Password: "test", IdentityKey: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Fingerprint() == "" || first.Fingerprint() != second.Fingerprint() {
+		t.Fatalf("fingerprints %q and %q, want the same key after restart", first.Fingerprint(), second.Fingerprint())
+	}
+
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proxy.NewServer(&proxy.ServerConfig{Password: "test", IdentityKey: path}); err == nil {
+		t.Fatal("NewServer accepted an invalid identity key file")
+	}
+}
+
+func TestPinnedServerFingerprint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.pem")
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.IdentityKey = path
+	}))
+
+	dial := func(fingerprint string) error {
+		dialer, err := proxy.NewDialer(&proxy.LocalConfig{
+			Server:            h.Server.Addr().String(),
+			Password:          "proxytest",
+			Timeout:           10,
+			ServerFingerprint: fingerprint,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dialer.Dial("tcp", h.TargetAddr())
+		if err == nil {
+			assertConnEcho(t, conn)
+			conn.Close()
+		}
+		return err
+	}
+
+	if err := dial(h.Server.Fingerprint()); err != nil {
+		t.Fatalf("matching fingerprint: %v", err)
+	}
+	other := "00" + h.Server.Fingerprint()[2:]
+	if other == h.Server.Fingerprint() {
+		other = "11" + other[2:]
+	}
+	if err := dial(other); !errors.Is(err, protocol.ErrIdentityMismatch) {
+		t.Fatalf("mismatched fingerprint: err = %v, want ErrIdentityMismatch", err)
+	}
+}
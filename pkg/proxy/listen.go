@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixPrefix 监听地址使用 Unix 域套接字时的前缀，如 "unix:/run/proxy.sock"
+const unixPrefix = "unix:"
+
+// IsUnixAddr 判断监听地址是否为 Unix 域套接字
+func IsUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix)
+}
+
+// listen 监听 host:port 或 unix:/path 形式的地址
+// Unix 套接字会先清理残留的套接字文件，并在创建后设置文件权限
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if !IsUnixAddr(addr) {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if path == "" {
+		return nil, fmt.Errorf("empty unix socket path")
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// 关闭监听器时 net 包会自动删除套接字文件
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// removeStaleSocket 删除上次异常退出遗留的套接字文件
+// 仍有进程在监听时返回错误，路径存在但不是套接字时拒绝删除
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,772 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go-proxy-eins/internal/breaker"
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/conntrack"
+	"go-proxy-eins/internal/httpproxy"
+	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/mux"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/ratelimit"
+	"go-proxy-eins/internal/relay"
+	"go-proxy-eins/internal/sniff"
+	"go-proxy-eins/internal/socks5"
+)
+
+// LocalProxy 本地代理客户端，同时提供 SOCKS5 与 HTTP CONNECT 入口
+type LocalProxy struct {
+	cfg *LocalConfig
+
+	// breaker 服务器连续不可达时暂停拨号，SOCKS5 与 HTTP 入口共用
+	breaker *breaker.Breaker
+
+	// sessions 多路复用会话池，未启用 mux 时为 nil
+	sessions *mux.Pool
+
+	// conns 正在转发的隧道，供管理接口查看与关闭
+	conns *conntrack.Registry
+
+	socksListeners   []net.Listener
+	httpListeners    []net.Listener
+	combinedListener net.Listener
+	pacListener      net.Listener
+	adminListener    net.Listener
+	closed           chan struct{}
+	closeOnce        sync.Once
+
+	// probes 在 SOCKS5 协商完成前断开的连接数
+	probes atomic.Int64
+}
+
+// NewLocalProxy 根据配置创建本地代理
+func NewLocalProxy(cfg *LocalConfig) *LocalProxy {
+	p := &LocalProxy{
+		cfg:     cfg,
+		breaker: breaker.New(cfg.BreakerThreshold, cfg.GetBreakerCooldown()),
+		conns:   conntrack.NewRegistry(),
+		closed:  make(chan struct{}),
+	}
+	if cfg.Mux {
+		p.sessions = httpproxy.NewMuxPool(cfg)
+	}
+	return p
+}
+
+// Probes 返回在 SOCKS5 协商完成前就断开的连接数，多为端口扫描与健康检查，不计入隧道失败
+func (p *LocalProxy) Probes() int64 {
+	return p.probes.Load()
+}
+
+// Start 监听 SOCKS5 与 HTTP 代理地址并在后台接受连接，ctx 取消时停止接受新连接
+// 地址可以是 host:port 或 unix:/path，每种入口可以有多个地址；HTTPProxyAddr 为空时不启动 HTTP 代理
+// 任一地址监听失败时关闭已打开的监听器并返回错误
+func (p *LocalProxy) Start(ctx context.Context) error {
+	mode, err := p.cfg.GetUnixSocketMode()
+	if err != nil {
+		return err
+	}
+
+	allow, err := parseClientAllow(p.cfg.ClientAllow)
+	if err != nil {
+		return err
+	}
+
+	if p.cfg.Plaintext {
+		logger.Log.Warn("PLAINTEXT mode enabled: traffic to the server is NOT encrypted or integrity-protected and can be read or altered by anyone on the network path; use only on loopback or trusted networks")
+	}
+
+	for _, addr := range p.cfg.LocalAddr {
+		listener, err := listen(addr, mode)
+		if err != nil {
+			p.closeListeners()
+			return fmt.Errorf("failed to listen on SOCKS5 address %s: %w", addr, err)
+		}
+		p.socksListeners = append(p.socksListeners, listener)
+		logger.Log.Info("SOCKS5 proxy is running", "address", listener.Addr())
+	}
+
+	for _, addr := range p.cfg.HTTPProxyAddr {
+		listener, err := listen(addr, mode)
+		if err != nil {
+			p.closeListeners()
+			return fmt.Errorf("failed to listen on HTTP proxy address %s: %w", addr, err)
+		}
+		p.httpListeners = append(p.httpListeners, listener)
+		logger.Log.Info("HTTP proxy is running", "address", listener.Addr())
+	}
+
+	if p.cfg.CombinedAddr != "" {
+		combinedListener, err := listen(p.cfg.CombinedAddr, mode)
+		if err != nil {
+			p.closeListeners()
+			return fmt.Errorf("failed to listen on combined address %s: %w", p.cfg.CombinedAddr, err)
+		}
+		p.combinedListener = combinedListener
+		logger.Log.Info("Combined SOCKS5/HTTP proxy is running", "address", combinedListener.Addr())
+	}
+
+	// PAC 内容取决于上面的实际监听地址，最后启动
+	if p.cfg.PACAddr != "" {
+		if err := p.startPAC(p.cfg.PACAddr, p.cfg.GetPACPath()); err != nil {
+			p.closeListeners()
+			return err
+		}
+	}
+
+	if p.cfg.AdminAddr != "" {
+		listener, err := startAdmin(p.cfg.AdminAddr, p.conns)
+		if err != nil {
+			p.closeListeners()
+			return err
+		}
+		p.adminListener = listener
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Close()
+		case <-p.closed:
+		}
+	}()
+
+	for _, l := range p.socksListeners {
+		go p.acceptLoop(l, "SOCKS5", p.clientFilterFor(l, "SOCKS5", allow), p.handleSOCKS5)
+	}
+	for _, l := range p.httpListeners {
+		go p.acceptLoop(l, "HTTP", p.clientFilterFor(l, "HTTP", allow), p.handleHTTPProxy)
+	}
+	if p.combinedListener != nil {
+		go p.acceptLoop(p.combinedListener, "combined", p.clientFilterFor(p.combinedListener, "combined", allow), p.handleCombined)
+	}
+	return nil
+}
+
+// SOCKS5Addr 返回第一个 SOCKS5 实际监听地址，Start 之前为 nil
+func (p *LocalProxy) SOCKS5Addr() net.Addr {
+	if len(p.socksListeners) == 0 {
+		return nil
+	}
+	return p.socksListeners[0].Addr()
+}
+
+// SOCKS5Addrs 返回所有 SOCKS5 实际监听地址
+func (p *LocalProxy) SOCKS5Addrs() []net.Addr {
+	return listenerAddrs(p.socksListeners)
+}
+
+// HTTPAddr 返回第一个 HTTP 代理实际监听地址，未启动时为 nil
+func (p *LocalProxy) HTTPAddr() net.Addr {
+	if len(p.httpListeners) == 0 {
+		return nil
+	}
+	return p.httpListeners[0].Addr()
+}
+
+// HTTPAddrs 返回所有 HTTP 代理实际监听地址
+func (p *LocalProxy) HTTPAddrs() []net.Addr {
+	return listenerAddrs(p.httpListeners)
+}
+
+// CombinedAddr 返回 SOCKS5/HTTP 合并入口的实际监听地址，未启动时为 nil
+func (p *LocalProxy) CombinedAddr() net.Addr {
+	if p.combinedListener == nil {
+		return nil
+	}
+	return p.combinedListener.Addr()
+}
+
+// Close 停止接受新连接，已建立的连接不受影响，多路复用会话在其上的隧道全部结束后关闭
+func (p *LocalProxy) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		err = p.closeListeners()
+		if p.sessions != nil {
+			p.sessions.Close()
+		}
+	})
+	return err
+}
+
+// closeListeners 关闭所有已打开的监听器，返回第一个错误
+func (p *LocalProxy) closeListeners() error {
+	var err error
+	listeners := append(append([]net.Listener{}, p.socksListeners...), p.httpListeners...)
+	if p.combinedListener != nil {
+		listeners = append(listeners, p.combinedListener)
+	}
+	if p.pacListener != nil {
+		listeners = append(listeners, p.pacListener)
+	}
+	if p.adminListener != nil {
+		listeners = append(listeners, p.adminListener)
+	}
+	for _, l := range listeners {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// listenerAddrs 返回监听器的实际地址
+func listenerAddrs(listeners []net.Listener) []net.Addr {
+	addrs := make([]net.Addr, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+// acceptLoop 接受连接直到监听器关闭，来源地址不在 allow 中的连接在任何协商之前直接关闭
+func (p *LocalProxy) acceptLoop(listener net.Listener, kind string, allow clientFilter, handle func(net.Conn, *slog.Logger)) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-p.closed:
+				return
+			default:
+				logger.Log.Warn("Failed to accept "+kind+" connection", "error", err)
+				continue
+			}
+		}
+
+		if !allow.allows(client.RemoteAddr()) {
+			logger.Log.Debug("Client address not allowed, rejecting "+kind+" connection", "remote", client.RemoteAddr())
+			client.Close()
+			continue
+		}
+
+		go handle(client, logger.WithConnID(logger.NewConnID()))
+	}
+}
+
+// handleHTTPProxy 处理 HTTP 代理连接
+func (p *LocalProxy) handleHTTPProxy(client net.Conn, log *slog.Logger) {
+	cfg := p.cfg
+
+	defer client.Close()
+	relay.TuneTCP(client, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+
+	// 设置超时
+	if cfg.GetHandshakeTimeout() > 0 {
+		client.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+
+	p.serveHTTPProxy(client, bufio.NewReader(client), log)
+}
+
+// serveHTTPProxy 从 reader 读取 HTTP 请求并处理
+// CONNECT 请求建立隧道，其它方法按普通 HTTP 代理转发
+func (p *LocalProxy) serveHTTPProxy(client net.Conn, reader *bufio.Reader, log *slog.Logger) {
+	cfg := p.cfg
+
+	// 窥探请求方法以确定请求类型
+	method, err := reader.Peek(len(http.MethodConnect))
+	if err != nil {
+		return
+	}
+
+	if httpproxy.IsHTTPConnect(method) {
+		requestLine, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		httpproxy.HandleHTTPConnect(client, reader, requestLine, cfg, p.breaker, p.sessions, p.conns, log)
+	} else {
+		httpproxy.HandleHTTPForward(client, reader, cfg, p.breaker, p.sessions, p.conns, log)
+	}
+}
+
+// handleSOCKS5 处理 SOCKS5 连接
+func (p *LocalProxy) handleSOCKS5(client net.Conn, log *slog.Logger) {
+	cfg := p.cfg
+
+	defer client.Close()
+	relay.TuneTCP(client, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+
+	// 设置超时
+	if cfg.GetHandshakeTimeout() > 0 {
+		client.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+
+	p.serveSOCKS5(client, bufio.NewReader(client), log)
+}
+
+// handleCombined 在同一端口上同时接受 SOCKS5 与 HTTP CONNECT，按首部字节分派
+func (p *LocalProxy) handleCombined(client net.Conn, log *slog.Logger) {
+	cfg := p.cfg
+
+	defer client.Close()
+	relay.TuneTCP(client, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+
+	// 设置超时
+	if cfg.GetHandshakeTimeout() > 0 {
+		client.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+
+	reader := bufio.NewReader(client)
+	isHTTP, _, err := httpproxy.DetectProtocol(reader)
+	if err != nil {
+		if clientGone(err) {
+			p.probes.Add(1)
+		}
+		log.Debug("Failed to detect protocol", "remote", client.RemoteAddr(), "error", err)
+		return
+	}
+
+	if isHTTP {
+		p.serveHTTPProxy(client, reader, log)
+	} else {
+		p.serveSOCKS5(client, reader, log)
+	}
+}
+
+// serveSOCKS5 从 reader 读取 SOCKS5 请求并处理
+func (p *LocalProxy) serveSOCKS5(client net.Conn, reader *bufio.Reader, log *slog.Logger) {
+	cfg := p.cfg
+
+	log.Debug("New SOCKS5 connection", "remote", client.RemoteAddr())
+
+	// 1-2. SOCKS5 认证与请求
+	// 协商完成前就断开的多为端口扫描与健康检查，只记 debug 日志并单独计数，不当作隧道失败
+	cmd, atyp, addr, port, err := readSOCKS5Request(client, reader, cfg.SOCKSResolve)
+	if err != nil {
+		if clientGone(err) {
+			p.probes.Add(1)
+			log.Debug("Client disconnected during SOCKS5 negotiation", "remote", client.RemoteAddr(), "error", err)
+		} else {
+			log.Warn("SOCKS5 negotiation failed", "remote", client.RemoteAddr(), "error", err)
+		}
+		return
+	}
+
+	if cmd != socks5.CmdConnect {
+		p.serveSOCKS5Resolve(client, cmd, atyp, addr, log)
+		return
+	}
+
+	dest := net.JoinHostPort(addr, strconv.Itoa(int(port)))
+	if err := protocol.CheckTargetLen(dest, cfg.GetMaxTargetLen()); err != nil {
+		log.Warn("Rejecting SOCKS5 request", "client", client.RemoteAddr(), "error", err)
+		writeSOCKS5Reply(client, socks5.ReplyConnectionNotAllowed)
+		return
+	}
+
+	log.Info("SOCKS5 request", "target", dest, "client", client.RemoteAddr())
+
+	access := logger.StartAccess("SOCKS5", client.RemoteAddr(), dest)
+	defer access.Done()
+
+	// 3. 连接远程服务器，连续失败过多时熔断以快速失败
+	// 服务器不可达时回复网络不可达，与服务器报告的目标连接失败区分开
+	if err := p.breaker.Allow(); err != nil {
+		if cfg.FallbackDirect {
+			p.serveDirect(client, reader, dest, access, err, log)
+			return
+		}
+		log.Warn("Server unavailable, connection rejected", "error", err)
+		writeSOCKS5Reply(client, socks5.ReplyNetworkUnreachable)
+		return
+	}
+	if p.sessions != nil {
+		p.serveSOCKS5Mux(client, reader, dest, access, log)
+		return
+	}
+	server, err := net.DialTimeout("tcp", cfg.Server, cfg.GetHandshakeTimeout())
+	if err != nil {
+		p.breaker.Failure()
+		log.Error("Failed to connect to server", "error", err)
+		if cfg.FallbackDirect {
+			p.serveDirect(client, reader, dest, access, err, log)
+			return
+		}
+		writeSOCKS5Reply(client, socks5.ReplyNetworkUnreachable)
+		return
+	}
+	relay.TuneTCP(server, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+	if !cfg.LowLatency {
+		server = relay.NewBatchConn(server, relay.BatchDelay).WithWriteTimeout(cfg.GetWriteTimeout())
+	}
+	defer server.Close()
+
+	// 设置服务器连接超时
+	if cfg.GetHandshakeTimeout() > 0 {
+		server.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+
+	log.Debug("Connected to server", "server", cfg.Server)
+
+	// 4. 执行握手认证
+	opts := protocol.HandshakeOptions{Obfuscate: cfg.Obfuscate, Compress: cfg.Compress, Plaintext: cfg.Plaintext, Cipher: cfg.GetCipher(), RekeyAfter: cfg.GetRekeyAfter(), Fingerprint: cfg.ServerFingerprint, Negotiate: cfg.Negotiate}
+	salt, opts, err := protocol.ClientHandshake(server, cfg.Password, opts)
+	if err != nil {
+		p.breaker.Failure()
+		log.Error("Handshake failed", "error", err)
+		if cfg.FallbackDirect {
+			server.Close()
+			p.serveDirect(client, reader, dest, access, err, log)
+			return
+		}
+		writeSOCKS5Reply(client, socks5.ReplyServerFailure)
+		return
+	}
+	p.breaker.Success()
+
+	log.Debug("Handshake successful", "obfuscate", opts.Obfuscate, "cipher", opts.Cipher)
+
+	// 5. 创建加密器并包装连接（加密 + 可选混淆，plaintext 时不包装）
+	secureReader, secureWriter, err := protocol.WrapTunnel(server, server, cfg.Password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		log.Error("Failed to create cipher", "error", err)
+		writeSOCKS5Reply(client, socks5.ReplyServerFailure)
+		return
+	}
+
+	// 6-7. 发送目标地址并等待服务器连接目标的响应
+	bound, ok := requestSOCKS5Target(client, secureReader, secureWriter, dest, log)
+	if !ok {
+		return
+	}
+	protocol.StartKeepalive(secureReader, secureWriter, cfg.GetKeepaliveInterval(), cfg.GetKeepaliveTimeout(), func() {
+		log.Warn("Keepalive timeout, closing tunnel", "target", dest)
+		server.Close()
+	})
+
+	// 压缩只用于转发阶段，位于加密层之上
+	var tunnelReader io.Reader = secureReader
+	var tunnelWriter io.Writer = secureWriter
+	if cfg.Compress {
+		tunnelReader = protocol.NewCompressedReader(secureReader)
+		tunnelWriter = protocol.NewCompressedWriter(secureWriter)
+	}
+
+	p.relaySOCKS5(client, reader, server, tunnelReader, tunnelWriter, dest, bound, access, log)
+}
+
+// serveSOCKS5Mux 在多路复用会话中打开一个流代替新的服务器连接
+func (p *LocalProxy) serveSOCKS5Mux(client net.Conn, reader *bufio.Reader, dest string, access *logger.AccessEntry, log *slog.Logger) {
+	cfg := p.cfg
+
+	stream, err := p.sessions.Open()
+	if err != nil {
+		p.breaker.Failure()
+		log.Error("Failed to open multiplexed stream", "error", err)
+		if cfg.FallbackDirect {
+			p.serveDirect(client, reader, dest, access, err, log)
+			return
+		}
+		var reply byte = socks5.ReplyServerFailure
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			reply = socks5.ReplyNetworkUnreachable
+		}
+		writeSOCKS5Reply(client, reply)
+		return
+	}
+	p.breaker.Success()
+	defer stream.Close()
+
+	if cfg.GetHandshakeTimeout() > 0 {
+		stream.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+	bound, ok := requestSOCKS5Target(client, stream, stream, dest, log)
+	if !ok {
+		return
+	}
+	p.relaySOCKS5(client, reader, stream, stream, stream, dest, bound, access, log)
+}
+
+// requestSOCKS5Target 发送目标地址并等待服务器连接目标的响应，返回服务端到目标的套接字的本地地址（未知时无效）
+// 失败时回复 SOCKS5 错误并返回 false
+func requestSOCKS5Target(client io.Writer, r io.Reader, w io.Writer, dest string, log *slog.Logger) (netip.AddrPort, bool) {
+	if err := protocol.WriteTarget(w, dest); err != nil {
+		log.Error("Failed to send target address", "error", err)
+		writeSOCKS5Reply(client, socks5.ReplyServerFailure)
+		return netip.AddrPort{}, false
+	}
+
+	status, bound, err := protocol.ReadConnectStatus(r)
+	if err != nil {
+		if errors.Is(err, cipher.ErrCipherMismatch) {
+			log.Error("Failed to decrypt server response, cipher or obfuscation settings likely differ from the server", "error", err)
+		} else {
+			log.Error("Failed to read server response", "error", err)
+		}
+		writeSOCKS5Reply(client, socks5.ReplyServerFailure)
+		return netip.AddrPort{}, false
+	}
+
+	if status != protocol.ConnectOK {
+		log.Warn("Server failed to connect to target", "target", dest, "reason", protocol.ConnectStatusText(status))
+		writeSOCKS5Reply(client, socksReply(status))
+		return netip.AddrPort{}, false
+	}
+	return bound, true
+}
+
+// relaySOCKS5 回复 SOCKS5 成功并在客户端与隧道之间转发数据，server 是服务器连接或多路复用流
+// bound 为服务端到目标的套接字的本地地址，作为应答的绑定地址
+func (p *LocalProxy) relaySOCKS5(client net.Conn, reader *bufio.Reader, server net.Conn, tunnelReader io.Reader, tunnelWriter io.Writer, dest string, bound netip.AddrPort, access *logger.AccessEntry, log *slog.Logger) {
+	cfg := p.cfg
+
+	// 握手阶段结束，转发阶段使用空闲超时
+	idle := relay.NewIdleTimeout(cfg.GetIdleTimeout(), client, server).WithWriteTimeout(cfg.GetWriteTimeout())
+
+	// 8. 回复 SOCKS5 成功，绑定地址为服务端到目标的套接字的本地地址；
+	// 服务端经上游代理连接而无从得知时，按 RFC 1928 对 CONNECT 的允许回复 0.0.0.0:0
+	if bound.IsValid() {
+		writeSOCKS5SuccessReply(client, net.TCPAddrFromAddrPort(bound))
+	} else {
+		writeSOCKS5Reply(client, socks5.ReplySuccess)
+	}
+	access.Established()
+
+	log.Debug("Tunnel established", "target", dest)
+
+	tracked := p.conns.Add("SOCKS5", client.RemoteAddr(), dest, client, server)
+	defer tracked.Remove()
+
+	var upSrc io.Reader = reader
+	if cfg.Sniff {
+		upSrc = sniff.NewReader(reader, log.With("target", dest))
+	}
+
+	// 9. 双向转发数据，一端半关闭时通知另一端
+	up := &relay.Stream{Name: "up", Dst: ratelimit.NewWriter(idle.Writer(tunnelWriter, server), cfg.GetRateLimit()), Src: idle.Reader(upSrc), Peer: tunnelWriter, Count: &tracked.BytesUp}
+	down := &relay.Stream{Name: "down", Dst: ratelimit.NewWriter(idle.Writer(client, client), cfg.GetRateLimit()), Src: idle.Reader(tunnelReader), Peer: client, Count: &tracked.BytesDown}
+	relay.Join(up, down, func() {
+		client.Close()
+		server.Close()
+	})
+	access.BytesUp, access.BytesDown = up.N, down.N
+	log.Debug("Transfer ended", relay.LogAttrs(up, down)...)
+
+	log.Debug("Connection closed", "target", dest)
+}
+
+// serveDirect 服务器不可用且启用了 fallback_direct 时，直接连接目标并转发，流量不经过服务器
+func (p *LocalProxy) serveDirect(client net.Conn, reader *bufio.Reader, dest string, access *logger.AccessEntry, cause error, log *slog.Logger) {
+	cfg := p.cfg
+
+	target, err := httpproxy.DialDirect(dest, cfg, cause, log)
+	if err != nil {
+		writeSOCKS5Reply(client, socksReply(connectStatus(err)))
+		return
+	}
+	defer target.Close()
+
+	idle := relay.NewIdleTimeout(cfg.GetIdleTimeout(), client, target).WithWriteTimeout(cfg.GetWriteTimeout())
+
+	writeSOCKS5SuccessReply(client, target.LocalAddr())
+	access.Established()
+
+	tracked := p.conns.Add("SOCKS5", client.RemoteAddr(), dest, client, target)
+	defer tracked.Remove()
+
+	up := &relay.Stream{Name: "up", Dst: ratelimit.NewWriter(idle.Writer(target, target), cfg.GetRateLimit()), Src: idle.Reader(reader), Peer: target, Count: &tracked.BytesUp}
+	down := &relay.Stream{Name: "down", Dst: ratelimit.NewWriter(idle.Writer(client, client), cfg.GetRateLimit()), Src: idle.Reader(target), Peer: client, Count: &tracked.BytesDown}
+	relay.Join(up, down, func() {
+		client.Close()
+		target.Close()
+	})
+	access.BytesUp, access.BytesDown = up.N, down.N
+	log.Debug("Transfer ended", relay.LogAttrs(up, down)...)
+
+	log.Debug("Direct connection closed", "target", dest)
+}
+
+// serveSOCKS5Resolve 处理 RESOLVE/RESOLVE_PTR 请求：通过服务端解析，结果放在应答的绑定地址中
+// RESOLVE 返回一个 IP 地址（优先 IPv4），RESOLVE_PTR 返回一个域名
+func (p *LocalProxy) serveSOCKS5Resolve(client net.Conn, cmd, atyp byte, addr string, log *slog.Logger) {
+	ctx := context.Background()
+
+	if cmd == socks5.CmdResolve {
+		log.Info("SOCKS5 resolve request", "host", addr, "client", client.RemoteAddr())
+		ips, err := p.Resolve(ctx, addr)
+		if err != nil || len(ips) == 0 {
+			log.Warn("Remote resolve failed", "host", addr, "error", err)
+			writeSOCKS5Reply(client, socks5.ReplyHostUnreachable)
+			return
+		}
+		ip := ips[0]
+		for _, candidate := range ips {
+			if candidate.To4() != nil {
+				ip = candidate
+				break
+			}
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			writeSOCKS5BindReply(client, socks5.AtypIPv4, ip4)
+		} else {
+			writeSOCKS5BindReply(client, socks5.AtypIPv6, ip.To16())
+		}
+		return
+	}
+
+	log.Info("SOCKS5 reverse resolve request", "addr", addr, "client", client.RemoteAddr())
+	if atyp == socks5.AtypDomain {
+		writeSOCKS5Reply(client, socks5.ReplyAddressNotSupported)
+		return
+	}
+	names, err := p.ResolvePTR(ctx, net.ParseIP(addr))
+	if err != nil || len(names) == 0 || len(names[0]) > 255 {
+		log.Warn("Remote reverse resolve failed", "addr", addr, "error", err)
+		writeSOCKS5Reply(client, socks5.ReplyHostUnreachable)
+		return
+	}
+	writeSOCKS5BindReply(client, socks5.AtypDomain, append([]byte{byte(len(names[0]))}, names[0]...))
+}
+
+// writeSOCKS5BindReply 发送绑定地址为 addr 的成功应答，addr 按 atyp 编码（域名带长度前缀），端口为 0
+func writeSOCKS5BindReply(w io.Writer, atyp byte, addr []byte) error {
+	reply := append([]byte{0x05, socks5.ReplySuccess, 0x00, atyp}, addr...)
+	_, err := w.Write(append(reply, 0, 0))
+	return err
+}
+
+// readSOCKS5Request 完成 SOCKS5 方法协商（无需认证）并读取请求
+// 版本、命令或地址类型不受支持时先向客户端回复错误，再返回错误
+func readSOCKS5Request(client io.Writer, reader *bufio.Reader, allowResolve bool) (cmd, atyp byte, addr string, port uint16, err error) {
+	ver, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, "", 0, err
+	}
+	nmethods, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, "", 0, err
+	}
+	if _, err := reader.Discard(int(nmethods)); err != nil { // 跳过 methods
+		return 0, 0, "", 0, err
+	}
+
+	if ver != 0x05 {
+		client.Write([]byte{0x05, 0xFF}) // 不支持的版本
+		return 0, 0, "", 0, fmt.Errorf("unsupported SOCKS version %d", ver)
+	}
+	if _, err := client.Write([]byte{0x05, 0x00}); err != nil { // 无需认证
+		return 0, 0, "", 0, err
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return 0, 0, "", 0, err
+	}
+
+	// 支持 CONNECT 及 Tor 扩展的 RESOLVE/RESOLVE_PTR
+	cmd = buf[1]
+	switch {
+	case cmd == socks5.CmdConnect:
+	case (cmd == socks5.CmdResolve || cmd == socks5.CmdResolvePTR) && allowResolve:
+	default:
+		writeSOCKS5Reply(client, socks5.ReplyCommandNotSupported)
+		return 0, 0, "", 0, fmt.Errorf("unsupported SOCKS5 command %d", cmd)
+	}
+
+	// 解析目标地址
+	atyp = buf[3]
+	switch atyp {
+	case 0x01: // IPv4
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return 0, 0, "", 0, err
+		}
+		addr = net.IP(ip).String()
+	case 0x03: // 域名
+		length, err := reader.ReadByte()
+		if err != nil {
+			return 0, 0, "", 0, err
+		}
+		host := make([]byte, length)
+		if _, err := io.ReadFull(reader, host); err != nil {
+			return 0, 0, "", 0, err
+		}
+		addr = string(host)
+	case 0x04: // IPv6
+		ip := make([]byte, 16)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return 0, 0, "", 0, err
+		}
+		addr = net.IP(ip).String()
+	default:
+		writeSOCKS5Reply(client, socks5.ReplyAddressNotSupported)
+		return 0, 0, "", 0, fmt.Errorf("unsupported SOCKS5 address type %d", atyp)
+	}
+
+	// 解析端口
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(reader, portBuf); err != nil {
+		return 0, 0, "", 0, err
+	}
+	return cmd, atyp, addr, binary.BigEndian.Uint16(portBuf), nil
+}
+
+// clientGone 判断 err 是否表示客户端已断开（EOF、连接被重置或已关闭），而不是协议错误或超时
+func clientGone(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, net.ErrClosed)
+}
+
+// writeSOCKS5SuccessReply 发送绑定地址为 bound 的成功应答
+// bound 不是 TCP 地址（如 Unix 套接字）时绑定地址为 0.0.0.0:0
+func writeSOCKS5SuccessReply(w io.Writer, bound net.Addr) error {
+	tcpAddr, ok := bound.(*net.TCPAddr)
+	if !ok {
+		return writeSOCKS5Reply(w, socks5.ReplySuccess)
+	}
+
+	reply := []byte{0x05, socks5.ReplySuccess, 0x00}
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+		reply = append(append(reply, socks5.AtypIPv4), ip4...)
+	} else if ip16 := tcpAddr.IP.To16(); ip16 != nil {
+		reply = append(append(reply, socks5.AtypIPv6), ip16...)
+	} else {
+		return writeSOCKS5Reply(w, socks5.ReplySuccess)
+	}
+	reply = binary.BigEndian.AppendUint16(reply, uint16(tcpAddr.Port))
+	_, err := w.Write(reply)
+	return err
+}
+
+// writeSOCKS5Reply 发送 SOCKS5 应答，绑定地址固定为 0.0.0.0:0
+func writeSOCKS5Reply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksReply 将服务端的连接目标应答状态转换为 SOCKS5 应答码
+func socksReply(status byte) byte {
+	switch status {
+	case protocol.ConnectRefused:
+		return socks5.ReplyConnectionRefused
+	case protocol.ConnectTimeout, protocol.ConnectDNSFailed, protocol.ConnectHostUnreachable:
+		return socks5.ReplyHostUnreachable
+	case protocol.ConnectNetUnreachable:
+		return socks5.ReplyNetworkUnreachable
+	case protocol.ConnectBlocked, protocol.ConnectForbidden:
+		return socks5.ReplyConnectionNotAllowed
+	default:
+		return socks5.ReplyServerFailure
+	}
+}
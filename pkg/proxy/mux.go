@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"go-proxy-eins/internal/mux"
+	"go-proxy-eins/internal/protocol"
+)
+
+// serveMux 把已认证的连接切换为多路复用会话，每个流与单独的隧道一样先发送请求再转发数据
+// 流不占用 max_connections 名额；服务端关闭时通知客户端不再打开新的流，已有的流结束后会话关闭
+// clientVersion 为握手时客户端的协议版本，会话中的每个流都按该版本应答
+func (s *Server) serveMux(conn net.Conn, secureReader io.Reader, secureWriter io.Writer, version string, clientVersion byte, log *slog.Logger) {
+	cfg := s.cfg
+
+	if version != protocol.MuxVersion {
+		log.Warn("Unsupported mux version", "version", version, "client", conn.RemoteAddr())
+		secureWriter.Write([]byte{protocol.ConnectFailed})
+		return
+	}
+	if _, err := secureWriter.Write([]byte{protocol.ConnectOK}); err != nil {
+		log.Error("Failed to send success response", "error", err)
+		return
+	}
+
+	// 会话长期保持，超时由各个流自行设置
+	conn.SetDeadline(time.Time{})
+
+	// 压缩作用于整个会话，位于加密层之上
+	var r io.Reader = secureReader
+	var w io.Writer = secureWriter
+	if cfg.Compress {
+		r = protocol.NewCompressedReader(secureReader)
+		w = protocol.NewCompressedWriter(secureWriter)
+	}
+
+	session := mux.Server(conn, r, w)
+	defer session.Close()
+	log.Debug("Multiplexed session started", "client", conn.RemoteAddr())
+
+	protocol.StartKeepalive(secureReader, secureWriter, cfg.GetKeepaliveInterval(), cfg.GetKeepaliveTimeout(), func() {
+		log.Warn("Keepalive timeout, closing tunnel", "client", conn.RemoteAddr())
+		conn.Close()
+	})
+
+	go func() {
+		select {
+		case <-s.closed:
+			session.GoAway()
+		case <-session.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveStream(stream, clientVersion, log.With("stream", stream.ID()))
+		}()
+	}
+	wg.Wait()
+
+	log.Debug("Multiplexed session closed", "client", conn.RemoteAddr())
+}
+
+// serveStream 处理多路复用会话中的一个流
+func (s *Server) serveStream(stream *mux.Stream, clientVersion byte, log *slog.Logger) {
+	defer stream.Close()
+
+	if timeout := s.cfg.GetHandshakeTimeout(); timeout > 0 {
+		stream.SetDeadline(time.Now().Add(timeout))
+	}
+
+	cmd, targetAddr, err := protocol.ReadRequest(stream)
+	if err != nil {
+		log.Error("Failed to read target address", "error", err)
+		return
+	}
+
+	switch cmd {
+	case protocol.CmdResolve:
+		s.handleResolve(stream, targetAddr, log)
+	case protocol.CmdResolvePTR:
+		s.handleResolvePTR(stream, targetAddr, log)
+	case protocol.CmdMux:
+		log.Warn("Nested mux request rejected", "client", stream.RemoteAddr())
+		stream.Write([]byte{protocol.ConnectFailed})
+	default:
+		s.serveTarget(stream, stream, stream, false, clientVersion, targetAddr, log)
+	}
+}
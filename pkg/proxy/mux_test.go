@@ -0,0 +1,179 @@
+package proxy_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// withMux 客户端启用多路复用，使用 connections 条长连接
+func withMux(connections int) proxytest.Option {
+	return proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		local.Mux = true
+		local.MuxConnections = connections
+	})
+}
+
+// openTunnels 经 SOCKS5 与 HTTP CONNECT 入口各打开 n 条到回显目标的隧道，检查回显后保持打开
+func openTunnels(t *testing.T, h *proxytest.Harness, n int) []net.Conn {
+	t.Helper()
+	var conns []net.Conn
+	for range n {
+		conn, err := h.DialTarget()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		assertConnEcho(t, conn)
+		conns = append(conns, conn)
+
+		code, conn := httpConnect(t, h.Local.HTTPAddr().String(), h.TargetAddr())
+		if code != http.StatusOK {
+			t.Fatalf("CONNECT status = %d, want 200", code)
+		}
+		assertConnEcho(t, conn)
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func TestMuxTunnelsShareConnection(t *testing.T) {
+	tests := map[string][]proxytest.Option{
+		"default":   nil,
+		"compress":  {withCompress()},
+		"obfuscate": {proxytest.WithObfuscate()},
+		"chacha20":  {withCipher(cipher.SuiteChaCha20Poly1305)},
+		"all":       {withCompress(), proxytest.WithObfuscate(), withCipher(cipher.SuiteChaCha20Poly1305)},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := startHarness(t, append(opts, withMux(0))...)
+
+			conns := openTunnels(t, h, 5)
+			if n := h.Server.ActiveConnections(); n != 1 {
+				t.Fatalf("%d tunnels use %d server connections, want 1", len(conns), n)
+			}
+
+			// 关闭一条隧道不影响同一连接上的其他隧道
+			conns[0].Close()
+			for _, conn := range conns[1:] {
+				assertConnEcho(t, conn)
+			}
+		})
+	}
+}
+
+func TestMuxConnections(t *testing.T) {
+	h := startHarness(t, withMux(3))
+
+	conns := openTunnels(t, h, 6)
+	if n := h.Server.ActiveConnections(); n != 3 {
+		t.Fatalf("%d tunnels use %d server connections, want mux_connections = 3", len(conns), n)
+	}
+}
+
+func TestMuxConcurrentTransfers(t *testing.T) {
+	h := startHarness(t, withMux(0))
+
+	// 并发传输超过流量控制窗口的数据，各流的数据不混在一起
+	const tunnels = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, tunnels)
+	for i := range tunnels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload := make([]byte, 1<<20+i)
+			rand.NewChaCha8([32]byte{byte(i)}).Read(payload)
+			if err := h.RoundTrip(payload); err != nil {
+				errs <- fmt.Errorf("tunnel %d: %w", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMuxSlowReaderDoesNotBlockOthers(t *testing.T) {
+	h := startHarness(t, withMux(0))
+
+	// 一条隧道的客户端停止读取，回显目标写满该流的窗口后阻塞
+	slow, err := h.DialTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	go slow.Write(make([]byte, 4<<20))
+
+	conn, err := h.DialTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	time.Sleep(200 * time.Millisecond)
+	assertConnEcho(t, conn)
+}
+
+func TestMuxHalfClose(t *testing.T) {
+	h := startHarness(t, withMux(0))
+
+	conn, err := h.DialTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// 客户端半关闭后仍能读完回显，之后读到 EOF
+	msg := []byte("last request")
+	conn.Write(msg)
+	conn.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("read %q after half-close, want %q", got, msg)
+	}
+}
+
+// BenchmarkTunnelLatency 打开隧道、完成一次回显并关闭的耗时：每条隧道单独连接与复用长连接对比
+func BenchmarkTunnelLatency(b *testing.B) {
+	for _, mode := range []struct {
+		name string
+		opts []proxytest.Option
+	}{
+		{"per-connection", nil},
+		{"mux", []proxytest.Option{withMux(0)}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			h, err := proxytest.NewHarness(mode.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer h.Close()
+
+			payload := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+			for b.Loop() {
+				if err := h.RoundTrip(payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
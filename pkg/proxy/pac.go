@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-proxy-eins/internal/logger"
+)
+
+// pacContentType PAC 文件的 MIME 类型
+const pacContentType = "application/x-ns-proxy-autoconfig"
+
+// startPAC 启动 PAC 文件服务，浏览器配置 http://addr/path 即可自动使用本地代理
+func (p *LocalProxy) startPAC(addr, path string) error {
+	rules, err := pacDirectRules(p.cfg.PACDirect)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on PAC address %s: %w", addr, err)
+	}
+	p.pacListener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", pacContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(generatePAC(p.pacProxies(r.Host), rules)))
+	})
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	logger.Log.Info("PAC server is running", "url", "http://"+listener.Addr().String()+path)
+	go server.Serve(listener)
+	return nil
+}
+
+// PACAddr 返回 PAC 服务的实际监听地址，未启用时为 nil
+func (p *LocalProxy) PACAddr() net.Addr {
+	if p.pacListener == nil {
+		return nil
+	}
+	return p.pacListener.Addr()
+}
+
+// pacProxies 根据实际监听地址生成 PAC 返回的代理列表，如 "SOCKS5 127.0.0.1:1080; PROXY 127.0.0.1:8080; DIRECT"
+// 监听在 0.0.0.0 等通配地址时使用客户端请求 PAC 时的主机名，局域网内的其它设备也能使用
+func (p *LocalProxy) pacProxies(requestHost string) string {
+	var proxies []string
+	if addr := pacAddr(append(p.SOCKS5Addrs(), p.CombinedAddr()), requestHost); addr != "" {
+		proxies = append(proxies, "SOCKS5 "+addr)
+	}
+	if addr := pacAddr(append(p.HTTPAddrs(), p.CombinedAddr()), requestHost); addr != "" {
+		proxies = append(proxies, "PROXY "+addr)
+	}
+	return strings.Join(append(proxies, "DIRECT"), "; ")
+}
+
+// pacAddr 返回第一个 TCP 监听地址，Unix 套接字无法写进 PAC
+func pacAddr(addrs []net.Addr, requestHost string) string {
+	for _, addr := range addrs {
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		host := tcpAddr.IP.String()
+		if tcpAddr.IP.IsUnspecified() {
+			host = "127.0.0.1"
+			if h, _, err := net.SplitHostPort(requestHost); err == nil {
+				host = h
+			} else if requestHost != "" {
+				host = requestHost
+			}
+		}
+		return net.JoinHostPort(host, strconv.Itoa(tcpAddr.Port))
+	}
+	return ""
+}
+
+// pacDirectRules 将 pac_direct 列表转换为 PAC 中的判断条件
+// 支持域名（同时匹配子域名）、含 * 的通配符和 IPv4 地址/CIDR；IP 规则只匹配 IP 字面量，避免浏览器为判断而解析域名
+func pacDirectRules(entries []string) ([]string, error) {
+	var rules []string
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case strings.Contains(entry, "/") || net.ParseIP(entry) != nil:
+			cidr := entry
+			if !strings.Contains(cidr, "/") {
+				cidr += "/32"
+			}
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil || ipNet.IP.To4() == nil {
+				return nil, fmt.Errorf("invalid pac_direct entry %q: only IPv4 addresses and CIDRs are supported", entry)
+			}
+			rules = append(rules, fmt.Sprintf("isIPv4 && isInNet(host, %q, %q)", ipNet.IP.String(), net.IP(ipNet.Mask).String()))
+		case strings.Trim(entry, "abcdefghijklmnopqrstuvwxyz0123456789.-_*") != "":
+			return nil, fmt.Errorf("invalid pac_direct entry %q", entry)
+		case strings.Contains(entry, "*"):
+			rules = append(rules, fmt.Sprintf("shExpMatch(host, %q)", entry))
+		default:
+			domain := strings.TrimPrefix(entry, ".")
+			rules = append(rules, fmt.Sprintf("host == %q || dnsDomainIs(host, %q)", domain, "."+domain))
+		}
+	}
+	return rules, nil
+}
+
+// generatePAC 生成 PAC 文件内容：不带点的主机名和匹配 rules 的目标直连，其余使用 proxies
+func generatePAC(proxies string, rules []string) string {
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("  host = host.toLowerCase();\n")
+	b.WriteString("  if (isPlainHostName(host)) return \"DIRECT\";\n")
+	b.WriteString("  var isIPv4 = /^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host);\n")
+	for _, rule := range rules {
+		fmt.Fprintf(&b, "  if (%s) return \"DIRECT\";\n", rule)
+	}
+	fmt.Fprintf(&b, "  return %q;\n", proxies)
+	b.WriteString("}\n")
+	return b.String()
+}
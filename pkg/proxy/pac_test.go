@@ -0,0 +1,60 @@
+package proxy_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+func TestPACServesListenAddressesAndDirectRules(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		local.PACAddr = "127.0.0.1:0"
+		local.PACPath = "auto.pac"
+		local.PACDirect = []string{"Example.COM", "*.lan", "192.168.0.0/16"}
+	}))
+
+	resp, err := http.Get("http://" + h.Local.PACAddr().String() + "/auto.pac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ns-proxy-autoconfig" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pac := string(body)
+
+	want := []string{
+		"function FindProxyForURL(url, host) {",
+		`if (isPlainHostName(host)) return "DIRECT";`,
+		`if (host == "example.com" || dnsDomainIs(host, ".example.com")) return "DIRECT";`,
+		`if (shExpMatch(host, "*.lan")) return "DIRECT";`,
+		`if (isIPv4 && isInNet(host, "192.168.0.0", "255.255.0.0")) return "DIRECT";`,
+		`return "SOCKS5 ` + h.Local.SOCKS5Addr().String() + `; PROXY ` + h.Local.HTTPAddr().String() + `; DIRECT";`,
+	}
+	for _, line := range want {
+		if !strings.Contains(pac, line) {
+			t.Errorf("PAC missing %q:\n%s", line, pac)
+		}
+	}
+}
+
+func TestPACRejectsInvalidDirectEntry(t *testing.T) {
+	_, err := proxytest.NewHarness(proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		local.PACAddr = "127.0.0.1:0"
+		local.PACDirect = []string{"2001:db8::/32"}
+	}))
+	if err == nil {
+		t.Fatal("expected an error for an IPv6 pac_direct entry")
+	}
+}
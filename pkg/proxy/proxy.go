@@ -0,0 +1,32 @@
+// Package proxy 将服务端与本地客户端的连接处理逻辑以库的形式对外提供，
+// cmd/server 与 cmd/local 只是在此之上的命令行封装。
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"go-proxy-eins/internal/config"
+)
+
+// ServerConfig 服务端配置
+type ServerConfig = config.ServerConfig
+
+// LocalConfig 客户端配置
+type LocalConfig = config.LocalConfig
+
+// waitTimeout 等待 WaitGroup 完成，超时返回 false；timeout 为 0 时不等待
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
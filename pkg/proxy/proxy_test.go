@@ -0,0 +1,362 @@
+package proxy_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/config"
+	"go-proxy-eins/internal/socks5"
+	"go-proxy-eins/internal/testutil"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// withCipher 两端使用同一加密套件
+func withCipher(suite string) proxytest.Option {
+	return proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Cipher = suite
+		local.Cipher = suite
+	})
+}
+
+// withCompress 两端同时启用压缩
+func withCompress() proxytest.Option {
+	return proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Compress = true
+		local.Compress = true
+	})
+}
+
+// TestRoundTripMatrix 经 SOCKS5 入口、加密隧道到回显目标再返回，覆盖 混淆 × 加密套件 × 压缩 的所有组合
+func TestRoundTripMatrix(t *testing.T) {
+	random := make([]byte, 3*cipher.MaxPayloadSize()+17)
+	r := rand.NewChaCha8([32]byte{})
+	r.Read(random)
+
+	payloads := map[string][]byte{
+		"small":        []byte("hello"),
+		"compressible": bytes.Repeat([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), 4096),
+		"random":       random,
+	}
+
+	for _, obfuscate := range []bool{false, true} {
+		for _, suite := range []string{cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305} {
+			for _, compress := range []bool{false, true} {
+				name := fmt.Sprintf("obfuscate=%v/cipher=%s/compress=%v", obfuscate, suite, compress)
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+					opts := []proxytest.Option{withCipher(suite)}
+					if obfuscate {
+						opts = append(opts, proxytest.WithObfuscate())
+					}
+					if compress {
+						opts = append(opts, withCompress())
+					}
+					h := startHarness(t, opts...)
+
+					for name, payload := range payloads {
+						if err := h.RoundTrip(payload); err != nil {
+							t.Fatalf("%s payload: %v", name, err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestRoundTripAcrossRekey 两个方向各传输数倍于 rekey_after 的数据，换钥前后的数据都应完整送达
+func TestRoundTripAcrossRekey(t *testing.T) {
+	payload := make([]byte, 3<<20+17)
+	rand.NewChaCha8([32]byte{1}).Read(payload)
+
+	for _, suite := range []string{cipher.SuiteXChaCha20Poly1305, cipher.SuiteChaCha20Poly1305} {
+		t.Run(suite, func(t *testing.T) {
+			h := startHarness(t, withCipher(suite), proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+				server.RekeyAfter = 1
+				local.RekeyAfter = 1
+			}))
+			if err := h.RoundTrip(payload); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestRoundTripPlaintext 两端都设置 plaintext 时不加密，上行与回显的下行数据都应完整送达
+func TestRoundTripPlaintext(t *testing.T) {
+	large := make([]byte, 4<<20+17)
+	rand.NewChaCha8([32]byte{2}).Read(large)
+	plaintext := proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Plaintext = true
+		local.Plaintext = true
+	})
+
+	tests := map[string][]proxytest.Option{
+		"tunnel":   {plaintext},
+		"compress": {plaintext, withCompress()},
+		"mux":      {plaintext, withMux(0)},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := startHarness(t, opts...)
+			if err := h.RoundTrip([]byte("hello")); err != nil {
+				t.Fatalf("small payload: %v", err)
+			}
+			if err := h.RoundTrip(large); err != nil {
+				t.Fatalf("large payload: %v", err)
+			}
+		})
+	}
+
+	// 只有服务端设置 plaintext 时握手被拒绝，不会退回加密或以明文发送
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Plaintext = true
+	}))
+	if err := h.RoundTrip([]byte("mismatched")); err == nil {
+		t.Fatal("round trip succeeded with plaintext set only on the server")
+	}
+}
+
+// TestRoundTripNegotiated 客户端的混淆与加密套件与服务端不同，开启 negotiate 后采用服务端的设置
+func TestRoundTripNegotiated(t *testing.T) {
+	server := func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Obfuscate = true
+		server.Cipher = cipher.SuiteChaCha20Poly1305
+		local.Cipher = cipher.SuiteXChaCha20Poly1305
+	}
+
+	h := startHarness(t, proxytest.WithConfig(func(s *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server(s, local)
+		local.Negotiate = true
+	}))
+	if err := h.RoundTrip([]byte("negotiated")); err != nil {
+		t.Fatal(err)
+	}
+
+	h = startHarness(t, proxytest.WithConfig(server))
+	if err := h.RoundTrip([]byte("mismatched")); err == nil {
+		t.Fatal("round trip succeeded with mismatched settings and negotiate off")
+	}
+}
+
+func TestKeepaliveIdleTunnel(t *testing.T) {
+	// 只有一端发送 ping，隧道只能靠对端的 pong 保持
+	tests := map[string]func(server *proxy.ServerConfig, local *proxy.LocalConfig){
+		"local": func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			local.KeepaliveInterval = 1
+			local.KeepaliveTimeout = 1
+		},
+		"server": func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			server.KeepaliveInterval = 1
+			server.KeepaliveTimeout = 1
+		},
+	}
+	for name, configure := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := startHarness(t, proxytest.WithConfig(configure))
+
+			conn, err := h.DialTarget()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			assertConnEcho(t, conn)
+
+			// 空闲超过数个 keepalive 周期后隧道仍然可用
+			time.Sleep(3 * time.Second)
+			assertConnEcho(t, conn)
+		})
+	}
+}
+
+func TestRoundTripPasswordMismatch(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		local.Password = "wrong"
+	}))
+
+	if err := h.RoundTrip([]byte("hello")); err == nil {
+		t.Fatal("round trip succeeded with a wrong password")
+	}
+}
+
+// TestHalfClose 客户端半关闭后目标收到 EOF，另一方向的应答仍能送达客户端
+func TestHalfClose(t *testing.T) {
+	sink, err := testutil.NewSink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			var opts []proxytest.Option
+			if compress {
+				opts = append(opts, withCompress())
+			}
+			h := startHarness(t, opts...)
+
+			conn, err := socks5.Dial(h.Local.SOCKS5Addr().String(), sink.Addr(), 10*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+			payload := bytes.Repeat([]byte("half-close "), 10000)
+			if _, err := conn.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Sink 收到 EOF 后才回写字节数，隧道不传递半关闭时这里会一直等待
+			var n uint64
+			if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+				t.Fatalf("read byte count after half-close: %v", err)
+			}
+			if n != uint64(len(payload)) {
+				t.Fatalf("target received %d bytes, want %d", n, len(payload))
+			}
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("after the reply: err = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+// closedAddr 返回回环地址上一个没有监听的端口
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestSOCKS5ReplyCodes(t *testing.T) {
+	refused := closedAddr(t)
+	_, refusedPort, _ := net.SplitHostPort(refused)
+	port, _ := strconv.Atoi(refusedPort)
+
+	tests := []struct {
+		name   string
+		opts   []proxytest.Option
+		target func(h *proxytest.Harness) string
+		want   byte
+	}{
+		{
+			name:   "target refused",
+			target: func(*proxytest.Harness) string { return refused },
+			want:   socks5.ReplyConnectionRefused,
+		},
+		{
+			name: "port blocked by server",
+			opts: []proxytest.Option{proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+				server.BlockedPorts = config.PortList{{Low: uint16(port), High: uint16(port)}}
+			})},
+			target: func(*proxytest.Harness) string { return refused },
+			want:   socks5.ReplyConnectionNotAllowed,
+		},
+		{
+			name: "server unreachable",
+			opts: []proxytest.Option{proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+				local.Server = closedAddr(t)
+			})},
+			target: (*proxytest.Harness).TargetAddr,
+			want:   socks5.ReplyNetworkUnreachable,
+		},
+		{
+			name: "handshake rejected",
+			opts: []proxytest.Option{proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+				local.Password = "wrong"
+			})},
+			target: (*proxytest.Harness).TargetAddr,
+			want:   socks5.ReplyServerFailure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startHarness(t, tt.opts...)
+
+			conn, _, err := socks5.DialWithAuthEx(h.Local.SOCKS5Addr().String(), tt.target(h), "", "", 10*time.Second)
+			if err == nil {
+				conn.Close()
+				t.Fatal("dial succeeded")
+			}
+			var replyErr *socks5.ReplyError
+			if !errors.As(err, &replyErr) {
+				t.Fatalf("err = %v, want a SOCKS5 reply error", err)
+			}
+			if replyErr.Code != tt.want {
+				t.Fatalf("reply = %d, want %d", replyErr.Code, tt.want)
+			}
+		})
+	}
+}
+
+// recordingTarget 启动记录连接来源地址的目标服务，来源地址即连接目标的套接字的本地地址
+func recordingTarget(t *testing.T) (addr string, peers <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		ch <- conn.RemoteAddr().String()
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+	return ln.Addr().String(), ch
+}
+
+func TestSOCKS5ReplyBindAddress(t *testing.T) {
+	tests := map[string]proxytest.Option{
+		// 服务端在连接成功的应答中给出到目标的套接字地址
+		"tunnel": proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {}),
+		"mux":    withMux(0),
+		// 服务器不可用时直连，绑定地址为本地到目标的套接字地址
+		"direct": proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+			local.Server = closedAddr(t)
+			local.FallbackDirect = true
+		}),
+	}
+	for name, opt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := startHarness(t, opt)
+			target, peers := recordingTarget(t)
+
+			conn, bound, err := socks5.DialWithAuthEx(h.Local.SOCKS5Addr().String(), target, "", "", 10*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if bound.Addr().IsUnspecified() || bound.Port() == 0 {
+				t.Fatalf("bind address = %v, want a real address", bound)
+			}
+			if got := <-peers; bound.String() != got {
+				t.Fatalf("bind address = %v, want the outbound socket address %s", bound, got)
+			}
+		})
+	}
+}
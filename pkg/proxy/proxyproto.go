@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"go-proxy-eins/internal/proxyproto"
+)
+
+// proxiedConn 使用 PROXY protocol 头部中客户端地址的连接
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr { return c.remote }
+
+// NetConn 返回底层连接，relay.TuneTCP 据此设置 TCP 选项
+func (c *proxiedConn) NetConn() net.Conn { return c.Conn }
+
+// CloseWrite 半关闭底层 TCP 连接
+func (c *proxiedConn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection does not support half-close")
+	}
+	return cw.CloseWrite()
+}
+
+// acceptProxied 读取负载均衡器发送的 PROXY protocol 头部，返回使用真实客户端地址的连接
+// 头部必须在 auth_timeout 内收完；按真实地址执行连接速率限制，失败或被限制时返回 false
+func (s *Server) acceptProxied(conn net.Conn, log *slog.Logger) (net.Conn, bool) {
+	conn.SetReadDeadline(time.Now().Add(s.cfg.GetAuthTimeout()))
+	src, err := proxyproto.ReadHeader(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Warn("Invalid PROXY protocol header", "remote", conn.RemoteAddr(), "error", err)
+		return nil, false
+	}
+	if src != nil {
+		log.Debug("PROXY protocol header received", "remote", src, "proxy", conn.RemoteAddr())
+		conn = &proxiedConn{Conn: conn, remote: src}
+	}
+
+	if s.connLimiter != nil {
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		if !s.connLimiter.Allow(host) {
+			log.Debug("Connection rate limit exceeded, rejecting", "remote", conn.RemoteAddr())
+			return nil, false
+		}
+	}
+	return conn, true
+}
@@ -0,0 +1,94 @@
+package proxy_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// tunnelWithPrefix 连接服务端，先发送 prefix（如 PROXY protocol 头部）再握手并请求连接 target
+func tunnelWithPrefix(t *testing.T, serverAddr, password string, prefix []byte, target string) (net.Conn, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.Write(prefix); err != nil {
+		return nil, err
+	}
+	salt, opts, err := protocol.ClientHandshake(conn, password, protocol.HandshakeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := protocol.WrapTunnel(conn, conn, password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := protocol.WriteTarget(w, target); err != nil {
+		return nil, err
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(r, status); err != nil {
+		return nil, err
+	}
+	if status[0] != protocol.ConnectOK {
+		t.Fatalf("connect status %d", status[0])
+	}
+	return conn, nil
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.ProxyProtocol = true
+		server.AdminAddr = "127.0.0.1:0"
+	}))
+	addr := h.Server.Addr().String()
+
+	headers := map[string]struct {
+		header []byte
+		client string
+	}{
+		"v1": {[]byte("PROXY TCP4 198.51.100.7 192.0.2.1 51234 8081\r\n"), "198.51.100.7:51234"},
+		"v2": {append([]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x21\x00\x24"),
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x07, // 源地址 2001:db8::7
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, // 目的地址 2001:db8::1
+			0xc8, 0x22, 0x1f, 0x91), "[2001:db8::7]:51234"},
+	}
+	for name, tt := range headers {
+		t.Run(name, func(t *testing.T) {
+			conn, err := tunnelWithPrefix(t, addr, "proxytest", tt.header, h.TargetAddr())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 服务端记录的客户端地址来自头部，而不是负载均衡器（这里是本机）的地址
+			infos := listConnections(t, h.Server.AdminAddr())
+			if len(infos) != 1 || infos[0].Client != tt.client {
+				t.Fatalf("server lists %+v, want one tunnel from %s", infos, tt.client)
+			}
+			conn.Close()
+			eventually(t, func() error {
+				if n := len(listConnections(t, h.Server.AdminAddr())); n != 0 {
+					return fmt.Errorf("server still lists %d tunnels", n)
+				}
+				return nil
+			})
+		})
+	}
+
+	t.Run("missing header", func(t *testing.T) {
+		if _, err := tunnelWithPrefix(t, addr, "proxytest", nil, h.TargetAddr()); err == nil {
+			t.Fatal("handshake without a PROXY protocol header succeeded")
+		}
+	})
+}
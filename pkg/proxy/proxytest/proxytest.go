@@ -0,0 +1,150 @@
+// Package proxytest 提供在同一进程内启动完整 服务端 + 本地客户端 链路的工具，
+// 用于端到端验证握手、加密转发等逻辑，无需启动独立的二进制程序。
+package proxytest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"go-proxy-eins/internal/socks5"
+	"go-proxy-eins/internal/testutil"
+	"go-proxy-eins/pkg/proxy"
+)
+
+// Option 在启动前调整服务端与客户端配置
+type Option func(server *proxy.ServerConfig, local *proxy.LocalConfig)
+
+// WithPassword 设置两端共用的密码
+func WithPassword(password string) Option {
+	return func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Password = password
+		local.Password = password
+	}
+}
+
+// WithObfuscate 两端同时启用流量混淆
+func WithObfuscate() Option {
+	return func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Obfuscate = true
+		local.Obfuscate = true
+	}
+}
+
+// WithConfig 直接修改配置，用于覆盖其它选项未涵盖的字段
+func WithConfig(fn func(server *proxy.ServerConfig, local *proxy.LocalConfig)) Option {
+	return Option(fn)
+}
+
+// Harness 运行中的 目标回显服务 + 服务端 + 本地客户端
+type Harness struct {
+	Server *proxy.Server
+	Local  *proxy.LocalProxy
+
+	// Target 回显目标服务，收到的数据原样写回
+	Target net.Listener
+
+	cancel context.CancelFunc
+}
+
+// NewHarness 在回环地址的随机端口上启动完整链路
+func NewHarness(opts ...Option) (*Harness, error) {
+	serverCfg := &proxy.ServerConfig{
+		Port:     0,
+		Password: "proxytest",
+		Timeout:  10,
+	}
+	localCfg := &proxy.LocalConfig{
+		LocalAddr:     []string{"127.0.0.1:0"},
+		HTTPProxyAddr: []string{"127.0.0.1:0"},
+		Password:      "proxytest",
+		Timeout:       10,
+	}
+	for _, opt := range opts {
+		opt(serverCfg, localCfg)
+	}
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for target: %w", err)
+	}
+	go testutil.Serve(target, testutil.Echo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Harness{Target: target, cancel: cancel}
+
+	h.Server, err = proxy.NewServer(serverCfg)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	if err := h.Server.Start(ctx); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	_, port, _ := net.SplitHostPort(h.Server.Addr().String())
+	if localCfg.Server == "" {
+		localCfg.Server = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	h.Local = proxy.NewLocalProxy(localCfg)
+	if err := h.Local.Start(ctx); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// TargetAddr 返回回显目标服务的地址
+func (h *Harness) TargetAddr() string {
+	return h.Target.Addr().String()
+}
+
+// DialTarget 通过本地 SOCKS5 入口连接回显目标服务
+func (h *Harness) DialTarget() (net.Conn, error) {
+	return socks5.Dial(h.Local.SOCKS5Addr().String(), h.TargetAddr(), 10*time.Second)
+}
+
+// RoundTrip 通过完整链路发送 payload 并校验回显内容一致
+func (h *Harness) RoundTrip(payload []byte) error {
+	conn, err := h.DialTarget()
+	if err != nil {
+		return fmt.Errorf("dial through proxy: %w", err)
+	}
+	defer conn.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(payload)
+		errCh <- err
+	}()
+
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return fmt.Errorf("read echo: %w", err)
+	}
+	if err := <-errCh; err != nil {
+		return fmt.Errorf("write payload: %w", err)
+	}
+	if !bytes.Equal(got, payload) {
+		return fmt.Errorf("echo mismatch: sent %d bytes, received different content", len(payload))
+	}
+	return nil
+}
+
+// Close 关闭链路上的所有组件
+func (h *Harness) Close() {
+	h.cancel()
+	if h.Local != nil {
+		h.Local.Close()
+	}
+	if h.Server != nil {
+		h.Server.Close()
+	}
+	h.Target.Close()
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/protocol"
+)
+
+// Resolve 通过服务端解析域名，避免本地 DNS 泄露
+// 域名不存在时返回 protocol.ErrNXDomain，服务端解析超时返回 protocol.ErrResolveTimeout
+func Resolve(ctx context.Context, cfg *LocalConfig, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	var ips []net.IP
+	err := resolveRequest(ctx, cfg, func(w io.Writer, r io.Reader) error {
+		if err := protocol.WriteResolveRequest(w, host); err != nil {
+			return err
+		}
+		var err error
+		ips, err = protocol.ReadResolveReply(r)
+		return err
+	})
+	return ips, err
+}
+
+// ResolvePTR 通过服务端反向解析 IP 地址，返回的域名已去掉末尾的点
+// 地址没有 PTR 记录时返回 protocol.ErrNXDomain；旧版本服务端不支持反向解析，会直接关闭连接
+func ResolvePTR(ctx context.Context, cfg *LocalConfig, ip net.IP) ([]string, error) {
+	var names []string
+	err := resolveRequest(ctx, cfg, func(w io.Writer, r io.Reader) error {
+		if err := protocol.WriteResolvePTRRequest(w, ip.String()); err != nil {
+			return err
+		}
+		var err error
+		names, err = protocol.ReadResolvePTRReply(r)
+		return err
+	})
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+	return names, err
+}
+
+// resolveRequest 连接服务端并完成握手，在加密通道上执行 exchange
+func resolveRequest(ctx context.Context, cfg *LocalConfig, exchange func(w io.Writer, r io.Reader) error) error {
+	if timeout := cfg.GetHandshakeTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	server, err := dialer.DialContext(ctx, "tcp", cfg.Server)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer server.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		server.SetDeadline(deadline)
+	}
+
+	// ctx 取消时中断阻塞的读写
+	stop := context.AfterFunc(ctx, func() {
+		server.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	opts := protocol.HandshakeOptions{Obfuscate: cfg.Obfuscate, Compress: cfg.Compress, Plaintext: cfg.Plaintext, Cipher: cfg.GetCipher(), RekeyAfter: cfg.GetRekeyAfter(), Fingerprint: cfg.ServerFingerprint, Negotiate: cfg.Negotiate}
+	salt, opts, err := protocol.ClientHandshake(server, cfg.Password, opts)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+
+	secureReader, secureWriter, err := protocol.WrapTunnel(server, server, cfg.Password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		return err
+	}
+
+	err = exchange(secureWriter, secureReader)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Resolve 使用本地代理的配置通过服务端解析域名，供分流等需要远端 DNS 结果的逻辑使用
+func (p *LocalProxy) Resolve(ctx context.Context, host string) ([]net.IP, error) {
+	return Resolve(ctx, p.cfg, host)
+}
+
+// ResolvePTR 使用本地代理的配置通过服务端反向解析 IP 地址
+func (p *LocalProxy) ResolvePTR(ctx context.Context, ip net.IP) ([]string, error) {
+	return ResolvePTR(ctx, p.cfg, ip)
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/protocol"
+)
+
+// 自检失败的阶段
+const (
+	StageConnect   = "connect"   // 无法连接服务器
+	StageHandshake = "handshake" // 服务器未按协议响应握手
+	StageAuth      = "auth"      // 密码错误
+	StageVersion   = "version"   // 协议版本不兼容
+	StageObfuscate = "obfuscate" // 混淆设置不一致
+	StageCompress  = "compress"  // 压缩设置不一致
+	StagePlaintext = "plaintext" // 明文传输设置不一致
+	StageSuite     = "suite"     // 加密套件不一致
+	StageRekey     = "rekey"     // 换钥设置不一致
+	StageIdentity  = "identity"  // 服务端身份与 server_fingerprint 不符，或服务端未配置身份密钥
+	StageCipher    = "cipher"    // 握手成功但无法解密服务器的数据，加密或混淆设置不一致
+	StageTunnel    = "tunnel"    // 握手成功但加密通道不可用
+	StageTarget    = "target"    // 服务器无法连接目标
+)
+
+// SelfTestError 自检失败，Stage 指明失败的阶段
+type SelfTestError struct {
+	Stage string
+	Err   error
+}
+
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// SelfTestResult 自检各阶段耗时
+type SelfTestResult struct {
+	Connect   time.Duration // TCP 连接服务器
+	Handshake time.Duration // 握手认证（含一次往返）
+	Target    time.Duration // 发送目标地址到收到服务器应答
+}
+
+// SelfTest 使用客户端配置连接服务器，完成握手并请求连接 target，
+// 不启动任何监听器。失败时返回 *SelfTestError
+func SelfTest(cfg *LocalConfig, target string) (*SelfTestResult, error) {
+	result := &SelfTestResult{}
+
+	start := time.Now()
+	server, err := net.DialTimeout("tcp", cfg.Server, cfg.GetHandshakeTimeout())
+	if err != nil {
+		return result, &SelfTestError{Stage: StageConnect, Err: err}
+	}
+	defer server.Close()
+	result.Connect = time.Since(start)
+
+	if cfg.GetHandshakeTimeout() > 0 {
+		server.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+
+	start = time.Now()
+	opts := protocol.HandshakeOptions{Obfuscate: cfg.Obfuscate, Compress: cfg.Compress, Plaintext: cfg.Plaintext, Cipher: cfg.GetCipher(), RekeyAfter: cfg.GetRekeyAfter(), Fingerprint: cfg.ServerFingerprint, Negotiate: cfg.Negotiate}
+	salt, opts, err := protocol.ClientHandshake(server, cfg.Password, opts)
+	if errors.Is(err, protocol.ErrAuthFailed) {
+		return result, &SelfTestError{Stage: StageAuth, Err: err}
+	}
+	if errors.Is(err, protocol.ErrUnsupportedVersion) {
+		return result, &SelfTestError{Stage: StageVersion, Err: err}
+	}
+	if errors.Is(err, protocol.ErrObfuscationMismatch) {
+		return result, &SelfTestError{Stage: StageObfuscate, Err: err}
+	}
+	if errors.Is(err, protocol.ErrCompressionMismatch) {
+		return result, &SelfTestError{Stage: StageCompress, Err: err}
+	}
+	if errors.Is(err, protocol.ErrPlaintextMismatch) {
+		return result, &SelfTestError{Stage: StagePlaintext, Err: err}
+	}
+	if errors.Is(err, protocol.ErrCipherSuiteMismatch) {
+		return result, &SelfTestError{Stage: StageSuite, Err: err}
+	}
+	if errors.Is(err, protocol.ErrRekeyMismatch) {
+		return result, &SelfTestError{Stage: StageRekey, Err: err}
+	}
+	if errors.Is(err, protocol.ErrIdentityMismatch) || errors.Is(err, protocol.ErrIdentityUnavailable) {
+		return result, &SelfTestError{Stage: StageIdentity, Err: err}
+	}
+	if err != nil {
+		return result, &SelfTestError{Stage: StageHandshake, Err: err}
+	}
+	result.Handshake = time.Since(start)
+
+	secureReader, secureWriter, err := protocol.WrapTunnel(server, server, cfg.Password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		return result, &SelfTestError{Stage: StageTunnel, Err: err}
+	}
+
+	start = time.Now()
+	if err := protocol.WriteTarget(secureWriter, target); err != nil {
+		return result, &SelfTestError{Stage: StageTunnel, Err: err}
+	}
+
+	status, _, err := protocol.ReadConnectStatus(secureReader)
+	if err != nil {
+		if errors.Is(err, cipher.ErrCipherMismatch) {
+			return result, &SelfTestError{Stage: StageCipher, Err: err}
+		}
+		return result, &SelfTestError{Stage: StageTunnel, Err: err}
+	}
+	result.Target = time.Since(start)
+
+	if status != protocol.ConnectOK {
+		return result, &SelfTestError{Stage: StageTarget, Err: fmt.Errorf("server could not connect to %s: %s", target, protocol.ConnectStatusText(status))}
+	}
+
+	return result, nil
+}
+
+// 自检报告中各步骤与整体的结果
+const (
+	StatusOK      = "ok"
+	StatusFail    = "fail"
+	StatusSkipped = "skipped" // 之前的步骤失败，未执行
+)
+
+// SelfTestStep 自检报告中一个步骤的结果
+type SelfTestStep struct {
+	Status string  `json:"status"` // ok、fail 或 skipped
+	MS     float64 `json:"ms"`     // 耗时（毫秒），失败或未执行时为 0
+}
+
+// SelfTestReport 自检结果的 JSON 格式（-test -json），供脚本与监控使用
+// 字段名与含义保持稳定，以后只增加字段
+type SelfTestReport struct {
+	Server    string       `json:"server"`
+	Target    string       `json:"target"`
+	Dial      SelfTestStep `json:"dial"`            // TCP 连接服务器
+	Handshake SelfTestStep `json:"handshake"`       // 握手认证
+	Connect   SelfTestStep `json:"connect"`         // 发送目标地址到收到服务器连接目标的应答
+	RTTMS     float64      `json:"rtt_ms"`          // 到服务器的往返时间，取 TCP 连接耗时；无法连接时为 0
+	Result    string       `json:"result"`          // ok 或 fail
+	Stage     string       `json:"stage,omitempty"` // 失败的阶段，见 Stage* 常量
+	Error     string       `json:"error,omitempty"`
+}
+
+// NewSelfTestReport 根据 SelfTest 的返回值生成报告
+func NewSelfTestReport(server, target string, result *SelfTestResult, err error) *SelfTestReport {
+	report := &SelfTestReport{Server: server, Target: target, Result: StatusOK}
+	if result == nil {
+		result = &SelfTestResult{}
+	}
+
+	// 失败的步骤之前的步骤都已成功，之后的步骤未执行
+	failed := -1
+	if err != nil {
+		failed = 0
+		report.Result = StatusFail
+		report.Error = err.Error()
+		var testErr *SelfTestError
+		if errors.As(err, &testErr) {
+			failed = selfTestStep(testErr.Stage)
+			report.Stage = testErr.Stage
+			report.Error = testErr.Err.Error()
+		}
+	}
+
+	steps := []*SelfTestStep{&report.Dial, &report.Handshake, &report.Connect}
+	durations := []time.Duration{result.Connect, result.Handshake, result.Target}
+	for i, step := range steps {
+		switch {
+		case failed < 0 || i < failed:
+			step.Status = StatusOK
+			step.MS = milliseconds(durations[i])
+		case i == failed:
+			step.Status = StatusFail
+		default:
+			step.Status = StatusSkipped
+		}
+	}
+	report.RTTMS = report.Dial.MS
+	return report
+}
+
+// selfTestStep 返回失败阶段所属的报告步骤：0 dial、1 handshake、2 connect
+func selfTestStep(stage string) int {
+	switch stage {
+	case StageConnect:
+		return 0
+	case StageCipher, StageTunnel, StageTarget:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// milliseconds 返回以毫秒为单位的耗时，保留微秒精度
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
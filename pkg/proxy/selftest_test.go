@@ -0,0 +1,140 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"go-proxy-eins/pkg/proxy"
+)
+
+// jsonFields 将 v 编码为 JSON 后解码为对象，供检查字段名
+func jsonFields(t *testing.T, v any) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+// assertFields 检查对象的字段名恰好为 want
+func assertFields(t *testing.T, fields map[string]any, want ...string) {
+	t.Helper()
+	got := slices.Sorted(maps.Keys(fields))
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("JSON fields = %v, want %v", got, want)
+	}
+}
+
+// assertStep 检查自检报告中一个步骤的字段与状态
+func assertStep(t *testing.T, fields map[string]any, name, status string) {
+	t.Helper()
+	step, ok := fields[name].(map[string]any)
+	if !ok {
+		t.Fatalf("%s = %v, want an object", name, fields[name])
+	}
+	assertFields(t, step, "status", "ms")
+	if step["status"] != status {
+		t.Fatalf("%s.status = %v, want %s", name, step["status"], status)
+	}
+	if ms, _ := step["ms"].(float64); (status == proxy.StatusOK) != (ms > 0) {
+		t.Fatalf("%s.ms = %v with status %s", name, step["ms"], status)
+	}
+}
+
+func TestSelfTestReportJSON(t *testing.T) {
+	h := startHarness(t)
+	cfg := &proxy.LocalConfig{Server: h.Server.Addr().String(), Password: "proxytest"}
+
+	result, err := proxy.SelfTest(cfg, h.TargetAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := jsonFields(t, proxy.NewSelfTestReport(cfg.Server, h.TargetAddr(), result, err))
+	assertFields(t, fields, "server", "target", "dial", "handshake", "connect", "rtt_ms", "result")
+	for _, step := range []string{"dial", "handshake", "connect"} {
+		assertStep(t, fields, step, proxy.StatusOK)
+	}
+	if fields["result"] != proxy.StatusOK || fields["server"] != cfg.Server || fields["target"] != h.TargetAddr() {
+		t.Fatalf("report = %v", fields)
+	}
+	if fields["rtt_ms"] != fields["dial"].(map[string]any)["ms"] {
+		t.Fatalf("rtt_ms = %v, want the dial time", fields["rtt_ms"])
+	}
+}
+
+func TestSelfTestReportJSONFailure(t *testing.T) {
+	h := startHarness(t)
+
+	tests := []struct {
+		name   string
+		cfg    *proxy.LocalConfig
+		stage  string
+		status [3]string // dial、handshake、connect
+	}{
+		{"server unreachable", &proxy.LocalConfig{Server: closedAddr(t), Password: "proxytest"}, proxy.StageConnect,
+			[3]string{proxy.StatusFail, proxy.StatusSkipped, proxy.StatusSkipped}},
+		{"wrong password", &proxy.LocalConfig{Server: h.Server.Addr().String(), Password: "wrong"}, proxy.StageAuth,
+			[3]string{proxy.StatusOK, proxy.StatusFail, proxy.StatusSkipped}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := proxy.SelfTest(tt.cfg, h.TargetAddr())
+			var testErr *proxy.SelfTestError
+			if !errors.As(err, &testErr) || testErr.Stage != tt.stage {
+				t.Fatalf("err = %v, want stage %s", err, tt.stage)
+			}
+
+			fields := jsonFields(t, proxy.NewSelfTestReport(tt.cfg.Server, h.TargetAddr(), result, err))
+			assertFields(t, fields, "server", "target", "dial", "handshake", "connect", "rtt_ms", "result", "stage", "error")
+			for i, step := range []string{"dial", "handshake", "connect"} {
+				assertStep(t, fields, step, tt.status[i])
+			}
+			if fields["result"] != proxy.StatusFail || fields["stage"] != tt.stage || fields["error"] == "" {
+				t.Fatalf("report = %v", fields)
+			}
+		})
+	}
+
+	// 目标无法连接时前两步成功
+	cfg := &proxy.LocalConfig{Server: h.Server.Addr().String(), Password: "proxytest"}
+	target := closedAddr(t)
+	result, err := proxy.SelfTest(cfg, target)
+	fields := jsonFields(t, proxy.NewSelfTestReport(cfg.Server, target, result, err))
+	assertStep(t, fields, "dial", proxy.StatusOK)
+	assertStep(t, fields, "handshake", proxy.StatusOK)
+	assertStep(t, fields, "connect", proxy.StatusFail)
+	if fields["stage"] != proxy.StageTarget {
+		t.Fatalf("stage = %v, want %s", fields["stage"], proxy.StageTarget)
+	}
+}
+
+func TestBenchmarkReportJSON(t *testing.T) {
+	cfg := &proxy.ServerConfig{Compress: true}
+	fields := jsonFields(t, proxy.NewBenchmarkReport(cfg, 1<<20, &proxy.BenchmarkResult{Upload: 1e8, Download: 2e8}, nil))
+	assertFields(t, fields, "cipher", "obfuscate", "compress", "plaintext", "size", "upload", "download", "result")
+	for _, name := range []string{"upload", "download"} {
+		step, ok := fields[name].(map[string]any)
+		if !ok {
+			t.Fatalf("%s = %v, want an object", name, fields[name])
+		}
+		assertFields(t, step, "ms", "mb_per_s")
+	}
+	if fields["result"] != proxy.StatusOK || fields["compress"] != true || fields["cipher"] != cfg.GetCipher() {
+		t.Fatalf("report = %v", fields)
+	}
+
+	fields = jsonFields(t, proxy.NewBenchmarkReport(cfg, 1<<20, nil, errors.New("boom")))
+	assertFields(t, fields, "cipher", "obfuscate", "compress", "plaintext", "size", "upload", "download", "result", "error")
+	if fields["upload"] != nil || fields["download"] != nil || fields["result"] != proxy.StatusFail || fields["error"] != "boom" {
+		t.Fatalf("failed report = %v", fields)
+	}
+}
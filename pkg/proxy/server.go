@@ -0,0 +1,875 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/conntrack"
+	"go-proxy-eins/internal/httpconnect"
+	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/ratelimit"
+	"go-proxy-eins/internal/relay"
+	"go-proxy-eins/internal/sniff"
+	"go-proxy-eins/internal/socks5"
+)
+
+// maxRetryDelay 上游拨号两次重试之间的最长等待时间
+const maxRetryDelay = 2 * time.Second
+
+// Server 代理服务端
+type Server struct {
+	cfg *ServerConfig
+
+	// upstreamHops 上游 SOCKS5 代理链，未配置或上游为 HTTP 代理时为空
+	upstreamHops []socks5.Hop
+
+	// upstreamHTTPHops 上游 HTTP CONNECT 代理链，upstream_type 为 http 时使用
+	upstreamHTTPHops []httpconnect.Hop
+
+	// upstreamPool 上游 SOCKS5 连接池，未启用时为 nil
+	upstreamPool *socks5.Pool
+
+	// sem 并发连接数限制，未启用时为 nil
+	sem chan struct{}
+
+	// pending 尚未收完握手数据的连接数限制，未启用时为 nil
+	pending chan struct{}
+
+	// derive 同时进行的 Argon2 密钥派生数限制，未启用时为 nil
+	derive chan struct{}
+
+	// connLimiter 单个客户端 IP 的连接速率限制，未启用时为 nil
+	connLimiter *ratelimit.KeyedLimiter
+
+	// dialer 直连目标使用的拨号器，双栈目标按 happy eyeballs 并行尝试
+	dialer *net.Dialer
+	// resolver 解析直连目标与客户端解析请求使用的解析器，未配置 resolver 时为系统解析器
+	resolver *net.Resolver
+
+	// health 健康检查 HTTP 服务，未启用时为 nil
+	health *http.Server
+	// healthListener 健康检查监听器，未启用时为 nil
+	healthListener net.Listener
+
+	// conns 正在转发的隧道，供管理接口查看与关闭
+	conns *conntrack.Registry
+	// adminListener 管理接口监听器，未启用时为 nil
+	adminListener net.Listener
+
+	// base 主密码与 credentials 中的凭据
+	base *credentials
+	// users users_file 中的用户，未配置时为 nil
+	users *userStore
+
+	// identity 服务端身份密钥，未配置 identity_key 时为 nil
+	identity ed25519.PrivateKey
+
+	// fronts front_map 目标改写，未配置时为 nil
+	fronts frontMap
+
+	listener  net.Listener
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	active    atomic.Int64
+}
+
+// NewServer 根据配置创建服务端
+func NewServer(cfg *ServerConfig) (*Server, error) {
+	s := &Server{
+		cfg:    cfg,
+		closed: make(chan struct{}),
+		conns:  conntrack.NewRegistry(),
+		dialer: &net.Dialer{
+			Timeout:       cfg.GetConnectTimeout(),
+			FallbackDelay: cfg.GetDialFallbackDelay(),
+		},
+	}
+
+	if cfg.SourceAddr != "" {
+		localAddr, err := sourceAddr(cfg.SourceAddr)
+		if err != nil {
+			return nil, err
+		}
+		s.dialer.LocalAddr = localAddr
+	}
+
+	s.resolver = net.DefaultResolver
+	resolver, err := newResolver(cfg.Resolver, cfg.GetHandshakeTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if resolver != nil {
+		s.resolver = resolver
+		s.dialer.Resolver = resolver
+	}
+
+	s.base = serverCredentials(cfg)
+	if cfg.UsersFile != "" {
+		users, err := newUserStore(cfg.UsersFile, s.base, cfg.GetCipher())
+		if err != nil {
+			return nil, err
+		}
+		s.users = users
+	}
+
+	fronts, err := newFrontMap(cfg.FrontMap)
+	if err != nil {
+		return nil, err
+	}
+	s.fronts = fronts
+
+	if cfg.IdentityKey != "" {
+		identity, err := loadIdentity(cfg.IdentityKey)
+		if err != nil {
+			return nil, err
+		}
+		s.identity = identity
+	}
+
+	// 解析上游代理链
+	for _, proxy := range cfg.UpstreamProxy {
+		if cfg.IsHTTPUpstream() {
+			hop, err := httpconnect.ParseHop(proxy, cfg.UpstreamUsername, cfg.UpstreamPassword)
+			if err != nil {
+				return nil, fmt.Errorf("invalid upstream proxy %q: %w", proxy, err)
+			}
+			s.upstreamHTTPHops = append(s.upstreamHTTPHops, hop)
+			continue
+		}
+		hop, err := socks5.ParseHop(proxy, cfg.UpstreamUsername, cfg.UpstreamPassword)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream proxy %q: %w", proxy, err)
+		}
+		s.upstreamHops = append(s.upstreamHops, hop)
+	}
+
+	// 并发连接数限制（可选）
+	if cfg.MaxConnections > 0 {
+		s.sem = make(chan struct{}, cfg.MaxConnections)
+	}
+	if cfg.MaxPendingHandshakes > 0 {
+		s.pending = make(chan struct{}, cfg.MaxPendingHandshakes)
+	}
+	if cfg.MaxConcurrentHandshakes > 0 {
+		s.derive = make(chan struct{}, cfg.MaxConcurrentHandshakes)
+	}
+
+	// 单个客户端 IP 的连接速率限制（可选）
+	if cfg.ConnRateLimit > 0 {
+		s.connLimiter = ratelimit.NewKeyedLimiter(cfg.ConnRateLimit, cfg.ConnRateBurst)
+	}
+
+	return s, nil
+}
+
+// Start 监听端口并在后台接受连接，ctx 取消时停止接受新连接
+func (s *Server) Start(ctx context.Context) error {
+	addr := s.cfg.GetListenAddr()
+	if addr == "" {
+		return fmt.Errorf("invalid listen_addr %q", s.cfg.ListenAddr)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	return s.StartListener(ctx, listener)
+}
+
+// StartListener 在已打开的监听器上接受连接，用于 systemd socket activation 等场景
+func (s *Server) StartListener(ctx context.Context, listener net.Listener) error {
+	if s.cfg.HealthAddr != "" {
+		if err := s.startHealth(s.cfg.HealthAddr); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	if s.cfg.AdminAddr != "" {
+		adminListener, err := startAdmin(s.cfg.AdminAddr, s.conns)
+		if err != nil {
+			s.stopHealth()
+			listener.Close()
+			return err
+		}
+		s.adminListener = adminListener
+	}
+
+	s.listener = listener
+
+	logger.Log.Info("Server is running", "address", listener.Addr())
+
+	if s.cfg.Plaintext {
+		logger.Log.Warn("PLAINTEXT mode enabled: tunnel traffic is NOT encrypted or integrity-protected and can be read or altered by anyone on the network path; use only on loopback or trusted networks")
+	}
+
+	if s.cfg.ProxyProtocol {
+		logger.Log.Info("PROXY protocol enabled, connections without a valid header will be rejected")
+	}
+
+	if s.identity != nil {
+		logger.Log.Info("Server identity enabled", "fingerprint", s.Fingerprint())
+	}
+
+	if n := len(s.cfg.Credentials); n > 0 {
+		logger.Log.Info("Additional credentials accepted", "count", n)
+	}
+	if s.users != nil {
+		logger.Log.Info("Users file loaded", "path", s.cfg.UsersFile, "users", s.users.credentials().Users)
+		go s.users.watch(s.closed)
+	}
+
+	// 上游连接池（可选，仅支持单个上游 SOCKS5 代理）
+	if len(s.upstreamHTTPHops) > 0 && s.cfg.UpstreamPoolSize > 0 {
+		logger.Log.Warn("Upstream connection pool is not supported with HTTP upstream proxies, disabled")
+	} else if len(s.upstreamHops) == 1 && s.cfg.UpstreamPoolSize > 0 {
+		hop := s.upstreamHops[0]
+		s.upstreamPool = socks5.NewPool(
+			hop.Addr,
+			hop.Username,
+			hop.Password,
+			s.cfg.UpstreamPoolSize,
+			s.cfg.GetUpstreamPoolIdle(),
+			s.cfg.GetConnectTimeout(),
+		)
+		logger.Log.Info("Upstream connection pool enabled", "size", s.cfg.UpstreamPoolSize)
+	} else if len(s.upstreamHops) > 1 && s.cfg.UpstreamPoolSize > 0 {
+		logger.Log.Warn("Upstream connection pool is not supported with proxy chains, disabled")
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.closed:
+		}
+	}()
+
+	go s.acceptLoop()
+	return nil
+}
+
+// Addr 返回实际监听地址，Start 之前为 nil；port 为 0 时由系统分配端口，可通过它取得
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Fingerprint 返回身份密钥的公钥指纹，供客户端配置 server_fingerprint；未配置 identity_key 时为空
+func (s *Server) Fingerprint() string {
+	if s.identity == nil {
+		return ""
+	}
+	return protocol.Fingerprint(s.identity.Public().(ed25519.PublicKey))
+}
+
+// ActiveConnections 返回当前正在处理的连接数
+func (s *Server) ActiveConnections() int64 {
+	return s.active.Load()
+}
+
+// Close 停止接受新连接并关闭健康检查与管理接口，已建立的连接不受影响
+func (s *Server) Close() error {
+	err := s.stopAccepting()
+	s.stopHealth()
+	s.stopAdmin()
+	return err
+}
+
+// Shutdown 停止接受新连接并等待进行中的连接结束，最长等待 grace
+// 所有连接在期限内结束时返回 true；等待期间 /readyz 返回 503，管理接口仍可关闭剩余的连接
+func (s *Server) Shutdown(grace time.Duration) bool {
+	s.stopAccepting()
+	defer s.stopHealth()
+	defer s.stopAdmin()
+
+	inFlight := s.active.Load()
+	logger.Log.Info("Draining connections", "active", inFlight, "grace", grace)
+	if waitTimeout(&s.wg, grace) {
+		logger.Log.Info("All connections drained", "drained", inFlight)
+		return true
+	}
+
+	remaining := s.active.Load()
+	logger.Log.Warn("Shutdown grace period expired, closing remaining connections",
+		"drained", inFlight-remaining,
+		"remaining", remaining)
+	return false
+}
+
+// stopAccepting 关闭主监听器和上游连接池，可重复调用
+func (s *Server) stopAccepting() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.listener != nil {
+			err = s.listener.Close()
+		}
+		if s.upstreamPool != nil {
+			s.upstreamPool.Close()
+		}
+	})
+	return err
+}
+
+// acceptLoop 接受连接直到监听器关闭
+func (s *Server) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+				logger.Log.Warn("Failed to accept connection", "error", err)
+				continue
+			}
+		}
+
+		// 在握手（Argon2 密钥派生）之前丢弃超速的连接；使用 PROXY protocol 时按头部中的真实地址在 acceptProxied 中限制
+		if s.connLimiter != nil && !s.cfg.ProxyProtocol {
+			host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			if !s.connLimiter.Allow(host) {
+				logger.Log.Debug("Connection rate limit exceeded, rejecting", "remote", conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
+		}
+
+		if s.sem != nil {
+			select {
+			case s.sem <- struct{}{}:
+			default:
+				logger.Log.Warn("Connection limit reached, rejecting",
+					"remote", conn.RemoteAddr(),
+					"active", s.active.Load(),
+					"max", s.cfg.MaxConnections)
+				conn.Close()
+				continue
+			}
+		}
+
+		// 慢速发送握手的连接最多占用 auth_timeout，这里限制同时等待握手的连接数
+		if s.pending != nil {
+			select {
+			case s.pending <- struct{}{}:
+			default:
+				logger.Log.Warn("Too many pending handshakes, rejecting",
+					"remote", conn.RemoteAddr(),
+					"max", s.cfg.MaxPendingHandshakes)
+				conn.Close()
+				if s.sem != nil {
+					<-s.sem
+				}
+				continue
+			}
+		}
+
+		s.wg.Add(1)
+		s.active.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.active.Add(-1)
+			if s.sem != nil {
+				defer func() { <-s.sem }()
+			}
+			log := logger.WithConnID(logger.NewConnID())
+			if s.cfg.ProxyProtocol {
+				proxied, ok := s.acceptProxied(conn, log)
+				if !ok {
+					conn.Close()
+					if s.pending != nil {
+						<-s.pending
+					}
+					return
+				}
+				conn = proxied
+			}
+			s.handleConnection(conn, log)
+		}()
+	}
+}
+
+// credentials 返回握手时接受的凭据，未配置 users_file 时只有主密码与 credentials
+func (s *Server) credentials() *credentials {
+	if s.users != nil {
+		return s.users.credentials()
+	}
+	return s.base
+}
+
+// deriveWait 等待密钥派生名额的最长时间，超时后关闭连接
+const deriveWait = 2 * time.Second
+
+// acquireDerive 获取一个密钥派生名额，deriveWait 内没有空闲名额或服务端关闭时返回 false
+func (s *Server) acquireDerive() bool {
+	if s.derive == nil {
+		return true
+	}
+	select {
+	case s.derive <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(deriveWait)
+	defer timer.Stop()
+	select {
+	case s.derive <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-s.closed:
+		return false
+	}
+}
+
+// releaseDerive 归还 acquireDerive 获取的名额
+func (s *Server) releaseDerive() {
+	if s.derive != nil {
+		<-s.derive
+	}
+}
+
+// handleConnection 处理一个客户端连接
+func (s *Server) handleConnection(conn net.Conn, log *slog.Logger) {
+	cfg := s.cfg
+
+	relay.TuneTCP(conn, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+	if !cfg.LowLatency {
+		conn = relay.NewBatchConn(conn, relay.BatchDelay).WithWriteTimeout(cfg.GetWriteTimeout())
+	}
+	defer conn.Close()
+
+	// 设置超时
+	var deadline time.Time
+	if cfg.GetHandshakeTimeout() > 0 {
+		deadline = time.Now().Add(cfg.GetHandshakeTimeout())
+		conn.SetDeadline(deadline)
+	}
+
+	log.Debug("New connection", "remote", conn.RemoteAddr())
+
+	// 1. 握手认证，握手数据必须在 auth_timeout 内收完，不受 handshake_timeout 影响
+	authDeadline := time.Now().Add(cfg.GetAuthTimeout())
+	if deadline.IsZero() || authDeadline.Before(deadline) {
+		conn.SetReadDeadline(authDeadline)
+	}
+	creds := s.credentials()
+	decoy := decoyResponse(cfg.Decoy)
+	opts := protocol.HandshakeOptions{Obfuscate: cfg.Obfuscate, Compress: cfg.Compress, Plaintext: cfg.Plaintext, Cipher: cfg.GetCipher(), RekeyAfter: cfg.GetRekeyAfter(), Identity: s.identity, QuietAuthFailure: decoy != nil}
+	salt, index, opts, err := protocol.ServerHandshakeCredentials(conn, conn, creds.Credentials, opts)
+	if s.pending != nil {
+		<-s.pending
+	}
+	conn.SetReadDeadline(deadline)
+	if err != nil {
+		log.Warn("Handshake failed", "remote", conn.RemoteAddr(), "error", err)
+		if decoy != nil && errors.Is(err, protocol.ErrUnauthenticated) {
+			sendDecoy(conn, decoy)
+		}
+		return
+	}
+
+	if id := creds.IDs[index]; id != "" {
+		log = log.With("user", id)
+	}
+	cred := creds.Credentials[index]
+	log.Debug("Handshake successful", "remote", conn.RemoteAddr(), "cipher", cred.Cipher)
+
+	// 2. 创建加密器并包装连接（加密 + 可选混淆），密钥派生需要等待空闲的名额
+	// plaintext 时不派生密钥，也不包装连接
+	if !opts.Plaintext && !s.acquireDerive() {
+		log.Warn("Too many concurrent key derivations, rejecting",
+			"remote", conn.RemoteAddr(),
+			"max", cfg.MaxConcurrentHandshakes)
+		return
+	}
+	secureReader, secureWriter, err := protocol.WrapTunnel(conn, conn, cred.Password, salt, opts, cipher.RoleServer)
+	if !opts.Plaintext {
+		s.releaseDerive()
+	}
+	if err != nil {
+		log.Error("Failed to create cipher", "error", err)
+		return
+	}
+
+	// 3. 读取目标地址
+	cmd, targetAddr, err := protocol.ReadRequest(secureReader)
+	if err != nil {
+		log.Error("Failed to read target address", "error", err)
+		return
+	}
+
+	// 域名解析请求：应答后关闭连接
+	switch cmd {
+	case protocol.CmdResolve:
+		s.handleResolve(secureWriter, targetAddr, log)
+		return
+	case protocol.CmdResolvePTR:
+		s.handleResolvePTR(secureWriter, targetAddr, log)
+		return
+	case protocol.CmdMux:
+		s.serveMux(conn, secureReader, secureWriter, targetAddr, opts.Version, log)
+		return
+	}
+
+	s.serveTarget(conn, secureReader, secureWriter, cfg.Compress, opts.Version, targetAddr, log)
+}
+
+// serveTarget 连接 targetAddr 并转发数据，conn 是客户端连接或多路复用会话中的一个流
+// clientVersion 为客户端的协议版本，决定连接成功的应答是否附带本地地址
+func (s *Server) serveTarget(conn net.Conn, secureReader io.Reader, secureWriter io.Writer, compress bool, clientVersion byte, targetAddr string, log *slog.Logger) {
+	cfg := s.cfg
+
+	if err := protocol.CheckTargetLen(targetAddr, cfg.GetMaxTargetLen()); err != nil {
+		log.Warn("Target address rejected", "client", conn.RemoteAddr(), "error", err)
+		secureWriter.Write([]byte{protocol.ConnectForbidden})
+		return
+	}
+
+	// 解密失步或恶意客户端可能发来无效地址，拨号前先校验
+	_, port, err := protocol.ParseTarget(targetAddr)
+	if err != nil {
+		log.Warn("Invalid target address", "target", targetAddr, "client", conn.RemoteAddr(), "error", err)
+		secureWriter.Write([]byte{protocol.ConnectFailed})
+		return
+	}
+
+	// front_map 改写实际连接的目标，之后的端口过滤、拨号与日志都使用改写后的地址
+	if backend, ok := s.fronts.rewrite(targetAddr); ok {
+		log.Debug("Target rewritten by front_map", "front", targetAddr, "target", backend)
+		targetAddr = backend
+		if _, port, err = protocol.ParseTarget(targetAddr); err != nil {
+			log.Warn("Invalid front_map backend", "target", targetAddr, "error", err)
+			secureWriter.Write([]byte{protocol.ConnectFailed})
+			return
+		}
+	}
+
+	if !cfg.IsPortAllowed(port) {
+		log.Warn("Target port not allowed", "target", targetAddr, "client", conn.RemoteAddr())
+		secureWriter.Write([]byte{protocol.ConnectBlocked})
+		return
+	}
+
+	log.Info("Connecting to target", "target", targetAddr, "client", conn.RemoteAddr())
+
+	// 配置了 connect_timeout 时连接目标只受该超时限制，连接期间不使用客户端连接上剩余的握手时间；
+	// 连接结束后重新给应答客户端留出 handshake_timeout
+	if cfg.ConnectTimeout > 0 {
+		conn.SetDeadline(time.Time{})
+	}
+
+	// 4. 连接目标服务器（通过上游 SOCKS5 代理或直连）
+	var target net.Conn
+	if cfg.HasUpstreamProxy() {
+		// 通过上游 SOCKS5 代理连接
+		log.Debug("Using upstream proxy", "type", cfg.UpstreamType, "proxy", s.upstreamChainString(), "target", targetAddr)
+		target, err = s.dialUpstream(targetAddr, log)
+	} else {
+		// 直接连接目标
+		target, err = s.dialer.Dial(cfg.GetDialNetwork(), targetAddr)
+	}
+	if cfg.ConnectTimeout > 0 && cfg.GetHandshakeTimeout() > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.GetHandshakeTimeout()))
+	}
+	if err != nil && cfg.HasUpstreamProxy() {
+		log.Warn("Failed to connect via upstream proxy", "proxy", s.upstreamChainString(), "target", targetAddr, "error", err)
+		secureWriter.Write([]byte{upstreamConnectStatus(err)})
+		return
+	}
+	if err != nil {
+		log.Warn("Failed to connect to target", "target", targetAddr, "error", err)
+		secureWriter.Write([]byte{connectStatus(err)})
+		return
+	}
+	defer target.Close()
+	relay.TuneTCP(target, cfg.GetTCPKeepAlive(), cfg.GetTCPNoDelay(), log)
+
+	// 握手阶段结束，转发阶段使用空闲超时
+	idle := relay.NewIdleTimeout(cfg.GetIdleTimeout(), conn, target).WithWriteTimeout(cfg.GetWriteTimeout())
+
+	// 5. 通知客户端连接成功，附带到目标的套接字的本地地址；经上游代理连接时出站地址在上游，不发送
+	var bound net.Addr
+	if !cfg.HasUpstreamProxy() {
+		bound = target.LocalAddr()
+	}
+	if err := protocol.WriteConnectOK(secureWriter, clientVersion, bound); err != nil {
+		log.Error("Failed to send success response", "error", err)
+		return
+	}
+
+	log.Debug("Connection established", "target", targetAddr)
+
+	// 多路复用的流不是 SecureReader，由会话统一发送 keepalive
+	protocol.StartKeepalive(secureReader, secureWriter, cfg.GetKeepaliveInterval(), cfg.GetKeepaliveTimeout(), func() {
+		log.Warn("Keepalive timeout, closing tunnel", "client", conn.RemoteAddr(), "target", targetAddr)
+		conn.Close()
+	})
+
+	tracked := s.conns.Add("tunnel", conn.RemoteAddr(), targetAddr, conn, target)
+	defer tracked.Remove()
+
+	// 压缩只用于转发阶段，位于加密层之上
+	var tunnelReader io.Reader = secureReader
+	var tunnelWriter io.Writer = secureWriter
+	if compress {
+		tunnelReader = protocol.NewCompressedReader(secureReader)
+		tunnelWriter = protocol.NewCompressedWriter(secureWriter)
+	}
+
+	upSrc := tunnelReader
+	if cfg.Sniff {
+		upSrc = sniff.NewReader(tunnelReader, log.With("target", targetAddr))
+	}
+
+	// 6. 双向转发数据，一端半关闭时通知另一端
+	up := &relay.Stream{Name: "up", Dst: ratelimit.NewWriter(idle.Writer(target, target), cfg.GetRateLimit()), Src: idle.Reader(upSrc), Peer: target, Count: &tracked.BytesUp}
+	down := &relay.Stream{Name: "down", Dst: ratelimit.NewWriter(idle.Writer(tunnelWriter, conn), cfg.GetRateLimit()), Src: idle.Reader(target), Peer: tunnelWriter, Count: &tracked.BytesDown}
+	relay.Join(up, down, func() {
+		conn.Close()
+		target.Close()
+	})
+	log.Debug("Transfer ended", relay.LogAttrs(up, down)...)
+
+	log.Debug("Connection closed", "target", targetAddr)
+}
+
+// handleResolve 为客户端解析域名，结果通过加密通道返回
+func (s *Server) handleResolve(w io.Writer, host string, log *slog.Logger) {
+	log.Debug("Resolve request", "host", host)
+
+	ctx := context.Background()
+	if timeout := s.cfg.GetHandshakeTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	addrs, err := s.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		log.Debug("Resolve failed", "host", host, "error", err)
+		protocol.WriteResolveReply(w, resolveStatus(err), nil)
+		return
+	}
+
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	if err := protocol.WriteResolveReply(w, protocol.ResolveOK, ips); err != nil {
+		log.Debug("Failed to send resolve reply", "error", err)
+	}
+}
+
+// handleResolvePTR 为客户端反向解析 IP 地址
+func (s *Server) handleResolvePTR(w io.Writer, addr string, log *slog.Logger) {
+	log.Debug("Reverse resolve request", "addr", addr)
+
+	if net.ParseIP(addr) == nil {
+		log.Debug("Invalid reverse resolve address", "addr", addr)
+		protocol.WriteResolvePTRReply(w, protocol.ResolveFailed, nil)
+		return
+	}
+
+	ctx := context.Background()
+	if timeout := s.cfg.GetHandshakeTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	names, err := s.resolver.LookupAddr(ctx, addr)
+	if err != nil {
+		log.Debug("Reverse resolve failed", "addr", addr, "error", err)
+		protocol.WriteResolvePTRReply(w, resolveStatus(err), nil)
+		return
+	}
+	if err := protocol.WriteResolvePTRReply(w, protocol.ResolveOK, names); err != nil {
+		log.Debug("Failed to send resolve reply", "error", err)
+	}
+}
+
+// resolveStatus 将解析错误转换为应答状态
+func resolveStatus(err error) byte {
+	var dnsErr *net.DNSError
+	errors.As(err, &dnsErr)
+	switch {
+	case dnsErr != nil && dnsErr.IsNotFound:
+		return protocol.ResolveNXDomain
+	case dnsErr != nil && dnsErr.IsTimeout, errors.Is(err, context.DeadlineExceeded):
+		return protocol.ResolveTimeout
+	default:
+		return protocol.ResolveFailed
+	}
+}
+
+// connectStatus 根据直连目标失败的原因选择发给客户端的应答状态
+func connectStatus(err error) byte {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return protocol.ConnectDNSFailed
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return protocol.ConnectRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return protocol.ConnectNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return protocol.ConnectHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return protocol.ConnectTimeout
+	default:
+		return protocol.ConnectFailed
+	}
+}
+
+// dialUpstream 通过上游代理连接目标，连接级失败时按指数退避加随机抖动重试
+// 上游代理明确拒绝的请求（应答码非成功、认证失败）重试也不会成功，直接返回
+func (s *Server) dialUpstream(targetAddr string, log *slog.Logger) (net.Conn, error) {
+	cfg := s.cfg
+	for attempt := 0; ; attempt++ {
+		var conn net.Conn
+		var err error
+		switch {
+		case s.upstreamPool != nil:
+			conn, err = s.upstreamPool.Dial(targetAddr)
+		case len(s.upstreamHTTPHops) > 0:
+			conn, err = httpconnect.DialChain(s.upstreamHTTPHops, targetAddr, cfg.GetConnectTimeout())
+		default:
+			conn, err = socks5.DialChain(s.upstreamHops, targetAddr, cfg.GetConnectTimeout())
+		}
+		if err == nil || attempt >= cfg.UpstreamRetries || !upstreamRetryable(err) {
+			return conn, err
+		}
+
+		delay := retryBackoff(cfg.GetUpstreamRetryDelay(), attempt)
+		log.Debug("Upstream proxy dial failed, retrying", "proxy", s.upstreamChainString(), "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-s.closed:
+			return nil, err
+		}
+	}
+}
+
+// upstreamRetryable 判断上游拨号失败是否值得重试
+// 上游代理给出的失败应答与认证错误是确定的结果，其余（连接失败、握手中途断开、超时）可能是上游暂时过载
+func upstreamRetryable(err error) bool {
+	var replyErr *socks5.ReplyError
+	var statusErr *httpconnect.StatusError
+	switch {
+	case errors.As(err, &replyErr),
+		errors.As(err, &statusErr),
+		errors.Is(err, socks5.ErrAuthRejected),
+		errors.Is(err, socks5.ErrCredentialsRequired),
+		errors.Is(err, socks5.ErrGSSAPIRequired):
+		return false
+	default:
+		return true
+	}
+}
+
+// retryBackoff 返回第 attempt 次重试（从 0 开始）前的等待时间：base 每次翻倍，不超过 maxRetryDelay，
+// 取其一半到全部之间的随机值，避免大量连接在上游恢复时同时重试
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// upstreamConnectStatus 根据上游代理的应答码选择应答状态
+// 无法连接上游代理本身属于服务端的问题，不代表目标的状态
+func upstreamConnectStatus(err error) byte {
+	var statusErr *httpconnect.StatusError
+	if errors.As(err, &statusErr) {
+		return httpUpstreamConnectStatus(statusErr.Code)
+	}
+
+	var replyErr *socks5.ReplyError
+	if !errors.As(err, &replyErr) {
+		return protocol.ConnectFailed
+	}
+	switch replyErr.Code {
+	case socks5.ReplyConnectionRefused:
+		return protocol.ConnectRefused
+	case socks5.ReplyNetworkUnreachable:
+		return protocol.ConnectNetUnreachable
+	case socks5.ReplyHostUnreachable:
+		return protocol.ConnectHostUnreachable
+	case socks5.ReplyTTLExpired:
+		return protocol.ConnectTimeout
+	case socks5.ReplyConnectionNotAllowed:
+		return protocol.ConnectForbidden
+	default:
+		return protocol.ConnectFailed
+	}
+}
+
+// httpUpstreamConnectStatus 根据上游 HTTP 代理对 CONNECT 的应答状态码选择应答状态
+// HTTP 代理通常只用 502 表示各种连接失败，无法进一步区分
+func httpUpstreamConnectStatus(code int) byte {
+	switch code {
+	case http.StatusForbidden:
+		return protocol.ConnectForbidden
+	case http.StatusGatewayTimeout:
+		return protocol.ConnectTimeout
+	default:
+		return protocol.ConnectFailed
+	}
+}
+
+// sourceAddr 解析直连目标使用的源地址，并确认它属于本机网卡
+func sourceAddr(addr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source address %q: not an IP address", addr)
+	}
+
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return &net.TCPAddr{IP: ip}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid source address %s: not assigned to any local interface", addr)
+}
+
+// upstreamChainString 返回上游代理链的地址描述（不含凭据），用于日志
+func (s *Server) upstreamChainString() string {
+	var addrs []string
+	for _, hop := range s.upstreamHops {
+		addrs = append(addrs, hop.Addr)
+	}
+	for _, hop := range s.upstreamHTTPHops {
+		addrs = append(addrs, hop.Addr)
+	}
+	return strings.Join(addrs, " -> ")
+}
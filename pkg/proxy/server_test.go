@@ -0,0 +1,422 @@
+package proxy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/config"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/socks5"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// startHarness 启动完整链路，测试结束时关闭
+func startHarness(t *testing.T, opts ...proxytest.Option) *proxytest.Harness {
+	t.Helper()
+	h, err := proxytest.NewHarness(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+// eventually 在 5 秒内反复调用 fn 直到其返回 nil
+func eventually(t *testing.T, fn func() error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// requestTarget 直接与服务端握手并请求连接 target，返回服务端的连接应答状态
+func requestTarget(t *testing.T, serverAddr, password, target string) byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	salt, opts, err := protocol.ClientHandshake(conn, password, protocol.HandshakeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := protocol.WrapTunnel(conn, conn, password, salt, opts, cipher.RoleClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.WriteTarget(w, target); err != nil {
+		t.Fatal(err)
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(r, status); err != nil {
+		t.Fatal(err)
+	}
+	return status[0]
+}
+
+// closedByPeer 判断对端是否在 wait 内关闭了连接
+func closedByPeer(conn net.Conn, wait time.Duration) bool {
+	conn.SetReadDeadline(time.Now().Add(wait))
+	_, err := conn.Read(make([]byte, 1))
+	return err != nil && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	const limit = 2
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.MaxConnections = limit
+	}))
+	addr := h.Server.Addr().String()
+
+	// 占满名额的连接不发送握手，一直占用直到关闭
+	held := make([]net.Conn, limit)
+	for i := range held {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		held[i] = conn
+	}
+	eventually(t, func() error {
+		if n := h.Server.ActiveConnections(); n != limit {
+			return errors.New("held connections not yet accepted")
+		}
+		return nil
+	})
+
+	for range 3 {
+		extra, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !closedByPeer(extra, 2*time.Second) {
+			t.Fatal("connection beyond max_connections was not rejected")
+		}
+		extra.Close()
+	}
+	for _, conn := range held {
+		if closedByPeer(conn, 50*time.Millisecond) {
+			t.Fatal("connection within max_connections was closed")
+		}
+	}
+	if err := h.RoundTrip([]byte("over the limit")); err == nil {
+		t.Fatal("tunnel succeeded while the server was at max_connections")
+	}
+
+	// 释放一个名额后隧道恢复可用
+	held[0].Close()
+	eventually(t, func() error {
+		return h.RoundTrip([]byte("after release"))
+	})
+}
+
+func TestServerActiveConnectionsDrain(t *testing.T) {
+	h := startHarness(t)
+
+	conn, err := h.DialTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.Server.ActiveConnections(); n != 1 {
+		t.Fatalf("active connections = %d, want 1", n)
+	}
+
+	conn.Close()
+	eventually(t, func() error {
+		if n := h.Server.ActiveConnections(); n != 0 {
+			return errors.New("tunnel still counted as active after close")
+		}
+		return nil
+	})
+}
+
+func TestServerDropsSlowHandshake(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.Timeout = 60
+		server.AuthTimeout = 1
+	}))
+
+	conn, err := net.Dial("tcp", h.Server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 每 100ms 发送 1 字节，握手数据永远发不完，连接应在 auth_timeout 后被关闭而不是等到 timeout
+	start := time.Now()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := conn.Write([]byte{protocol.ProtocolVersion}); err != nil {
+			break
+		}
+		if closedByPeer(conn, time.Millisecond) {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("trickling client was not dropped")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatalf("client dropped after %v, before auth_timeout", elapsed)
+	}
+	eventually(t, func() error {
+		if n := h.Server.ActiveConnections(); n != 0 {
+			return errors.New("slow handshake still counted as active")
+		}
+		return nil
+	})
+}
+
+func TestServerMaxPendingHandshakes(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.MaxPendingHandshakes = 1
+	}))
+	addr := h.Server.Addr().String()
+
+	// 不发送握手的连接占用唯一的名额
+	held, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	eventually(t, func() error {
+		if n := h.Server.ActiveConnections(); n != 1 {
+			return errors.New("held connection not yet accepted")
+		}
+		return nil
+	})
+
+	extra, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extra.Close()
+	if !closedByPeer(extra, 2*time.Second) {
+		t.Fatal("connection beyond max_pending_handshakes was not rejected")
+	}
+
+	// 握手完成后释放名额，已建立的隧道不占用等待握手的名额
+	held.Close()
+	eventually(t, func() error {
+		return h.RoundTrip([]byte("after release"))
+	})
+	conn, err := h.DialTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.RoundTrip([]byte("second tunnel")); err != nil {
+		t.Fatalf("pending slot not released after handshake: %v", err)
+	}
+}
+
+// BenchmarkShortLivedTunnels 每次迭代通过完整链路打开一条隧道，往返少量数据后关闭
+func BenchmarkShortLivedTunnels(b *testing.B) {
+	h, err := proxytest.NewHarness()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer h.Close()
+
+	payload := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := h.RoundTrip(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestServerRejectsInvalidTarget(t *testing.T) {
+	h := startHarness(t)
+	addr := h.Server.Addr().String()
+
+	for _, target := range []string{
+		"no-port",
+		"example.com:",
+		"example.com:0",
+		"example.com:65536",
+		"example.com:http",
+		":443",
+		"bad host:443",
+		"[2001:db8::1:443",
+		"\x00\x01garbage",
+	} {
+		if status := requestTarget(t, addr, "proxytest", target); status != protocol.ConnectFailed {
+			t.Errorf("target %q: status = %d, want ConnectFailed", target, status)
+		}
+	}
+
+	// 有效目标仍然可以连接
+	if status := requestTarget(t, addr, "proxytest", h.TargetAddr()); status != protocol.ConnectOK {
+		t.Fatalf("valid target: status = %d, want ConnectOK", status)
+	}
+}
+
+func TestServerPortFilter(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.AllowedPorts = config.PortList{{Low: 1, High: 65535}}
+		server.BlockedPorts = config.PortList{{Low: 25, High: 25}, {Low: 6000, High: 6100}}
+	}))
+	addr := h.Server.Addr().String()
+
+	for _, target := range []string{"127.0.0.1:25", "127.0.0.1:6000", "127.0.0.1:6050", "example.com:6100"} {
+		if status := requestTarget(t, addr, "proxytest", target); status != protocol.ConnectBlocked {
+			t.Errorf("target %q: status = %d, want ConnectBlocked", target, status)
+		}
+	}
+	if status := requestTarget(t, addr, "proxytest", h.TargetAddr()); status != protocol.ConnectOK {
+		t.Fatalf("allowed target: status = %d, want ConnectOK", status)
+	}
+}
+
+func TestServerAllowedPortsOnly(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.AllowedPorts = config.PortList{{Low: 443, High: 443}}
+	}))
+
+	if status := requestTarget(t, h.Server.Addr().String(), "proxytest", h.TargetAddr()); status != protocol.ConnectBlocked {
+		t.Fatalf("port outside allowed_ports: status = %d, want ConnectBlocked", status)
+	}
+	if err := h.RoundTrip([]byte("hello")); err == nil {
+		t.Fatal("tunnel to a port outside allowed_ports succeeded")
+	}
+}
+
+// stuckTarget 启动接受连接后从不读取的目标服务，模拟 TCP 零窗口
+func stuckTarget(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestWriteTimeoutClosesStuckTunnel(t *testing.T) {
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.WriteTimeout = 1
+		local.WriteTimeout = 1
+	}))
+
+	conn, err := socks5.Dial(h.Local.SOCKS5Addr().String(), stuckTarget(t), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 持续写入直到两端的缓冲区填满，服务端写往目标的操作在 write_timeout 后失败并关闭隧道
+	go func() {
+		chunk := make([]byte, 64<<10)
+		for {
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read data from a target that never writes")
+	} else if isTimeout(err) {
+		t.Fatal("tunnel to a target that stopped reading was not closed")
+	}
+	eventually(t, func() error {
+		if n := h.Server.ActiveConnections(); n != 0 {
+			return fmt.Errorf("server still has %d connections", n)
+		}
+		return nil
+	})
+}
+
+func TestServerListensOnPortZero(t *testing.T) {
+	s, err := proxy.NewServer(&proxy.ServerConfig{
+		Password:   "test",
+		ListenAddr: "127.0.0.1",
+		Port:       0,
+		HealthAddr: "127.0.0.1:0",
+		AdminAddr:  "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr() != nil {
+		t.Fatalf("Addr() = %v before Start, want nil", s.Addr())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 端口由系统分配，返回的地址可以直接连接
+	for name, addr := range map[string]net.Addr{"listen": s.Addr(), "health": s.HealthAddr(), "admin": s.AdminAddr()} {
+		if addr == nil || addr.(*net.TCPAddr).Port == 0 {
+			t.Fatalf("%s address = %v, want the assigned port", name, addr)
+		}
+	}
+	if err := handshakeAs(s.Addr().String(), "test"); err != nil {
+		t.Fatalf("handshake with the reported address: %v", err)
+	}
+	for _, url := range []string{"http://" + s.HealthAddr().String() + "/healthz", "http://" + s.AdminAddr().String() + "/connections"} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s = %d, want 200", url, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerHealthAddrDisabled(t *testing.T) {
+	h := startHarness(t)
+	if addr := h.Server.HealthAddr(); addr != nil {
+		t.Fatalf("HealthAddr() = %v without health_addr, want nil", addr)
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/socks5"
+)
+
+func TestConnectStatus(t *testing.T) {
+	opErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want byte
+	}{
+		{"refused", opErr(syscall.ECONNREFUSED), protocol.ConnectRefused},
+		{"net unreachable", opErr(syscall.ENETUNREACH), protocol.ConnectNetUnreachable},
+		{"host unreachable", opErr(syscall.EHOSTUNREACH), protocol.ConnectHostUnreachable},
+		{"timeout", &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, protocol.ConnectTimeout},
+		{"dns", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "invalid.test", IsNotFound: true}}, protocol.ConnectDNSFailed},
+		{"wrapped", fmt.Errorf("dial target: %w", opErr(syscall.ECONNREFUSED)), protocol.ConnectRefused},
+		{"other", errors.New("something else"), protocol.ConnectFailed},
+	}
+	for _, tt := range tests {
+		if got := connectStatus(tt.err); got != tt.want {
+			t.Errorf("%s: connectStatus = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConnectStatusFromDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, err = net.Dial("tcp", addr)
+	if err == nil {
+		t.Fatal("dial to a closed port succeeded")
+	}
+	if got := connectStatus(err); got != protocol.ConnectRefused {
+		t.Fatalf("connectStatus(%v) = %d, want ConnectRefused", err, got)
+	}
+}
+
+func TestSocksReply(t *testing.T) {
+	tests := []struct {
+		status byte
+		want   byte
+	}{
+		{protocol.ConnectFailed, socks5.ReplyServerFailure},
+		{protocol.ConnectRefused, socks5.ReplyConnectionRefused},
+		{protocol.ConnectTimeout, socks5.ReplyHostUnreachable},
+		{protocol.ConnectBlocked, socks5.ReplyConnectionNotAllowed},
+		{protocol.ConnectDNSFailed, socks5.ReplyHostUnreachable},
+		{protocol.ConnectForbidden, socks5.ReplyConnectionNotAllowed},
+		{protocol.ConnectNetUnreachable, socks5.ReplyNetworkUnreachable},
+		{protocol.ConnectHostUnreachable, socks5.ReplyHostUnreachable},
+		{0xff, socks5.ReplyServerFailure}, // 新版本服务端的未知状态
+	}
+	for _, tt := range tests {
+		if got := socksReply(tt.status); got != tt.want {
+			t.Errorf("socksReply(%d) = %d, want %d", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package proxy_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/internal/socks5"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// withMaxTargetLen 设置一端的 max_target_len，另一端不限制
+func withMaxTargetLen(server, local int) proxytest.Option {
+	return proxytest.WithConfig(func(s *proxy.ServerConfig, l *proxy.LocalConfig) {
+		s.MaxTargetLen = server
+		l.MaxTargetLen = local
+	})
+}
+
+func TestMaxTargetLen(t *testing.T) {
+	// 回显目标的地址形如 127.0.0.1:port，长度只在运行时可知，上限按它设置
+	probe := startHarness(t)
+	n := len(probe.TargetAddr())
+
+	tests := []struct {
+		name          string
+		server, local int
+		ok            bool
+	}{
+		{"server at limit", n, 0, true},
+		{"server over limit", n - 1, 0, false},
+		{"local at limit", 0, n, true},
+		{"local over limit", 0, n - 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startHarness(t, withMaxTargetLen(tt.server, tt.local))
+			if len(h.TargetAddr()) != n {
+				t.Skipf("target address %s is not %d bytes", h.TargetAddr(), n)
+			}
+
+			conn, _, err := socks5.DialWithAuthEx(h.Local.SOCKS5Addr().String(), h.TargetAddr(), "", "", 10*time.Second)
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.Close()
+				return
+			}
+			var replyErr *socks5.ReplyError
+			if !errors.As(err, &replyErr) || replyErr.Code != socks5.ReplyConnectionNotAllowed {
+				t.Fatalf("err = %v, want connection not allowed", err)
+			}
+		})
+	}
+}
+
+func TestDialerMaxTargetLen(t *testing.T) {
+	h := startHarness(t)
+	n := len(h.TargetAddr())
+
+	for _, limit := range []int{n, n - 1} {
+		dialer, err := proxy.NewDialer(&proxy.LocalConfig{
+			Server:       h.Server.Addr().String(),
+			Password:     "proxytest",
+			Timeout:      10,
+			MaxTargetLen: limit,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dialer.Dial("tcp", h.TargetAddr())
+		if limit == n {
+			if err != nil {
+				t.Fatalf("limit %d: %v", limit, err)
+			}
+			conn.Close()
+			continue
+		}
+		if !errors.Is(err, protocol.ErrTargetTooLong) {
+			t.Fatalf("limit %d: err = %v, want ErrTargetTooLong before contacting the server", limit, err)
+		}
+	}
+}
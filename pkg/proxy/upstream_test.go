@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/socks5"
+)
+
+// flakyUpstream 测试用的上游 SOCKS5 代理：前 failures 个连接直接关闭，之后以 reply 应答 CONNECT
+// reply 为成功时不真正连接目标，应答后保持连接直到对端关闭
+func flakyUpstream(t *testing.T, failures int32, reply byte) (addr string, attempts *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	attempts = new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if attempts.Add(1) <= failures {
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				// 方法协商：[版本][方法数][方法...]，选择无需认证
+				header := make([]byte, 2)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+					return
+				}
+				conn.Write([]byte{socks5.Version5, socks5.AuthNone})
+				// 请求：[版本][命令][保留][IPv4][地址 4][端口 2]
+				if _, err := io.ReadFull(conn, make([]byte, 10)); err != nil {
+					return
+				}
+				conn.Write([]byte{socks5.Version5, reply, 0, socks5.AtypIPv4, 0, 0, 0, 0, 0, 0})
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String(), attempts
+}
+
+func newUpstreamServer(t *testing.T, upstream string, retries int) *Server {
+	t.Helper()
+	s, err := NewServer(&ServerConfig{
+		Password:           "test",
+		UpstreamProxy:      []string{upstream},
+		UpstreamRetries:    retries,
+		UpstreamRetryDelay: 10,
+		ConnectTimeout:     5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDialUpstreamRetriesConnectionFailures(t *testing.T) {
+	addr, attempts := flakyUpstream(t, 2, socks5.ReplySuccess)
+	s := newUpstreamServer(t, addr, 2)
+
+	conn, err := s.dialUpstream("192.0.2.1:80", logger.Log)
+	if err != nil {
+		t.Fatalf("dial after two failures: %v", err)
+	}
+	conn.Close()
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("%d attempts, want 3", n)
+	}
+}
+
+func TestDialUpstreamGivesUpAfterRetries(t *testing.T) {
+	addr, attempts := flakyUpstream(t, 3, socks5.ReplySuccess)
+	s := newUpstreamServer(t, addr, 2)
+
+	if _, err := s.dialUpstream("192.0.2.1:80", logger.Log); err == nil {
+		t.Fatal("dial succeeded although every attempt failed")
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("%d attempts, want 1 + 2 retries", n)
+	}
+}
+
+func TestDialUpstreamRefusalFailsFast(t *testing.T) {
+	addr, attempts := flakyUpstream(t, 0, socks5.ReplyHostUnreachable)
+	s := newUpstreamServer(t, addr, 2)
+
+	_, err := s.dialUpstream("192.0.2.1:80", logger.Log)
+	var replyErr *socks5.ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != socks5.ReplyHostUnreachable {
+		t.Fatalf("err = %v, want host unreachable reply", err)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("%d attempts, want no retry after a SOCKS5 refusal", n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt, want := range []time.Duration{base, 2 * base, 4 * base, 8 * base, 16 * base, maxRetryDelay, maxRetryDelay} {
+		for range 20 {
+			d := retryBackoff(base, attempt)
+			if d < want/2 || d > want {
+				t.Fatalf("attempt %d: backoff %v outside [%v, %v]", attempt, d, want/2, want)
+			}
+		}
+	}
+	if d := retryBackoff(0, 3); d != 0 {
+		t.Fatalf("zero base delay: backoff %v", d)
+	}
+}
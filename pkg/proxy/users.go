@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+
+	"go-proxy-eins/internal/logger"
+	"go-proxy-eins/internal/protocol"
+)
+
+// usersReloadDelay 收到 users_file 的变化通知后等待的时间，合并编辑器保存时的连续多次写入，避免读到写了一半的文件
+const usersReloadDelay = 100 * time.Millisecond
+
+// user users_file 中的一个用户
+type user struct {
+	ID       string
+	Password string
+}
+
+// credentials 握手时可用的全部凭据，Credentials 与 IDs 一一对应
+type credentials struct {
+	Credentials []protocol.Credential
+	IDs         []string
+	Users       int // users_file 中的用户数
+}
+
+// serverCredentials 返回主密码（如有）与 credentials 中的凭据，ID 为空
+func serverCredentials(cfg *ServerConfig) *credentials {
+	creds := &credentials{}
+	if cfg.Password != "" {
+		creds.Credentials = append(creds.Credentials, protocol.Credential{Password: cfg.Password, Cipher: cfg.GetCipher()})
+		creds.IDs = append(creds.IDs, "")
+	}
+	for _, c := range cfg.Credentials {
+		creds.Credentials = append(creds.Credentials, protocol.Credential{Password: c.Password, Cipher: c.GetCipher(cfg.GetCipher())})
+		creds.IDs = append(creds.IDs, "")
+	}
+	return creds
+}
+
+// userStore 保存 users_file 中的用户，重新加载时整体替换，握手使用替换时刻的快照
+type userStore struct {
+	path   string
+	base   *credentials // 主密码与 credentials，不能与用户密码重复
+	cipher string       // 用户使用的加密套件
+
+	current atomic.Pointer[credentials]
+	data    []byte // 上次加载的文件内容
+}
+
+// newUserStore 加载 users_file，文件不存在或内容无效时返回错误
+func newUserStore(path string, base *credentials, cipher string) (*userStore, error) {
+	u := &userStore{path: path, base: base, cipher: cipher}
+	if _, err := u.reload(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// credentials 返回当前可用的凭据，主密码与 credentials 排在最前，ID 为空
+func (u *userStore) credentials() *credentials {
+	return u.current.Load()
+}
+
+// reload 文件内容变化时重新加载，返回是否已替换
+// 文件内容无效时保留之前的用户列表
+func (u *userStore) reload() (bool, error) {
+	data, err := os.ReadFile(u.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read users file: %w", err)
+	}
+	if u.current.Load() != nil && bytes.Equal(data, u.data) {
+		return false, nil
+	}
+
+	users, err := parseUsers(u.path, data, u.base.Credentials)
+	if err != nil {
+		return false, err
+	}
+	u.data = data
+
+	creds := &credentials{
+		Credentials: append([]protocol.Credential(nil), u.base.Credentials...),
+		IDs:         append([]string(nil), u.base.IDs...),
+		Users:       len(users),
+	}
+	for _, usr := range users {
+		creds.Credentials = append(creds.Credentials, protocol.Credential{Password: usr.Password, Cipher: u.cipher})
+		creds.IDs = append(creds.IDs, usr.ID)
+	}
+	u.current.Store(creds)
+	return true, nil
+}
+
+// watch 用 fsnotify 监视文件变化并重新加载，直到 done 关闭
+// 监视的是文件所在目录：编辑器替换文件、配置管理工具原子重命名后，原文件的监视会失效
+// 删除的用户只是无法建立新连接，已建立的隧道不受影响
+func (u *userStore) watch(done <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Log.Error("Failed to watch users file, changes will not be reloaded", "path", u.path, "error", err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(u.path)); err != nil {
+		logger.Log.Error("Failed to watch users file, changes will not be reloaded", "path", u.path, "error", err)
+		return
+	}
+
+	name := filepath.Clean(u.path)
+	delay := time.NewTimer(usersReloadDelay)
+	delay.Stop()
+	defer delay.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// 只关心本文件的写入与创建（包括重命名到该路径），删除或移走时保留当前的用户列表
+			if filepath.Clean(event.Name) == name && event.Has(fsnotify.Write|fsnotify.Create) {
+				delay.Reset(usersReloadDelay)
+			}
+			continue
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Log.Warn("Users file watcher error", "path", u.path, "error", err)
+			continue
+		case <-delay.C:
+		}
+
+		changed, err := u.reload()
+		if err != nil {
+			logger.Log.Error("Failed to reload users file, keeping previous users", "path", u.path, "error", err)
+			continue
+		}
+		if changed {
+			logger.Log.Info("Users file reloaded", "path", u.path, "users", u.current.Load().Users)
+		}
+	}
+}
+
+// parseUsers 解析 users_file，格式为 {"用户 ID": "密码", ...}，扩展名为 .yaml 或 .yml 时按 YAML 解析（"用户 ID: 密码"）
+// 用户 ID 与密码不能为空；密码之间以及与主密码、credentials 不能重复，否则无法区分连接属于哪个用户
+func parseUsers(path string, data []byte, reserved []protocol.Credential) ([]user, error) {
+	var entries map[string]string
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &entries)
+	default:
+		err = json.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %w", path, err)
+	}
+
+	users := make([]user, 0, len(entries))
+	owner := make(map[string]string, len(entries))
+	server := make(map[string]bool, len(reserved))
+	for _, c := range reserved {
+		server[c.Password] = true
+	}
+	for id, pass := range entries {
+		if id == "" {
+			return nil, fmt.Errorf("users file %s: empty user id", path)
+		}
+		if pass == "" {
+			return nil, fmt.Errorf("users file %s: empty password for user %q", path, id)
+		}
+		if server[pass] {
+			return nil, fmt.Errorf("users file %s: user %q has the same password as the server password", path, id)
+		}
+		if other, ok := owner[pass]; ok {
+			return nil, fmt.Errorf("users file %s: users %q and %q have the same password", path, other, id)
+		}
+		owner[pass] = id
+		users = append(users, user{ID: id, Password: pass})
+	}
+
+	// 按 ID 排序，日志与握手尝试顺序稳定
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
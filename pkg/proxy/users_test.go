@@ -0,0 +1,146 @@
+package proxy_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-proxy-eins/internal/cipher"
+	"go-proxy-eins/internal/config"
+	"go-proxy-eins/internal/protocol"
+	"go-proxy-eins/pkg/proxy"
+	"go-proxy-eins/pkg/proxy/proxytest"
+)
+
+// handshakeAs 用 password 与服务端握手，返回握手错误
+func handshakeAs(serverAddr, password string) error {
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, _, err = protocol.ClientHandshake(conn, password, protocol.HandshakeOptions{})
+	return err
+}
+
+// writeUsers 替换 users_file 的内容
+func writeUsers(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUsersFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	writeUsers(t, path, `{"alice": "alice-password"}`)
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.UsersFile = path
+	}))
+	addr := h.Server.Addr().String()
+
+	if err := handshakeAs(addr, "alice-password"); err != nil {
+		t.Fatalf("alice: %v", err)
+	}
+	if err := handshakeAs(addr, "bob-password"); err == nil {
+		t.Fatal("bob authenticated before being added")
+	}
+
+	// 添加用户后不需要重启，收到文件变化通知后重新加载
+	writeUsers(t, path, `{"alice": "alice-password", "bob": "bob-password"}`)
+	eventually(t, func() error {
+		return handshakeAs(addr, "bob-password")
+	})
+	if err := handshakeAs(addr, "alice-password"); err != nil {
+		t.Fatalf("alice after reload: %v", err)
+	}
+
+	// 文件内容无效时保留之前的用户列表
+	writeUsers(t, path, `{"bob": "bob-password", "carol": "bob-password"}`)
+	time.Sleep(500 * time.Millisecond) // 等待重新加载失败
+	if err := handshakeAs(addr, "bob-password"); err != nil {
+		t.Fatalf("bob after an invalid users file: %v", err)
+	}
+
+	// 删除的用户无法再建立新连接
+	writeUsers(t, path, `{"bob": "bob-password"}`)
+	eventually(t, func() error {
+		if handshakeAs(addr, "alice-password") == nil {
+			return errors.New("removed user still authenticates")
+		}
+		return nil
+	})
+}
+
+func TestUsersFileYAMLReplacedByRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.yaml")
+	writeUsers(t, path, "alice: alice-password\n")
+	h := startHarness(t, proxytest.WithConfig(func(server *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server.UsersFile = path
+	}))
+	addr := h.Server.Addr().String()
+
+	if err := handshakeAs(addr, "alice-password"); err != nil {
+		t.Fatalf("alice: %v", err)
+	}
+
+	// 配置管理工具写入临时文件后原子重命名，替换了被监视的文件
+	tmp := filepath.Join(dir, "users.yaml.tmp")
+	writeUsers(t, tmp, "alice: alice-password\nbob: bob-password\n")
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() error {
+		return handshakeAs(addr, "bob-password")
+	})
+
+	// 替换后仍能检测到之后的修改
+	writeUsers(t, path, "bob: bob-password\n")
+	eventually(t, func() error {
+		if handshakeAs(addr, "alice-password") == nil {
+			return errors.New("removed user still authenticates")
+		}
+		return nil
+	})
+}
+
+func TestServerCredentials(t *testing.T) {
+	// 服务端改用新密码与 chacha20-poly1305，迁移期间仍接受旧密码与 xchacha20-poly1305
+	server := func(server *proxy.ServerConfig) {
+		server.Password = "new-password"
+		server.Cipher = cipher.SuiteChaCha20Poly1305
+		server.Credentials = []config.Credential{{Password: "old-password", Cipher: cipher.SuiteXChaCha20Poly1305}}
+	}
+	tests := []struct {
+		name, password, cipher string
+	}{
+		{"new", "new-password", cipher.SuiteChaCha20Poly1305},
+		{"old", "old-password", cipher.SuiteXChaCha20Poly1305},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := startHarness(t, proxytest.WithConfig(func(s *proxy.ServerConfig, local *proxy.LocalConfig) {
+				server(s)
+				local.Password = tt.password
+				local.Cipher = tt.cipher
+			}))
+			if err := h.RoundTrip([]byte("rolling migration")); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	h := startHarness(t, proxytest.WithConfig(func(s *proxy.ServerConfig, local *proxy.LocalConfig) {
+		server(s)
+		local.Password = "old-password"
+		local.Cipher = cipher.SuiteChaCha20Poly1305
+	}))
+	if err := h.RoundTrip([]byte("rolling migration")); err == nil {
+		t.Fatal("old password with the new cipher was accepted")
+	}
+}